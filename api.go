@@ -15,7 +15,10 @@
 */
 package xweb
 
-import "net/http"
+import (
+	"context"
+	"net/http"
+)
 
 // ApiBinding is an interface defines the minimum operations necessary to convert configuration into a ApiHandler
 // by some ApiHandlerFactory. The ApiBinding.Binding() value is used to map configuration data to specific
@@ -36,6 +39,20 @@ type ApiHandler interface {
 	http.Handler
 }
 
+// LifecycleApiHandler is an optional interface for ApiHandler's that run background work (e.g. caches or pollers) tied
+// to the lifecycle of the Server hosting them. Start is called by Server.Start before requests are served and Stop is
+// called by Server.Shutdown after requests have drained. Both are called once for each ApiConfig the ApiHandler was
+// created for.
+type LifecycleApiHandler interface {
+	ApiHandler
+
+	// Start starts background work. An error aborts Server.Start, LifecycleApiHandler's already started are stopped.
+	Start() error
+
+	// Stop stops background work. It should return promptly once ctx is done.
+	Stop(ctx context.Context)
+}
+
 // The ApiHandlerFactory interface generates ApiHandler instances. Factories can use a single instance or multiple
 // instances based on need. This interface allows ApiHandler logic to be reused across multiple xweb.Server's while
 // delegating the instance management to the factory.
@@ -51,3 +68,13 @@ type ApiHandlerFactory interface {
 	// instances or configuration from another section).
 	Validate(config *InstanceConfig) error
 }
+
+// ApiConfigValidatingFactory is an optional interface for ApiHandlerFactory's that validate the options of individual
+// ApiConfig's. InstanceConfig.Validate calls ValidateApiConfig for every enabled ApiConfig with the factory's binding so
+// that malformed options are rejected when the configuration is validated instead of when ApiHandler's are created.
+type ApiConfigValidatingFactory interface {
+	ApiHandlerFactory
+
+	// ValidateApiConfig validates a single ApiConfig, including its Options(), of the supplied ServerConfig
+	ValidateApiConfig(serverConfig *ServerConfig, apiConfig *ApiConfig) error
+}
@@ -16,15 +16,29 @@
 
 package xweb
 
-import "github.com/pkg/errors"
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"time"
+)
 
 // ApiConfig represents some "api" or "site" by binding name. Each ApiConfig configuration is used against a Registry
 // to locate the proper factory to generate a ApiHandler. The options provided by this structure are parsed by the
 // ApiHandlerFactory and the behavior, valid keys, and valid values are not defined by xweb components, but by that
 // ApiHandlerFactory and its resulting ApiHandler's.
 type ApiConfig struct {
-	binding string
-	options map[interface{}]interface{}
+	binding  string
+	options  map[interface{}]interface{}
+	disabled bool
+	priority int
+
+	maxBodyBytes    int64
+	hasMaxBodyBytes bool
+
+	handlerTimeout    time.Duration
+	hasHandlerTimeout bool
+
+	middleware []string
 }
 
 // Binding returns the string that uniquely identifies bo the ApiHandlerFactory and resulting ApiHandler instances that
@@ -38,6 +52,39 @@ func (api *ApiConfig) Options() map[interface{}]interface{} {
 	return api.options
 }
 
+// Enabled returns false if the ApiConfig was disabled in configuration. Disabled APIs are validated but not served.
+func (api *ApiConfig) Enabled() bool {
+	return !api.disabled
+}
+
+// Priority returns the routing priority of the API, zero if not configured. The ApiHandler's of a Server are provided
+// to its DemuxFactory ordered by descending priority, APIs with equal priorities keep their configuration order. As
+// DemuxFactory's match in the order provided and fall back to the last ApiHandler as the default, higher priority APIs
+// are matched first and the lowest priority API is the default unless an ApiHandler declares itself the default.
+func (api *ApiConfig) Priority() int {
+	return api.priority
+}
+
+// MaxBodyBytes returns the request body size limit of the API and true if it overrides the server's maxBodyBytes
+// option. A limit of zero is unlimited, which allows large upload APIs to opt out of the server's limit.
+func (api *ApiConfig) MaxBodyBytes() (int64, bool) {
+	return api.maxBodyBytes, api.hasMaxBodyBytes
+}
+
+// HandlerTimeout returns the request processing timeout of the API and true if it overrides the server's
+// handlerTimeout option. A timeout of zero is unlimited, which allows streaming and long-poll APIs to opt out of the
+// server's timeout.
+func (api *ApiConfig) HandlerTimeout() (time.Duration, bool) {
+	return api.handlerTimeout, api.hasHandlerTimeout
+}
+
+// Middleware returns the names of the middleware, registered via InstanceOptions.ApiMiddleware, that wrap the
+// ApiHandler of the API, outermost first. They run after all middleware of the bind point and the body limit of the
+// API, and within the handler timeout of the API, so they only see requests routed to the API.
+func (api *ApiConfig) Middleware() []string {
+	return api.middleware
+}
+
 // Parse the configuration map for an ApiConfig.
 func (api *ApiConfig) Parse(apiConfigMap map[interface{}]interface{}) error {
 	if bindingInterface, ok := apiConfigMap["binding"]; ok {
@@ -58,6 +105,58 @@ func (api *ApiConfig) Parse(apiConfigMap map[interface{}]interface{}) error {
 		}
 	} //no else optional
 
+	if enabledInterface, ok := apiConfigMap["enabled"]; ok {
+		if enabled, ok := enabledInterface.(bool); ok {
+			api.disabled = !enabled
+		} else {
+			return errors.New("enabled if declared must be a boolean")
+		}
+	} //no else optional, defaults to enabled
+
+	if priorityInterface, ok := apiConfigMap["priority"]; ok {
+		if priority, ok := priorityInterface.(int); ok {
+			api.priority = priority
+		} else {
+			return errors.New("priority if declared must be an integer")
+		}
+	} //no else optional, defaults to 0
+
+	if maxBodyBytesInterface, ok := apiConfigMap["maxBodyBytes"]; ok {
+		if maxBodyBytes, ok := maxBodyBytesInterface.(int); ok && maxBodyBytes >= 0 {
+			api.maxBodyBytes = int64(maxBodyBytes)
+			api.hasMaxBodyBytes = true
+		} else {
+			return errors.New("maxBodyBytes if declared must be a non-negative integer")
+		}
+	} //no else optional, defaults to the server's maxBodyBytes
+
+	if handlerTimeoutInterface, ok := apiConfigMap["handlerTimeout"]; ok {
+		if handlerTimeoutStr, ok := handlerTimeoutInterface.(string); ok {
+			handlerTimeout, err := time.ParseDuration(handlerTimeoutStr)
+			if err != nil || handlerTimeout < 0 {
+				return fmt.Errorf("could not parse handlerTimeout %s as a non-negative duration (e.g. 1m)", handlerTimeoutStr)
+			}
+			api.handlerTimeout = handlerTimeout
+			api.hasHandlerTimeout = true
+		} else {
+			return errors.New("handlerTimeout if declared must be a string")
+		}
+	} //no else optional, defaults to the server's handlerTimeout
+
+	if middlewareInterface, ok := apiConfigMap["middleware"]; ok {
+		if middlewareNames, ok := middlewareInterface.([]interface{}); ok {
+			for i, nameInterface := range middlewareNames {
+				if name, ok := nameInterface.(string); ok && name != "" {
+					api.middleware = append(api.middleware, name)
+				} else {
+					return fmt.Errorf("middleware at index [%d] must be a non-empty string", i)
+				}
+			}
+		} else {
+			return errors.New("middleware if declared must be an array")
+		}
+	} //no else optional, defaults to no middleware
+
 	return nil
 }
 
@@ -0,0 +1,113 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// apiMiddlewareContextKey is the context key of the apiMiddleware of the Server handling a request
+const apiMiddlewareContextKey = ContextKey("xweb.apiMiddleware.ContextKey")
+
+// apiNextContextKey is the context key of the http.Handler the innermost per API middleware of a request calls
+const apiNextContextKey = ContextKey("xweb.apiMiddleware.next.ContextKey")
+
+// apiMiddleware are the per API middleware chains of a Server, keyed by binding. Each chain is built once, so that
+// middleware with state (e.g. rate limiters) is shared by all requests, and ends in a handler that serves the request
+// with the http.Handler stored under apiNextContextKey by wrap.
+type apiMiddleware struct {
+	bindings map[string]http.Handler
+}
+
+// newApiMiddleware builds the middleware chains of the ApiConfig's of a ServerConfig from the named middleware in
+// registered. Nil is returned if no ApiConfig lists middleware. An error is returned if an ApiConfig lists middleware
+// that is not registered.
+func newApiMiddleware(serverConfig *ServerConfig, registered []Middleware) (*apiMiddleware, error) {
+	chains := &apiMiddleware{
+		bindings: map[string]http.Handler{},
+	}
+
+	for _, api := range serverConfig.APIs {
+		if _, exists := chains.bindings[api.Binding()]; exists || len(api.Middleware()) == 0 {
+			continue
+		}
+
+		var handler http.Handler = http.HandlerFunc(serveApiNext)
+
+		//outermost first, so wrap from the innermost
+		for i := len(api.Middleware()) - 1; i >= 0; i-- {
+			name := api.Middleware()[i]
+
+			index := MiddlewareIndex(registered, name)
+			if index < 0 {
+				return nil, fmt.Errorf("api binding [%s] lists middleware [%s] which is not registered", api.Binding(), name)
+			}
+
+			handler = registered[index].Wrap(handler)
+		}
+
+		chains.bindings[api.Binding()] = handler
+	}
+
+	if len(chains.bindings) == 0 {
+		return nil, nil
+	}
+
+	return chains, nil
+}
+
+// wrap returns a http.Handler that serves requests with the middleware chain of the supplied binding, which calls
+// next once it is passed. Next is returned if the binding has no middleware.
+func (chains *apiMiddleware) wrap(binding string, next http.Handler) http.Handler {
+	chain, ok := chains.bindings[binding]
+
+	if !ok {
+		return next
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		chain.ServeHTTP(writer, request.WithContext(context.WithValue(request.Context(), apiNextContextKey, next)))
+	})
+}
+
+// serveApiNext is the innermost handler of every per API middleware chain. It serves the request with the
+// http.Handler stored by apiMiddleware.wrap.
+func serveApiNext(writer http.ResponseWriter, request *http.Request) {
+	if next, ok := request.Context().Value(apiNextContextKey).(http.Handler); ok {
+		next.ServeHTTP(writer, request)
+		return
+	}
+
+	http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// wrapApiMiddleware wraps a http.Handler with another http.Handler that makes the Server's apiMiddleware available to
+// serveApiHandler, which applies the middleware of the ApiHandler selected for the request.
+func (server *Server) wrapApiMiddleware(handler http.Handler) http.Handler {
+	chains := server.apiMiddleware
+
+	if chains == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx := context.WithValue(request.Context(), apiMiddlewareContextKey, chains)
+		handler.ServeHTTP(writer, request.WithContext(ctx))
+	})
+}
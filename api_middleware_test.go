@@ -0,0 +1,146 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_apiMiddleware(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	registry := NewRegistryMap()
+	for _, binding := range []string{"protected", "public"} {
+		require.New(t).NoError(registry.Add(&bindingHandlerFactory{binding: binding}))
+	}
+
+	var calls []string
+	wraps := 0
+
+	record := func(name string) Middleware {
+		return Middleware{Name: name, Wrap: func(next http.Handler) http.Handler {
+			wraps++
+			return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(writer, request)
+			})
+		}}
+	}
+
+	deny := Middleware{Name: "deny", Wrap: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if request.Header.Get("Authorization") == "" {
+				writer.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(writer, request)
+		})
+	}}
+
+	newInstance := func() *InstanceImpl {
+		instance := NewDefaultInstance(registry, id)
+		instance.DemuxFactory = &PathPrefixDemuxFactory{}
+		instance.Config.Options.ApiMiddleware = []Middleware{record("outer"), record("inner"), deny}
+		return instance
+	}
+
+	newServerConfig := func(middleware ...interface{}) *ServerConfig {
+		protected := &ApiConfig{}
+		require.New(t).NoError(protected.Parse(map[interface{}]interface{}{"binding": "protected", "middleware": middleware}))
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.APIs = []*ApiConfig{protected, {binding: "public"}}
+		return serverConfig
+	}
+
+	t.Run("middleware wraps only the apis that list it, in order", func(t *testing.T) {
+		req := require.New(t)
+		calls, wraps = nil, 0
+
+		var chain []string
+		instance := newInstance()
+		instance.Config.Options.Middleware = func(_ *Server, _ *BindPointConfig, defaults []Middleware) []Middleware {
+			chain = middlewareNames(defaults)
+			return defaults
+		}
+
+		server := newTestServer(t, instance, newServerConfig("outer", "inner", "deny"))
+		req.Equal(2, wraps)
+		req.Equal(MiddlewareApi, chain[len(chain)-1])
+
+		serve := func(path string, authorized bool) *httptest.ResponseRecorder {
+			request := httptest.NewRequest(http.MethodGet, path, nil)
+			if authorized {
+				request.Header.Set("Authorization", "token")
+			}
+			recorder := httptest.NewRecorder()
+			server.httpServers[0].Handler.ServeHTTP(recorder, request)
+			return recorder
+		}
+
+		req.Equal(http.StatusOK, serve("/public", false).Code)
+		req.Empty(calls)
+
+		req.Equal(http.StatusUnauthorized, serve("/protected", false).Code)
+		req.Equal([]string{"outer", "inner"}, calls)
+
+		recorder := serve("/protected", true)
+		req.Equal(http.StatusOK, recorder.Code)
+		req.Equal("protected", recorder.Body.String())
+		req.Equal([]string{"outer", "inner", "outer", "inner"}, calls)
+		req.Equal(2, wraps)
+	})
+
+	t.Run("the default chain is unchanged without api middleware", func(t *testing.T) {
+		req := require.New(t)
+
+		var chain []string
+		instance := newInstance()
+		instance.Config.Options.Middleware = func(_ *Server, _ *BindPointConfig, defaults []Middleware) []Middleware {
+			chain = middlewareNames(defaults)
+			return defaults
+		}
+
+		newTestServer(t, instance, newServerConfig())
+		req.NotContains(chain, MiddlewareApi)
+	})
+
+	t.Run("unregistered middleware is an error", func(t *testing.T) {
+		req := require.New(t)
+
+		instance := newInstance()
+		serverConfig := newServerConfig("outer", "unknown")
+
+		_, err := NewServer(instance, serverConfig)
+		req.Error(err)
+		req.Contains(err.Error(), "api binding [protected] lists middleware [unknown] which is not registered")
+
+		err = instance.Config.validateApiMiddleware(serverConfig)
+		req.Error(err)
+		req.Contains(err.Error(), "middleware [unknown] which is not registered")
+	})
+
+	t.Run("invalid middleware lists are an error", func(t *testing.T) {
+		req := require.New(t)
+
+		req.Error((&ApiConfig{}).Parse(map[interface{}]interface{}{"binding": "protected", "middleware": "outer"}))
+		req.Error((&ApiConfig{}).Parse(map[interface{}]interface{}{"binding": "protected", "middleware": []interface{}{""}}))
+	})
+}
@@ -0,0 +1,148 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DefaultBindRegistry is a process wide BindRegistry. Instances opt in to cross instance collision detection by
+// setting InstanceOptions.BindRegistry to it.
+var DefaultBindRegistry = NewBindRegistry()
+
+// BindRegistry tracks the interface addresses bound by Server's so that colliding bind points, including those of
+// different Instance's in the same process, are reported with a clear error instead of failing to listen
+// unpredictably.
+type BindRegistry struct {
+	lock     sync.Mutex
+	bindings []*bindRegistration
+}
+
+type bindRegistration struct {
+	network     string
+	address     string
+	host        string
+	port        string
+	owner       interface{}
+	description string
+}
+
+// NewBindRegistry creates a new, empty BindRegistry
+func NewBindRegistry() *BindRegistry {
+	return &BindRegistry{}
+}
+
+// Register records that owner, described by description, binds address. An error naming both parties is returned if
+// address collides with an address registered by another owner. Addresses collide if their ports match and their
+// hosts match or either host is unspecified (e.g. ":443", "0.0.0.0:443"). Addresses with port 0 never collide. Unix
+// socket addresses (see UnixSocketPrefix) collide if their paths match.
+func (registry *BindRegistry) Register(address string, owner interface{}, description string) error {
+	return registry.RegisterNetwork(NetworkTcp, address, owner, description)
+}
+
+// RegisterNetwork is Register for an address bound on network (see BindPointConfig.ListenNetwork). Addresses bound
+// on NetworkTcp4 never collide with addresses bound on NetworkTcp6, which allows IPv4 and IPv6 to be bound on the same
+// port by separate bind points.
+func (registry *BindRegistry) RegisterNetwork(network string, address string, owner interface{}, description string) error {
+	host, port, err := splitBindAddress(address)
+	if err != nil {
+		return fmt.Errorf("could not register bind point [%s]: %v", address, err)
+	}
+
+	registration := &bindRegistration{
+		network:     network,
+		address:     address,
+		host:        host,
+		port:        port,
+		owner:       owner,
+		description: description,
+	}
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	for _, existing := range registry.bindings {
+		if existing.owner != owner && existing.collides(registration) {
+			return fmt.Errorf("bind point [%s] of %s collides with bind point [%s] of %s", address, description, existing.address, existing.description)
+		}
+	}
+
+	registry.bindings = append(registry.bindings, registration)
+
+	return nil
+}
+
+// Unregister removes all addresses registered by owner.
+func (registry *BindRegistry) Unregister(owner interface{}) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	var bindings []*bindRegistration
+
+	for _, registration := range registry.bindings {
+		if registration.owner != owner {
+			bindings = append(bindings, registration)
+		}
+	}
+
+	registry.bindings = bindings
+}
+
+func (registration *bindRegistration) collides(other *bindRegistration) bool {
+	//port 0 binds a port chosen by the operating system, which never collides
+	if registration.port != other.port || registration.port == "0" {
+		return false
+	}
+
+	//IPv4 only and IPv6 only bind points may share a port
+	if (registration.network == NetworkTcp4 && other.network == NetworkTcp6) || (registration.network == NetworkTcp6 && other.network == NetworkTcp4) {
+		return false
+	}
+
+	return registration.host == "" || other.host == "" || registration.host == other.host
+}
+
+// splitBindAddress splits a bind address into host and port. Unix socket addresses are split into their cleaned path
+// and a port of "unix" so that they only collide with each other.
+func splitBindAddress(address string) (string, string, error) {
+	if strings.HasPrefix(address, UnixSocketPrefix) {
+		return filepath.Clean(strings.TrimPrefix(address, UnixSocketPrefix)), "unix", nil
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", "", err
+	}
+
+	return normalizeBindHost(host), port, nil
+}
+
+// normalizeBindHost returns an empty string for unspecified hosts and a canonical form for IPs.
+func normalizeBindHost(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip.IsUnspecified() {
+			return ""
+		}
+		return ip.String()
+	}
+
+	return strings.ToLower(host)
+}
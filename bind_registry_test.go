@@ -0,0 +1,123 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestBindRegistry_Register(t *testing.T) {
+	t.Run("identical addresses of different owners collide", func(t *testing.T) {
+		registry := NewBindRegistry()
+		req := require.New(t)
+		req.NoError(registry.Register("127.0.0.1:8443", "a", "owner a"))
+
+		err := registry.Register("127.0.0.1:8443", "b", "owner b")
+		req.Error(err)
+		req.Contains(err.Error(), "owner a")
+		req.Contains(err.Error(), "owner b")
+	})
+
+	t.Run("unspecified hosts collide with any host on the same port", func(t *testing.T) {
+		registry := NewBindRegistry()
+		req := require.New(t)
+		req.NoError(registry.Register("127.0.0.1:8443", "a", "owner a"))
+		req.Error(registry.Register("0.0.0.0:8443", "b", "owner b"))
+		req.Error(registry.Register(":8443", "c", "owner c"))
+	})
+
+	t.Run("different ports or hosts do not collide", func(t *testing.T) {
+		registry := NewBindRegistry()
+		req := require.New(t)
+		req.NoError(registry.Register("127.0.0.1:8443", "a", "owner a"))
+		req.NoError(registry.Register("127.0.0.1:8444", "b", "owner b"))
+		req.NoError(registry.Register("127.0.0.2:8443", "c", "owner c"))
+	})
+
+	t.Run("unix sockets collide on the same path only", func(t *testing.T) {
+		registry := NewBindRegistry()
+		req := require.New(t)
+		req.NoError(registry.Register("unix:/tmp/admin.sock", "a", "owner a"))
+		req.Error(registry.Register("unix:/tmp/../tmp/admin.sock", "b", "owner b"))
+		req.NoError(registry.Register("unix:/tmp/other.sock", "c", "owner c"))
+		req.NoError(registry.Register(":8443", "d", "owner d"))
+	})
+
+	t.Run("IPv4 only and IPv6 only networks do not collide", func(t *testing.T) {
+		registry := NewBindRegistry()
+		req := require.New(t)
+		req.NoError(registry.RegisterNetwork(NetworkTcp4, ":8443", "a", "owner a"))
+		req.NoError(registry.RegisterNetwork(NetworkTcp6, ":8443", "b", "owner b"))
+		req.Error(registry.RegisterNetwork(NetworkTcp4, "0.0.0.0:8443", "c", "owner c"))
+		req.Error(registry.Register(":8443", "d", "owner d"))
+	})
+
+	t.Run("port 0 does not collide", func(t *testing.T) {
+		registry := NewBindRegistry()
+		req := require.New(t)
+		req.NoError(registry.Register("127.0.0.1:0", "a", "owner a"))
+		req.NoError(registry.Register("127.0.0.1:0", "b", "owner b"))
+	})
+
+	t.Run("unregistered addresses may be registered again", func(t *testing.T) {
+		registry := NewBindRegistry()
+		req := require.New(t)
+		req.NoError(registry.Register("127.0.0.1:8443", "a", "owner a"))
+		registry.Unregister("a")
+		req.NoError(registry.Register("127.0.0.1:8443", "b", "owner b"))
+	})
+}
+
+func TestInstanceImpl_bindRegistryCollision(t *testing.T) {
+	req := require.New(t)
+
+	registry := NewBindRegistry()
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+	address := freeAddress(t)
+
+	first := newTestInstance(t, id, nil)
+	first.Config.Options.Name = "first"
+	first.Config.Options.BindRegistry = registry
+	first.Config.ServerConfigs = []*ServerConfig{newTestServerConfig(id, address)}
+
+	second := newTestInstance(t, id, nil)
+	second.Config.Options.Name = "second"
+	second.Config.Options.BindRegistry = registry
+	second.Config.ServerConfigs = []*ServerConfig{newTestServerConfig(id, address)}
+
+	req.NoError(first.Run())
+	defer first.Shutdown()
+	req.Eventually(first.IsReady, 5*time.Second, 10*time.Millisecond)
+
+	err := second.Run()
+	defer second.Shutdown()
+
+	req.Error(err)
+	req.Contains(err.Error(), "instance [first]")
+
+	select {
+	case err := <-second.StartErrors():
+		req.Contains(err.Error(), "instance [first]")
+		req.Contains(err.Error(), "instance [second]")
+	case <-time.After(5 * time.Second):
+		req.Fail("expected a bind point collision error")
+	}
+
+	req.True(first.IsReady())
+}
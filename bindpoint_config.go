@@ -17,23 +17,216 @@
 package xweb
 
 import (
+	"crypto/tls"
 	"fmt"
 	"github.com/pkg/errors"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
 )
 
+// UnixSocketPrefix is the prefix of BindPointConfig.InterfaceAddress values that denote a unix domain socket path
+// instead of an <interface>:<port> (e.g. "unix:/var/run/ziti/admin.sock").
+const UnixSocketPrefix = "unix:"
+
+// ClientAuthPolicyMap is a map of clientAuth configuration strings to the tls.ClientAuthType they select
+var ClientAuthPolicyMap = map[string]tls.ClientAuthType{
+	"none":             tls.NoClientCert,
+	"request":          tls.RequestClientCert,
+	"require":          tls.RequireAnyClientCert,
+	"verifyIfGiven":    tls.VerifyClientCertIfGiven,
+	"requireAndVerify": tls.RequireAndVerifyClientCert,
+}
+
+// Networks that may be configured for bind points with <interface>:<port> addresses. NetworkTcp4 and NetworkTcp6
+// restrict a bind point to IPv4 or IPv6, e.g. so that ":443" binds the same address families on all platforms.
+const (
+	NetworkTcp  = "tcp"
+	NetworkTcp4 = "tcp4"
+	NetworkTcp6 = "tcp6"
+)
+
+// DefaultClientAuthPolicy is the clientAuth policy of bind points that do not configure one
+const DefaultClientAuthPolicy = "request"
+
 // BindPointConfig represents the interface:port address of where a http.Server should listen for a ServerConfig and the public
 // address that should be used to address it.
+//
+// The interface address may also be a unix domain socket in the form "unix:<path>". The advertised Address,
+// NewAddress, and NewAddresses are still validated as <ip/host>:<port> as they are sent to clients and must be
+// addresses that clients can connect to.
+//
+// Bind points may disable TLS via "serveTLS: false" to serve plain HTTP, in which case the Identity of the
+// ServerConfig is not presented on that bind point. It is intended for unix sockets and for loopback interfaces behind
+// a TLS terminating proxy, a warning is logged if it is used on any other interface.
+//
+// Bind points may enable "redirectHttps: true" to serve plain HTTP and permanently redirect all requests to the same
+// path and query at the advertised Address with the https scheme, e.g. a companion ":80" bind point of a server that
+// serves its APIs on ":443". They serve no APIs.
+//
+// Bind points behind L4 load balancers may enable "proxyProtocol: true" to accept PROXY protocol v1 or v2 headers
+// ahead of the TLS handshake. The client address conveyed by the header is used as the remote address of requests.
+// Connections without a valid header are rejected, so it must only be enabled if all clients connect through the load
+// balancer.
 type BindPointConfig struct {
-	InterfaceAddress string //<interface>:<port>
+	// Name optionally identifies the bind point in logs and to ApiHandlers shared by several bind points, e.g. to
+	// apply per bind point policy, see BindPointNameFromRequestContext. It must be unique within the ServerConfig.
+	// Parse defaults it to the InterfaceAddress. If empty, the InterfaceAddress identifies the bind point, see GetName.
+	Name string
+
+	InterfaceAddress string //<interface>:<port> or unix:<path>
 	Address          string //<ip/host>:<port>
 	NewAddress       string //<ip/host>:<port> sent out as a header for clients to alternatively swap to (ip -> hostname moves)
+	DisableTLS       bool   //serve plain HTTP
+	ProxyProtocol    bool   //require a PROXY protocol header on each connection
+	MaxConnections   int    //maximum number of concurrently open connections, 0 is unlimited
+	H2c              bool   //serve HTTP/2 cleartext (h2c) in addition to HTTP/1.1, requires DisableTLS
+	RedirectHttps    bool   //redirect all requests to the advertised Address with the https scheme, requires DisableTLS
+
+	// NewAddresses are additional <ip/host>:<port> addresses sent out after NewAddress, in order of preference, so
+	// clients may pick the first reachable one during staged migrations
+	NewAddresses []string
+
+	// NewAddressPaths optionally limits the responses the new addresses are sent on to requests whose path starts
+	// with one of the listed prefixes. If empty, they are sent on all responses.
+	NewAddressPaths []string
+
+	// AllowedCidrs and DeniedCidrs optionally restrict the client addresses that may make requests. Requests from
+	// clients within a denied CIDR, or outside all allowed CIDRs if any are listed, receive a 403. Single IP addresses
+	// may be listed as well.
+	AllowedCidrs []string
+	DeniedCidrs  []string
+
+	// ClientAuthPolicy optionally selects how client certificates are requested and verified on this bind point, one of
+	// the keys of ClientAuthPolicyMap. If empty, DefaultClientAuthPolicy is used.
+	ClientAuthPolicy string
+
+	// ClientCas optionally overrides ServerConfig.ClientCas for this bind point.
+	ClientCas string
+
+	// Network optionally selects the network the InterfaceAddress is bound on, one of NetworkTcp, NetworkTcp4, or
+	// NetworkTcp6. If empty, NetworkTcp is used, which binds both IPv4 and IPv6 for wildcard addresses where the
+	// platform supports it. Bind points restricted to IPv4 or IPv6 do not share their port with other transports.
+	Network string
+
+	// APIs optionally lists the bindings of the ServerConfig's APIs served on this bind point. If empty, all APIs are
+	// served. Listing a single binding dedicates the bind point to that API, which is served without demultiplexing.
+	APIs []string
+}
+
+// GetName returns the Name of the bind point or its InterfaceAddress if Name is empty.
+func (bindPoint *BindPointConfig) GetName() string {
+	if bindPoint.Name != "" {
+		return bindPoint.Name
+	}
+
+	return strings.TrimSpace(bindPoint.InterfaceAddress)
+}
+
+// IsUnixSocket returns true if the InterfaceAddress denotes a unix domain socket.
+func (bindPoint *BindPointConfig) IsUnixSocket() bool {
+	return strings.HasPrefix(strings.TrimSpace(bindPoint.InterfaceAddress), UnixSocketPrefix)
+}
+
+// UnixSocketPath returns the path of the unix domain socket denoted by InterfaceAddress or an empty string if the
+// InterfaceAddress is not a unix domain socket.
+func (bindPoint *BindPointConfig) UnixSocketPath() string {
+	if !bindPoint.IsUnixSocket() {
+		return ""
+	}
+
+	return strings.TrimPrefix(strings.TrimSpace(bindPoint.InterfaceAddress), UnixSocketPrefix)
+}
+
+// IsLoopback returns true if the InterfaceAddress is a unix domain socket or a loopback interface, i.e. it is only
+// reachable from the local host.
+func (bindPoint *BindPointConfig) IsLoopback() bool {
+	if bindPoint.IsUnixSocket() {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(strings.TrimSpace(bindPoint.InterfaceAddress))
+	if err != nil {
+		return false
+	}
+
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ListenNetwork returns the network the InterfaceAddress is bound on, i.e. "unix" for unix domain sockets, otherwise
+// Network or NetworkTcp if it is not set.
+func (bindPoint *BindPointConfig) ListenNetwork() string {
+	if bindPoint.IsUnixSocket() {
+		return "unix"
+	}
+
+	if bindPoint.Network == "" {
+		return NetworkTcp
+	}
+
+	return bindPoint.Network
+}
+
+// ClientAuth returns the tls.ClientAuthType selected by ClientAuthPolicy, tls.RequestClientCert if it is empty or
+// invalid.
+func (bindPoint *BindPointConfig) ClientAuth() tls.ClientAuthType {
+	if clientAuth, ok := ClientAuthPolicyMap[bindPoint.ClientAuthPolicy]; ok {
+		return clientAuth
+	}
+
+	return tls.RequestClientCert
+}
+
+// AllNewAddresses returns NewAddress, if set, followed by NewAddresses with duplicates removed.
+func (bindPoint *BindPointConfig) AllNewAddresses() []string {
+	var addresses []string
+	seen := map[string]struct{}{}
+
+	for _, address := range append([]string{bindPoint.NewAddress}, bindPoint.NewAddresses...) {
+		if address == "" {
+			continue
+		}
+
+		if _, ok := seen[address]; !ok {
+			seen[address] = struct{}{}
+			addresses = append(addresses, address)
+		}
+	}
+
+	return addresses
+}
+
+// SendsNewAddressesFor returns true if the new addresses should be sent on the response to a request for path.
+func (bindPoint *BindPointConfig) SendsNewAddressesFor(path string) bool {
+	if len(bindPoint.NewAddressPaths) == 0 {
+		return true
+	}
+
+	for _, prefix := range bindPoint.NewAddressPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
 }
 
 // Parse the configuration map for a BindPointConfig.
 func (bindPoint *BindPointConfig) Parse(config map[interface{}]interface{}) error {
+	if nameVal, ok := config["name"]; ok {
+		if name, ok := nameVal.(string); ok {
+			bindPoint.Name = strings.TrimSpace(name)
+		} else {
+			return errors.New("could not use value for name, not a string")
+		}
+	}
+
 	if interfaceVal, ok := config["interface"]; ok {
 		if address, ok := interfaceVal.(string); ok {
 			bindPoint.InterfaceAddress = address
@@ -58,6 +251,157 @@ func (bindPoint *BindPointConfig) Parse(config map[interface{}]interface{}) erro
 		}
 	}
 
+	if newAddressesVal, ok := config["newAddresses"]; ok {
+		if newAddresses, ok := newAddressesVal.([]interface{}); ok {
+			for i, newAddressVal := range newAddresses {
+				if newAddress, ok := newAddressVal.(string); ok {
+					bindPoint.NewAddresses = append(bindPoint.NewAddresses, newAddress)
+				} else {
+					return fmt.Errorf("could not use value for newAddresses at index [%d], not a string", i)
+				}
+			}
+		} else {
+			return errors.New("could not use value for newAddresses, not an array")
+		}
+	}
+
+	if pathsVal, ok := config["newAddressPaths"]; ok {
+		if paths, ok := pathsVal.([]interface{}); ok {
+			for i, pathVal := range paths {
+				if path, ok := pathVal.(string); ok {
+					bindPoint.NewAddressPaths = append(bindPoint.NewAddressPaths, path)
+				} else {
+					return fmt.Errorf("could not use value for newAddressPaths at index [%d], not a string", i)
+				}
+			}
+		} else {
+			return errors.New("could not use value for newAddressPaths, not an array")
+		}
+	}
+
+	for _, key := range []string{"allowedCidrs", "deniedCidrs"} {
+		cidrsVal, ok := config[key]
+		if !ok {
+			continue
+		}
+
+		cidrsArray, ok := cidrsVal.([]interface{})
+		if !ok {
+			return fmt.Errorf("could not use value for %s, not an array", key)
+		}
+
+		var cidrs []string
+		for i, cidrVal := range cidrsArray {
+			if cidr, ok := cidrVal.(string); ok {
+				cidrs = append(cidrs, cidr)
+			} else {
+				return fmt.Errorf("could not use value for %s at index [%d], not a string", key, i)
+			}
+		}
+
+		//fail fast on invalid entries
+		if _, err := parseCidrs(cidrs); err != nil {
+			return fmt.Errorf("could not use value for %s: %v", key, err)
+		}
+
+		if key == "allowedCidrs" {
+			bindPoint.AllowedCidrs = cidrs
+		} else {
+			bindPoint.DeniedCidrs = cidrs
+		}
+	}
+
+	if apisVal, ok := config["apis"]; ok {
+		if apis, ok := apisVal.([]interface{}); ok {
+			for i, apiVal := range apis {
+				if api, ok := apiVal.(string); ok {
+					bindPoint.APIs = append(bindPoint.APIs, api)
+				} else {
+					return fmt.Errorf("could not use value for apis at index [%d], not a string", i)
+				}
+			}
+		} else {
+			return errors.New("could not use value for apis, not an array")
+		}
+	}
+
+	if serveTlsVal, ok := config["serveTLS"]; ok {
+		if serveTls, ok := serveTlsVal.(bool); ok {
+			bindPoint.DisableTLS = !serveTls
+		} else {
+			return errors.New("could not use value for serveTLS, not a bool")
+		}
+	}
+
+	if redirectHttpsVal, ok := config["redirectHttps"]; ok {
+		if redirectHttps, ok := redirectHttpsVal.(bool); ok {
+			bindPoint.RedirectHttps = redirectHttps
+		} else {
+			return errors.New("could not use value for redirectHttps, not a bool")
+		}
+
+		if bindPoint.RedirectHttps {
+			//redirect bind points serve plain HTTP, which may be stated but not contradicted
+			if serveTls, ok := config["serveTLS"].(bool); ok && serveTls {
+				return errors.New("redirectHttps may not be set when serveTLS is true")
+			}
+
+			bindPoint.DisableTLS = true
+		}
+	}
+
+	if proxyProtocolVal, ok := config["proxyProtocol"]; ok {
+		if proxyProtocol, ok := proxyProtocolVal.(bool); ok {
+			bindPoint.ProxyProtocol = proxyProtocol
+		} else {
+			return errors.New("could not use value for proxyProtocol, not a bool")
+		}
+	}
+
+	if h2cVal, ok := config["h2c"]; ok {
+		if h2c, ok := h2cVal.(bool); ok {
+			bindPoint.H2c = h2c
+		} else {
+			return errors.New("could not use value for h2c, not a bool")
+		}
+	}
+
+	if clientAuthVal, ok := config["clientAuth"]; ok {
+		if clientAuth, ok := clientAuthVal.(string); ok {
+			bindPoint.ClientAuthPolicy = clientAuth
+		} else {
+			return errors.New("could not use value for clientAuth, not a string")
+		}
+	}
+
+	if networkVal, ok := config["network"]; ok {
+		if network, ok := networkVal.(string); ok {
+			bindPoint.Network = network
+		} else {
+			return errors.New("could not use value for network, not a string")
+		}
+	}
+
+	if clientCasVal, ok := config["clientCas"]; ok {
+		if clientCas, ok := clientCasVal.(string); ok {
+			bindPoint.ClientCas = clientCas
+		} else {
+			return errors.New("could not use value for clientCas, not a string")
+		}
+	}
+
+	if maxConnectionsVal, ok := config["maxConnections"]; ok {
+		if maxConnections, ok := maxConnectionsVal.(int); ok {
+			bindPoint.MaxConnections = maxConnections
+		} else {
+			return errors.New("could not use value for maxConnections, not an integer")
+		}
+	}
+
+	if bindPoint.Name == "" {
+		bindPoint.Name = strings.TrimSpace(bindPoint.InterfaceAddress)
+	}
+
 	return nil
 }
 
@@ -65,8 +409,60 @@ func (bindPoint *BindPointConfig) Parse(config map[interface{}]interface{}) erro
 func (bindPoint *BindPointConfig) Validate() error {
 
 	// required
-	if err := validateHostPort(bindPoint.InterfaceAddress); err != nil {
-		return fmt.Errorf("invalid interface address [%s]: %v", bindPoint.InterfaceAddress, err)
+	if bindPoint.IsUnixSocket() {
+		if err := validateUnixSocketPath(bindPoint.UnixSocketPath()); err != nil {
+			return fmt.Errorf("invalid interface address [%s]: %v", bindPoint.InterfaceAddress, err)
+		}
+	} else {
+		if err := validateInterfaceHostPort(bindPoint.InterfaceAddress); err != nil {
+			return fmt.Errorf("invalid interface address [%s]: %v", bindPoint.InterfaceAddress, err)
+		}
+	}
+
+	if err := bindPoint.validateNetwork(); err != nil {
+		return err
+	}
+
+	if bindPoint.H2c && !bindPoint.DisableTLS {
+		return errors.New("h2c may only be set when serveTLS is false, HTTP/2 is negotiated via TLS otherwise")
+	}
+
+	if bindPoint.RedirectHttps {
+		if !bindPoint.DisableTLS {
+			return errors.New("redirectHttps may only be set when serveTLS is false")
+		}
+
+		if bindPoint.H2c {
+			return errors.New("h2c may not be set when redirectHttps is true")
+		}
+
+		if len(bindPoint.APIs) > 0 {
+			return errors.New("apis may not be set when redirectHttps is true, redirect bind points serve no APIs")
+		}
+	}
+
+	if bindPoint.ClientAuthPolicy != "" {
+		if _, ok := ClientAuthPolicyMap[bindPoint.ClientAuthPolicy]; !ok {
+			return fmt.Errorf("invalid value [%s] for clientAuth, must be one of: %s", bindPoint.ClientAuthPolicy, strings.Join(clientAuthPolicies(), ", "))
+		}
+
+		if bindPoint.DisableTLS {
+			return errors.New("clientAuth may not be set when serveTLS is false")
+		}
+	}
+
+	if bindPoint.ClientCas != "" {
+		if bindPoint.DisableTLS {
+			return errors.New("clientCas may not be set when serveTLS is false")
+		}
+
+		if _, err := LoadClientCas(bindPoint.ClientCas); err != nil {
+			return fmt.Errorf("invalid clientCas: %v", err)
+		}
+	}
+
+	if bindPoint.MaxConnections < 0 {
+		return fmt.Errorf("value [%d] for maxConnections too low, must not be negative", bindPoint.MaxConnections)
 	}
 
 	// required
@@ -81,10 +477,95 @@ func (bindPoint *BindPointConfig) Validate() error {
 		}
 	}
 
+	//optional
+	for i, newAddress := range bindPoint.NewAddresses {
+		if err := validateHostPort(newAddress); err != nil {
+			return fmt.Errorf("invalid new address [%s] at index [%d]: %v", newAddress, i, err)
+		}
+	}
+
+	//optional
+	if _, err := newIpFilter(bindPoint); err != nil {
+		return err
+	}
+
+	//optional
+	for i, path := range bindPoint.NewAddressPaths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("invalid new address path [%s] at index [%d]: must start with /", path, i)
+		}
+	}
+
 	return nil
 }
 
+// validateNetwork checks that Network is known, is not set for unix domain sockets, and matches the address family of
+// an IP InterfaceAddress.
+func (bindPoint *BindPointConfig) validateNetwork() error {
+	if bindPoint.Network == "" {
+		return nil
+	}
+
+	if bindPoint.Network != NetworkTcp && bindPoint.Network != NetworkTcp4 && bindPoint.Network != NetworkTcp6 {
+		return fmt.Errorf("invalid value [%s] for network, must be one of: %s, %s, %s", bindPoint.Network, NetworkTcp, NetworkTcp4, NetworkTcp6)
+	}
+
+	if bindPoint.IsUnixSocket() {
+		return fmt.Errorf("network [%s] may not be set for unix socket interface address [%s]", bindPoint.Network, bindPoint.InterfaceAddress)
+	}
+
+	host, _, err := net.SplitHostPort(bindPoint.InterfaceAddress)
+	if err != nil {
+		return nil //reported by the interface address validation
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	if bindPoint.Network == NetworkTcp4 && ip.To4() == nil {
+		return fmt.Errorf("network [%s] may not be used with IPv6 interface address [%s]", bindPoint.Network, bindPoint.InterfaceAddress)
+	}
+
+	if bindPoint.Network == NetworkTcp6 && ip.To4() != nil {
+		return fmt.Errorf("network [%s] may not be used with IPv4 interface address [%s]", bindPoint.Network, bindPoint.InterfaceAddress)
+	}
+
+	return nil
+}
+
+// clientAuthPolicies returns the keys of ClientAuthPolicyMap in sorted order
+func clientAuthPolicies() []string {
+	var policies []string
+	for policy := range ClientAuthPolicyMap {
+		policies = append(policies, policy)
+	}
+	sort.Strings(policies)
+
+	return policies
+}
+
+func validateUnixSocketPath(path string) error {
+	if strings.TrimSpace(path) == "" {
+		return errors.New("unix socket path must be specified")
+	}
+
+	return nil
+}
+
+// validateHostPort validates an <ip/host>:<port> address that clients connect to.
 func validateHostPort(address string) error {
+	return validateHostPortRange(address, 1)
+}
+
+// validateInterfaceHostPort validates an <interface>:<port> address to bind. Port 0 is allowed and binds a port chosen
+// by the operating system, see Server.BoundAddresses.
+func validateInterfaceHostPort(address string) error {
+	return validateHostPortRange(address, 0)
+}
+
+func validateHostPortRange(address string, minPort int64) error {
 	address = strings.TrimSpace(address)
 
 	if address == "" {
@@ -107,8 +588,8 @@ func validateHostPort(address string) error {
 
 	if port, err := strconv.ParseInt(port, 10, 32); err != nil {
 		return errors.New("invalid port, must be a integer")
-	} else if port < 1 || port > 65535 {
-		return errors.New("invalid port, must 1-65535")
+	} else if port < minPort || port > 65535 {
+		return errors.Errorf("invalid port, must %d-65535", minPort)
 	}
 
 	return nil
@@ -0,0 +1,99 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"net/http"
+)
+
+// bodyLimitsContextKey is the context key of the bodyLimits of the Server handling a request
+const bodyLimitsContextKey = ContextKey("xweb.bodyLimits.ContextKey")
+
+// bodyLimits are the request body size limits of a Server: the server wide limit from SizeLimitOptions.MaxBodyBytes
+// and the limits of APIs that override it. A limit of zero is unlimited.
+type bodyLimits struct {
+	defaultLimit int64
+	bindings     map[string]int64
+}
+
+// newBodyLimits returns the bodyLimits for a ServerConfig or nil if no limits apply.
+func newBodyLimits(serverConfig *ServerConfig) *bodyLimits {
+	limits := &bodyLimits{
+		defaultLimit: serverConfig.Options.MaxBodyBytes,
+		bindings:     map[string]int64{},
+	}
+
+	for _, api := range serverConfig.APIs {
+		if limit, ok := api.MaxBodyBytes(); ok {
+			if _, exists := limits.bindings[api.Binding()]; !exists {
+				limits.bindings[api.Binding()] = limit
+			}
+		}
+	}
+
+	if limits.defaultLimit == 0 && len(limits.bindings) == 0 {
+		return nil
+	}
+
+	return limits
+}
+
+// limitFor returns the body size limit for requests served by the ApiHandler with the supplied binding.
+func (limits *bodyLimits) limitFor(binding string) int64 {
+	if limit, ok := limits.bindings[binding]; ok {
+		return limit
+	}
+
+	return limits.defaultLimit
+}
+
+// apply limits the body of a request served by the ApiHandler with the supplied binding. Requests that declare a
+// content length over the limit are answered with http.StatusRequestEntityTooLarge (413) and false is returned.
+// Bodies without a declared length are wrapped with http.MaxBytesReader, reads past the limit fail and the connection
+// is closed after the response.
+func (limits *bodyLimits) apply(binding string, writer http.ResponseWriter, request *http.Request) bool {
+	limit := limits.limitFor(binding)
+
+	if limit <= 0 || request.Body == nil || request.Body == http.NoBody {
+		return true
+	}
+
+	if request.ContentLength > limit {
+		http.Error(writer, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return false
+	}
+
+	request.Body = http.MaxBytesReader(writer, request.Body, limit)
+
+	return true
+}
+
+// wrapBodyLimits wraps a http.Handler with another http.Handler that makes the Server's bodyLimits available to
+// serveApiHandler, which applies the limit of the ApiHandler selected for the request.
+func (server *Server) wrapBodyLimits(handler http.Handler) http.Handler {
+	limits := newBodyLimits(server.ServerConfig)
+
+	if limits == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx := context.WithValue(request.Context(), bodyLimitsContextKey, limits)
+		handler.ServeHTTP(writer, request.WithContext(ctx))
+	})
+}
@@ -0,0 +1,110 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// bodyReadingHandler reads the request body and responds with http.StatusRequestEntityTooLarge if the read fails
+type bodyReadingHandler struct {
+	bindingPathHandler
+}
+
+func (h *bodyReadingHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if _, err := io.ReadAll(request.Body); err != nil {
+		writer.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	writer.WriteHeader(http.StatusOK)
+}
+
+// bodyReadingHandlerFactory creates bodyReadingHandler's for binding
+type bodyReadingHandlerFactory struct {
+	binding string
+}
+
+func (f *bodyReadingHandlerFactory) Binding() string {
+	return f.binding
+}
+
+func (f *bodyReadingHandlerFactory) New(_ *ServerConfig, _ map[interface{}]interface{}) (ApiHandler, error) {
+	return &bodyReadingHandler{bindingPathHandler{pathHandler{name: f.binding, rootPath: "/" + f.binding}}}, nil
+}
+
+func (f *bodyReadingHandlerFactory) Validate(_ *InstanceConfig) error {
+	return nil
+}
+
+func TestServer_maxBodyBytes(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "localhost")
+
+	registry := NewRegistryMap()
+	for _, binding := range []string{"limited", "uploads"} {
+		req.NoError(registry.Add(&bodyReadingHandlerFactory{binding: binding}))
+	}
+
+	instance := NewDefaultInstance(registry, id)
+	instance.DemuxFactory = &PathPrefixDemuxFactory{}
+
+	uploads := &ApiConfig{}
+	req.NoError(uploads.Parse(map[interface{}]interface{}{"binding": "uploads", "maxBodyBytes": 0}))
+
+	serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+	serverConfig.APIs = []*ApiConfig{{binding: "limited"}, uploads}
+	req.NoError(serverConfig.Options.Parse(map[interface{}]interface{}{"maxBodyBytes": 8}))
+	req.NoError(serverConfig.Validate(registry))
+
+	handler := newTestServer(t, instance, serverConfig).httpServers[0].Handler
+
+	serve := func(path string, body io.Reader) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, body))
+		return recorder.Code
+	}
+
+	t.Run("bodies within the limit are served", func(t *testing.T) {
+		require.New(t).Equal(http.StatusOK, serve("/limited", strings.NewReader("12345678")))
+	})
+
+	t.Run("bodies with a content length over the limit are rejected", func(t *testing.T) {
+		require.New(t).Equal(http.StatusRequestEntityTooLarge, serve("/limited", strings.NewReader("123456789")))
+	})
+
+	t.Run("bodies without a content length fail to read past the limit", func(t *testing.T) {
+		require.New(t).Equal(http.StatusRequestEntityTooLarge, serve("/limited", io.MultiReader(strings.NewReader("123456789"))))
+	})
+
+	t.Run("apis may opt out of the limit", func(t *testing.T) {
+		require.New(t).Equal(http.StatusOK, serve("/uploads", strings.NewReader(strings.Repeat("x", 1024))))
+	})
+
+	t.Run("invalid values are an error", func(t *testing.T) {
+		req := require.New(t)
+
+		options := &Options{}
+		req.Error(options.Parse(map[interface{}]interface{}{"maxBodyBytes": 0}))
+		req.Error((&ApiConfig{}).Parse(map[interface{}]interface{}{"binding": "uploads", "maxBodyBytes": -1}))
+	})
+}
@@ -0,0 +1,154 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/xweb/v2/middleware"
+	"net/http"
+)
+
+// The names of the middleware Server's wrap the ApiHandler's of each bind point with, see InstanceOptions.Middleware.
+const (
+	MiddlewareRequestId         = "requestId"
+	MiddlewareAccessLog         = "accessLog"
+	MiddlewareSecurityHeaders   = "securityHeaders"
+	MiddlewareIpFilter          = "ipFilter"
+	MiddlewareRateLimit         = "rateLimit"
+	MiddlewareInFlight          = "inFlight"
+	MiddlewareCompression       = "compression"
+	MiddlewarePanicRecovery     = "panicRecovery"
+	MiddlewareRequestDeadline   = "requestDeadline"
+	MiddlewareHealthChecks      = "healthChecks"
+	MiddlewareCors              = "cors"
+	MiddlewareHandlerTimeout    = "handlerTimeout"
+	MiddlewareBodyLimit         = "bodyLimit"
+	MiddlewareCtrlAddressHeader = "ctrlAddressHeader"
+	MiddlewareApi               = "apiMiddleware"
+)
+
+// Middleware is a named http.Handler wrapper in the middleware chain of a bind point
+type Middleware struct {
+	Name string
+	Wrap func(next http.Handler) http.Handler
+}
+
+// MiddlewareChainFunc returns the middleware chain of a bind point, outermost first. It receives the default chain,
+// which may be reordered, extended, or shortened and returned.
+type MiddlewareChainFunc func(server *Server, bindPoint *BindPointConfig, chain []Middleware) []Middleware
+
+// MiddlewareIndex returns the index of the middleware with the supplied name in chain or -1 if it is not present
+func MiddlewareIndex(chain []Middleware, name string) int {
+	for i, m := range chain {
+		if m.Name == name {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// defaultMiddleware returns the default middleware chain of a bind point, outermost first. Middleware that the
+// configuration does not enable are omitted.
+func (server *Server) defaultMiddleware(point *BindPointConfig) ([]Middleware, error) {
+	//first, so that all other middleware may log the request id
+	chain := []Middleware{{Name: MiddlewareRequestId, Wrap: func(next http.Handler) http.Handler {
+		return middleware.NewRequestIdHandler(next, nil)
+	}}}
+
+	if server.ServerConfig.Options.AccessLog {
+		chain = append(chain, Middleware{Name: MiddlewareAccessLog, Wrap: func(next http.Handler) http.Handler {
+			return middleware.NewAccessLogHandler(next, &middleware.AccessLogOptions{
+				Sink: server.instanceOptions.AccessLogSink,
+			})
+		}})
+	}
+
+	//before filters and limits, so that their rejections carry the headers as well
+	if server.ServerConfig.SecurityHeaders != nil {
+		chain = append(chain, Middleware{Name: MiddlewareSecurityHeaders, Wrap: func(next http.Handler) http.Handler {
+			return middleware.NewSecurityHeadersHandler(next, server.ServerConfig.SecurityHeaders.MiddlewareOptions())
+		}})
+	}
+
+	ipFilter, err := newIpFilter(point)
+	if err != nil {
+		return nil, err
+	}
+	if ipFilter != nil {
+		chain = append(chain, Middleware{Name: MiddlewareIpFilter, Wrap: ipFilter.wrap})
+	}
+
+	if server.ServerConfig.Options.RateLimit > 0 {
+		chain = append(chain, Middleware{Name: MiddlewareRateLimit, Wrap: func(next http.Handler) http.Handler {
+			return middleware.NewRateLimitHandler(next, &middleware.RateLimitOptions{
+				Rate:  server.ServerConfig.Options.RateLimit,
+				Burst: server.ServerConfig.Options.RateLimitBurst,
+				Key:   server.instanceOptions.RateLimitKey,
+				Skip:  server.isHealthCheckRequest,
+			})
+		}})
+	}
+
+	if server.inFlight != nil {
+		chain = append(chain, Middleware{Name: MiddlewareInFlight, Wrap: server.inFlight.wrap})
+	}
+
+	if server.ServerConfig.Options.Compression {
+		chain = append(chain, Middleware{Name: MiddlewareCompression, Wrap: func(next http.Handler) http.Handler {
+			return middleware.NewCompressionHandlerWithOptions(next, &middleware.CompressionOptions{
+				OnError: func(request *http.Request, err *middleware.CompressionError) {
+					pfxlog.Logger().Errorf("compressed response aborted by server %s: %v", server.ServerConfig.Name, err)
+				},
+				Level:        server.ServerConfig.Options.CompressionLevel,
+				MinSize:      server.ServerConfig.Options.CompressionMinSize,
+				ContentTypes: server.ServerConfig.Options.CompressionContentTypes,
+			})
+		}})
+	}
+
+	chain = append(chain,
+		Middleware{Name: MiddlewarePanicRecovery, Wrap: server.wrapPanicRecovery},
+		Middleware{Name: MiddlewareRequestDeadline, Wrap: func(next http.Handler) http.Handler {
+			return middleware.NewDeadlineHandler(next, server.requestTimeout)
+		}},
+	)
+
+	if server.instanceOptions.HealthChecks {
+		chain = append(chain, Middleware{Name: MiddlewareHealthChecks, Wrap: server.wrapHealthChecks})
+	}
+
+	if server.ServerConfig.Cors != nil {
+		chain = append(chain, Middleware{Name: MiddlewareCors, Wrap: func(next http.Handler) http.Handler {
+			return middleware.NewCorsHandler(next, server.ServerConfig.Cors.MiddlewareOptions())
+		}})
+	}
+
+	chain = append(chain,
+		Middleware{Name: MiddlewareHandlerTimeout, Wrap: server.wrapHandlerTimeouts},
+		Middleware{Name: MiddlewareBodyLimit, Wrap: server.wrapBodyLimits},
+		Middleware{Name: MiddlewareCtrlAddressHeader, Wrap: func(next http.Handler) http.Handler {
+			return server.wrapSetCtrlAddressHeader(point, next)
+		}},
+	)
+
+	if server.apiMiddleware != nil {
+		chain = append(chain, Middleware{Name: MiddlewareApi, Wrap: server.wrapApiMiddleware})
+	}
+
+	return chain, nil
+}
@@ -0,0 +1,144 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// middlewareNames returns the names of the middleware in chain
+func middlewareNames(chain []Middleware) []string {
+	var names []string
+	for _, m := range chain {
+		names = append(names, m.Name)
+	}
+	return names
+}
+
+func TestServer_middleware(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("the default chain matches the enabled options", func(t *testing.T) {
+		req := require.New(t)
+
+		var chain []string
+		instance := newTestInstance(t, id, nil)
+		instance.Config.Options.Middleware = func(_ *Server, _ *BindPointConfig, defaults []Middleware) []Middleware {
+			chain = middlewareNames(defaults)
+			return defaults
+		}
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.Options.AccessLog = true
+		newTestServer(t, instance, serverConfig)
+
+		req.Equal([]string{
+			MiddlewareRequestId,
+			MiddlewareAccessLog,
+			MiddlewareCompression,
+			MiddlewarePanicRecovery,
+			MiddlewareRequestDeadline,
+			MiddlewareHandlerTimeout,
+			MiddlewareBodyLimit,
+			MiddlewareCtrlAddressHeader,
+		}, chain)
+	})
+
+	t.Run("security headers follow the access log when configured", func(t *testing.T) {
+		req := require.New(t)
+
+		var chain []string
+		instance := newTestInstance(t, id, nil)
+		instance.Config.Options.Middleware = func(_ *Server, _ *BindPointConfig, defaults []Middleware) []Middleware {
+			chain = middlewareNames(defaults)
+			return defaults
+		}
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.Options.AccessLog = true
+		serverConfig.SecurityHeaders = &SecurityHeadersConfig{}
+		serverConfig.SecurityHeaders.Default()
+		server := newTestServer(t, instance, serverConfig)
+
+		req.Equal([]string{MiddlewareRequestId, MiddlewareAccessLog, MiddlewareSecurityHeaders}, chain[:3])
+
+		recorder := httptest.NewRecorder()
+		server.httpServers[0].Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/mock-handler", nil))
+		req.Equal("nosniff", recorder.Header().Get("X-Content-Type-Options"))
+		req.Equal("DENY", recorder.Header().Get("X-Frame-Options"))
+	})
+
+	t.Run("custom middleware may be inserted and the chain reordered", func(t *testing.T) {
+		req := require.New(t)
+
+		var calls []string
+		record := func(name string) Middleware {
+			return Middleware{Name: name, Wrap: func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+					calls = append(calls, name)
+					next.ServeHTTP(writer, request)
+				})
+			}}
+		}
+
+		var boundPoint *BindPointConfig
+		instance := newTestInstance(t, id, nil)
+		instance.Config.Options.Middleware = func(server *Server, bindPoint *BindPointConfig, defaults []Middleware) []Middleware {
+			boundPoint = bindPoint
+
+			compression := MiddlewareIndex(defaults, MiddlewareCompression)
+			req.GreaterOrEqual(compression, 0)
+			req.Equal(-1, MiddlewareIndex(defaults, "unknown"))
+
+			chain := []Middleware{record("outer")}
+			chain = append(chain, defaults[:compression+1]...)
+			chain = append(chain, record("afterCompression"))
+			return append(chain, defaults[compression+1:]...)
+		}
+
+		server := newTestServer(t, instance, newTestServerConfig(id, "127.0.0.1:443"))
+		req.Same(server.httpServers[0].BindPointConfig, boundPoint)
+
+		recorder := httptest.NewRecorder()
+		server.httpServers[0].Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/mock-handler", nil))
+
+		req.Equal(http.StatusOK, recorder.Code)
+		req.Equal([]string{"outer", "afterCompression"}, calls)
+	})
+
+	t.Run("middleware may be removed", func(t *testing.T) {
+		req := require.New(t)
+
+		instance := newTestInstance(t, id, nil)
+		instance.Config.Options.Middleware = func(_ *Server, _ *BindPointConfig, defaults []Middleware) []Middleware {
+			compression := MiddlewareIndex(defaults, MiddlewareCompression)
+			return append(defaults[:compression:compression], defaults[compression+1:]...)
+		}
+
+		server := newTestServer(t, instance, newTestServerConfig(id, "127.0.0.1:443"))
+
+		request := httptest.NewRequest(http.MethodGet, "/mock-handler", nil)
+		request.Header.Set("Accept-Encoding", "gzip")
+		recorder := httptest.NewRecorder()
+		server.httpServers[0].Handler.ServeHTTP(recorder, request)
+
+		req.Empty(recorder.Header().Get("Content-Encoding"))
+	})
+}
@@ -0,0 +1,43 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import "os"
+
+// expandConfigEnv returns a copy of a configuration value with ${VAR} and $VAR references in all string values,
+// including those of nested maps and arrays, replaced by the values of the corresponding environment variables.
+// References to unset variables are replaced by an empty string. Map keys are not expanded.
+func expandConfigEnv(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case string:
+		return os.ExpandEnv(typedValue)
+	case map[interface{}]interface{}:
+		result := make(map[interface{}]interface{}, len(typedValue))
+		for key, nestedValue := range typedValue {
+			result[key] = expandConfigEnv(nestedValue)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(typedValue))
+		for i, nestedValue := range typedValue {
+			result[i] = expandConfigEnv(nestedValue)
+		}
+		return result
+	default:
+		return value
+	}
+}
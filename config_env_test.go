@@ -0,0 +1,74 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestInstanceConfig_expandEnv(t *testing.T) {
+	t.Setenv("XWEB_TEST_HOST", "127.0.0.1")
+	t.Setenv("XWEB_TEST_PORT", "8443")
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	newConfigMap := func() map[interface{}]interface{} {
+		return map[interface{}]interface{}{
+			"web": []interface{}{
+				map[interface{}]interface{}{
+					"name": "env",
+					"bindPoints": []interface{}{
+						map[interface{}]interface{}{
+							"interface": "${XWEB_TEST_HOST}:$XWEB_TEST_PORT",
+							"address":   "$XWEB_TEST_HOST:${XWEB_TEST_PORT}",
+						},
+					},
+					"apis": []interface{}{
+						map[interface{}]interface{}{"binding": "mockHandler"},
+					},
+				},
+			},
+		}
+	}
+
+	t.Run("references are expanded when enabled", func(t *testing.T) {
+		req := require.New(t)
+
+		instance := newTestInstance(t, id, nil)
+		instance.Config.Options.ExpandEnv = true
+
+		configMap := newConfigMap()
+		req.NoError(instance.Config.Parse(configMap))
+
+		bindPoint := instance.Config.ServerConfigs[0].BindPoints[0]
+		req.Equal("127.0.0.1:8443", bindPoint.InterfaceAddress)
+		req.Equal("127.0.0.1:8443", bindPoint.Address)
+
+		//the supplied configuration map is not modified
+		req.Equal(newConfigMap(), configMap)
+	})
+
+	t.Run("references are taken literally by default", func(t *testing.T) {
+		req := require.New(t)
+
+		instance := newTestInstance(t, id, nil)
+		req.NoError(instance.Config.Parse(newConfigMap()))
+
+		req.Equal("${XWEB_TEST_HOST}:$XWEB_TEST_PORT", instance.Config.ServerConfigs[0].BindPoints[0].InterfaceAddress)
+	})
+}
@@ -0,0 +1,177 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"crypto/tls"
+	"golang.org/x/net/http2"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// connTimeouts are the read, read header, write, and idle timeouts of a Server from its TimeoutOptions. A timeout of
+// zero is unlimited.
+type connTimeouts struct {
+	read       time.Duration
+	readHeader time.Duration
+	write      time.Duration
+	idle       time.Duration
+}
+
+// newConnTimeouts returns the connTimeouts for the supplied options. As for http.Server, the read timeout is used if
+// the read header or idle timeout is zero.
+func newConnTimeouts(options *Options) *connTimeouts {
+	timeouts := &connTimeouts{
+		read:       options.ReadTimeout,
+		readHeader: options.ReadHeaderTimeout,
+		write:      options.WriteTimeout,
+		idle:       options.IdleTimeout,
+	}
+
+	if timeouts.readHeader <= 0 {
+		timeouts.readHeader = timeouts.read
+	}
+
+	if timeouts.idle <= 0 {
+		timeouts.idle = timeouts.read
+	}
+
+	return timeouts
+}
+
+// connDeadlines applies the connTimeouts of a Server to the connections of its http.Server's as deadlines. The
+// timeouts of a http.Server must not be changed while it is serving, so they are left unset and the current
+// connTimeouts are applied instead: the read header timeout to new connections via onConnState, the read and write
+// timeouts to each request via wrap, and the idle timeout to idle connections via onConnState.
+type connDeadlines struct {
+	timeouts atomic.Pointer[connTimeouts]
+
+	lock       sync.Mutex
+	idleTimers map[net.Conn]*time.Timer
+}
+
+func newConnDeadlines(options *Options) *connDeadlines {
+	deadlines := &connDeadlines{
+		idleTimers: map[net.Conn]*time.Timer{},
+	}
+
+	deadlines.timeouts.Store(newConnTimeouts(options))
+
+	return deadlines
+}
+
+// onConnState is a http.Server ConnState hook. New connections must send their first request within the read header
+// timeout, idle connections are closed once they were idle for the idle timeout.
+func (deadlines *connDeadlines) onConnState(conn net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		if timeout := deadlines.timeouts.Load().readHeader; timeout > 0 {
+			_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		}
+	case http.StateActive:
+		deadlines.stopIdleTimer(conn)
+
+		//HTTP/2 connections read frames until they are closed, requests are limited by their stream deadlines
+		if isHttp2Conn(conn) {
+			_ = conn.SetReadDeadline(time.Time{})
+		}
+	case http.StateIdle:
+		deadlines.startIdleTimer(conn)
+	case http.StateClosed, http.StateHijacked:
+		deadlines.stopIdleTimer(conn)
+	}
+}
+
+// startIdleTimer starts a timer that aborts reading the next request on conn once the idle timeout elapses, unless
+// conn becomes active first.
+func (deadlines *connDeadlines) startIdleTimer(conn net.Conn) {
+	timeout := deadlines.timeouts.Load().idle
+
+	deadlines.lock.Lock()
+	defer deadlines.lock.Unlock()
+
+	if timer := deadlines.idleTimers[conn]; timer != nil {
+		timer.Stop()
+		delete(deadlines.idleTimers, conn)
+	}
+
+	if timeout <= 0 {
+		return
+	}
+
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		deadlines.lock.Lock()
+		defer deadlines.lock.Unlock()
+
+		if deadlines.idleTimers[conn] == timer {
+			delete(deadlines.idleTimers, conn)
+			_ = conn.SetReadDeadline(time.Unix(1, 0))
+		}
+	})
+
+	deadlines.idleTimers[conn] = timer
+}
+
+// stopIdleTimer stops the idle timer of conn, if any.
+func (deadlines *connDeadlines) stopIdleTimer(conn net.Conn) {
+	deadlines.lock.Lock()
+	defer deadlines.lock.Unlock()
+
+	if timer := deadlines.idleTimers[conn]; timer != nil {
+		timer.Stop()
+		delete(deadlines.idleTimers, conn)
+	}
+}
+
+// wrap returns handler wrapped with a http.Handler that limits the time to read each request's body to the read
+// timeout and the time to write its response to the write timeout, both measured from when the request's headers
+// were read.
+func (deadlines *connDeadlines) wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if deadliner, ok := writer.(responseDeadliner); ok {
+			timeouts := deadlines.timeouts.Load()
+			now := time.Now()
+
+			if timeouts.read > 0 {
+				_ = deadliner.SetReadDeadline(now.Add(timeouts.read))
+			}
+
+			if timeouts.write > 0 {
+				_ = deadliner.SetWriteDeadline(now.Add(timeouts.write))
+			}
+		}
+
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+// responseDeadliner is implemented by the http.ResponseWriter's of net/http and golang.org/x/net/http2, see
+// http.ResponseController.
+type responseDeadliner interface {
+	SetReadDeadline(deadline time.Time) error
+	SetWriteDeadline(deadline time.Time) error
+}
+
+// isHttp2Conn returns true if conn is a TLS connection that negotiated HTTP/2.
+func isHttp2Conn(conn net.Conn) bool {
+	tlsConn, ok := conn.(*tls.Conn)
+	return ok && tlsConn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS
+}
@@ -0,0 +1,234 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServer_connTimeouts(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	start := func(t *testing.T, handler http.HandlerFunc, options func(*Options)) *Server {
+		serverConfig := newTestServerConfig(id, freeAddress(t))
+		options(&serverConfig.Options)
+
+		server := newTestServer(t, newTestInstance(t, id, &funcHandler{handlerFunc: handler}), serverConfig)
+		startTestServer(t, server)
+		t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+		return server
+	}
+
+	ok := func(writer http.ResponseWriter, _ *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}
+
+	//closedWithin returns true if the server closes conn within timeout
+	closedWithin := func(conn *tls.Conn, timeout time.Duration) bool {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		_, err := conn.Read(make([]byte, 1))
+		return err == io.EOF
+	}
+
+	//get sends a request on conn and reads its response
+	get := func(t *testing.T, conn *tls.Conn) {
+		req := require.New(t)
+
+		_, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n"))
+		req.NoError(err)
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		req.NoError(err)
+		_ = resp.Body.Close()
+		req.Equal(http.StatusOK, resp.StatusCode)
+	}
+
+	dial := func(t *testing.T, server *Server) *tls.Conn {
+		conn, err := tls.Dial("tcp", server.httpServers[0].Addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}})
+		require.New(t).NoError(err)
+		t.Cleanup(func() { _ = conn.Close() })
+
+		return conn
+	}
+
+	t.Run("the http.Server's have no timeouts", func(t *testing.T) {
+		req := require.New(t)
+
+		server := newTestServer(t, newTestInstance(t, id, nil), newTestServerConfig(id, "127.0.0.1:443"))
+
+		req.Zero(server.httpServers[0].ReadTimeout)
+		req.Zero(server.httpServers[0].ReadHeaderTimeout)
+		req.Zero(server.httpServers[0].WriteTimeout)
+		req.Zero(server.httpServers[0].IdleTimeout)
+	})
+
+	t.Run("new connections are closed after the read header timeout", func(t *testing.T) {
+		server := start(t, ok, func(options *Options) {
+			options.ReadHeaderTimeout = 100 * time.Millisecond
+		})
+
+		require.New(t).True(closedWithin(dial(t, server), 5*time.Second))
+	})
+
+	t.Run("idle connections are closed after the idle timeout", func(t *testing.T) {
+		req := require.New(t)
+
+		server := start(t, ok, func(options *Options) {
+			options.IdleTimeout = 100 * time.Millisecond
+		})
+
+		conn := dial(t, server)
+		get(t, conn)
+
+		idleSince := time.Now()
+		req.True(closedWithin(conn, 5*time.Second))
+		req.GreaterOrEqual(time.Since(idleSince), 100*time.Millisecond)
+	})
+
+	t.Run("responses are limited by the write timeout", func(t *testing.T) {
+		req := require.New(t)
+
+		server := start(t, func(writer http.ResponseWriter, _ *http.Request) {
+			time.Sleep(300 * time.Millisecond)
+			_, _ = writer.Write([]byte(strings.Repeat("x", 1<<20)))
+		}, func(options *Options) {
+			options.WriteTimeout = 100 * time.Millisecond
+		})
+
+		resp, err := newTestClient().Get("https://" + server.httpServers[0].Addr + "/")
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+		}
+		req.Error(err)
+	})
+
+	t.Run("HTTP/2 connections are not closed by the read header timeout", func(t *testing.T) {
+		req := require.New(t)
+
+		server := start(t, ok, func(options *Options) {
+			options.ReadHeaderTimeout = 100 * time.Millisecond
+		})
+
+		client := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				ForceAttemptHTTP2: true,
+			},
+		}
+		defer client.CloseIdleConnections()
+
+		reused := false
+		getWithTrace := func() {
+			trace := &httptrace.ClientTrace{GotConn: func(info httptrace.GotConnInfo) { reused = info.Reused }}
+			request, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, "https://"+server.httpServers[0].Addr+"/", nil)
+			req.NoError(err)
+
+			resp, err := client.Do(request)
+			req.NoError(err)
+			_ = resp.Body.Close()
+			req.Equal(2, resp.ProtoMajor)
+		}
+
+		getWithTrace()
+		time.Sleep(300 * time.Millisecond)
+		getWithTrace()
+		req.True(reused)
+	})
+
+	t.Run("updated timeouts apply to connections that become idle", func(t *testing.T) {
+		req := require.New(t)
+
+		server := start(t, ok, func(options *Options) {
+			options.IdleTimeout = time.Minute
+		})
+
+		options := server.ServerConfig.Options
+		options.IdleTimeout = 100 * time.Millisecond
+		req.NoError(server.UpdateOptions(options))
+
+		conn := dial(t, server)
+		get(t, conn)
+		req.True(closedWithin(conn, 5*time.Second))
+	})
+
+	t.Run("timeouts may be updated while requests are in flight", func(t *testing.T) {
+		req := require.New(t)
+
+		server := start(t, func(writer http.ResponseWriter, request *http.Request) {
+			_, _ = io.ReadAll(request.Body)
+			writer.WriteHeader(http.StatusOK)
+		}, func(*Options) {})
+
+		client := newTestClient()
+		defer client.CloseIdleConnections()
+
+		done := make(chan struct{})
+		waitGroup := sync.WaitGroup{}
+		served := atomic.Int64{}
+
+		for i := 0; i < 4; i++ {
+			waitGroup.Add(1)
+			go func() {
+				defer waitGroup.Done()
+
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+
+					resp, err := client.Post("https://"+server.httpServers[0].Addr+"/", "text/plain", strings.NewReader("body"))
+					if err == nil {
+						_ = resp.Body.Close()
+						served.Add(1)
+					}
+				}
+			}()
+		}
+
+		for i := 1; i <= 20; i++ {
+			options := server.ServerConfig.Options
+			options.ReadTimeout = time.Duration(i) * time.Second
+			options.ReadHeaderTimeout = time.Duration(i) * time.Second
+			options.WriteTimeout = time.Duration(i) * time.Second
+			options.IdleTimeout = time.Duration(i) * time.Second
+			req.NoError(server.UpdateOptions(options))
+
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		close(done)
+		waitGroup.Wait()
+
+		req.Positive(served.Load())
+		req.Equal(20*time.Second, server.connDeadlines.timeouts.Load().idle)
+	})
+}
@@ -0,0 +1,114 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// connectionLimiter counts the open connections of a bind point and, if limit is greater than zero, limits them to
+// limit. Connections are counted when they are accepted by a connectionLimitListener and released when their
+// http.Server reports them closed or hijacked via onConnState. Connections are not wrapped so that the http.Server
+// still sees the *tls.Conn's of TLS listeners.
+type connectionLimiter struct {
+	slots chan struct{}
+	count atomic.Int64
+}
+
+func newConnectionLimiter(limit int) *connectionLimiter {
+	result := &connectionLimiter{}
+
+	if limit > 0 {
+		result.slots = make(chan struct{}, limit)
+	}
+
+	return result
+}
+
+// acquire blocks until a connection may be accepted or closeNotify is closed, in which case false is returned.
+func (limiter *connectionLimiter) acquire(closeNotify <-chan struct{}) bool {
+	if limiter.slots == nil {
+		return true
+	}
+
+	select {
+	case limiter.slots <- struct{}{}:
+		return true
+	case <-closeNotify:
+		return false
+	}
+}
+
+func (limiter *connectionLimiter) release() {
+	if limiter.slots != nil {
+		<-limiter.slots
+	}
+}
+
+// onConnState is a http.Server ConnState hook that releases connections that are closed or hijacked.
+func (limiter *connectionLimiter) onConnState(_ net.Conn, state http.ConnState) {
+	if state == http.StateClosed || state == http.StateHijacked {
+		limiter.count.Add(-1)
+		limiter.release()
+	}
+}
+
+// connectionLimitListener is a net.Listener that counts accepted connections with a connectionLimiter. Accept blocks
+// while the limit of the connectionLimiter is reached.
+type connectionLimitListener struct {
+	net.Listener
+	limiter *connectionLimiter
+
+	closeOnce   sync.Once
+	closeNotify chan struct{}
+}
+
+func newConnectionLimitListener(listener net.Listener, limiter *connectionLimiter) *connectionLimitListener {
+	return &connectionLimitListener{
+		Listener:    listener,
+		limiter:     limiter,
+		closeNotify: make(chan struct{}),
+	}
+}
+
+func (listener *connectionLimitListener) Accept() (net.Conn, error) {
+	if !listener.limiter.acquire(listener.closeNotify) {
+		return nil, net.ErrClosed
+	}
+
+	conn, err := listener.Listener.Accept()
+
+	if err != nil {
+		listener.limiter.release()
+		return nil, err
+	}
+
+	listener.limiter.count.Add(1)
+
+	return conn, nil
+}
+
+func (listener *connectionLimitListener) Close() error {
+	listener.closeOnce.Do(func() {
+		close(listener.closeNotify)
+	})
+
+	return listener.Listener.Close()
+}
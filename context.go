@@ -19,15 +19,17 @@ package xweb
 import "context"
 
 const (
-	HandlerContextKey = ContextKey("xweb.ApiHandler.ContextKey")
-	ServerContextKey  = ContextKey("xweb.Server.ContextKey")
+	HandlerContextKey  = ContextKey("xweb.ApiHandler.ContextKey")
+	ServerContextKey   = ContextKey("xweb.Server.ContextKey")
+	ShutdownContextKey = ContextKey("xweb.Shutdown.ContextKey")
 )
 
-// HandlerFromRequestContext us a utility function to retrieve a ApiHandler reference, that the demux http.Handler
-// deferred to, during downstream  http.Handler processing from the http.Request context.
-func HandlerFromRequestContext(ctx context.Context) *ApiHandler {
+// HandlerFromRequestContext is a utility function to retrieve the ApiHandler, that the demux http.Handler deferred to,
+// during downstream http.Handler processing from the http.Request context. Returns nil if the request has not been
+// routed to an ApiHandler.
+func HandlerFromRequestContext(ctx context.Context) ApiHandler {
 	if val := ctx.Value(HandlerContextKey); val != nil {
-		if handler, ok := val.(*ApiHandler); ok {
+		if handler, ok := val.(ApiHandler); ok {
 			return handler
 		}
 	}
@@ -44,3 +46,26 @@ func ServerContextFromRequestContext(ctx context.Context) *ServerContext {
 	}
 	return nil
 }
+
+// ShutdownContextFromRequestContext is a utility function to retrieve the shutdown context of the Server handling a
+// request. It is canceled when the Server starts shutting down, allowing long-running handlers to abort early (e.g.
+// with a 503 Service Unavailable) instead of running until their connection is closed. Returns nil if the request is
+// not handled by a Server.
+func ShutdownContextFromRequestContext(ctx context.Context) context.Context {
+	if val := ctx.Value(ShutdownContextKey); val != nil {
+		if shutdownCtx, ok := val.(context.Context); ok {
+			return shutdownCtx
+		}
+	}
+	return nil
+}
+
+// BindPointNameFromRequestContext is a utility function to retrieve the name of the bind point that accepted a request
+// (see BindPointConfig.GetName), e.g. so that an ApiHandler served on several bind points can apply per bind point
+// policy. Returns an empty string if the request is not handled by a Server.
+func BindPointNameFromRequestContext(ctx context.Context) string {
+	if serverContext := ServerContextFromRequestContext(ctx); serverContext != nil {
+		return serverContext.BindPointName()
+	}
+	return ""
+}
@@ -0,0 +1,96 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandlerFromRequestContext(t *testing.T) {
+	t.Run("returns nil for requests that were not routed", func(t *testing.T) {
+		require.New(t).Nil(HandlerFromRequestContext(context.Background()))
+	})
+
+	t.Run("returns the ApiHandler the demux selected", func(t *testing.T) {
+		req := require.New(t)
+
+		var found ApiHandler
+		selected := &routingHandler{
+			ApiHandler: &pathHandler{name: "a", rootPath: "/a"},
+			next: http.HandlerFunc(func(_ http.ResponseWriter, request *http.Request) {
+				found = HandlerFromRequestContext(request.Context())
+			}),
+		}
+
+		demux, err := (&PathPrefixDemuxFactory{}).Build([]ApiHandler{selected, &pathHandler{name: "b", rootPath: "/b"}})
+		req.NoError(err)
+
+		demux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/a/x", nil))
+
+		req.NotNil(found)
+		req.Same(selected, found)
+	})
+}
+
+func TestBindPointNameFromRequestContext(t *testing.T) {
+	t.Run("returns an empty string for requests not handled by a server", func(t *testing.T) {
+		require.New(t).Empty(BindPointNameFromRequestContext(context.Background()))
+	})
+
+	t.Run("identifies the bind point that accepted the request", func(t *testing.T) {
+		req := require.New(t)
+		id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+		var found []string
+		handler := &funcHandler{handlerFunc: func(_ http.ResponseWriter, request *http.Request) {
+			found = append(found, BindPointNameFromRequestContext(request.Context()))
+		}}
+
+		instance := newTestInstance(t, id, handler)
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.BindPoints[0].Name = "public"
+		serverConfig.BindPoints = append(serverConfig.BindPoints, &BindPointConfig{InterfaceAddress: "127.0.0.1:444", Address: "127.0.0.1:444"})
+		req.NoError(serverConfig.Validate(instance.GetRegistry()))
+
+		server := newTestServer(t, instance, serverConfig)
+
+		for _, httpServer := range server.httpServers {
+			request := httptest.NewRequest(http.MethodGet, "/mock-handler", nil).WithContext(httpServer.NewBaseContext(nil))
+			httpServer.Handler.ServeHTTP(httptest.NewRecorder(), request)
+		}
+
+		req.Equal([]string{"public", "127.0.0.1:444"}, found)
+	})
+
+	t.Run("bind point names must be unique", func(t *testing.T) {
+		req := require.New(t)
+		id, _, _ := newTestIdentity(t, "127.0.0.1")
+		instance := newTestInstance(t, id, nil)
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.BindPoints = append(serverConfig.BindPoints, &BindPointConfig{Name: "127.0.0.1:443", InterfaceAddress: "127.0.0.1:444", Address: "127.0.0.1:444"})
+
+		err := serverConfig.Validate(instance.GetRegistry())
+		req.Error(err)
+		req.Contains(err.Error(), "name [127.0.0.1:443] is already used by the address at index [0]")
+	})
+}
@@ -0,0 +1,148 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"github.com/openziti/xweb/v2/middleware"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+// CorsConfig represents the CORS settings of a ServerConfig. When present, CORS headers are added to responses for
+// allowed origins and CORS preflight requests are answered before they reach any ApiHandler.
+type CorsConfig struct {
+	AllowedOrigins   []string
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// Parse the configuration map for a CorsConfig.
+func (cors *CorsConfig) Parse(config map[interface{}]interface{}) error {
+	var err error
+
+	if cors.AllowedOrigins, err = parseStringList(config, "allowedOrigins"); err != nil {
+		return err
+	}
+
+	if cors.AllowedMethods, err = parseStringList(config, "allowedMethods"); err != nil {
+		return err
+	}
+
+	if cors.AllowedHeaders, err = parseStringList(config, "allowedHeaders"); err != nil {
+		return err
+	}
+
+	if interfaceVal, ok := config["allowCredentials"]; ok {
+		if allowCredentials, ok := interfaceVal.(bool); ok {
+			cors.AllowCredentials = allowCredentials
+		} else {
+			return errors.New("could not use value for allowCredentials, not a boolean")
+		}
+	}
+
+	if interfaceVal, ok := config["maxAge"]; ok {
+		if maxAgeStr, ok := interfaceVal.(string); ok {
+			if maxAge, err := time.ParseDuration(maxAgeStr); err == nil {
+				cors.MaxAge = maxAge
+			} else {
+				return fmt.Errorf("could not parse maxAge %s as a duration (e.g. 1m): %v", maxAgeStr, err)
+			}
+		} else {
+			return errors.New("could not use value for maxAge, not a string")
+		}
+	}
+
+	return nil
+}
+
+// Validate this configuration object.
+func (cors *CorsConfig) Validate() error {
+	if len(cors.AllowedOrigins) == 0 {
+		return errors.New("allowedOrigins must specify at least one origin")
+	}
+
+	for i, origin := range cors.AllowedOrigins {
+		if strings.TrimSpace(origin) == "" {
+			return fmt.Errorf("invalid allowedOrigins value at index [%d]: must not be empty", i)
+		}
+
+		if strings.Count(origin, middleware.CorsWildcard) > 1 {
+			return fmt.Errorf("invalid allowedOrigins value [%s]: must contain at most one wildcard", origin)
+		}
+
+		//credentialed requests from any origin would be allowed, as the request's origin is echoed back
+		if origin == middleware.CorsWildcard && cors.AllowCredentials {
+			return fmt.Errorf("invalid allowedOrigins value [%s]: must not allow any origin if allowCredentials is true", origin)
+		}
+	}
+
+	for i, method := range cors.AllowedMethods {
+		if strings.TrimSpace(method) == "" {
+			return fmt.Errorf("invalid allowedMethods value at index [%d]: must not be empty", i)
+		}
+	}
+
+	if cors.MaxAge < 0 {
+		return fmt.Errorf("value [%s] for maxAge too low, must not be negative", cors.MaxAge.String())
+	}
+
+	return nil
+}
+
+// MiddlewareOptions returns the middleware.CorsOptions equivalent of this configuration.
+func (cors *CorsConfig) MiddlewareOptions() *middleware.CorsOptions {
+	return &middleware.CorsOptions{
+		AllowedOrigins:   cors.AllowedOrigins,
+		AllowedMethods:   cors.AllowedMethods,
+		AllowedHeaders:   cors.AllowedHeaders,
+		AllowCredentials: cors.AllowCredentials,
+		MaxAge:           cors.MaxAge,
+	}
+}
+
+// parseStringList parses the optional list of strings at key from a configuration map.
+func parseStringList(config map[interface{}]interface{}, key string) ([]string, error) {
+	interfaceVal, ok := config[key]
+
+	if !ok {
+		return nil, nil
+	}
+
+	interfaceVals, ok := interfaceVal.([]interface{})
+
+	if !ok {
+		return nil, fmt.Errorf("could not use value for %s, not an array", key)
+	}
+
+	var result []string
+
+	for i, val := range interfaceVals {
+		str, ok := val.(string)
+
+		if !ok {
+			return nil, fmt.Errorf("could not use value for %s at index [%d], not a string", key, i)
+		}
+
+		result = append(result, str)
+	}
+
+	return result, nil
+}
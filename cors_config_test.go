@@ -0,0 +1,68 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestCorsConfig_Parse(t *testing.T) {
+	t.Run("all values are parsed", func(t *testing.T) {
+		req := require.New(t)
+		cors := &CorsConfig{}
+
+		req.NoError(cors.Parse(map[interface{}]interface{}{
+			"allowedOrigins":   []interface{}{"https://app.example.com", "https://*.example.org"},
+			"allowedMethods":   []interface{}{"GET", "POST"},
+			"allowedHeaders":   []interface{}{"Authorization"},
+			"allowCredentials": true,
+			"maxAge":           "5m",
+		}))
+		req.NoError(cors.Validate())
+
+		req.Equal([]string{"https://app.example.com", "https://*.example.org"}, cors.AllowedOrigins)
+		req.Equal([]string{"GET", "POST"}, cors.AllowedMethods)
+		req.Equal([]string{"Authorization"}, cors.AllowedHeaders)
+		req.True(cors.AllowCredentials)
+		req.Equal(5*time.Minute, cors.MaxAge)
+	})
+
+	t.Run("non-string origins are rejected", func(t *testing.T) {
+		cors := &CorsConfig{}
+		require.New(t).Error(cors.Parse(map[interface{}]interface{}{
+			"allowedOrigins": []interface{}{1},
+		}))
+	})
+
+	t.Run("at least one origin is required", func(t *testing.T) {
+		cors := &CorsConfig{}
+		require.New(t).Error(cors.Validate())
+	})
+
+	t.Run("any origin is rejected with credentials", func(t *testing.T) {
+		req := require.New(t)
+		cors := &CorsConfig{AllowedOrigins: []string{"*"}}
+		req.NoError(cors.Validate())
+
+		cors.AllowCredentials = true
+		err := cors.Validate()
+		req.Error(err)
+		req.Contains(err.Error(), "must not allow any origin if allowCredentials is true")
+	})
+}
@@ -0,0 +1,272 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/michaelquigley/pfxlog"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	crlRequestTimeout   = time.Second * 10
+	crlMaxResponseBytes = 16 * 1024 * 1024
+)
+
+// crlChecker rejects client certificates listed as revoked by a certificate revocation list (CRL) loaded from a file
+// or an http(s) URL. CRLs loaded from URLs are refreshed on a background goroutine. If a refresh fails, the previous
+// CRL remains in use. Once the next update time of the current CRL has passed, client certificates of its issuer are
+// rejected until a newer CRL is loaded.
+type crlChecker struct {
+	name      string
+	source    string
+	interval  time.Duration
+	clientCas []*x509.Certificate
+	client    *http.Client
+
+	lock    sync.RWMutex
+	crl     *x509.RevocationList
+	revoked map[string]struct{}
+
+	startOnce   sync.Once
+	stopOnce    sync.Once
+	closeNotify chan struct{}
+}
+
+// newCrlChecker creates a crlChecker for the CRL at source, loading it immediately. If clientCas are supplied, each
+// CRL loaded must be signed by one of them.
+func newCrlChecker(name string, source string, interval time.Duration, clientCas []*x509.Certificate) (*crlChecker, error) {
+	checker := &crlChecker{
+		name:        name,
+		source:      source,
+		interval:    interval,
+		clientCas:   clientCas,
+		client:      &http.Client{Timeout: crlRequestTimeout},
+		closeNotify: make(chan struct{}),
+	}
+
+	if err := checker.refresh(); err != nil {
+		return nil, err
+	}
+
+	return checker, nil
+}
+
+// Start begins refreshing URL based CRLs on a background goroutine. Subsequent calls have no effect.
+func (checker *crlChecker) Start() {
+	if !isCrlUrl(checker.source) {
+		return
+	}
+
+	checker.startOnce.Do(func() {
+		go checker.run()
+	})
+}
+
+// Stop ends the background refresh goroutine. Subsequent calls have no effect.
+func (checker *crlChecker) Stop() {
+	checker.stopOnce.Do(func() {
+		close(checker.closeNotify)
+	})
+}
+
+func (checker *crlChecker) run() {
+	for {
+		select {
+		case <-time.After(checker.interval):
+			if err := checker.refresh(); err != nil {
+				pfxlog.Logger().WithField("server", checker.name).Warnf("could not refresh CRL from [%s], continuing to use the previous CRL: %v", checker.source, err)
+			}
+		case <-checker.closeNotify:
+			return
+		}
+	}
+}
+
+// refresh loads the CRL from its source and replaces the current one
+func (checker *crlChecker) refresh() error {
+	var crl *x509.RevocationList
+	var err error
+
+	if isCrlUrl(checker.source) {
+		crl, err = checker.fetch()
+	} else {
+		crl, err = loadCrlFile(checker.source)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if err = checker.verifySignature(crl); err != nil {
+		return err
+	}
+
+	if isCrlExpired(crl) {
+		pfxlog.Logger().WithField("server", checker.name).Errorf("CRL from [%s] was due to be updated at %s, client certificates issued by [%s] will be rejected until an updated CRL is loaded", checker.source, crl.NextUpdate.Format(time.RFC3339), crl.Issuer.String())
+	}
+
+	revoked := map[string]struct{}{}
+	for _, revokedCert := range crl.RevokedCertificates {
+		revoked[revokedCert.SerialNumber.String()] = struct{}{}
+	}
+
+	checker.lock.Lock()
+	checker.crl = crl
+	checker.revoked = revoked
+	checker.lock.Unlock()
+
+	return nil
+}
+
+// verifySignature returns an error if clientCas were supplied and crl is not signed by any of them. This covers
+// clients whose certificate chains are not verified, for which verifyPeerCertificate has no issuer to check the CRL
+// against.
+func (checker *crlChecker) verifySignature(crl *x509.RevocationList) error {
+	if len(checker.clientCas) == 0 {
+		return nil
+	}
+
+	for _, clientCa := range checker.clientCas {
+		if bytes.Equal(clientCa.RawSubject, crl.RawIssuer) && crl.CheckSignatureFrom(clientCa) == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("CRL issued by [%s] is not signed by any of the client CAs", crl.Issuer.String())
+}
+
+// fetch requests the CRL from its URL source
+func (checker *crlChecker) fetch() (*x509.RevocationList, error) {
+	response, err := checker.client.Get(checker.source)
+	if err != nil {
+		return nil, fmt.Errorf("could not contact CRL server [%s]: %v", checker.source, err)
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CRL server [%s] returned status %d", checker.source, response.StatusCode)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(response.Body, crlMaxResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("could not read CRL: %v", err)
+	}
+
+	return parseCrl(raw)
+}
+
+// verifyPeerCertificate is suitable for tls.Config.VerifyPeerCertificate. It returns an error, failing the handshake
+// with a bad_certificate alert, if the client's leaf certificate is issued by the issuer of the CRL and its serial
+// number is listed as revoked or the next update time of the CRL has passed. If the client's certificate chain was
+// verified, the CRL must be signed by the issuer in the chain.
+func (checker *crlChecker) verifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return nil
+	}
+
+	var leaf, issuer *x509.Certificate
+
+	if len(verifiedChains) > 0 && len(verifiedChains[0]) > 0 {
+		leaf = verifiedChains[0][0]
+
+		if len(verifiedChains[0]) > 1 {
+			issuer = verifiedChains[0][1]
+		}
+	} else {
+		var err error
+		if leaf, err = x509.ParseCertificate(rawCerts[0]); err != nil {
+			return fmt.Errorf("could not parse client certificate: %v", err)
+		}
+	}
+
+	checker.lock.RLock()
+	crl := checker.crl
+	revoked := checker.revoked
+	checker.lock.RUnlock()
+
+	if !bytes.Equal(leaf.RawIssuer, crl.RawIssuer) {
+		return nil
+	}
+
+	if issuer != nil {
+		if err := crl.CheckSignatureFrom(issuer); err != nil {
+			return fmt.Errorf("could not verify CRL for issuer [%s] of client certificate [%s]: %v", issuer.Subject.CommonName, leaf.Subject.CommonName, err)
+		}
+	}
+
+	if isCrlExpired(crl) {
+		return fmt.Errorf("could not check revocation of client certificate [%s]: the CRL of issuer [%s] was due to be updated at %s", leaf.Subject.CommonName, crl.Issuer.String(), crl.NextUpdate.Format(time.RFC3339))
+	}
+
+	if _, ok := revoked[leaf.SerialNumber.String()]; ok {
+		return fmt.Errorf("client certificate [%s] with serial number [%s] has been revoked", leaf.Subject.CommonName, leaf.SerialNumber.String())
+	}
+
+	return nil
+}
+
+// isCrlExpired returns true if crl has a next update time and it has passed
+func isCrlExpired(crl *x509.RevocationList) bool {
+	return !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate)
+}
+
+// isCrlUrl returns true if source is an http(s) URL rather than a file path
+func isCrlUrl(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// loadCrlFile loads a PEM or DER encoded CRL from the file at path
+func loadCrlFile(path string) (*x509.RevocationList, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read CRL file [%s]: %v", path, err)
+	}
+
+	return parseCrl(raw)
+}
+
+// parseCrl parses a PEM or DER encoded CRL
+func parseCrl(raw []byte) (*x509.RevocationList, error) {
+	if block, _ := pem.Decode(raw); block != nil {
+		if block.Type != "X509 CRL" {
+			return nil, fmt.Errorf("unexpected PEM block type [%s], expected X509 CRL", block.Type)
+		}
+
+		raw = block.Bytes
+	}
+
+	if len(raw) == 0 {
+		return nil, errors.New("CRL is empty")
+	}
+
+	crl, err := x509.ParseRevocationList(raw)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse CRL: %v", err)
+	}
+
+	return crl, nil
+}
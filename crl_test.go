@@ -0,0 +1,280 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// crlTestCa is a CA able to issue client certificates and CRLs revoking them
+type crlTestCa struct {
+	t    *testing.T
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newCrlTestCa(t *testing.T, commonName string) *crlTestCa {
+	req := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	req.NoError(err)
+
+	cert, err := x509.ParseCertificate(der)
+	req.NoError(err)
+
+	return &crlTestCa{t: t, cert: cert, key: key}
+}
+
+// issue creates a client certificate with the supplied serial number
+func (ca *crlTestCa) issue(serial int64) tls.Certificate {
+	req := require.New(ca.t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca.cert, &key.PublicKey, ca.key)
+	req.NoError(err)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// crl creates a PEM encoded CRL revoking the supplied serial numbers
+func (ca *crlTestCa) crl(number int64, revoked ...int64) []byte {
+	return ca.crlUntil(time.Now().Add(time.Hour), number, revoked...)
+}
+
+// crlUntil creates a PEM encoded CRL with the supplied next update time revoking the supplied serial numbers
+func (ca *crlTestCa) crlUntil(nextUpdate time.Time, number int64, revoked ...int64) []byte {
+	var revokedCerts []pkix.RevokedCertificate
+	for _, serial := range revoked {
+		revokedCerts = append(revokedCerts, pkix.RevokedCertificate{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(number),
+		ThisUpdate:          time.Now().Add(-time.Hour),
+		NextUpdate:          nextUpdate,
+		RevokedCertificates: revokedCerts,
+	}, ca.cert, ca.key)
+	require.New(ca.t).NoError(err)
+
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func Test_crlChecker(t *testing.T) {
+	ca := newCrlTestCa(t, "client-ca")
+	good := ca.issue(10)
+	revoked := ca.issue(11)
+
+	verify := func(checker *crlChecker, cert tls.Certificate) error {
+		return checker.verifyPeerCertificate(cert.Certificate, nil)
+	}
+
+	t.Run("revoked certificates are rejected", func(t *testing.T) {
+		req := require.New(t)
+
+		crlFile := filepath.Join(t.TempDir(), "crl.pem")
+		req.NoError(os.WriteFile(crlFile, ca.crl(1, 11), 0600))
+
+		checker, err := newCrlChecker("test", crlFile, time.Hour, nil)
+		req.NoError(err)
+
+		req.NoError(verify(checker, good))
+		req.ErrorContains(verify(checker, revoked), "client certificate [client] with serial number [11] has been revoked")
+	})
+
+	t.Run("certificates of other issuers are not checked", func(t *testing.T) {
+		req := require.New(t)
+
+		crlFile := filepath.Join(t.TempDir(), "crl.pem")
+		req.NoError(os.WriteFile(crlFile, ca.crl(1, 11), 0600))
+
+		checker, err := newCrlChecker("test", crlFile, time.Hour, nil)
+		req.NoError(err)
+
+		req.NoError(verify(checker, newCrlTestCa(t, "other-ca").issue(11)))
+	})
+
+	t.Run("certificates are rejected once the crl is due to be updated", func(t *testing.T) {
+		req := require.New(t)
+
+		crlFile := filepath.Join(t.TempDir(), "crl.pem")
+		req.NoError(os.WriteFile(crlFile, ca.crlUntil(time.Now().Add(-time.Minute), 1, 11), 0600))
+
+		checker, err := newCrlChecker("test", crlFile, time.Hour, nil)
+		req.NoError(err)
+
+		req.ErrorContains(verify(checker, good), "the CRL of issuer [CN=client-ca] was due to be updated")
+		req.NoError(verify(checker, newCrlTestCa(t, "other-ca").issue(10)))
+
+		req.NoError(os.WriteFile(crlFile, ca.crl(2, 11), 0600))
+		req.NoError(checker.refresh())
+		req.NoError(verify(checker, good))
+	})
+
+	t.Run("crls must be signed by one of the client cas", func(t *testing.T) {
+		req := require.New(t)
+
+		crlFile := filepath.Join(t.TempDir(), "crl.pem")
+		req.NoError(os.WriteFile(crlFile, ca.crl(1, 11), 0600))
+
+		checker, err := newCrlChecker("test", crlFile, time.Hour, []*x509.Certificate{ca.cert})
+		req.NoError(err)
+		req.Error(verify(checker, revoked))
+
+		//same subject, different key
+		imposter := newCrlTestCa(t, "client-ca")
+		req.NoError(os.WriteFile(crlFile, imposter.crl(2), 0600))
+
+		req.ErrorContains(checker.refresh(), "CRL issued by [CN=client-ca] is not signed by any of the client CAs")
+		req.Error(verify(checker, revoked))
+
+		_, err = newCrlChecker("test", crlFile, time.Hour, []*x509.Certificate{ca.cert})
+		req.ErrorContains(err, "not signed by any of the client CAs")
+	})
+
+	t.Run("crls from urls are refreshed", func(t *testing.T) {
+		req := require.New(t)
+
+		var crl atomic.Value
+		crl.Store(ca.crl(1))
+
+		crlServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			_, _ = writer.Write(crl.Load().([]byte))
+		}))
+		defer crlServer.Close()
+
+		checker, err := newCrlChecker("test", crlServer.URL, time.Hour, nil)
+		req.NoError(err)
+		req.NoError(verify(checker, revoked))
+
+		crl.Store(ca.crl(2, 11))
+		req.NoError(checker.refresh())
+		req.Error(verify(checker, revoked))
+	})
+
+	t.Run("a failed refresh keeps the previous crl", func(t *testing.T) {
+		req := require.New(t)
+
+		var fail atomic.Bool
+
+		crlServer := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if fail.Load() {
+				writer.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = writer.Write(ca.crl(1, 11))
+		}))
+		defer crlServer.Close()
+
+		checker, err := newCrlChecker("test", crlServer.URL, time.Hour, nil)
+		req.NoError(err)
+
+		fail.Store(true)
+		req.ErrorContains(checker.refresh(), "returned status 500")
+		req.Error(verify(checker, revoked))
+	})
+
+	t.Run("invalid crls are rejected", func(t *testing.T) {
+		req := require.New(t)
+
+		crlFile := filepath.Join(t.TempDir(), "crl.pem")
+		req.NoError(os.WriteFile(crlFile, []byte("not a crl"), 0600))
+
+		_, err := newCrlChecker("test", crlFile, time.Hour, nil)
+		req.ErrorContains(err, "could not parse CRL")
+
+		options := &CrlOptions{}
+		options.Default()
+		options.ClientCrl = crlFile
+		req.ErrorContains(options.Validate(), "invalid clientCrl")
+
+		options.ClientCrl = ""
+		options.ClientCrlRefreshInterval = time.Second
+		req.ErrorContains(options.Validate(), "too low")
+	})
+}
+
+func TestServer_clientCrl(t *testing.T) {
+	req := require.New(t)
+
+	ca := newCrlTestCa(t, "client-ca")
+
+	crlFile := filepath.Join(t.TempDir(), "crl.pem")
+	req.NoError(os.WriteFile(crlFile, ca.crl(1, 11), 0600))
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+	serverConfig.ClientCas = "pem:" + string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw}))
+	serverConfig.BindPoints[0].ClientAuthPolicy = "requireAndVerify"
+	req.NoError(serverConfig.Options.Parse(map[interface{}]interface{}{"clientCrl": crlFile}))
+	req.NoError(serverConfig.Validate(newTestInstance(t, id, nil).GetRegistry()))
+
+	server := newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+	tlsConfig := server.httpServers[0].TLSConfig
+
+	req.NoError(handshakeWith(tlsConfig, ca.issue(10)))
+	req.Error(handshakeWith(tlsConfig, ca.issue(11)))
+
+	t.Run("a crl not signed by the client cas is rejected", func(t *testing.T) {
+		req := require.New(t)
+
+		otherCrlFile := filepath.Join(t.TempDir(), "crl.pem")
+		req.NoError(os.WriteFile(otherCrlFile, newCrlTestCa(t, "client-ca").crl(1, 11), 0600))
+
+		serverConfig.Options.ClientCrl = otherCrlFile
+
+		_, err := NewServer(newTestInstance(t, id, nil), serverConfig)
+		req.ErrorContains(err, "not signed by any of the client CAs")
+	})
+}
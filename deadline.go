@@ -0,0 +1,65 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/openziti/xweb/v2/middleware"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// requestDeadline is the effective RequestDeadlineOptions of a Server.
+type requestDeadline struct {
+	timeout     time.Duration
+	exemptPaths []string
+}
+
+// newRequestDeadline returns the requestDeadline for the supplied Options or nil if request deadlines are disabled.
+func newRequestDeadline(options *Options) *requestDeadline {
+	if !options.RequestDeadline {
+		return nil
+	}
+
+	timeout := options.RequestTimeout
+	if timeout == 0 {
+		timeout = options.WriteTimeout
+	}
+
+	return &requestDeadline{
+		timeout:     timeout,
+		exemptPaths: options.RequestDeadlineExemptPaths,
+	}
+}
+
+// requestTimeout is a middleware.RequestTimeoutFunc that returns the timeout of the Server's current requestDeadline
+// or zero if request deadlines are disabled or the request is exempt.
+func (server *Server) requestTimeout(request *http.Request) time.Duration {
+	deadline := server.requestDeadline.Load()
+
+	if deadline == nil || middleware.IsStreamingRequest(request) {
+		return 0
+	}
+
+	for _, exemptPath := range deadline.exemptPaths {
+		if strings.HasPrefix(request.URL.Path, exemptPath) {
+			return 0
+		}
+	}
+
+	return deadline.timeout
+}
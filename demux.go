@@ -21,17 +21,32 @@ import (
 	"errors"
 	"fmt"
 	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/xweb/v2/middleware"
+	"hash/fnv"
+	"net"
 	"net/http"
+	"regexp"
+	"sort"
 	"strings"
 )
 
 // DemuxFactory generates a http.Handler that interrogates a http.Request and routes them to ApiHandler instances. The selected
 // ApiHandler is added to the context with a key of HandlerContextKey. Each DemuxFactory implementation must define
-// its own behaviors for an unmatched http.Request.
+// its own behaviors for an unmatched http.Request. Implementations should record the binding of the selected ApiHandler
+// on the middleware.AccessLogEntry of the request, if present, so that it appears in access logs.
 type DemuxFactory interface {
 	Build(handlers []ApiHandler) (DemuxHandler, error)
 }
 
+// BindPointDemuxFactory is an optional interface for DemuxFactory's that build a separate DemuxHandler for each bind
+// point of a ServerConfig. It allows routing decisions to depend on the ServerConfig and BindPointConfig the requests
+// arrive on, e.g. to serve a restricted set of ApiHandler's on an internal bind point and all of them on a public one.
+// BuildForBindPoint is used instead of Build when a DemuxFactory implements it.
+type BindPointDemuxFactory interface {
+	DemuxFactory
+	BuildForBindPoint(serverConfig *ServerConfig, bindPoint *BindPointConfig, handlers []ApiHandler) (DemuxHandler, error)
+}
+
 type DemuxHandler interface {
 	DefaultHttpHandlerProvider
 	http.Handler
@@ -48,61 +63,275 @@ func (d *DemuxHandlerImpl) ServeHTTP(writer http.ResponseWriter, request *http.R
 	d.Handler.ServeHTTP(writer, request)
 }
 
+// MatchingDemuxHandler is a DemuxHandler that can report the ApiHandler it selects for a http.Request without serving
+// it. Match returns nil if no ApiHandler matches the request, the default ApiHandler is not considered a match.
+// The DemuxHandler's built by the DemuxFactory's in this package implement it, which allows them to be combined by
+// CompositeDemuxFactory.
+type MatchingDemuxHandler interface {
+	DemuxHandler
+	Match(request *http.Request) ApiHandler
+}
+
+// matchingDemuxHandler is a MatchingDemuxHandler that serves requests with the ApiHandler selected by its match
+// function. Unmatched requests are served by the default ApiHandler, if any, then the default http.Handler of the
+// DemuxFactory that built it, and finally with an empty http.StatusNotFound (404) response.
+type matchingDemuxHandler struct {
+	DefaultHttpHandlerProviderImpl
+	factory    DefaultHttpHandlerProvider
+	defaultApi ApiHandler
+	match      func(request *http.Request) ApiHandler
+}
+
+var _ MatchingDemuxHandler = &matchingDemuxHandler{}
+
+func newMatchingDemuxHandler(factory DefaultHttpHandlerProvider, defaultApi ApiHandler, match func(request *http.Request) ApiHandler) *matchingDemuxHandler {
+	return &matchingDemuxHandler{
+		factory:    factory,
+		defaultApi: defaultApi,
+		match:      match,
+	}
+}
+
+func (d *matchingDemuxHandler) Match(request *http.Request) ApiHandler {
+	return d.match(request)
+}
+
+func (d *matchingDemuxHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if handler := d.match(request); handler != nil {
+		serveApiHandler(handler, writer, request)
+		return
+	}
+
+	if d.defaultApi != nil {
+		serveApiHandler(d.defaultApi, writer, request)
+		return
+	}
+
+	if defaultHttpHandler := d.factory.GetDefaultHttpHandler(); defaultHttpHandler != nil {
+		defaultHttpHandler.ServeHTTP(writer, request)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNotFound)
+	_, _ = writer.Write([]byte{})
+}
+
+// defaultApiDemuxHandler is a MatchingDemuxHandler that serves requests its MatchingDemuxHandler does not match with
+// defaultApi, see ServerConfig.DefaultApi.
+type defaultApiDemuxHandler struct {
+	MatchingDemuxHandler
+	defaultApi ApiHandler
+}
+
+func (d *defaultApiDemuxHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if handler := d.Match(request); handler != nil {
+		serveApiHandler(handler, writer, request)
+		return
+	}
+
+	serveApiHandler(d.defaultApi, writer, request)
+}
+
 // PathPrefixDemuxFactory is a DemuxFactory that routes http.Request requests to a specific ApiHandler from a set of
 // ApiHandler's by URL path prefixes. A http.Handler for NoHandlerFound can be provided to specify behavior to perform
 // when a ApiHandler is not selected. By default an empty response with a http.StatusNotFound (404) will be sent.
+//
+// If StickyKey is set, multiple ApiHandler's may share a root path. Requests matching a shared root path are routed
+// to one of its ApiHandler's by hashing the key returned by StickyKey, so that requests with the same key
+// consistently reach the same ApiHandler. This is best-effort: the selection changes if the set of ApiHandler's
+// changes, and it is not a replacement for proper session affinity. It is disabled by default.
+//
+// ApiHandler's that implement MultiPathApiHandler are matched by all of their RootPaths.
+//
+// Root paths match whole path segments: "/api" (or "/api/") matches "/api" and "/api/..." but not "/apiary".
 type PathPrefixDemuxFactory struct {
 	DefaultHttpHandlerProviderImpl
+	StickyKey StickyKeyFunc
+
+	// StringPrefixMatching, if true, matches root paths as plain string prefixes of request paths, e.g. "/api"
+	// matches "/apiary". It restores the matching of earlier versions for deployments that depend on it.
+	StringPrefixMatching bool
+
+	// CaseInsensitive, if true, matches root paths regardless of case, e.g. "/api" matches "/API/resource"
+	CaseInsensitive bool
+}
+
+// matches returns true if the request path is matched by rootPath.
+func (factory *PathPrefixDemuxFactory) matches(path, rootPath string) bool {
+	if factory.CaseInsensitive {
+		path, rootPath = strings.ToLower(path), strings.ToLower(rootPath)
+	}
+
+	if factory.StringPrefixMatching {
+		return strings.HasPrefix(path, rootPath)
+	}
+
+	return matchesPathSegments(path, rootPath)
+}
+
+// matchesPathSegments returns true if path is rootPath, with or without a trailing slash, or a path below it.
+func matchesPathSegments(path, rootPath string) bool {
+	rootPath = strings.TrimSuffix(rootPath, "/")
+	return path == rootPath || strings.HasPrefix(path, rootPath+"/")
 }
 
 var _ DemuxFactory = &PathPrefixDemuxFactory{}
 
+// MultiPathApiHandler is an optional interface for ApiHandler's that serve several unrelated root paths (e.g. "/api"
+// and "/spec"). PathPrefixDemuxFactory and LongestPrefixDemuxFactory match requests against all RootPaths instead of
+// RootPath, which should return the primary root path for other uses (e.g. logging). If RootPaths is empty, RootPath
+// is used.
+type MultiPathApiHandler interface {
+	ApiHandler
+	RootPaths() []string
+}
+
+// rootPaths returns the RootPaths of a MultiPathApiHandler, if any, otherwise the RootPath of the ApiHandler.
+// Duplicates are removed.
+func rootPaths(handler ApiHandler) []string {
+	multiPathHandler, ok := handler.(MultiPathApiHandler)
+	if !ok || len(multiPathHandler.RootPaths()) == 0 {
+		return []string{handler.RootPath()}
+	}
+
+	var paths []string
+	seen := map[string]struct{}{}
+
+	for _, path := range multiPathHandler.RootPaths() {
+		if _, ok := seen[path]; !ok {
+			seen[path] = struct{}{}
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// prefixRoute is a root path and the ApiHandler it routes to
+type prefixRoute struct {
+	rootPath string
+	handler  ApiHandler
+}
+
+// prefixRoutes returns the routes of all root paths of the supplied ApiHandler's, in the order of the ApiHandler's.
+// An error is returned if a root path is used by more than one ApiHandler.
+func prefixRoutes(handlers []ApiHandler) ([]*prefixRoute, error) {
+	var routes []*prefixRoute
+	handlerMap := map[string]ApiHandler{}
+
+	for _, handler := range handlers {
+		for _, rootPath := range rootPaths(handler) {
+			if existing, ok := handlerMap[rootPath]; ok {
+				return nil, fmt.Errorf("duplicate root path [%s] detected for both bindings [%s] and [%s]", rootPath, handler.Binding(), existing.Binding())
+			}
+			handlerMap[rootPath] = handler
+			routes = append(routes, &prefixRoute{rootPath: rootPath, handler: handler})
+		}
+	}
+
+	return routes, nil
+}
+
+// StickyKeyFunc returns the key used to consistently select an ApiHandler for a http.Request when multiple
+// ApiHandler's share a route.
+type StickyKeyFunc func(request *http.Request) string
+
+// StickyKeyClientIP is a StickyKeyFunc that uses the client's IP address as the key.
+func StickyKeyClientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+
+	if err != nil {
+		return request.RemoteAddr
+	}
+
+	return host
+}
+
+// StickyKeyClientCertSubject is a StickyKeyFunc that uses the subject of the client's certificate as the key. If the
+// client did not present a certificate the client's IP address is used.
+func StickyKeyClientCertSubject(request *http.Request) string {
+	if request.TLS != nil && len(request.TLS.PeerCertificates) > 0 {
+		return request.TLS.PeerCertificates[0].Subject.String()
+	}
+
+	return StickyKeyClientIP(request)
+}
+
 // Build performs ApiHandler selection based on URL path prefixes
 func (factory *PathPrefixDemuxFactory) Build(handlers []ApiHandler) (DemuxHandler, error) {
+	if factory.StickyKey != nil {
+		return factory.buildSticky(handlers)
+	}
+
 	defaultApi, err := getDefault(handlers)
 
 	if err != nil {
 		return nil, err
 	}
 
-	handlerMap := map[string]ApiHandler{}
+	routes, err := prefixRoutes(handlers)
 
-	for _, handler := range handlers {
-		if existing, ok := handlerMap[handler.RootPath()]; ok {
-			return nil, fmt.Errorf("duplicate root path [%s] detected for both bindings [%s] and [%s]", handler.RootPath(), handler.Binding(), existing.Binding())
+	if err != nil {
+		return nil, err
+	}
+
+	return newMatchingDemuxHandler(factory, defaultApi, func(request *http.Request) ApiHandler {
+		for _, route := range routes {
+			if factory.matches(request.URL.Path, route.rootPath) {
+				return route.handler
+			}
 		}
-		handlerMap[handler.RootPath()] = handler
+
+		return nil
+	}), nil
+}
+
+// buildSticky performs ApiHandler selection based on URL path prefixes, selecting among ApiHandler's that share a
+// root path by the hash of the StickyKey of the request.
+func (factory *PathPrefixDemuxFactory) buildSticky(handlers []ApiHandler) (DemuxHandler, error) {
+	defaultApi, err := getDefault(handlers)
+
+	if err != nil {
+		return nil, err
 	}
 
-	return &DemuxHandlerImpl{
-		Handler: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-			for _, handler := range handlers {
-				if strings.HasPrefix(request.URL.Path, handler.RootPath()) {
+	type rootPathGroup struct {
+		rootPath string
+		handlers []ApiHandler
+	}
 
-					//store this ApiHandler on the request context, useful for logging by downstream http handlers
-					ctx := context.WithValue(request.Context(), HandlerContextKey, handler)
-					newRequest := request.WithContext(ctx)
-					handler.ServeHTTP(writer, newRequest)
-					return
-				}
-			}
+	var groups []*rootPathGroup
+	groupMap := map[string]*rootPathGroup{}
+
+	for _, handler := range handlers {
+		for _, rootPath := range rootPaths(handler) {
+			group, ok := groupMap[rootPath]
 
-			if defaultApi != nil {
-				ctx := context.WithValue(request.Context(), HandlerContextKey, defaultApi)
-				newRequest := request.WithContext(ctx)
-				defaultApi.ServeHTTP(writer, newRequest)
-				return
+			if !ok {
+				group = &rootPathGroup{rootPath: rootPath}
+				groupMap[rootPath] = group
+				groups = append(groups, group)
 			}
 
-			if defaultHttpHandler := factory.GetDefaultHttpHandler(); defaultHttpHandler != nil {
-				defaultHttpHandler.ServeHTTP(writer, request)
-				return
+			group.handlers = append(group.handlers, handler)
+		}
+	}
+
+	return newMatchingDemuxHandler(factory, defaultApi, func(request *http.Request) ApiHandler {
+		for _, group := range groups {
+			if factory.matches(request.URL.Path, group.rootPath) {
+				if len(group.handlers) == 1 {
+					return group.handlers[0]
+				}
+
+				hash := fnv.New32a()
+				_, _ = hash.Write([]byte(factory.StickyKey(request)))
+				return group.handlers[hash.Sum32()%uint32(len(group.handlers))]
 			}
+		}
 
-			writer.WriteHeader(http.StatusNotFound)
-			_, _ = writer.Write([]byte{})
-		}),
-	}, nil
+		return nil
+	}), nil
 }
 
 // getDefault determines from a slice of ApiHandler which will act as the default handlers
@@ -112,14 +341,44 @@ func (factory *PathPrefixDemuxFactory) Build(handlers []ApiHandler) (DemuxHandle
 //
 // If a handler declares itself the default, only one is allowed to do so and if another
 // handler does so, it will generate an error. If no handler declares itself, the
-// last handler will be used.
+// last handler that has not opted out via DefaultEligibleApiHandler will be used. If all
+// handlers have opted out, no default handler is returned. Server provides handlers ordered by ApiConfig.Priority,
+// making the last handler that of the lowest priority API rather than depending on configuration order alone. Servers
+// whose ServerConfig sets RequireDefaultApi fail to build rather than fall back to the last handler.
 func getDefault(handlers []ApiHandler) (ApiHandler, error) {
-	var defaults []ApiHandler
-
 	if len(handlers) == 0 {
 		return nil, errors.New("no handlers provided")
 	}
 
+	declared, err := getDeclaredDefault(handlers)
+	if err != nil {
+		return nil, err
+	}
+
+	if declared == nil {
+		for i := len(handlers) - 1; i >= 0; i-- {
+			lastHandler := handlers[i]
+
+			if !isDefaultEligible(lastHandler) {
+				continue
+			}
+
+			pfxlog.Logger().Warnf("no defualt handlers were found, using the last handler [Binding: %s, Type: %T] as the default", lastHandler.Binding(), lastHandler)
+			return lastHandler, nil
+		}
+
+		pfxlog.Logger().Warn("no default handlers were found and all handlers are ineligible to be the default, no default handler will be used")
+		return nil, nil
+	}
+
+	return declared, nil
+}
+
+// getDeclaredDefault returns the handler that declares itself the default via DefaultApiHandler, nil if none does. An
+// error is returned if more than one handler declares itself the default.
+func getDeclaredDefault(handlers []ApiHandler) (ApiHandler, error) {
+	var defaults []ApiHandler
+
 	for _, handler := range handlers {
 		if curHandler, ok := handler.(DefaultApiHandler); ok {
 			if curHandler.IsDefault() {
@@ -129,9 +388,7 @@ func getDefault(handlers []ApiHandler) (ApiHandler, error) {
 	}
 
 	if len(defaults) == 0 {
-		lastHandler := handlers[len(handlers)-1]
-		pfxlog.Logger().Warnf("no defualt handlers were found, using the last handler [Binding: %s, Type: %T] as the default", lastHandler.Binding(), lastHandler)
-		return lastHandler, nil
+		return nil, nil
 	}
 
 	if len(defaults) > 1 {
@@ -164,38 +421,278 @@ func (factory *IsHandledDemuxFactory) Build(handlers []ApiHandler) (DemuxHandler
 		return nil, err
 	}
 
-	return &DemuxHandlerImpl{
-		Handler: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+	return newMatchingDemuxHandler(factory, defaultApi, func(request *http.Request) ApiHandler {
+		for _, handler := range handlers {
+			if handler.IsHandler(request) {
+				return handler
+			}
+		}
 
-			for _, handler := range handlers {
-				if handler.IsHandler(request) {
-					ctx := context.WithValue(request.Context(), HandlerContextKey, handler)
-					newRequest := request.WithContext(ctx)
-					handler.ServeHTTP(writer, newRequest)
-					return
-				}
+		return nil
+	}), nil
+}
+
+// PatternApiHandler is an optional interface for ApiHandler's that match request paths by a regular expression instead
+// of their root path. It is used by LongestPrefixDemuxFactory.
+type PatternApiHandler interface {
+	ApiHandler
+	PathPattern() *regexp.Regexp
+}
+
+// LongestPrefixDemuxFactory is a DemuxFactory that routes http.Request requests to a specific ApiHandler by URL path.
+// ApiHandler's that implement PatternApiHandler are matched first, in the order provided, by their PathPattern. All
+// other ApiHandler's are matched by the longest root path (see MultiPathApiHandler) that prefixes the request path by
+// whole path segments, so that the selection does not depend on the order of the ApiHandler's (e.g. "/apiv2/x" is
+// routed to "/apiv2" rather than "/api") and "/api" does not match "/apiary". Unmatched requests are routed to the
+// default ApiHandler (see getDefault) or, if there is none, the default http.Handler.
+type LongestPrefixDemuxFactory struct {
+	DefaultHttpHandlerProviderImpl
+}
+
+var _ DemuxFactory = &LongestPrefixDemuxFactory{}
+
+// Build performs ApiHandler selection based on path patterns and the longest matching URL path prefix
+func (factory *LongestPrefixDemuxFactory) Build(handlers []ApiHandler) (DemuxHandler, error) {
+	defaultApi, err := getDefault(handlers)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var patternHandlers []PatternApiHandler
+	var prefixHandlers []ApiHandler
+	patternMap := map[string]ApiHandler{}
+
+	for _, handler := range handlers {
+		if patternHandler, ok := handler.(PatternApiHandler); ok && patternHandler.PathPattern() != nil {
+			pattern := patternHandler.PathPattern().String()
 
+			if existing, ok := patternMap[pattern]; ok {
+				return nil, fmt.Errorf("duplicate path pattern [%s] detected for both bindings [%s] and [%s]", pattern, handler.Binding(), existing.Binding())
 			}
+			patternMap[pattern] = handler
+			patternHandlers = append(patternHandlers, patternHandler)
+			continue
+		}
+
+		prefixHandlers = append(prefixHandlers, handler)
+	}
+
+	routes, err := prefixRoutes(prefixHandlers)
 
-			if defaultApi != nil {
-				ctx := context.WithValue(request.Context(), HandlerContextKey, defaultApi)
-				newRequest := request.WithContext(ctx)
-				defaultApi.ServeHTTP(writer, newRequest)
-				return
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].rootPath) > len(routes[j].rootPath)
+	})
+
+	return newMatchingDemuxHandler(factory, defaultApi, func(request *http.Request) ApiHandler {
+		for _, handler := range patternHandlers {
+			if handler.PathPattern().MatchString(request.URL.Path) {
+				return handler
 			}
+		}
 
-			if defaultHttpHandler := factory.GetDefaultHttpHandler(); defaultHttpHandler != nil {
-				defaultHttpHandler.ServeHTTP(writer, request)
-				return
+		for _, route := range routes {
+			if matchesPathSegments(request.URL.Path, route.rootPath) {
+				return route.handler
 			}
+		}
 
-			writer.WriteHeader(http.StatusNotFound)
-			_, _ = writer.Write([]byte{})
-		}),
-	}, nil
+		return nil
+	}), nil
+}
+
+// HostedApiHandler is an optional interface for ApiHandler's that declares the host names they serve. It is used by
+// HostHeaderDemuxFactory to route requests by their Host header.
+type HostedApiHandler interface {
+	ApiHandler
+	Hostnames() []string
+}
+
+// HostHeaderDemuxFactory is a DemuxFactory that routes http.Request requests to a specific ApiHandler by the host name
+// of the request's Host header. ApiHandler's declare the host names they serve by implementing HostedApiHandler. Host
+// names are matched case-insensitively and without the port. Requests for undeclared host names are routed to the
+// default ApiHandler (see getDefault) or, if there is none, the default http.Handler.
+type HostHeaderDemuxFactory struct {
+	DefaultHttpHandlerProviderImpl
+}
+
+var _ DemuxFactory = &HostHeaderDemuxFactory{}
+
+// Build performs ApiHandler selection based on the Host header
+func (factory *HostHeaderDemuxFactory) Build(handlers []ApiHandler) (DemuxHandler, error) {
+	defaultApi, err := getDefault(handlers)
+
+	if err != nil {
+		return nil, err
+	}
+
+	handlerMap := map[string]ApiHandler{}
+
+	for _, handler := range handlers {
+		hostedHandler, ok := handler.(HostedApiHandler)
+
+		if !ok {
+			continue
+		}
+
+		for _, hostname := range hostedHandler.Hostnames() {
+			hostname = normalizeHostname(hostname)
+
+			if existing, ok := handlerMap[hostname]; ok {
+				return nil, fmt.Errorf("duplicate hostname [%s] detected for both bindings [%s] and [%s]", hostname, handler.Binding(), existing.Binding())
+			}
+			handlerMap[hostname] = handler
+		}
+	}
+
+	return newMatchingDemuxHandler(factory, defaultApi, func(request *http.Request) ApiHandler {
+		return handlerMap[requestHostname(request)]
+	}), nil
+}
+
+// CompositeDemuxFactory is a DemuxFactory that combines an ordered list of DemuxFactory's, e.g. to route by host name
+// first and fall back to URL path prefixes. Each request is offered to the DemuxHandler of each DemuxFactory in turn
+// and is served by the first that matches it. Requests that none match are routed to the default ApiHandler (see
+// getDefault) or, if there is none, the default http.Handler of the CompositeDemuxFactory. The default behaviors of
+// the combined DemuxFactory's are not used.
+//
+// The DemuxHandler's built by the combined DemuxFactory's must implement MatchingDemuxHandler.
+type CompositeDemuxFactory struct {
+	DefaultHttpHandlerProviderImpl
+	Factories []DemuxFactory
+}
+
+var _ DemuxFactory = &CompositeDemuxFactory{}
+
+// NewCompositeDemuxFactory returns a CompositeDemuxFactory that tries the supplied DemuxFactory's in order
+func NewCompositeDemuxFactory(factories ...DemuxFactory) *CompositeDemuxFactory {
+	return &CompositeDemuxFactory{
+		Factories: factories,
+	}
+}
+
+// Build performs ApiHandler selection with the first matching DemuxHandler of the combined DemuxFactory's
+func (factory *CompositeDemuxFactory) Build(handlers []ApiHandler) (DemuxHandler, error) {
+	if len(factory.Factories) == 0 {
+		return nil, errors.New("no demux factories provided")
+	}
+
+	defaultApi, err := getDefault(handlers)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var demuxHandlers []MatchingDemuxHandler
+
+	for i, childFactory := range factory.Factories {
+		demuxHandler, err := childFactory.Build(handlers)
+
+		if err != nil {
+			return nil, fmt.Errorf("error building demux factory at index %d [%T]: %v", i, childFactory, err)
+		}
+
+		matchingDemuxHandler, ok := demuxHandler.(MatchingDemuxHandler)
+
+		if !ok {
+			return nil, fmt.Errorf("demux handler [%T] built by demux factory at index %d [%T] does not implement MatchingDemuxHandler", demuxHandler, i, childFactory)
+		}
+
+		demuxHandlers = append(demuxHandlers, matchingDemuxHandler)
+	}
+
+	return newMatchingDemuxHandler(factory, defaultApi, func(request *http.Request) ApiHandler {
+		for _, demuxHandler := range demuxHandlers {
+			if handler := demuxHandler.Match(request); handler != nil {
+				return handler
+			}
+		}
+
+		return nil
+	}), nil
+}
+
+// requestHostname returns the lower case host name of the request's Host header without the port.
+func requestHostname(request *http.Request) string {
+	host := request.Host
+
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	return normalizeHostname(host)
+}
+
+// normalizeHostname returns the lower case form of a host name, with the brackets of IPv6 addresses removed.
+func normalizeHostname(hostname string) string {
+	return strings.ToLower(strings.Trim(strings.TrimSpace(hostname), "[]"))
+}
+
+// serveApiHandler serves the request with the selected ApiHandler. The ApiHandler is stored on the request context
+// under HandlerContextKey and as the Handler of the request's ServerContext, useful for logging by downstream http
+// handlers, and its binding is recorded on the request's middleware.AccessLogEntry if the request is being access
+// logged.
+func serveApiHandler(handler ApiHandler, writer http.ResponseWriter, request *http.Request) {
+	if entry := middleware.AccessLogEntryFromContext(request.Context()); entry != nil {
+		entry.SetField(middleware.AccessLogFieldBinding, handler.Binding())
+	}
+
+	ctx := context.WithValue(request.Context(), HandlerContextKey, handler)
+
+	if serverContext := ServerContextFromRequestContext(ctx); serverContext != nil {
+		if !serverContext.perRequest {
+			requestServerContext := *serverContext
+			requestServerContext.perRequest = true
+			serverContext = &requestServerContext
+			ctx = context.WithValue(ctx, ServerContextKey, serverContext)
+		}
+
+		serverContext.Handler = handler
+	}
+
+	request = request.WithContext(ctx)
+
+	if limits, ok := ctx.Value(bodyLimitsContextKey).(*bodyLimits); ok && !limits.apply(handler.Binding(), writer, request) {
+		return
+	}
+
+	var next http.Handler = handler
+
+	if chains, ok := ctx.Value(apiMiddlewareContextKey).(*apiMiddleware); ok {
+		next = chains.wrap(handler.Binding(), next)
+	}
+
+	if timeouts, ok := ctx.Value(handlerTimeoutsContextKey).(*handlerTimeouts); ok {
+		next = timeouts.wrap(handler.Binding(), next)
+	}
+
+	next.ServeHTTP(writer, request)
 }
 
 type DefaultApiHandler interface {
 	ApiHandler
 	IsDefault() bool
 }
+
+// DefaultEligibleApiHandler is an optional interface for ApiHandler's that allows them to opt out of being selected as
+// the default handler when no handler declares itself the default via DefaultApiHandler. Handlers that do not
+// implement this interface are eligible. A handler that explicitly declares itself the default via
+// DefaultApiHandler is always used as the default.
+type DefaultEligibleApiHandler interface {
+	ApiHandler
+	IsDefaultEligible() bool
+}
+
+// isDefaultEligible returns true if the supplied ApiHandler may be selected as the default handler by the last handler
+// fallback.
+func isDefaultEligible(handler ApiHandler) bool {
+	if eligibleHandler, ok := handler.(DefaultEligibleApiHandler); ok {
+		return eligibleHandler.IsDefaultEligible()
+	}
+
+	return true
+}
@@ -17,22 +17,31 @@ limitations under the License.
 package xweb
 
 import (
+	"fmt"
 	"github.com/stretchr/testify/require"
 	"net/http"
+	"net/http/httptest"
+	"regexp"
 	"testing"
 )
 
 var _ ApiHandler = (*mockHandler)(nil)
 var _ DefaultApiHandler = (*mockHandler)(nil)
+var _ DefaultEligibleApiHandler = (*mockHandler)(nil)
 
 type mockHandler struct {
-	isDefault bool
+	isDefault         bool
+	defaultIneligible bool
 }
 
 func (m *mockHandler) IsDefault() bool {
 	return m.isDefault
 }
 
+func (m *mockHandler) IsDefaultEligible() bool {
+	return !m.defaultIneligible
+}
+
 func (m *mockHandler) Binding() string {
 	return "mockHandler"
 }
@@ -54,6 +63,66 @@ func (m *mockHandler) ServeHTTP(writer http.ResponseWriter, request *http.Reques
 	_, _ = writer.Write([]byte(m.Binding()))
 }
 
+// pathHandler is a mockHandler with a configurable root path that responds with its name
+type pathHandler struct {
+	mockHandler
+	name     string
+	rootPath string
+}
+
+func (p *pathHandler) RootPath() string {
+	return p.rootPath
+}
+
+func (p *pathHandler) ServeHTTP(writer http.ResponseWriter, _ *http.Request) {
+	writer.WriteHeader(http.StatusOK)
+	_, _ = writer.Write([]byte(p.name))
+}
+
+// serveName sends a request for path from remoteAddr to handler and returns the response body
+func serveName(handler http.Handler, path, remoteAddr string) string {
+	request := httptest.NewRequest(http.MethodGet, path, nil)
+	request.RemoteAddr = remoteAddr
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return recorder.Body.String()
+}
+
+func TestPathPrefixDemuxFactory_sticky(t *testing.T) {
+	t.Run("duplicate root paths are an error when sticky routing is disabled", func(t *testing.T) {
+		factory := &PathPrefixDemuxFactory{}
+		_, err := factory.Build([]ApiHandler{
+			&pathHandler{name: "a", rootPath: "/api"},
+			&pathHandler{name: "b", rootPath: "/api"},
+		})
+		require.New(t).Error(err)
+	})
+
+	t.Run("requests from the same client ip reach the same handler", func(t *testing.T) {
+		req := require.New(t)
+
+		factory := &PathPrefixDemuxFactory{StickyKey: StickyKeyClientIP}
+		demux, err := factory.Build([]ApiHandler{
+			&pathHandler{name: "a", rootPath: "/api"},
+			&pathHandler{name: "b", rootPath: "/api"},
+			&pathHandler{name: "c", rootPath: "/api"},
+			&pathHandler{name: "other", rootPath: "/other"},
+		})
+		req.NoError(err)
+
+		seen := map[string]struct{}{}
+		for i := 0; i < 50; i++ {
+			ip := fmt.Sprintf("10.0.0.%d", i)
+			first := serveName(demux, "/api/resource", ip+":1000")
+			req.Equal(first, serveName(demux, "/api/resource", ip+":2000"))
+			seen[first] = struct{}{}
+		}
+
+		req.Len(seen, 3, "expected requests to be spread across all handlers sharing the root path")
+		req.Equal("other", serveName(demux, "/other", "10.0.0.1:1000"))
+	})
+}
+
 func Test_getDefault(t *testing.T) {
 
 	t.Run("a nil slice results in an error", func(t *testing.T) {
@@ -155,4 +224,443 @@ func Test_getDefault(t *testing.T) {
 		req.NoError(err)
 		req.Equal(h2, defaultHandler)
 	})
+
+	t.Run("a slice with an ineligible last entry returns the last eligible entry", func(t *testing.T) {
+		h1 := &mockHandler{}
+		h2 := &mockHandler{}
+		h3 := &mockHandler{defaultIneligible: true}
+
+		handlers := []ApiHandler{
+			h1,
+			h2,
+			h3,
+		}
+
+		defaultHandler, err := getDefault(handlers)
+
+		req := require.New(t)
+		req.NoError(err)
+		req.Same(h2, defaultHandler)
+	})
+
+	t.Run("a slice with only ineligible entries returns no default", func(t *testing.T) {
+		h1 := &mockHandler{defaultIneligible: true}
+		h2 := &mockHandler{defaultIneligible: true}
+
+		handlers := []ApiHandler{
+			h1,
+			h2,
+		}
+
+		defaultHandler, err := getDefault(handlers)
+
+		req := require.New(t)
+		req.NoError(err)
+		req.Nil(defaultHandler)
+	})
+
+	t.Run("an ineligible entry that declares itself the default is returned", func(t *testing.T) {
+		h1 := &mockHandler{}
+		h2 := &mockHandler{isDefault: true, defaultIneligible: true}
+		h3 := &mockHandler{}
+
+		handlers := []ApiHandler{
+			h1,
+			h2,
+			h3,
+		}
+
+		defaultHandler, err := getDefault(handlers)
+
+		req := require.New(t)
+		req.NoError(err)
+		req.Same(h2, defaultHandler)
+	})
+}
+
+// hostHandler is a pathHandler that declares host names
+type hostHandler struct {
+	pathHandler
+	hostnames []string
+}
+
+func (h *hostHandler) Hostnames() []string {
+	return h.hostnames
+}
+
+func TestHostHeaderDemuxFactory(t *testing.T) {
+	serveHost := func(handler http.Handler, host string) string {
+		request := httptest.NewRequest(http.MethodGet, "/api", nil)
+		request.Host = host
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Body.String()
+	}
+
+	t.Run("requests are routed by host name", func(t *testing.T) {
+		req := require.New(t)
+
+		factory := &HostHeaderDemuxFactory{}
+		demux, err := factory.Build([]ApiHandler{
+			&hostHandler{pathHandler: pathHandler{name: "a", rootPath: "/api"}, hostnames: []string{"a.example.com"}},
+			&hostHandler{pathHandler: pathHandler{name: "b", rootPath: "/api"}, hostnames: []string{"B.example.com", "[::1]"}},
+			&pathHandler{name: "default", rootPath: "/api", mockHandler: mockHandler{isDefault: true}},
+		})
+		req.NoError(err)
+
+		req.Equal("a", serveHost(demux, "a.example.com"))
+		req.Equal("a", serveHost(demux, "A.Example.com:8443"))
+		req.Equal("b", serveHost(demux, "b.example.com"))
+		req.Equal("b", serveHost(demux, "[::1]:8443"))
+		req.Equal("default", serveHost(demux, "unknown.example.com"))
+	})
+
+	t.Run("unmatched requests without a default handler use the default http handler", func(t *testing.T) {
+		req := require.New(t)
+
+		factory := &HostHeaderDemuxFactory{}
+		factory.SetDefaultHttpHandler(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			_, _ = writer.Write([]byte("fallback"))
+		}))
+
+		demux, err := factory.Build([]ApiHandler{
+			&hostHandler{pathHandler: pathHandler{name: "a", rootPath: "/api", mockHandler: mockHandler{defaultIneligible: true}}, hostnames: []string{"a.example.com"}},
+		})
+		req.NoError(err)
+
+		req.Equal("fallback", serveHost(demux, "unknown.example.com"))
+	})
+
+	t.Run("duplicate host names are an error", func(t *testing.T) {
+		factory := &HostHeaderDemuxFactory{}
+		_, err := factory.Build([]ApiHandler{
+			&hostHandler{pathHandler: pathHandler{name: "a"}, hostnames: []string{"a.example.com"}},
+			&hostHandler{pathHandler: pathHandler{name: "b"}, hostnames: []string{"A.example.com"}},
+		})
+		require.New(t).Error(err)
+	})
+}
+
+// patternHandler is a pathHandler that matches paths by a regular expression
+type patternHandler struct {
+	pathHandler
+	pattern *regexp.Regexp
+}
+
+func (p *patternHandler) PathPattern() *regexp.Regexp {
+	return p.pattern
+}
+
+func TestLongestPrefixDemuxFactory(t *testing.T) {
+	handlers := func() []ApiHandler {
+		return []ApiHandler{
+			&pathHandler{name: "api", rootPath: "/api"},
+			&pathHandler{name: "apiv2", rootPath: "/apiv2"},
+			&pathHandler{name: "default", rootPath: "/", mockHandler: mockHandler{isDefault: true}},
+		}
+	}
+
+	t.Run("path prefix matching depends on handler order", func(t *testing.T) {
+		req := require.New(t)
+
+		demux, err := (&PathPrefixDemuxFactory{StringPrefixMatching: true}).Build(handlers())
+		req.NoError(err)
+
+		//the shorter prefix shadows the longer one as it is declared first
+		req.Equal("api", serveName(demux, "/apiv2/resource", "127.0.0.1:1000"))
+	})
+
+	t.Run("the longest prefix is matched regardless of handler order", func(t *testing.T) {
+		req := require.New(t)
+
+		demux, err := (&LongestPrefixDemuxFactory{}).Build(handlers())
+		req.NoError(err)
+
+		req.Equal("apiv2", serveName(demux, "/apiv2/resource", "127.0.0.1:1000"))
+		req.Equal("api", serveName(demux, "/api/resource", "127.0.0.1:1000"))
+		req.Equal("default", serveName(demux, "/other", "127.0.0.1:1000"))
+	})
+
+	t.Run("root paths are matched by whole path segments", func(t *testing.T) {
+		req := require.New(t)
+
+		demux, err := (&LongestPrefixDemuxFactory{}).Build(handlers())
+		req.NoError(err)
+
+		req.Equal("api", serveName(demux, "/api", "127.0.0.1:1000"))
+		req.Equal("api", serveName(demux, "/api/", "127.0.0.1:1000"))
+		req.Equal("default", serveName(demux, "/apiary", "127.0.0.1:1000"))
+	})
+
+	t.Run("path patterns are matched before prefixes", func(t *testing.T) {
+		req := require.New(t)
+
+		demux, err := (&LongestPrefixDemuxFactory{}).Build(append(handlers(),
+			&patternHandler{pathHandler: pathHandler{name: "versioned"}, pattern: regexp.MustCompile(`^/api/v[0-9]+/`)},
+		))
+		req.NoError(err)
+
+		req.Equal("versioned", serveName(demux, "/api/v3/resource", "127.0.0.1:1000"))
+		req.Equal("api", serveName(demux, "/api/resource", "127.0.0.1:1000"))
+	})
+
+	t.Run("duplicate root paths and patterns are an error", func(t *testing.T) {
+		req := require.New(t)
+
+		_, err := (&LongestPrefixDemuxFactory{}).Build(append(handlers(), &pathHandler{name: "dup", rootPath: "/api"}))
+		req.Error(err)
+
+		_, err = (&LongestPrefixDemuxFactory{}).Build([]ApiHandler{
+			&patternHandler{pathHandler: pathHandler{name: "a"}, pattern: regexp.MustCompile(`^/x`)},
+			&patternHandler{pathHandler: pathHandler{name: "b"}, pattern: regexp.MustCompile(`^/x`)},
+		})
+		req.Error(err)
+	})
+}
+
+func TestPathPrefixDemuxFactory_matching(t *testing.T) {
+	handlers := func() []ApiHandler {
+		return []ApiHandler{
+			&pathHandler{name: "api", rootPath: "/api"},
+			&pathHandler{name: "docs", rootPath: "/docs/"},
+			&pathHandler{name: "default", rootPath: "/", mockHandler: mockHandler{isDefault: true}},
+		}
+	}
+
+	t.Run("root paths match whole path segments", func(t *testing.T) {
+		req := require.New(t)
+
+		demux, err := (&PathPrefixDemuxFactory{}).Build(handlers())
+		req.NoError(err)
+
+		req.Equal("api", serveName(demux, "/api", "127.0.0.1:1000"))
+		req.Equal("api", serveName(demux, "/api/", "127.0.0.1:1000"))
+		req.Equal("api", serveName(demux, "/api/resource", "127.0.0.1:1000"))
+		req.Equal("default", serveName(demux, "/apiary", "127.0.0.1:1000"))
+		req.Equal("default", serveName(demux, "/API/resource", "127.0.0.1:1000"))
+
+		//trailing slashes of root paths are ignored
+		req.Equal("docs", serveName(demux, "/docs", "127.0.0.1:1000"))
+		req.Equal("docs", serveName(demux, "/docs/index.html", "127.0.0.1:1000"))
+		req.Equal("default", serveName(demux, "/docsite", "127.0.0.1:1000"))
+	})
+
+	t.Run("string prefix matching matches partial segments", func(t *testing.T) {
+		req := require.New(t)
+
+		demux, err := (&PathPrefixDemuxFactory{StringPrefixMatching: true}).Build(handlers())
+		req.NoError(err)
+
+		req.Equal("api", serveName(demux, "/apiary", "127.0.0.1:1000"))
+		req.Equal("default", serveName(demux, "/docs", "127.0.0.1:1000"))
+	})
+
+	t.Run("case insensitive matching ignores case", func(t *testing.T) {
+		req := require.New(t)
+
+		demux, err := (&PathPrefixDemuxFactory{CaseInsensitive: true}).Build(handlers())
+		req.NoError(err)
+
+		req.Equal("api", serveName(demux, "/API/resource", "127.0.0.1:1000"))
+		req.Equal("api", serveName(demux, "/Api", "127.0.0.1:1000"))
+		req.Equal("default", serveName(demux, "/APIARY", "127.0.0.1:1000"))
+	})
+
+	t.Run("sticky routing uses the same matching", func(t *testing.T) {
+		req := require.New(t)
+
+		demux, err := (&PathPrefixDemuxFactory{StickyKey: StickyKeyClientIP, CaseInsensitive: true}).Build(handlers())
+		req.NoError(err)
+
+		req.Equal("api", serveName(demux, "/API/resource", "127.0.0.1:1000"))
+		req.Equal("default", serveName(demux, "/apiary", "127.0.0.1:1000"))
+	})
+}
+
+// multiPathHandler is a pathHandler that serves several root paths
+type multiPathHandler struct {
+	pathHandler
+	rootPaths []string
+}
+
+func (p *multiPathHandler) RootPaths() []string {
+	return p.rootPaths
+}
+
+func TestMultiPathApiHandler(t *testing.T) {
+	handlers := func() []ApiHandler {
+		return []ApiHandler{
+			&multiPathHandler{pathHandler: pathHandler{name: "api", rootPath: "/api"}, rootPaths: []string{"/api", "/spec", "/api"}},
+			&pathHandler{name: "apiv2", rootPath: "/apiv2"},
+			&pathHandler{name: "default", rootPath: "/", mockHandler: mockHandler{isDefault: true}},
+		}
+	}
+
+	t.Run("all root paths are matched by path prefix", func(t *testing.T) {
+		req := require.New(t)
+
+		demux, err := (&PathPrefixDemuxFactory{}).Build(handlers())
+		req.NoError(err)
+
+		req.Equal("api", serveName(demux, "/api/resource", "127.0.0.1:1000"))
+		req.Equal("api", serveName(demux, "/spec/openapi.json", "127.0.0.1:1000"))
+		req.Equal("default", serveName(demux, "/other", "127.0.0.1:1000"))
+	})
+
+	t.Run("all root paths are matched by longest prefix", func(t *testing.T) {
+		req := require.New(t)
+
+		demux, err := (&LongestPrefixDemuxFactory{}).Build(handlers())
+		req.NoError(err)
+
+		req.Equal("apiv2", serveName(demux, "/apiv2/resource", "127.0.0.1:1000"))
+		req.Equal("api", serveName(demux, "/spec/openapi.json", "127.0.0.1:1000"))
+	})
+
+	t.Run("the root path is used if there are no root paths", func(t *testing.T) {
+		req := require.New(t)
+
+		demux, err := (&PathPrefixDemuxFactory{}).Build([]ApiHandler{
+			&multiPathHandler{pathHandler: pathHandler{name: "api", rootPath: "/api"}},
+			&pathHandler{name: "default", rootPath: "/", mockHandler: mockHandler{isDefault: true}},
+		})
+		req.NoError(err)
+
+		req.Equal("api", serveName(demux, "/api/resource", "127.0.0.1:1000"))
+	})
+
+	t.Run("root paths may not collide with those of other handlers", func(t *testing.T) {
+		dup := &pathHandler{name: "dup", rootPath: "/spec"}
+
+		for _, factory := range []DemuxFactory{&PathPrefixDemuxFactory{}, &LongestPrefixDemuxFactory{}} {
+			_, err := factory.Build(append(handlers(), dup))
+			require.New(t).ErrorContains(err, "duplicate root path [/spec]")
+		}
+	})
+
+	t.Run("handlers share all root paths when sticky", func(t *testing.T) {
+		req := require.New(t)
+
+		demux, err := (&PathPrefixDemuxFactory{StickyKey: StickyKeyClientIP}).Build([]ApiHandler{
+			&multiPathHandler{pathHandler: pathHandler{name: "a", rootPath: "/api"}, rootPaths: []string{"/api", "/spec"}},
+			&multiPathHandler{pathHandler: pathHandler{name: "b", rootPath: "/api"}, rootPaths: []string{"/api", "/spec"}},
+		})
+		req.NoError(err)
+
+		served := map[string]bool{}
+		for i := 0; i < 20; i++ {
+			remoteAddr := fmt.Sprintf("10.0.0.%d:1000", i)
+			name := serveName(demux, "/spec", remoteAddr)
+			req.Equal(name, serveName(demux, "/spec", remoteAddr))
+			served[name] = true
+		}
+		req.Len(served, 2)
+	})
+}
+
+// bindPointDemuxFactory is a BindPointDemuxFactory that serves only the first handler on bind points with an
+// interface address of internalAddress
+type bindPointDemuxFactory struct {
+	PathPrefixDemuxFactory
+	internalAddress string
+}
+
+func (factory *bindPointDemuxFactory) BuildForBindPoint(_ *ServerConfig, bindPoint *BindPointConfig, handlers []ApiHandler) (DemuxHandler, error) {
+	if bindPoint.InterfaceAddress == factory.internalAddress {
+		handlers = handlers[:1]
+	}
+
+	return factory.Build(handlers)
+}
+
+func TestBindPointDemuxFactory(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "localhost")
+
+	registry := NewRegistryMap()
+	for _, binding := range []string{"a", "b"} {
+		req.NoError(registry.Add(&bindingHandlerFactory{binding: binding}))
+	}
+
+	instance := NewDefaultInstance(registry, id)
+	instance.DemuxFactory = &bindPointDemuxFactory{internalAddress: "127.0.0.1:444"}
+
+	serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+	serverConfig.APIs = []*ApiConfig{{binding: "a"}, {binding: "b"}}
+	serverConfig.BindPoints = append(serverConfig.BindPoints, &BindPointConfig{InterfaceAddress: "127.0.0.1:444", Address: "127.0.0.1:444"})
+
+	server := newTestServer(t, instance, serverConfig)
+
+	req.Equal("b", serveName(server.httpServers[0].Handler, "/b", "127.0.0.1:1000"))
+	req.Equal("a", serveName(server.httpServers[1].Handler, "/b", "127.0.0.1:1000"))
+}
+
+func TestCompositeDemuxFactory(t *testing.T) {
+	serve := func(handler http.Handler, host, path string) string {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Host = host
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Body.String()
+	}
+
+	t.Run("requests are served by the first matching factory", func(t *testing.T) {
+		req := require.New(t)
+
+		factory := NewCompositeDemuxFactory(&HostHeaderDemuxFactory{}, &PathPrefixDemuxFactory{})
+		demux, err := factory.Build([]ApiHandler{
+			&hostHandler{pathHandler: pathHandler{name: "hosted", rootPath: "/hosted"}, hostnames: []string{"a.example.com"}},
+			&pathHandler{name: "api", rootPath: "/api"},
+			&pathHandler{name: "default", rootPath: "/default", mockHandler: mockHandler{isDefault: true}},
+		})
+		req.NoError(err)
+
+		req.Equal("hosted", serve(demux, "a.example.com", "/api"))
+		req.Equal("api", serve(demux, "b.example.com", "/api"))
+		req.Equal("hosted", serve(demux, "b.example.com", "/hosted"))
+		req.Equal("default", serve(demux, "b.example.com", "/other"))
+	})
+
+	t.Run("unmatched requests without a default handler use the composite's default http handler", func(t *testing.T) {
+		req := require.New(t)
+
+		pathFactory := &PathPrefixDemuxFactory{}
+		pathFactory.SetDefaultHttpHandler(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			_, _ = writer.Write([]byte("path fallback"))
+		}))
+
+		factory := NewCompositeDemuxFactory(&HostHeaderDemuxFactory{}, pathFactory)
+		factory.SetDefaultHttpHandler(http.HandlerFunc(func(writer http.ResponseWriter, _ *http.Request) {
+			_, _ = writer.Write([]byte("fallback"))
+		}))
+
+		demux, err := factory.Build([]ApiHandler{
+			&pathHandler{name: "api", rootPath: "/api", mockHandler: mockHandler{defaultIneligible: true}},
+		})
+		req.NoError(err)
+
+		req.Equal("api", serve(demux, "a.example.com", "/api"))
+		req.Equal("fallback", serve(demux, "a.example.com", "/other"))
+	})
+
+	t.Run("factories that do not build matching demux handlers are an error", func(t *testing.T) {
+		req := require.New(t)
+
+		factory := NewCompositeDemuxFactory(&PathPrefixDemuxFactory{}, &plainDemuxFactory{})
+		_, err := factory.Build([]ApiHandler{&pathHandler{name: "api", rootPath: "/api"}})
+		req.Error(err)
+	})
+
+	t.Run("at least one factory is required", func(t *testing.T) {
+		_, err := NewCompositeDemuxFactory().Build([]ApiHandler{&pathHandler{name: "api", rootPath: "/api"}})
+		require.New(t).Error(err)
+	})
+}
+
+// plainDemuxFactory is a DemuxFactory that builds a DemuxHandler that does not implement MatchingDemuxHandler
+type plainDemuxFactory struct{}
+
+func (factory *plainDemuxFactory) Build(handlers []ApiHandler) (DemuxHandler, error) {
+	return &DemuxHandlerImpl{Handler: handlers[0]}, nil
 }
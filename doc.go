@@ -44,5 +44,18 @@ Another way to say it: each Instance defines a configuration section (default `w
 hosted APIs. Each ServerConfig maps to one Server/http.Server per BindPointConfig. No two Server instances can have
 colliding BindPointConfig's due to port conflicts.
 
+Options shared by many servers (e.g. timeouts and TLS versions) may be defined once in an optional root section
+(default `webOptions`, see InstanceConfig.OptionsSection) next to the `web` section. Each ServerConfig inherits them
+and its own `options` override them. The section is not named `options` as root sections are shared with the
+configuration of the application embedding xweb.
+
+	webOptions:
+	  readTimeout: 10s
+	  minTLSVersion: TLS1.3
+	web:
+	  - name: api
+	    options:
+	      readTimeout: 30s
+
 */
 package xweb
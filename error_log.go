@@ -0,0 +1,80 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/michaelquigley/pfxlog"
+	"io"
+	"strings"
+)
+
+// tlsHandshakeErrorPrefix prefixes the TLS handshake errors logged by http.Server's
+const tlsHandshakeErrorPrefix = "http: TLS handshake error"
+
+// benignHandshakeErrors are TLS handshake errors commonly caused by port scanners, health probes, and clients that
+// disconnect early rather than by misconfiguration
+var benignHandshakeErrors = []string{
+	"first record does not look like a TLS handshake",
+	"client offered only unsupported versions",
+	"no cipher suite supported by both client and server",
+	"unsupported SSLv2 handshake received",
+	"connection reset by peer",
+	"broken pipe",
+	"i/o timeout",
+	": EOF",
+}
+
+// handshakeErrorFilter is an io.Writer for http.Server ErrorLog's that logs benign TLS handshake errors (see
+// benignHandshakeErrors) at debug level and writes all other messages to next.
+type handshakeErrorFilter struct {
+	serverName string
+	next       io.Writer
+}
+
+func newHandshakeErrorFilter(serverName string, next io.Writer) *handshakeErrorFilter {
+	return &handshakeErrorFilter{
+		serverName: serverName,
+		next:       next,
+	}
+}
+
+func (filter *handshakeErrorFilter) Write(p []byte) (int, error) {
+	message := strings.TrimSpace(string(p))
+
+	if isBenignHandshakeError(message) {
+		pfxlog.Logger().WithField("server", filter.serverName).Debug(message)
+		return len(p), nil
+	}
+
+	return filter.next.Write(p)
+}
+
+// isBenignHandshakeError returns true if message is a TLS handshake error logged by a http.Server that is listed in
+// benignHandshakeErrors
+func isBenignHandshakeError(message string) bool {
+	if !strings.HasPrefix(message, tlsHandshakeErrorPrefix) {
+		return false
+	}
+
+	for _, benign := range benignHandshakeErrors {
+		if strings.Contains(message, benign) {
+			return true
+		}
+	}
+
+	return false
+}
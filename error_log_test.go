@@ -0,0 +1,67 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/require"
+	"log"
+	"testing"
+)
+
+func Test_handshakeErrorFilter(t *testing.T) {
+	t.Run("benign handshake errors are filtered", func(t *testing.T) {
+		req := require.New(t)
+
+		buffer := &bytes.Buffer{}
+		errorLog := log.New(newHandshakeErrorFilter("test", buffer), "", 0)
+
+		errorLog.Printf("http: TLS handshake error from 10.0.0.1:5555: tls: first record does not look like a TLS handshake")
+		errorLog.Printf("http: TLS handshake error from 10.0.0.1:5555: EOF")
+		errorLog.Printf("http: TLS handshake error from 10.0.0.1:5555: read tcp 10.0.0.2:443->10.0.0.1:5555: read: connection reset by peer")
+		req.Empty(buffer.String())
+	})
+
+	t.Run("other errors are written", func(t *testing.T) {
+		req := require.New(t)
+
+		buffer := &bytes.Buffer{}
+		errorLog := log.New(newHandshakeErrorFilter("test", buffer), "", 0)
+
+		errorLog.Printf("http: TLS handshake error from 10.0.0.1:5555: tls: client didn't provide a certificate")
+		errorLog.Printf("http: Accept error: accept tcp: too many open files")
+		req.Contains(buffer.String(), "client didn't provide a certificate")
+		req.Contains(buffer.String(), "too many open files")
+	})
+
+	t.Run("is toggled by the filterHandshakeErrors option", func(t *testing.T) {
+		req := require.New(t)
+
+		id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+		server := newTestServer(t, newTestInstance(t, id, nil), newTestServerConfig(id, "127.0.0.1:443"))
+		_, filtered := server.httpServers[0].ErrorLog.Writer().(*handshakeErrorFilter)
+		req.False(filtered)
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		req.NoError(serverConfig.Options.Parse(map[interface{}]interface{}{"filterHandshakeErrors": true}))
+
+		server = newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+		_, filtered = server.httpServers[0].ErrorLog.Writer().(*handshakeErrorFilter)
+		req.True(filtered)
+	})
+}
@@ -0,0 +1,92 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// HandlerTimeoutMessage is the body of the http.StatusServiceUnavailable (503) response sent when an ApiHandler does not
+// complete within its handler timeout
+const HandlerTimeoutMessage = "request processing timed out"
+
+// handlerTimeoutsContextKey is the context key of the handlerTimeouts of the Server handling a request
+const handlerTimeoutsContextKey = ContextKey("xweb.handlerTimeouts.ContextKey")
+
+// handlerTimeouts are the ApiHandler processing time limits of a Server: the server wide timeout from
+// TimeoutOptions.HandlerTimeout and the timeouts of APIs that override it. A timeout of zero is unlimited.
+type handlerTimeouts struct {
+	defaultTimeout time.Duration
+	bindings       map[string]time.Duration
+}
+
+// newHandlerTimeouts returns the handlerTimeouts for the supplied options and APIs or nil if no timeouts apply.
+func newHandlerTimeouts(options *Options, apis []*ApiConfig) *handlerTimeouts {
+	timeouts := &handlerTimeouts{
+		defaultTimeout: options.HandlerTimeout,
+		bindings:       map[string]time.Duration{},
+	}
+
+	for _, api := range apis {
+		if timeout, ok := api.HandlerTimeout(); ok {
+			if _, exists := timeouts.bindings[api.Binding()]; !exists {
+				timeouts.bindings[api.Binding()] = timeout
+			}
+		}
+	}
+
+	if timeouts.defaultTimeout == 0 && len(timeouts.bindings) == 0 {
+		return nil
+	}
+
+	return timeouts
+}
+
+// timeoutFor returns the processing timeout for requests served by the ApiHandler with the supplied binding.
+func (timeouts *handlerTimeouts) timeoutFor(binding string) time.Duration {
+	if timeout, ok := timeouts.bindings[binding]; ok {
+		return timeout
+	}
+
+	return timeouts.defaultTimeout
+}
+
+// wrap returns handler wrapped with http.TimeoutHandler if a timeout applies to the supplied binding. Responses of
+// wrapped handlers are buffered and may not be flushed or hijacked, streaming APIs should opt out.
+func (timeouts *handlerTimeouts) wrap(binding string, handler http.Handler) http.Handler {
+	timeout := timeouts.timeoutFor(binding)
+
+	if timeout <= 0 {
+		return handler
+	}
+
+	return http.TimeoutHandler(handler, timeout, HandlerTimeoutMessage)
+}
+
+// wrapHandlerTimeouts wraps a http.Handler with another http.Handler that makes the Server's current handlerTimeouts
+// available to serveApiHandler, which applies the timeout of the ApiHandler selected for the request.
+func (server *Server) wrapHandlerTimeouts(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if timeouts := server.handlerTimeouts.Load(); timeouts != nil {
+			request = request.WithContext(context.WithValue(request.Context(), handlerTimeoutsContextKey, timeouts))
+		}
+
+		handler.ServeHTTP(writer, request)
+	})
+}
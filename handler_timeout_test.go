@@ -0,0 +1,116 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// slowHandler waits for delay before responding with http.StatusOK
+type slowHandler struct {
+	bindingPathHandler
+	delay time.Duration
+}
+
+func (h *slowHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	select {
+	case <-time.After(h.delay):
+		writer.WriteHeader(http.StatusOK)
+	case <-request.Context().Done():
+	}
+}
+
+// slowHandlerFactory creates slowHandler's for binding
+type slowHandlerFactory struct {
+	binding string
+}
+
+func (f *slowHandlerFactory) Binding() string {
+	return f.binding
+}
+
+func (f *slowHandlerFactory) New(_ *ServerConfig, _ map[interface{}]interface{}) (ApiHandler, error) {
+	return &slowHandler{bindingPathHandler: bindingPathHandler{pathHandler{name: f.binding, rootPath: "/" + f.binding}}, delay: 200 * time.Millisecond}, nil
+}
+
+func (f *slowHandlerFactory) Validate(_ *InstanceConfig) error {
+	return nil
+}
+
+func TestServer_handlerTimeout(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "localhost")
+
+	registry := NewRegistryMap()
+	for _, binding := range []string{"limited", "streaming"} {
+		req.NoError(registry.Add(&slowHandlerFactory{binding: binding}))
+	}
+
+	instance := NewDefaultInstance(registry, id)
+	instance.DemuxFactory = &PathPrefixDemuxFactory{}
+
+	streaming := &ApiConfig{}
+	req.NoError(streaming.Parse(map[interface{}]interface{}{"binding": "streaming", "handlerTimeout": "0s"}))
+
+	serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+	serverConfig.APIs = []*ApiConfig{{binding: "limited"}, streaming}
+	req.NoError(serverConfig.Options.Parse(map[interface{}]interface{}{"handlerTimeout": "20ms"}))
+	req.NoError(serverConfig.Validate(registry))
+
+	server := newTestServer(t, instance, serverConfig)
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.httpServers[0].Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder
+	}
+
+	t.Run("requests exceeding the timeout receive a 503", func(t *testing.T) {
+		req := require.New(t)
+
+		recorder := serve("/limited")
+		req.Equal(http.StatusServiceUnavailable, recorder.Code)
+		req.Equal(HandlerTimeoutMessage, recorder.Body.String())
+	})
+
+	t.Run("apis may opt out of the timeout", func(t *testing.T) {
+		require.New(t).Equal(http.StatusOK, serve("/streaming").Code)
+	})
+
+	t.Run("the timeout may be updated on a running server", func(t *testing.T) {
+		req := require.New(t)
+
+		options := serverConfig.Options
+		options.HandlerTimeout = 0
+		req.NoError(server.UpdateOptions(options))
+
+		req.Equal(http.StatusOK, serve("/limited").Code)
+	})
+
+	t.Run("invalid values are an error", func(t *testing.T) {
+		req := require.New(t)
+
+		options := &Options{}
+		req.Error(options.Parse(map[interface{}]interface{}{"handlerTimeout": 5}))
+		req.Error((&ApiConfig{}).Parse(map[interface{}]interface{}{"binding": "streaming", "handlerTimeout": "-1s"}))
+	})
+}
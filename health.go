@@ -0,0 +1,176 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// LivenessPath is the path of the liveness endpoint installed when InstanceOptions.HealthChecks is enabled. It
+	// responds with http.StatusOK while the Server is able to handle requests.
+	LivenessPath = "/healthz"
+
+	// ReadinessPath is the path of the readiness endpoint installed when InstanceOptions.HealthChecks is enabled. It
+	// responds with http.StatusOK while the Server is ready and all of its HealthCheckingApiHandler's are healthy and
+	// http.StatusServiceUnavailable (503) otherwise. The body is a JSON HealthReport.
+	ReadinessPath = "/readyz"
+
+	// HealthCheckTimeout is the time all HealthCheckingApiHandler's of a Server are given to complete their checks
+	HealthCheckTimeout = 5 * time.Second
+
+	HealthStatusReady     = "ready"
+	HealthStatusNotReady  = "not ready"
+	HealthStatusUnhealthy = "unhealthy"
+	HealthStatusOk        = "ok"
+	HealthStatusError     = "error"
+)
+
+// HealthCheckingApiHandler is an optional interface for ApiHandler's that depend on resources (e.g. databases or
+// upstream services) whose health should contribute to the readiness of the Server hosting them.
+type HealthCheckingApiHandler interface {
+	ApiHandler
+
+	// HealthCheck returns an error if the ApiHandler is not healthy. It should return promptly once ctx is done.
+	HealthCheck(ctx context.Context) error
+}
+
+// HealthReport is the JSON body returned by the readiness endpoint.
+type HealthReport struct {
+	// Status is HealthStatusReady, HealthStatusNotReady if the Server is not serving, or HealthStatusUnhealthy if any
+	// HealthCheckingApiHandler reported an error.
+	Status string `json:"status"`
+
+	// Apis is the result of each HealthCheckingApiHandler keyed by binding
+	Apis map[string]ApiHealth `json:"apis,omitempty"`
+}
+
+// ApiHealth is the health of a single HealthCheckingApiHandler.
+type ApiHealth struct {
+	// Status is HealthStatusOk or HealthStatusError
+	Status string `json:"status"`
+
+	// Error is the error returned by HealthCheck, if any
+	Error string `json:"error,omitempty"`
+}
+
+// IsReady returns true if all bind points of the Server are bound and serving and Shutdown has not been called.
+func (server *Server) IsReady() bool {
+	if server.shuttingDown.Load() || len(server.httpServers) == 0 {
+		return false
+	}
+
+	for _, httpServer := range server.httpServers {
+		if !httpServer.listening.Load() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isHealthCheckRequest returns true if health checks are enabled and the request is for LivenessPath or ReadinessPath.
+func (server *Server) isHealthCheckRequest(request *http.Request) bool {
+	if !server.instanceOptions.HealthChecks {
+		return false
+	}
+
+	return request.URL.Path == LivenessPath || request.URL.Path == ReadinessPath
+}
+
+// HealthCheck runs the health checks of all HealthCheckingApiHandler's of the Server concurrently and returns the
+// result of each, keyed by binding. A nil error indicates a healthy ApiHandler.
+func (server *Server) HealthCheck(ctx context.Context) map[string]error {
+	results := map[string]error{}
+	resultsLock := sync.Mutex{}
+	waitGroup := sync.WaitGroup{}
+
+	for _, handler := range server.healthCheckers {
+		waitGroup.Add(1)
+
+		localHandler := handler
+		go func() {
+			defer waitGroup.Done()
+
+			err := localHandler.HealthCheck(ctx)
+
+			resultsLock.Lock()
+			results[localHandler.Binding()] = err
+			resultsLock.Unlock()
+		}()
+	}
+
+	waitGroup.Wait()
+
+	return results
+}
+
+// healthReport builds the HealthReport for the Server and returns it with the HTTP status code it should be
+// served with.
+func (server *Server) healthReport(ctx context.Context) (*HealthReport, int) {
+	if !server.IsReady() {
+		return &HealthReport{Status: HealthStatusNotReady}, http.StatusServiceUnavailable
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckTimeout)
+	defer cancel()
+
+	report := &HealthReport{Status: HealthStatusReady}
+	statusCode := http.StatusOK
+
+	for binding, err := range server.HealthCheck(ctx) {
+		if report.Apis == nil {
+			report.Apis = map[string]ApiHealth{}
+		}
+
+		if err != nil {
+			report.Apis[binding] = ApiHealth{Status: HealthStatusError, Error: err.Error()}
+			report.Status = HealthStatusUnhealthy
+			statusCode = http.StatusServiceUnavailable
+		} else {
+			report.Apis[binding] = ApiHealth{Status: HealthStatusOk}
+		}
+	}
+
+	return report, statusCode
+}
+
+// wrapHealthChecks wraps a http.Handler with another http.Handler that answers requests for LivenessPath and
+// ReadinessPath and passes all other requests through.
+func (server *Server) wrapHealthChecks(handler http.Handler) http.Handler {
+	wrappedHandler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		switch request.URL.Path {
+		case LivenessPath:
+			writer.WriteHeader(http.StatusOK)
+			_, _ = writer.Write([]byte("ok"))
+		case ReadinessPath:
+			report, statusCode := server.healthReport(request.Context())
+
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(statusCode)
+			_ = json.NewEncoder(writer).Encode(report)
+		default:
+			handler.ServeHTTP(writer, request)
+		}
+	})
+
+	return wrappedHandler
+}
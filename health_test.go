@@ -0,0 +1,169 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestServer_healthChecks(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+	instance := newTestInstance(t, id, nil)
+	instance.Config.Options.HealthChecks = true
+
+	serverConfig := newTestServerConfig(id, freeAddress(t))
+	secondAddress := freeAddress(t)
+	serverConfig.BindPoints = append(serverConfig.BindPoints, &BindPointConfig{InterfaceAddress: secondAddress, Address: secondAddress})
+
+	server := newTestServer(t, instance, serverConfig)
+
+	t.Run("readiness is unavailable before start", func(t *testing.T) {
+		req := require.New(t)
+		recorder := httptest.NewRecorder()
+		server.httpServers[0].Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+		req.Equal(http.StatusServiceUnavailable, recorder.Code)
+		req.False(server.IsReady())
+	})
+
+	startTestServer(t, server)
+
+	t.Run("all bind points are ready after start", func(t *testing.T) {
+		req := require.New(t)
+		req.Eventually(server.IsReady, 5*time.Second, 10*time.Millisecond)
+
+		client := newTestClient()
+		for _, httpServer := range server.httpServers {
+			for _, path := range []string{LivenessPath, ReadinessPath} {
+				resp, err := client.Get("https://" + httpServer.Addr + path)
+				req.NoError(err)
+				_ = resp.Body.Close()
+				req.Equal(http.StatusOK, resp.StatusCode, "%s on %s", path, httpServer.Addr)
+			}
+		}
+	})
+
+	t.Run("requests for other paths reach the ApiHandler", func(t *testing.T) {
+		req := require.New(t)
+		resp, err := newTestClient().Get("https://" + server.httpServers[0].Addr + "/mock-handler")
+		req.NoError(err)
+		_ = resp.Body.Close()
+		req.Equal(http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("readiness is unavailable after shutdown", func(t *testing.T) {
+		req := require.New(t)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+
+		req.False(server.IsReady())
+
+		recorder := httptest.NewRecorder()
+		server.httpServers[0].Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+		req.Equal(http.StatusServiceUnavailable, recorder.Code)
+	})
+}
+
+func TestServer_healthChecksAreNotRateLimited(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+	instance := newTestInstance(t, id, nil)
+	instance.Config.Options.HealthChecks = true
+
+	serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+	serverConfig.Options.RateLimit = 1
+	serverConfig.Options.RateLimitBurst = 1
+
+	handler := newTestServer(t, instance, serverConfig).httpServers[0].Handler
+
+	serve := func(path string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		return recorder.Code
+	}
+
+	req.Equal(http.StatusOK, serve("/mock-handler"))
+	req.Equal(http.StatusTooManyRequests, serve("/mock-handler"))
+	req.Equal(http.StatusOK, serve(LivenessPath))
+}
+
+// healthCheckingHandler is a mockHandler whose health check returns err
+type healthCheckingHandler struct {
+	mockHandler
+	err atomic.Pointer[error]
+}
+
+func (h *healthCheckingHandler) HealthCheck(_ context.Context) error {
+	if err := h.err.Load(); err != nil {
+		return *err
+	}
+	return nil
+}
+
+func TestServer_healthCheckingApiHandlers(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	handler := &healthCheckingHandler{}
+	instance := newTestInstance(t, id, handler)
+	instance.Config.Options.HealthChecks = true
+
+	server := newTestServer(t, instance, newTestServerConfig(id, freeAddress(t)))
+	startTestServer(t, server)
+	defer server.Shutdown(context.Background())
+
+	readiness := func() (int, *HealthReport) {
+		recorder := httptest.NewRecorder()
+		server.httpServers[0].Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+
+		report := &HealthReport{}
+		require.New(t).NoError(json.Unmarshal(recorder.Body.Bytes(), report))
+
+		return recorder.Code, report
+	}
+
+	t.Run("healthy handlers are reported per binding", func(t *testing.T) {
+		req := require.New(t)
+		req.Eventually(server.IsReady, 5*time.Second, 10*time.Millisecond)
+
+		code, report := readiness()
+		req.Equal(http.StatusOK, code)
+		req.Equal(HealthStatusReady, report.Status)
+		req.Equal(map[string]ApiHealth{"mockHandler": {Status: HealthStatusOk}}, report.Apis)
+	})
+
+	t.Run("unhealthy handlers make the server unavailable", func(t *testing.T) {
+		req := require.New(t)
+
+		err := errors.New("database unreachable")
+		handler.err.Store(&err)
+		defer handler.err.Store(nil)
+
+		code, report := readiness()
+		req.Equal(http.StatusServiceUnavailable, code)
+		req.Equal(HealthStatusUnhealthy, report.Status)
+		req.Equal(map[string]ApiHealth{"mockHandler": {Status: HealthStatusError, Error: "database unreachable"}}, report.Apis)
+	})
+}
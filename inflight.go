@@ -0,0 +1,109 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"github.com/michaelquigley/pfxlog"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// InFlightRequest describes a request that is being served by a Server with in-flight request tracking enabled.
+type InFlightRequest struct {
+	ID         uint64
+	Method     string
+	Path       string
+	RemoteAddr string
+	Started    time.Time
+
+	cancel context.CancelFunc
+}
+
+// Age returns how long the request has been in flight.
+func (request *InFlightRequest) Age() time.Duration {
+	return time.Since(request.Started)
+}
+
+// Cancel cancels the context of the request. Handlers that respect their request's context stop serving it.
+func (request *InFlightRequest) Cancel() {
+	request.cancel()
+}
+
+// inFlightTracker tracks the requests being served by a Server.
+type inFlightTracker struct {
+	nextId   atomic.Uint64
+	requests sync.Map
+}
+
+// wrap wraps a http.Handler with another http.Handler that tracks requests while they are being served.
+func (tracker *inFlightTracker) wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		ctx, cancel := context.WithCancel(request.Context())
+		defer cancel()
+
+		inFlightRequest := &InFlightRequest{
+			ID:         tracker.nextId.Add(1),
+			Method:     request.Method,
+			Path:       request.URL.Path,
+			RemoteAddr: request.RemoteAddr,
+			Started:    time.Now(),
+			cancel:     cancel,
+		}
+
+		tracker.requests.Store(inFlightRequest.ID, inFlightRequest)
+		defer tracker.requests.Delete(inFlightRequest.ID)
+
+		handler.ServeHTTP(writer, request.WithContext(ctx))
+	})
+}
+
+// list returns the tracked requests, oldest first.
+func (tracker *inFlightTracker) list() []*InFlightRequest {
+	var requests []*InFlightRequest
+
+	tracker.requests.Range(func(_, value interface{}) bool {
+		requests = append(requests, value.(*InFlightRequest))
+		return true
+	})
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].ID < requests[j].ID
+	})
+
+	return requests
+}
+
+// InFlightRequests returns the requests currently being served, oldest first. It returns nil unless the
+// trackInFlightRequests option is enabled.
+func (server *Server) InFlightRequests() []*InFlightRequest {
+	if server.inFlight == nil {
+		return nil
+	}
+
+	return server.inFlight.list()
+}
+
+// logInFlightRequests logs the requests that are still being served, used to diagnose shutdowns that do not drain.
+func (server *Server) logInFlightRequests() {
+	for _, request := range server.InFlightRequests() {
+		pfxlog.Logger().Warnf("server %s request still in flight during shutdown: %s %s from %s for %s", server.ServerConfig.Name, request.Method, request.Path, request.RemoteAddr, request.Age())
+	}
+}
@@ -0,0 +1,72 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestServer_InFlightRequests(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("tracking is disabled by default", func(t *testing.T) {
+		server := newTestServer(t, newTestInstance(t, id, nil), newTestServerConfig(id, "127.0.0.1:443"))
+		require.New(t).Nil(server.InFlightRequests())
+	})
+
+	t.Run("active requests are listed until they complete or are canceled", func(t *testing.T) {
+		req := require.New(t)
+
+		started := make(chan struct{})
+		handler := &funcHandler{handlerFunc: func(writer http.ResponseWriter, request *http.Request) {
+			close(started)
+			<-request.Context().Done()
+		}}
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.Options.TrackInFlightRequests = true
+		server := newTestServer(t, newTestInstance(t, id, handler), serverConfig)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			server.httpServers[0].Handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPut, "/stuck", nil))
+		}()
+
+		<-started
+
+		requests := server.InFlightRequests()
+		req.Len(requests, 1)
+		req.Equal(http.MethodPut, requests[0].Method)
+		req.Equal("/stuck", requests[0].Path)
+		req.Greater(requests[0].Age(), time.Duration(0))
+
+		requests[0].Cancel()
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			req.Fail("expected the canceled request to complete")
+		}
+
+		req.Empty(server.InFlightRequests())
+	})
+}
@@ -0,0 +1,29 @@
+//go:build !xweb_insecure_notls
+
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+)
+
+// enableInsecureNoTls rejects InstanceOptions.InsecureNoTLS in binaries built without the xweb_insecure_notls build
+// tag so that it cannot be enabled in production builds.
+func enableInsecureNoTls(serverConfig *ServerConfig) error {
+	return fmt.Errorf("server %s requested InsecureNoTLS, which requires building with the xweb_insecure_notls build tag", serverConfig.Name)
+}
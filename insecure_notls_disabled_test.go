@@ -0,0 +1,36 @@
+//go:build !xweb_insecure_notls
+
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestNewServer_insecureNoTlsRequiresBuildTag(t *testing.T) {
+	req := require.New(t)
+
+	instance := newTestInstance(t, nil, nil)
+	instance.Config.Options.InsecureNoTLS = true
+
+	server, err := NewServer(instance, newTestServerConfig(nil, "127.0.0.1:443"))
+	req.Error(err)
+	req.Nil(server)
+	req.Contains(err.Error(), "xweb_insecure_notls")
+}
@@ -0,0 +1,66 @@
+//go:build xweb_insecure_notls
+
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewServer_insecureNoTls(t *testing.T) {
+	req := require.New(t)
+
+	handler := &funcHandler{handlerFunc: func(writer http.ResponseWriter, _ *http.Request) {
+		_, _ = writer.Write([]byte("plain"))
+	}}
+
+	instance := newTestInstance(t, nil, handler)
+	instance.Config.Options.InsecureNoTLS = true
+
+	address := freeAddress(t)
+	server := newTestServer(t, instance, newTestServerConfig(nil, address))
+
+	go func() {
+		_ = server.Start()
+	}()
+
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	var body []byte
+	req.Eventually(func() bool {
+		resp, err := http.Get("http://" + address + "/")
+		if err != nil {
+			return false
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		body, err = io.ReadAll(resp.Body)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	req.Equal("plain", string(body))
+}
@@ -17,9 +17,12 @@ package xweb
 
 import (
 	"context"
+	"fmt"
 	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/foundation/v2/errorz"
 	"github.com/openziti/identity"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -29,7 +32,7 @@ type Instance interface {
 	DefaultHttpHandlerProvider
 	Enabled() bool
 	LoadConfig(cfgmap map[interface{}]interface{}) error
-	Run()
+	Run() error
 	Shutdown()
 	GetRegistry() Registry
 	GetDemuxFactory() DemuxFactory
@@ -39,15 +42,32 @@ type Instance interface {
 const (
 	DefaultIdentitySection = "identity"
 	DefaultConfigSection   = "web"
+	DefaultOptionsSection  = "webOptions"
+
+	waitForServerInterval = 10 * time.Millisecond
 )
 
 // InstanceImpl is a basic implementation of Instance.
 type InstanceImpl struct {
 	DefaultHttpHandlerProviderImpl
 	Config       *InstanceConfig
-	servers      []*Server
 	Registry     Registry
 	DemuxFactory DemuxFactory
+
+	serversLock sync.RWMutex
+	servers     []*Server
+	reloadLock  sync.Mutex
+
+	startErrors     chan error
+	startErrorsOnce sync.Once
+
+	readyLock        sync.Mutex
+	readyFileWritten bool
+	shutdown         bool
+
+	shutdownCompleteOnce       sync.Once
+	shutdownCompleteNotify     chan struct{}
+	shutdownCompleteNotifyOnce sync.Once
 }
 
 var _ Instance = &InstanceImpl{}
@@ -60,6 +80,7 @@ func NewDefaultInstance(registry Registry, defaultIdentity identity.Identity) *I
 			DefaultIdentitySection: DefaultIdentitySection,
 			DefaultIdentity:        defaultIdentity,
 			Section:                DefaultConfigSection,
+			OptionsSection:         DefaultOptionsSection,
 		},
 	}
 }
@@ -98,47 +119,337 @@ func (i *InstanceImpl) LoadConfig(cfgmap map[interface{}]interface{}) error {
 	return nil
 }
 
-// Build assembles all the xweb components from configuration and prepares to have Start() called.
-func (i *InstanceImpl) Build() {
+// ValidateOnly parses and validates cfgmap as LoadConfig would, returning the same errors, without altering the
+// InstanceImpl's InstanceConfig. No servers are created, no listeners are bound, and file watching is not enabled on
+// the identities loaded for validation. It is intended for checking configurations before they are deployed.
+func (i *InstanceImpl) ValidateOnly(cfgmap map[interface{}]interface{}) error {
+	config := &InstanceConfig{
+		DefaultIdentity:        i.Config.DefaultIdentity,
+		DefaultIdentitySection: i.Config.DefaultIdentitySection,
+		Section:                i.Config.Section,
+		OptionsSection:         i.Config.OptionsSection,
+		Options:                i.Config.Options,
+		validateOnly:           true,
+	}
+
+	if err := config.Parse(cfgmap); err != nil {
+		return err
+	}
+
+	return config.Validate(i.Registry)
+}
+
+// Build assembles all the xweb components from configuration, binds all of their bind points, and prepares to have
+// Start() called. If a server cannot be created or bound, the bind points already bound by Build are closed and an
+// error is returned. Bind failures are also reported on the channel returned by StartErrors().
+func (i *InstanceImpl) Build() error {
 	for _, serverConfig := range i.Config.ServerConfigs {
 		server, err := NewServer(i, serverConfig)
 
 		if err != nil {
-			pfxlog.Logger().Fatalf("error starting xweb server for %s: %v", serverConfig.Name, err)
+			i.abortBuild()
+			return fmt.Errorf("error creating xweb server for %s: %w", serverConfig.Name, err)
 		}
 
+		i.serversLock.Lock()
 		i.servers = append(i.servers, server)
+		i.serversLock.Unlock()
+
+		if err = i.bindServer(server); err != nil {
+			i.abortBuild()
+			return fmt.Errorf("error starting server %s: %w", serverConfig.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// bindServer registers and binds all bind points of a Server. Failures are recorded on the Server and reported on the
+// channel returned by StartErrors().
+func (i *InstanceImpl) bindServer(server *Server) error {
+	err := i.registerBindPoints(server)
+
+	if err == nil {
+		err = server.Listen()
+	}
+
+	if err != nil {
+		server.setStartError(err)
+		i.reportStartError(server, err)
+	}
+
+	return err
+}
+
+// getServers returns a snapshot of the Servers built by the instance.
+func (i *InstanceImpl) getServers() []*Server {
+	i.serversLock.RLock()
+	defer i.serversLock.RUnlock()
+
+	return append([]*Server(nil), i.servers...)
+}
+
+// abortBuild closes the listeners of and unregisters all servers created by Build.
+func (i *InstanceImpl) abortBuild() {
+	for _, server := range i.getServers() {
+		server.closeListeners()
+
+		if registry := i.Config.Options.BindRegistry; registry != nil {
+			registry.Unregister(server)
+		}
 	}
 }
 
-// Start calls Start() on all Servers that were built by calling Build().
+// Start calls Start() on all Servers that were built by calling Build(). Servers are started asynchronously, failures
+// are logged and reported on the channel returned by StartErrors(). If any Server fails to start or stops serving due
+// to an error, the whole instance is shut down so that it is never left partially serving.
 func (i *InstanceImpl) Start() {
-	for _, server := range i.servers {
+	for _, server := range i.getServers() {
 		s := server //avoid closure scoping issues
+
 		go func() {
 			if err := s.Start(); err != nil {
-				pfxlog.Logger().Errorf("error starting server %s: %v", s.ServerConfig.Name, err)
+				i.reportStartError(s, err)
+				i.rollbackStart(s)
 			}
 		}()
 	}
+
+	i.startReadyNotification()
+}
+
+// rollbackStart shuts down the instance after the supplied Server failed, unless it is already shut down.
+func (i *InstanceImpl) rollbackStart(failed *Server) {
+	if i.isShutdown() {
+		return
+	}
+
+	pfxlog.Logger().Errorf("server %s failed, shutting down all servers", failed.ServerConfig.Name)
+	i.Shutdown()
+}
+
+// IsReady returns true if the instance has servers and all of them have bound and are serving all of their bind
+// points. It returns false once Shutdown has been called.
+func (i *InstanceImpl) IsReady() bool {
+	servers := i.getServers()
+
+	if len(servers) == 0 {
+		return false
+	}
+
+	for _, server := range servers {
+		if !server.IsReady() {
+			return false
+		}
+	}
+
+	return true
 }
 
-// Run builds and starts the necessary xweb.Server's
-func (i *InstanceImpl) Run() {
-	i.Build()
+// reportStartError logs a Server start failure and reports it on the channel returned by StartErrors().
+func (i *InstanceImpl) reportStartError(server *Server, err error) {
+	pfxlog.Logger().Errorf("error starting server %s: %v", server.ServerConfig.Name, err)
+
+	select {
+	case i.getStartErrors() <- fmt.Errorf("error starting server %s: %w", server.ServerConfig.Name, err):
+	default:
+		pfxlog.Logger().Warnf("start error channel is full, error for server %s not reported", server.ServerConfig.Name)
+	}
+}
+
+// registerBindPoints registers all bind points of a Server with InstanceOptions.BindRegistry, if set. If any bind
+// point collides, the Server's registrations are removed and an error is returned.
+func (i *InstanceImpl) registerBindPoints(server *Server) error {
+	registry := i.Config.Options.BindRegistry
+
+	if registry == nil {
+		return nil
+	}
+
+	description := fmt.Sprintf("server [%s] of instance [%s]", server.ServerConfig.Name, i.name())
+
+	for _, bindPoint := range server.ServerConfig.BindPoints {
+		if err := registry.RegisterNetwork(bindPoint.ListenNetwork(), bindPoint.InterfaceAddress, server, description); err != nil {
+			registry.Unregister(server)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// name returns InstanceOptions.Name or the configuration section if no name is set.
+func (i *InstanceImpl) name() string {
+	if i.Config.Options.Name != "" {
+		return i.Config.Options.Name
+	}
+
+	return i.Config.Section
+}
+
+// WaitForServer blocks until all bind points of the server built from the ServerConfig with the supplied name are
+// serving. An error is returned if the server fails to start or timeout elapses first.
+func (i *InstanceImpl) WaitForServer(name string, timeout time.Duration) error {
+	ticker := time.NewTicker(waitForServerInterval)
+	defer ticker.Stop()
+
+	deadline := time.After(timeout)
+
+	for {
+		found := false
+		ready := true
+
+		for _, server := range i.getServers() {
+			if server.ServerConfig.Name != name {
+				continue
+			}
+
+			found = true
+
+			if err := server.StartError(); err != nil {
+				return fmt.Errorf("server %s failed to start: %w", name, err)
+			}
+
+			if !server.IsReady() {
+				ready = false
+			}
+		}
+
+		if found && ready {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			if !found {
+				return fmt.Errorf("timed out after %s waiting for server %s, no server with that name was built", timeout, name)
+			}
+			return fmt.Errorf("timed out after %s waiting for server %s to be serving", timeout, name)
+		}
+	}
+}
+
+// StartErrors returns a channel that receives an error for each Server that fails to start (e.g. its address is
+// already in use) or stops serving due to an error. Servers that stop due to Shutdown do not report errors. The
+// channel is never closed.
+func (i *InstanceImpl) StartErrors() <-chan error {
+	return i.getStartErrors()
+}
+
+func (i *InstanceImpl) getStartErrors() chan error {
+	i.startErrorsOnce.Do(func() {
+		size := 1
+
+		if i.Config != nil && len(i.Config.ServerConfigs) > size {
+			size = len(i.Config.ServerConfigs)
+		}
+
+		i.startErrors = make(chan error, size)
+	})
+
+	return i.startErrors
+}
+
+// Run builds and starts the necessary xweb.Server's. An error is returned, and no server is started, if any server
+// cannot be built or bound. Run does not block, use Wait to block until the servers have been shut down.
+func (i *InstanceImpl) Run() error {
+	if err := i.Build(); err != nil {
+		return err
+	}
+
 	i.Start()
+
+	return nil
 }
 
-// Shutdown stop all running xweb.Server's
+// Shutdown stop all running xweb.Server's. Each server is given its shutdown timeout to drain in-flight requests
+// before its connections are forcibly closed. InstanceOptions.OnShutdownComplete is invoked once all servers have been
+// shut down.
 func (i *InstanceImpl) Shutdown() {
-	for _, server := range i.servers {
+	i.stopReadyNotification()
+
+	//wait for any in progress Reload so that the servers it starts are shut down as well
+	i.reloadLock.Lock()
+	defer i.reloadLock.Unlock()
+
+	waitGroup := &sync.WaitGroup{}
+
+	for _, server := range i.getServers() {
+		waitGroup.Add(1)
+
 		localServer := server
 		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
-			defer cancel()
-			localServer.Shutdown(ctx)
+			defer waitGroup.Done()
+			i.shutdownServer(localServer)
 		}()
 	}
+
+	go func() {
+		waitGroup.Wait()
+
+		i.shutdownCompleteOnce.Do(func() {
+			if onShutdownComplete := i.Config.Options.OnShutdownComplete; onShutdownComplete != nil {
+				onShutdownComplete()
+			}
+			close(i.getShutdownCompleteNotify())
+		})
+	}()
+}
+
+// Wait blocks until Shutdown has been called, either directly or by the instance after a server failed to start or
+// stopped serving, and all servers have been shut down. It returns the errors of all servers that failed, or nil if
+// all servers stopped cleanly. Wait returns after InstanceOptions.OnShutdownComplete has returned.
+func (i *InstanceImpl) Wait() error {
+	<-i.getShutdownCompleteNotify()
+
+	var errs errorz.MultipleErrors
+
+	for _, server := range i.getServers() {
+		if err := server.StartError(); err != nil {
+			errs = append(errs, fmt.Errorf("server %s failed: %w", server.ServerConfig.Name, err))
+		}
+	}
+
+	return errs.ToError()
+}
+
+func (i *InstanceImpl) getShutdownCompleteNotify() chan struct{} {
+	i.shutdownCompleteNotifyOnce.Do(func() {
+		i.shutdownCompleteNotify = make(chan struct{})
+	})
+
+	return i.shutdownCompleteNotify
+}
+
+// shutdownServer shuts down a Server, giving it its shutdown timeout to drain, unregisters its bind points, and
+// invokes InstanceOptions.OnServerStopped.
+func (i *InstanceImpl) shutdownServer(server *Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), i.shutdownTimeout(server.ServerConfig))
+	defer cancel()
+	server.Shutdown(ctx)
+
+	if registry := i.Config.Options.BindRegistry; registry != nil {
+		registry.Unregister(server)
+	}
+
+	if onServerStopped := i.Config.Options.OnServerStopped; onServerStopped != nil {
+		onServerStopped(server.ServerConfig)
+	}
+}
+
+// shutdownTimeout returns the shutdown timeout for a ServerConfig: the ServerConfig's shutdownTimeout option, the
+// InstanceOptions.ShutdownTimeout, or DefaultShutdownTimeout in that order of precedence.
+func (i *InstanceImpl) shutdownTimeout(serverConfig *ServerConfig) time.Duration {
+	if serverConfig != nil && serverConfig.Options.ShutdownTimeout > 0 {
+		return serverConfig.Options.ShutdownTimeout
+	}
+
+	if i.Config != nil && i.Config.Options.ShutdownTimeout > 0 {
+		return i.Config.Options.ShutdownTimeout
+	}
+
+	return DefaultShutdownTimeout
 }
 
 // DefaultHttpHandlerProvider is an interface that allows different levels of xweb's components: Instance, ServerConfig,
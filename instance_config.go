@@ -22,6 +22,11 @@ import (
 	"fmt"
 	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/identity"
+	"github.com/openziti/xweb/v2/middleware"
+	"log"
+	"net"
+	"net/http"
+	"strings"
 	"time"
 )
 
@@ -32,6 +37,11 @@ const (
 	DefaultHttpWriteTimeout = time.Second * 10
 	DefaultHttpReadTimeout  = time.Second * 5
 	DefaultHttpIdleTimeout  = time.Second * 5
+
+	DefaultShutdownTimeout = time.Second * 15
+
+	DefaultCrlRefreshInterval = time.Hour
+	MinCrlRefreshInterval     = time.Minute
 )
 
 // TlsVersionMap is a map of configuration strings to TLS version identifiers
@@ -50,6 +60,14 @@ var ReverseTlsVersionMap = map[int]string{
 	tls.VersionTLS13: "TLS1.3",
 }
 
+// CurveMap is a map of configuration strings to elliptic curve identifiers
+var CurveMap = map[string]tls.CurveID{
+	"X25519": tls.X25519,
+	"P-256":  tls.CurveP256,
+	"P-384":  tls.CurveP384,
+	"P-521":  tls.CurveP521,
+}
+
 // InstanceConfig is the root configuration options necessary to start numerous http.Server instances
 type InstanceConfig struct {
 	SourceConfig map[interface{}]interface{}
@@ -57,17 +75,147 @@ type InstanceConfig struct {
 	ServerConfigs []*ServerConfig
 	Section       string
 
+	// OptionsSection is the name of the optional root section of options (see Options) inherited by all
+	// ServerConfig's, DefaultOptionsSection if empty. Each server's own options override them.
+	OptionsSection string
+
 	DefaultIdentity        identity.Identity
 	DefaultIdentitySection string
 
 	//used for loading/validation logic, use DefaultIdentity.InstanceConfig() for runtime
 	defaultIdentityConfig *identity.Config
 
+	//true if DefaultIdentity was loaded from defaultIdentityConfig by Validate rather than supplied
+	defaultIdentityLoaded bool
+
+	//true if the config is only parsed and validated, see InstanceImpl.ValidateOnly, identity file watching is skipped
+	validateOnly bool
+
+	Options InstanceOptions
+
+	// Warnings are non-fatal advisories about the configuration (e.g. deprecated TLS versions) found by Validate
+	Warnings []string
+
 	enabled bool
 }
 
+// InstanceOptions are instance wide options that are provided programmatically by the embedding application rather
+// than parsed from configuration. They apply to all ServerConfig's of an InstanceConfig.
+type InstanceOptions struct {
+	// OnClientHello, if set, is invoked for every TLS handshake on every bind point with the client's
+	// tls.ClientHelloInfo and the BindPointConfig that accepted the connection. It is intended for inspection such
+	// as TLS fingerprinting and cannot alter or abort the handshake. It is invoked synchronously during each
+	// handshake and must return quickly.
+	OnClientHello func(hello *tls.ClientHelloInfo, bindPoint *BindPointConfig)
+
+	// OnConnState, if set, is invoked for every connection state change (see http.Server.ConnState) on every bind
+	// point with the BindPointConfig that accepted the connection, e.g. to count connections by state for capacity
+	// planning. It is invoked after the connection accounting of the maxConnections option and must return quickly.
+	OnConnState func(conn net.Conn, state http.ConnState, bindPoint *BindPointConfig)
+
+	// OnServerStarted, if set, is invoked once a Server is serving all of its bind points, with its ServerConfig and
+	// the addresses its bind points are bound to. It is invoked synchronously on the goroutine that started the Server.
+	OnServerStarted func(serverConfig *ServerConfig, addresses []net.Addr)
+
+	// OnServerStopped, if set, is invoked after a Server has been shut down and its bind points released, whether by
+	// Shutdown or by Reload removing it. It is invoked synchronously on the goroutine that shut down the Server.
+	OnServerStopped func(serverConfig *ServerConfig)
+
+	// OnShutdownComplete, if set, is invoked once after Shutdown has been called and all servers have been shut down
+	// and OnServerStopped has returned for each of them.
+	OnShutdownComplete func()
+
+	// ShutdownTimeout is the time servers are given to drain in-flight requests during shutdown before their
+	// connections are forcibly closed. A ServerConfig may override it with the shutdownTimeout option. If zero,
+	// DefaultShutdownTimeout is used.
+	ShutdownTimeout time.Duration
+
+	// HealthChecks, if true, installs liveness (LivenessPath) and readiness (ReadinessPath) endpoints on every
+	// Server. They are answered before requests reach any ApiHandler.
+	HealthChecks bool
+
+	// Name identifies the instance in messages such as bind point collision errors. If empty, the configuration
+	// section name is used.
+	Name string
+
+	// BindRegistry, if set, is used to detect bind points that collide with those of other servers using the same
+	// BindRegistry before they are bound. Set it to DefaultBindRegistry to detect collisions across all Instance's in
+	// the process that opt in.
+	BindRegistry *BindRegistry
+
+	// InsecureNoTLS, if true, serves plain HTTP on all bind points of all servers. Servers do not use identities
+	// and may be built without them. It is intended for local development and testing only and is only honored by
+	// binaries built with the xweb_insecure_notls build tag; otherwise servers fail to build. A warning is logged for
+	// every server it is applied to.
+	InsecureNoTLS bool
+
+	// TlsKeyLogFile, if set, is the file TLS session secrets of all servers are appended to for debugging, unless
+	// a ServerConfig sets the tlsKeyLogFile option. Anyone with access to the file can decrypt captured traffic, so
+	// it is only honored if the TlsKeyLogEnv environment variable is set to "true"; otherwise servers fail to build.
+	// A warning is logged for every server it is applied to.
+	TlsKeyLogFile string
+
+	// AccessLogSink, if set, receives the access log entries of all servers that enable the accessLog option instead
+	// of the default logger.
+	AccessLogSink middleware.AccessLogSink
+
+	// ErrorLog, if set, returns the logger that receives the errors of the http.Server of a bind point, e.g. failed
+	// TLS handshakes, so they may be tagged with the server name and routed to a structured logger. If it is nil or
+	// returns nil, errors are logged by the default logger.
+	ErrorLog func(serverConfig *ServerConfig, bindPoint *BindPointConfig) *log.Logger
+
+	// ReadyFile, if set, is the path of a file that is written, containing the process id, once all servers are
+	// serving and removed on shutdown. It allows external supervisors to detect readiness without probing listeners.
+	ReadyFile string
+
+	// SystemdNotify, if true, sends READY=1 to systemd via sd_notify once all servers are serving and STOPPING=1 on
+	// shutdown. It has no effect if the process is not run by systemd with notification enabled (e.g. Type=notify).
+	SystemdNotify bool
+
+	// RateLimitKey, if set, identifies the clients that are rate limited by servers that enable the rateLimit option.
+	// If nil, middleware.RateLimitKeyClientCertSubject is used.
+	RateLimitKey middleware.RateLimitKeyFunc
+
+	// OnWarning, if set, receives each non-fatal configuration advisory found during validation instead of it being
+	// logged. All advisories are also available from InstanceConfig.Warnings.
+	OnWarning func(warning string)
+
+	// ListenerMutator, if set, is invoked with the listener of every bind point once it is bound and before it is
+	// served. The returned listener is served instead, which allows listeners to be wrapped, e.g. to limit
+	// connections, parse PROXY protocol headers, or collect per-connection metrics.
+	ListenerMutator ListenerMutator
+
+	// PreBoundListeners, if set, provides already bound listeners, e.g. inherited via systemd socket activation, that
+	// are served instead of binding the bind points with matching InterfaceAddress's.
+	PreBoundListeners *PreBoundListeners
+
+	// ExpandEnv, if true, expands ${VAR} and $VAR references to environment variables in all string values of the
+	// configuration map before it is parsed. It is disabled by default so that literal values containing "$" are not
+	// altered.
+	ExpandEnv bool
+
+	// Middleware, if set, returns the middleware chain of each bind point, outermost first. It receives the default
+	// chain, named by the Middleware* constants, and may reorder it or insert its own Middleware at any position, e.g.
+	// to move access logging outside of compression or to add metrics.
+	Middleware MiddlewareChainFunc
+
+	// ApiMiddleware are named Middleware that ApiConfig's may list (see ApiConfig.Middleware) to wrap only their
+	// ApiHandler, e.g. for authentication or rate limiting of a single API. Each Middleware is wrapped once per
+	// ApiConfig and Server. They run after the chain of the bind point, see Middleware, once the demux selected the
+	// ApiHandler of a request.
+	ApiMiddleware []Middleware
+}
+
+// ListenerMutator wraps or replaces the bound listener of a bind point. The returned listener must close the supplied
+// listener when it is closed.
+type ListenerMutator func(listener net.Listener, bindPoint *BindPointConfig) net.Listener
+
 // Parse parses a configuration map, looking for sections that define an identity.InstanceConfig and an array of ServerConfig's.
 func (config *InstanceConfig) Parse(configMap map[interface{}]interface{}) error {
+	if config.Options.ExpandEnv {
+		configMap = expandConfigEnv(configMap).(map[interface{}]interface{})
+	}
+
 	config.SourceConfig = configMap
 
 	if config.DefaultIdentity == nil && config.DefaultIdentitySection == "" {
@@ -98,13 +246,33 @@ func (config *InstanceConfig) Parse(configMap map[interface{}]interface{}) error
 		config.defaultIdentityConfig = config.DefaultIdentity.GetConfig()
 	}
 
+	if config.OptionsSection == "" {
+		config.OptionsSection = DefaultOptionsSection
+	}
+
+	//instance wide options, each server parses its own options over them
+	inheritedOptions := &Options{}
+	inheritedOptions.Default()
+
+	if optionsInterface, ok := configMap[config.OptionsSection]; ok {
+		if optionsMap, ok := optionsInterface.(map[interface{}]interface{}); ok {
+			if err := inheritedOptions.parse(optionsMap); err != nil {
+				return fmt.Errorf("error parsing options section [%s]: %v", config.OptionsSection, err)
+			}
+		} else {
+			return fmt.Errorf("options section [%s] must be a map", config.OptionsSection)
+		}
+	}
+
 	if sectionVal, ok := configMap[config.Section]; ok {
 		//treat section like an array of maps
 		if sectionArrayVals, ok := sectionVal.([]interface{}); ok {
 			for i, sectionArrayVal := range sectionArrayVals {
 				if sectionMap, ok := sectionArrayVal.(map[interface{}]interface{}); ok {
 					serverConfig := &ServerConfig{
-						DefaultIdentity: config.DefaultIdentity,
+						DefaultIdentity:  config.DefaultIdentity,
+						inheritedOptions: inheritedOptions,
+						validateOnly:     config.validateOnly,
 					}
 					if err := serverConfig.Parse(sectionMap, config.Section); err != nil {
 						return fmt.Errorf("error parsing web configuration [%s] at index [%d]: %v", config.Section, i, err)
@@ -131,9 +299,12 @@ func (config *InstanceConfig) Validate(registry Registry) error {
 		//validate default identity by loading
 		if defaultIdentity, err := identity.LoadIdentity(*config.defaultIdentityConfig); err == nil {
 			config.DefaultIdentity = defaultIdentity
+			config.defaultIdentityLoaded = true
 
-			if err := config.DefaultIdentity.WatchFiles(); err != nil {
-				pfxlog.Logger().Warnf("could not enable file watching on default identity: %v", err)
+			if !config.validateOnly {
+				if err := config.DefaultIdentity.WatchFiles(); err != nil {
+					pfxlog.Logger().Warnf("could not enable file watching on default identity: %v", err)
+				}
 			}
 		} else {
 			return fmt.Errorf("could not load default identity: %v", err)
@@ -153,23 +324,127 @@ func (config *InstanceConfig) Validate(registry Registry) error {
 			return fmt.Errorf("could not validate server at %s[%d]: %v", config.Section, i, err)
 		}
 
+		if err := config.validateApiMiddleware(serverConfig); err != nil {
+			return fmt.Errorf("could not validate server at %s[%d]: %v", config.Section, i, err)
+		}
+
 		for _, api := range serverConfig.APIs {
 			presentApis[api.Binding()] = registry.Get(api.Binding())
 		}
 	}
 
+	if err := config.validateBindPointCollisions(); err != nil {
+		return err
+	}
+
+	if err := config.validateApiConfigs(registry); err != nil {
+		return err
+	}
+
 	for presentApiBinding, presentApiFactory := range presentApis {
 		if err := presentApiFactory.Validate(config); err != nil {
 			return fmt.Errorf("error validating ApiConfig binding %s: %v", presentApiBinding, err)
 		}
 	}
 
+	config.reportWarnings()
+
 	//enabled only after validation passes
 	config.enabled = true
 
 	return nil
 }
 
+// validateApiMiddleware verifies that all middleware listed by the ApiConfig's of a ServerConfig are registered in
+// InstanceOptions.ApiMiddleware.
+func (config *InstanceConfig) validateApiMiddleware(serverConfig *ServerConfig) error {
+	for _, api := range serverConfig.APIs {
+		for _, name := range api.Middleware() {
+			if MiddlewareIndex(config.Options.ApiMiddleware, name) < 0 {
+				return fmt.Errorf("api binding [%s] lists middleware [%s] which is not registered", api.Binding(), name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// reportWarnings collects the non-fatal advisories for the configuration into Warnings and reports each to
+// InstanceOptions.OnWarning or, if it is not set, logs them.
+func (config *InstanceConfig) reportWarnings() {
+	config.Warnings = nil
+
+	if len(config.ServerConfigs) == 0 {
+		config.Warnings = append(config.Warnings, fmt.Sprintf("%s section defines no servers, nothing will be served", config.Section))
+	}
+
+	for _, serverConfig := range config.ServerConfigs {
+		for _, warning := range serverConfig.Warnings() {
+			config.Warnings = append(config.Warnings, fmt.Sprintf("server [%s]: %s", serverConfig.Name, warning))
+		}
+	}
+
+	for _, warning := range config.Warnings {
+		if config.Options.OnWarning != nil {
+			config.Options.OnWarning(warning)
+		} else {
+			pfxlog.Logger().Warn(warning)
+		}
+	}
+}
+
+// validateBindPointCollisions returns an error listing all bind points, across all ServerConfig's, whose interface
+// addresses collide with one another (see BindRegistry.Register).
+func (config *InstanceConfig) validateBindPointCollisions() error {
+	bindRegistry := NewBindRegistry()
+	var collisions []string
+
+	for i, serverConfig := range config.ServerConfigs {
+		for j, bindPoint := range serverConfig.BindPoints {
+			description := fmt.Sprintf("server [%s] at %s[%d] bind point [%d]", serverConfig.Name, config.Section, i, j)
+
+			if err := bindRegistry.RegisterNetwork(bindPoint.ListenNetwork(), bindPoint.InterfaceAddress, bindPoint, description); err != nil {
+				collisions = append(collisions, err.Error())
+			}
+		}
+	}
+
+	if len(collisions) > 0 {
+		return fmt.Errorf("colliding bind points found: %s", strings.Join(collisions, "; "))
+	}
+
+	return nil
+}
+
+// validateApiConfigs passes every enabled ApiConfig to its factory if the factory is an ApiConfigValidatingFactory. All
+// invalid ApiConfig's are reported together.
+func (config *InstanceConfig) validateApiConfigs(registry Registry) error {
+	var invalid []string
+
+	for i, serverConfig := range config.ServerConfigs {
+		for j, api := range serverConfig.APIs {
+			if !api.Enabled() {
+				continue
+			}
+
+			validator, ok := registry.Get(api.Binding()).(ApiConfigValidatingFactory)
+			if !ok {
+				continue
+			}
+
+			if err := validator.ValidateApiConfig(serverConfig, api); err != nil {
+				invalid = append(invalid, fmt.Sprintf("server [%s] at %s[%d] api [%d] binding [%s]: %v", serverConfig.Name, config.Section, i, j, api.Binding(), err))
+			}
+		}
+	}
+
+	if len(invalid) > 0 {
+		return fmt.Errorf("invalid ApiConfig options found: %s", strings.Join(invalid, "; "))
+	}
+
+	return nil
+}
+
 // Enabled returns true/false on whether this configuration should be considered "enabled". Set to true after
 // Validate passes.
 func (config *InstanceConfig) Enabled() bool {
@@ -180,22 +455,97 @@ func (config *InstanceConfig) Enabled() bool {
 type Options struct {
 	TimeoutOptions
 	TlsVersionOptions
+	OcspOptions
+	CrlOptions
+	TlsKeyLogOptions
+	AccessLogOptions
+	ErrorLogOptions
+	RequestDeadlineOptions
+	RateLimitOptions
+	InFlightOptions
+	SizeLimitOptions
+	ConnectionOptions
+	CompressionOptions
 }
 
 // Default provides defaults for all necessary values
 func (options *Options) Default() {
 	options.TimeoutOptions.Default()
 	options.TlsVersionOptions.Default()
+	options.OcspOptions.Default()
+	options.CrlOptions.Default()
+	options.TlsKeyLogOptions.Default()
+	options.AccessLogOptions.Default()
+	options.ErrorLogOptions.Default()
+	options.RequestDeadlineOptions.Default()
+	options.RateLimitOptions.Default()
+	options.InFlightOptions.Default()
+	options.SizeLimitOptions.Default()
+	options.ConnectionOptions.Default()
+	options.CompressionOptions.Default()
 }
 
 // Parse parses a configuration map
 func (options *Options) Parse(optionsMap map[interface{}]interface{}) error {
-	if err := options.TimeoutOptions.Parse(optionsMap); err != nil {
+	if err := options.parse(optionsMap); err != nil {
 		return fmt.Errorf("error parsing options: %v", err)
 	}
 
+	return nil
+}
+
+// parse is Parse without adding context to errors, for callers that name the section being parsed.
+func (options *Options) parse(optionsMap map[interface{}]interface{}) error {
+	if err := options.TimeoutOptions.Parse(optionsMap); err != nil {
+		return err
+	}
+
 	if err := options.TlsVersionOptions.Parse(optionsMap); err != nil {
-		return fmt.Errorf("error parsing options: %v", err)
+		return err
+	}
+
+	if err := options.OcspOptions.Parse(optionsMap); err != nil {
+		return err
+	}
+
+	if err := options.CrlOptions.Parse(optionsMap); err != nil {
+		return err
+	}
+
+	if err := options.TlsKeyLogOptions.Parse(optionsMap); err != nil {
+		return err
+	}
+
+	if err := options.AccessLogOptions.Parse(optionsMap); err != nil {
+		return err
+	}
+
+	if err := options.ErrorLogOptions.Parse(optionsMap); err != nil {
+		return err
+	}
+
+	if err := options.RequestDeadlineOptions.Parse(optionsMap); err != nil {
+		return err
+	}
+
+	if err := options.RateLimitOptions.Parse(optionsMap); err != nil {
+		return err
+	}
+
+	if err := options.InFlightOptions.Parse(optionsMap); err != nil {
+		return err
+	}
+
+	if err := options.SizeLimitOptions.Parse(optionsMap); err != nil {
+		return err
+	}
+
+	if err := options.ConnectionOptions.Parse(optionsMap); err != nil {
+		return err
+	}
+
+	if err := options.CompressionOptions.Parse(optionsMap); err != nil {
+		return err
 	}
 
 	return nil
@@ -206,6 +556,17 @@ type TimeoutOptions struct {
 	ReadTimeout  time.Duration
 	IdleTimeout  time.Duration
 	WriteTimeout time.Duration
+
+	// ReadHeaderTimeout limits the time allowed to read request headers. If zero, ReadTimeout is used.
+	ReadHeaderTimeout time.Duration
+
+	// ShutdownTimeout overrides InstanceOptions.ShutdownTimeout for a single server if greater than zero
+	ShutdownTimeout time.Duration
+
+	// HandlerTimeout, if greater than zero, limits the time ApiHandler's may take to process a request. Requests that
+	// exceed it are answered with http.StatusServiceUnavailable (503). ApiConfig's may override it, see
+	// ApiConfig.HandlerTimeout.
+	HandlerTimeout time.Duration
 }
 
 // Default defaults all HTTP timeout options
@@ -229,6 +590,18 @@ func (timeoutOptions *TimeoutOptions) Parse(config map[interface{}]interface{})
 		}
 	}
 
+	if interfaceVal, ok := config["readHeaderTimeout"]; ok {
+		if readHeaderTimeoutStr, ok := interfaceVal.(string); ok {
+			if readHeaderTimeout, err := time.ParseDuration(readHeaderTimeoutStr); err == nil {
+				timeoutOptions.ReadHeaderTimeout = readHeaderTimeout
+			} else {
+				return fmt.Errorf("could not parse readHeaderTimeout %s as a duration (e.g. 1m): %v", readHeaderTimeoutStr, err)
+			}
+		} else {
+			return errors.New("could not use value for readHeaderTimeout, not a string")
+		}
+	}
+
 	if interfaceVal, ok := config["idleTimeout"]; ok {
 		if idleTimeoutStr, ok := interfaceVal.(string); ok {
 			if idleTimeout, err := time.ParseDuration(idleTimeoutStr); err == nil {
@@ -253,6 +626,30 @@ func (timeoutOptions *TimeoutOptions) Parse(config map[interface{}]interface{})
 		}
 	}
 
+	if interfaceVal, ok := config["shutdownTimeout"]; ok {
+		if shutdownTimeoutStr, ok := interfaceVal.(string); ok {
+			if shutdownTimeout, err := time.ParseDuration(shutdownTimeoutStr); err == nil {
+				timeoutOptions.ShutdownTimeout = shutdownTimeout
+			} else {
+				return fmt.Errorf("could not parse shutdownTimeout %s as a duration (e.g. 1m): %v", shutdownTimeoutStr, err)
+			}
+		} else {
+			return errors.New("could not use value for shutdownTimeout, not a string")
+		}
+	}
+
+	if interfaceVal, ok := config["handlerTimeout"]; ok {
+		if handlerTimeoutStr, ok := interfaceVal.(string); ok {
+			if handlerTimeout, err := time.ParseDuration(handlerTimeoutStr); err == nil {
+				timeoutOptions.HandlerTimeout = handlerTimeout
+			} else {
+				return fmt.Errorf("could not parse handlerTimeout %s as a duration (e.g. 1m): %v", handlerTimeoutStr, err)
+			}
+		} else {
+			return errors.New("could not use value for handlerTimeout, not a string")
+		}
+	}
+
 	return nil
 }
 
@@ -270,6 +667,18 @@ func (timeoutOptions *TimeoutOptions) Validate() error {
 		return fmt.Errorf("value [%s] for idleTimeout too low, must be positive", timeoutOptions.IdleTimeout.String())
 	}
 
+	if timeoutOptions.ReadHeaderTimeout < 0 {
+		return fmt.Errorf("value [%s] for readHeaderTimeout too low, must not be negative", timeoutOptions.ReadHeaderTimeout.String())
+	}
+
+	if timeoutOptions.ShutdownTimeout < 0 {
+		return fmt.Errorf("value [%s] for shutdownTimeout too low, must not be negative", timeoutOptions.ShutdownTimeout.String())
+	}
+
+	if timeoutOptions.HandlerTimeout < 0 {
+		return fmt.Errorf("value [%s] for handlerTimeout too low, must not be negative", timeoutOptions.HandlerTimeout.String())
+	}
+
 	return nil
 }
 
@@ -280,6 +689,10 @@ type TlsVersionOptions struct {
 
 	MaxTLSVersion    int
 	maxTLSVersionStr string
+
+	// CurvePreferences, if not empty, restricts the elliptic curves used for key exchange to those listed, in order of
+	// preference. If empty, the Go defaults are used.
+	CurvePreferences []tls.CurveID
 }
 
 // Default defaults TLS versions
@@ -316,6 +729,24 @@ func (tlsVersionOptions *TlsVersionOptions) Parse(config map[interface{}]interfa
 		}
 	}
 
+	curveNames, err := parseStringList(config, "curvePreferences")
+	if err != nil {
+		return err
+	}
+
+	if curveNames != nil {
+		tlsVersionOptions.CurvePreferences = nil
+
+		for _, curveName := range curveNames {
+			curve, ok := CurveMap[curveName]
+			if !ok {
+				return fmt.Errorf("could not use value for curvePreferences, invalid value [%s]", curveName)
+			}
+
+			tlsVersionOptions.CurvePreferences = append(tlsVersionOptions.CurvePreferences, curve)
+		}
+	}
+
 	return nil
 }
 
@@ -328,6 +759,450 @@ func (tlsVersionOptions *TlsVersionOptions) Validate() error {
 	return nil
 }
 
+// OcspOptions represents OCSP stapling options
+type OcspOptions struct {
+	OcspStapling bool
+}
+
+// Default defaults OCSP stapling to disabled
+func (ocspOptions *OcspOptions) Default() {
+	ocspOptions.OcspStapling = false
+}
+
+// Parse parses a config map
+func (ocspOptions *OcspOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["ocspStapling"]; ok {
+		if ocspStapling, ok := interfaceVal.(bool); ok {
+			ocspOptions.OcspStapling = ocspStapling
+		} else {
+			return errors.New("could not use value for ocspStapling, not a boolean")
+		}
+	}
+
+	return nil
+}
+
+// CrlOptions represents client certificate revocation options. When ClientCrl is set, client certificates issued by
+// the issuer of the CRL are rejected during the TLS handshake if their serial number is listed as revoked. ClientCrl is
+// either a file path or an http(s) URL. CRLs obtained from URLs are refreshed every ClientCrlRefreshInterval.
+type CrlOptions struct {
+	ClientCrl                string
+	ClientCrlRefreshInterval time.Duration
+}
+
+// Default defaults revocation checking to disabled and the CRL refresh interval to DefaultCrlRefreshInterval
+func (crlOptions *CrlOptions) Default() {
+	crlOptions.ClientCrl = ""
+	crlOptions.ClientCrlRefreshInterval = DefaultCrlRefreshInterval
+}
+
+// Parse parses a config map
+func (crlOptions *CrlOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["clientCrl"]; ok {
+		if clientCrl, ok := interfaceVal.(string); ok {
+			crlOptions.ClientCrl = clientCrl
+		} else {
+			return errors.New("could not use value for clientCrl, not a string")
+		}
+	}
+
+	if interfaceVal, ok := config["clientCrlRefreshInterval"]; ok {
+		if refreshIntervalStr, ok := interfaceVal.(string); ok {
+			if refreshInterval, err := time.ParseDuration(refreshIntervalStr); err == nil {
+				crlOptions.ClientCrlRefreshInterval = refreshInterval
+			} else {
+				return fmt.Errorf("could not parse clientCrlRefreshInterval %s as a duration (e.g. 1h): %v", refreshIntervalStr, err)
+			}
+		} else {
+			return errors.New("could not use value for clientCrlRefreshInterval, not a string")
+		}
+	}
+
+	return nil
+}
+
+// Validate validates the configuration values and returns nil or error
+func (crlOptions *CrlOptions) Validate() error {
+	if crlOptions.ClientCrlRefreshInterval < MinCrlRefreshInterval {
+		return fmt.Errorf("value [%s] for clientCrlRefreshInterval too low, must be at least %s", crlOptions.ClientCrlRefreshInterval.String(), MinCrlRefreshInterval.String())
+	}
+
+	if crlOptions.ClientCrl != "" && !isCrlUrl(crlOptions.ClientCrl) {
+		if _, err := loadCrlFile(crlOptions.ClientCrl); err != nil {
+			return fmt.Errorf("invalid clientCrl: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// TlsKeyLogOptions represents TLS debugging options. When TlsKeyLogFile is set, TLS session secrets are appended to
+// it in NSS key log format (SSLKEYLOGFILE) so that captured traffic can be decrypted, e.g. by Wireshark. It is only
+// honored if the TlsKeyLogEnv environment variable is set to "true", see InstanceOptions.TlsKeyLogFile.
+type TlsKeyLogOptions struct {
+	TlsKeyLogFile string
+}
+
+// Default defaults TLS key logging to disabled
+func (tlsKeyLogOptions *TlsKeyLogOptions) Default() {
+	tlsKeyLogOptions.TlsKeyLogFile = ""
+}
+
+// Parse parses a config map
+func (tlsKeyLogOptions *TlsKeyLogOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["tlsKeyLogFile"]; ok {
+		if tlsKeyLogFile, ok := interfaceVal.(string); ok {
+			tlsKeyLogOptions.TlsKeyLogFile = tlsKeyLogFile
+		} else {
+			return errors.New("could not use value for tlsKeyLogFile, not a string")
+		}
+	}
+
+	return nil
+}
+
+// AccessLogOptions represents access logging options
+type AccessLogOptions struct {
+	AccessLog bool
+}
+
+// Default defaults access logging to disabled
+func (accessLogOptions *AccessLogOptions) Default() {
+	accessLogOptions.AccessLog = false
+}
+
+// Parse parses a config map
+func (accessLogOptions *AccessLogOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["accessLog"]; ok {
+		if accessLog, ok := interfaceVal.(bool); ok {
+			accessLogOptions.AccessLog = accessLog
+		} else {
+			return errors.New("could not use value for accessLog, not a boolean")
+		}
+	}
+
+	return nil
+}
+
+// ErrorLogOptions represents http.Server error logging options. When FilterHandshakeErrors is true, TLS handshake
+// errors that are commonly caused by port scanners and clients disconnecting early (e.g. "first record does not look
+// like a TLS handshake") are logged at debug level instead of info level. Other errors are unaffected. It applies to
+// the default error log only, not to those supplied by InstanceOptions.ErrorLog.
+type ErrorLogOptions struct {
+	FilterHandshakeErrors bool
+}
+
+// Default defaults handshake error filtering to disabled, so handshake errors are logged as before unless opted in
+func (errorLogOptions *ErrorLogOptions) Default() {
+	errorLogOptions.FilterHandshakeErrors = false
+}
+
+// Parse parses a config map
+func (errorLogOptions *ErrorLogOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["filterHandshakeErrors"]; ok {
+		if filterHandshakeErrors, ok := interfaceVal.(bool); ok {
+			errorLogOptions.FilterHandshakeErrors = filterHandshakeErrors
+		} else {
+			return errors.New("could not use value for filterHandshakeErrors, not a boolean")
+		}
+	}
+
+	return nil
+}
+
+// RequestDeadlineOptions represents request deadline options. When enabled, the context of each request carries a
+// deadline of RequestTimeout, or the write timeout if RequestTimeout is zero, so that handlers can stop working on
+// requests whose responses can no longer be written. Protocol upgrades (e.g. websockets), server-sent event streams,
+// and requests for paths starting with any of RequestDeadlineExemptPaths are exempt.
+type RequestDeadlineOptions struct {
+	RequestDeadline            bool
+	RequestTimeout             time.Duration
+	RequestDeadlineExemptPaths []string
+}
+
+// Default defaults request deadlines to disabled
+func (requestDeadlineOptions *RequestDeadlineOptions) Default() {
+	requestDeadlineOptions.RequestDeadline = false
+	requestDeadlineOptions.RequestTimeout = 0
+	requestDeadlineOptions.RequestDeadlineExemptPaths = nil
+}
+
+// Parse parses a config map
+func (requestDeadlineOptions *RequestDeadlineOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["requestDeadline"]; ok {
+		if requestDeadline, ok := interfaceVal.(bool); ok {
+			requestDeadlineOptions.RequestDeadline = requestDeadline
+		} else {
+			return errors.New("could not use value for requestDeadline, not a boolean")
+		}
+	}
+
+	if interfaceVal, ok := config["requestTimeout"]; ok {
+		if requestTimeoutStr, ok := interfaceVal.(string); ok {
+			if requestTimeout, err := time.ParseDuration(requestTimeoutStr); err == nil {
+				requestDeadlineOptions.RequestTimeout = requestTimeout
+			} else {
+				return fmt.Errorf("could not parse requestTimeout %s as a duration (e.g. 1m): %v", requestTimeoutStr, err)
+			}
+		} else {
+			return errors.New("could not use value for requestTimeout, not a string")
+		}
+	}
+
+	exemptPaths, err := parseStringList(config, "requestDeadlineExemptPaths")
+	if err != nil {
+		return err
+	}
+
+	if exemptPaths != nil {
+		requestDeadlineOptions.RequestDeadlineExemptPaths = exemptPaths
+	}
+
+	return nil
+}
+
+// Validate validates the configuration values and returns nil or error
+func (requestDeadlineOptions *RequestDeadlineOptions) Validate() error {
+	if requestDeadlineOptions.RequestTimeout < 0 {
+		return fmt.Errorf("value [%s] for requestTimeout too low, must not be negative", requestDeadlineOptions.RequestTimeout.String())
+	}
+
+	return nil
+}
+
+// RateLimitOptions represents rate limiting options. When RateLimit is greater than zero, requests are limited to
+// RateLimit requests per second with bursts of up to RateLimitBurst requests per client. Clients are identified by
+// InstanceOptions.RateLimitKey, by default the subject of their certificate or their IP if they did not present one.
+type RateLimitOptions struct {
+	RateLimit      float64
+	RateLimitBurst int
+}
+
+// Default defaults rate limiting to disabled
+func (rateLimitOptions *RateLimitOptions) Default() {
+	rateLimitOptions.RateLimit = 0
+	rateLimitOptions.RateLimitBurst = 0
+}
+
+// Parse parses a config map
+func (rateLimitOptions *RateLimitOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["rateLimit"]; ok {
+		switch rateLimit := interfaceVal.(type) {
+		case int:
+			rateLimitOptions.RateLimit = float64(rateLimit)
+		case float64:
+			rateLimitOptions.RateLimit = rateLimit
+		default:
+			return errors.New("could not use value for rateLimit, not a number")
+		}
+	}
+
+	if interfaceVal, ok := config["rateLimitBurst"]; ok {
+		if rateLimitBurst, ok := interfaceVal.(int); ok {
+			rateLimitOptions.RateLimitBurst = rateLimitBurst
+		} else {
+			return errors.New("could not use value for rateLimitBurst, not an integer")
+		}
+	}
+
+	return nil
+}
+
+// Validate validates the configuration values and returns nil or error
+func (rateLimitOptions *RateLimitOptions) Validate() error {
+	if rateLimitOptions.RateLimit < 0 {
+		return fmt.Errorf("value [%v] for rateLimit too low, must not be negative", rateLimitOptions.RateLimit)
+	}
+
+	if rateLimitOptions.RateLimitBurst < 0 {
+		return fmt.Errorf("value [%d] for rateLimitBurst too low, must not be negative", rateLimitOptions.RateLimitBurst)
+	}
+
+	return nil
+}
+
+// InFlightOptions represents in-flight request tracking options. Tracking has a small per-request cost and is
+// disabled by default. When enabled, Server.InFlightRequests returns the requests being served and requests still in
+// flight when a shutdown times out are logged.
+type InFlightOptions struct {
+	TrackInFlightRequests bool
+}
+
+// Default defaults in-flight request tracking to disabled
+func (inFlightOptions *InFlightOptions) Default() {
+	inFlightOptions.TrackInFlightRequests = false
+}
+
+// Parse parses a config map
+func (inFlightOptions *InFlightOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["trackInFlightRequests"]; ok {
+		if trackInFlightRequests, ok := interfaceVal.(bool); ok {
+			inFlightOptions.TrackInFlightRequests = trackInFlightRequests
+		} else {
+			return errors.New("could not use value for trackInFlightRequests, not a boolean")
+		}
+	}
+
+	return nil
+}
+
+// SizeLimitOptions represents request size limit options. A MaxHeaderBytes of zero uses the Go default of
+// http.DefaultMaxHeaderBytes (1MB).
+type SizeLimitOptions struct {
+	MaxHeaderBytes int
+
+	// MaxBodyBytes limits the size of request bodies served by ApiHandler's, zero is unlimited. ApiConfig's may
+	// override it, see ApiConfig.MaxBodyBytes.
+	MaxBodyBytes int64
+}
+
+// Default defaults all size limits to the Go defaults
+func (sizeLimitOptions *SizeLimitOptions) Default() {
+	sizeLimitOptions.MaxHeaderBytes = 0
+	sizeLimitOptions.MaxBodyBytes = 0
+}
+
+// Parse parses a config map
+func (sizeLimitOptions *SizeLimitOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["maxHeaderBytes"]; ok {
+		if maxHeaderBytes, ok := interfaceVal.(int); ok {
+			if maxHeaderBytes <= 0 {
+				return fmt.Errorf("value [%d] for maxHeaderBytes too low, must be positive", maxHeaderBytes)
+			}
+			sizeLimitOptions.MaxHeaderBytes = maxHeaderBytes
+		} else {
+			return errors.New("could not use value for maxHeaderBytes, not an integer")
+		}
+	}
+
+	if interfaceVal, ok := config["maxBodyBytes"]; ok {
+		if maxBodyBytes, ok := interfaceVal.(int); ok {
+			if maxBodyBytes <= 0 {
+				return fmt.Errorf("value [%d] for maxBodyBytes too low, must be positive", maxBodyBytes)
+			}
+			sizeLimitOptions.MaxBodyBytes = int64(maxBodyBytes)
+		} else {
+			return errors.New("could not use value for maxBodyBytes, not an integer")
+		}
+	}
+
+	return nil
+}
+
+// Validate validates the configuration values and returns nil or error
+func (sizeLimitOptions *SizeLimitOptions) Validate() error {
+	if sizeLimitOptions.MaxHeaderBytes < 0 {
+		return fmt.Errorf("value [%d] for maxHeaderBytes too low, must be positive", sizeLimitOptions.MaxHeaderBytes)
+	}
+
+	if sizeLimitOptions.MaxBodyBytes < 0 {
+		return fmt.Errorf("value [%d] for maxBodyBytes too low, must be positive", sizeLimitOptions.MaxBodyBytes)
+	}
+
+	return nil
+}
+
+// ConnectionOptions represents connection handling options. When KeepAlivesEnabled is false, each connection serves a
+// single request and responses carry a "Connection: close" header.
+type ConnectionOptions struct {
+	KeepAlivesEnabled bool
+}
+
+// Default defaults keep-alives to enabled
+func (connectionOptions *ConnectionOptions) Default() {
+	connectionOptions.KeepAlivesEnabled = true
+}
+
+// Parse parses a config map
+func (connectionOptions *ConnectionOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["keepAlivesEnabled"]; ok {
+		if keepAlivesEnabled, ok := interfaceVal.(bool); ok {
+			connectionOptions.KeepAlivesEnabled = keepAlivesEnabled
+		} else {
+			return errors.New("could not use value for keepAlivesEnabled, not a boolean")
+		}
+	}
+
+	return nil
+}
+
+// CompressionOptions represents response compression options. When Compression is true, responses are compressed
+// with the highest priority encoding the client accepts. CompressionLevel ranges from 1 (fastest) to 9 (smallest),
+// zero uses the default level of each encoding. Responses smaller than CompressionMinSize bytes are not compressed.
+// If CompressionContentTypes is not empty, only responses with a listed media type are compressed, entries such as
+// "text/*" match all subtypes.
+type CompressionOptions struct {
+	Compression             bool
+	CompressionLevel        int
+	CompressionMinSize      int
+	CompressionContentTypes []string
+}
+
+// Default defaults compression to enabled for all responses at the default level of each encoding
+func (compressionOptions *CompressionOptions) Default() {
+	compressionOptions.Compression = true
+	compressionOptions.CompressionLevel = 0
+	compressionOptions.CompressionMinSize = 0
+	compressionOptions.CompressionContentTypes = nil
+}
+
+// Parse parses a config map
+func (compressionOptions *CompressionOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["compression"]; ok {
+		if compression, ok := interfaceVal.(bool); ok {
+			compressionOptions.Compression = compression
+		} else {
+			return errors.New("could not use value for compression, not a boolean")
+		}
+	}
+
+	if interfaceVal, ok := config["compressionLevel"]; ok {
+		if compressionLevel, ok := interfaceVal.(int); ok {
+			compressionOptions.CompressionLevel = compressionLevel
+		} else {
+			return errors.New("could not use value for compressionLevel, not an integer")
+		}
+	}
+
+	if interfaceVal, ok := config["compressionMinSize"]; ok {
+		if compressionMinSize, ok := interfaceVal.(int); ok {
+			compressionOptions.CompressionMinSize = compressionMinSize
+		} else {
+			return errors.New("could not use value for compressionMinSize, not an integer")
+		}
+	}
+
+	contentTypes, err := parseStringList(config, "compressionContentTypes")
+	if err != nil {
+		return err
+	}
+
+	if contentTypes != nil {
+		compressionOptions.CompressionContentTypes = contentTypes
+	}
+
+	return nil
+}
+
+// Validate validates the configuration values and returns nil or error
+func (compressionOptions *CompressionOptions) Validate() error {
+	if level := compressionOptions.CompressionLevel; level != 0 && (level < middleware.MinCompressionLevel || level > middleware.MaxCompressionLevel) {
+		return fmt.Errorf("value [%d] for compressionLevel out of range, must be between %d and %d", level, middleware.MinCompressionLevel, middleware.MaxCompressionLevel)
+	}
+
+	if compressionOptions.CompressionMinSize < 0 {
+		return fmt.Errorf("value [%d] for compressionMinSize too low, must not be negative", compressionOptions.CompressionMinSize)
+	}
+
+	for i, contentType := range compressionOptions.CompressionContentTypes {
+		if strings.TrimSpace(contentType) == "" {
+			return fmt.Errorf("value for compressionContentTypes at index [%d] must not be empty", i)
+		}
+	}
+
+	return nil
+}
+
 func parseIdentityConfig(identityMap map[interface{}]interface{}, pathContext string) (*identity.Config, error) {
 	idConfig, err := identity.NewConfigFromMap(identityMap)
 
@@ -0,0 +1,504 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"crypto/tls"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInstanceImpl_StartErrors(t *testing.T) {
+	req := require.New(t)
+
+	//hold the port so the server cannot bind it
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+	instance := newTestInstance(t, id, nil)
+	instance.Config.ServerConfigs = []*ServerConfig{newTestServerConfig(id, listener.Addr().String())}
+
+	err = instance.Run()
+	defer instance.Shutdown()
+
+	//bind failures are returned from Run and reported on StartErrors
+	req.Error(err)
+	req.Contains(err.Error(), "test")
+
+	select {
+	case err := <-instance.StartErrors():
+		req.Error(err)
+		req.Contains(err.Error(), "test")
+	case <-time.After(5 * time.Second):
+		req.Fail("expected a start error for a bind point that is in use")
+	}
+}
+
+func TestInstanceImpl_shutdownTimeout(t *testing.T) {
+	instance := NewDefaultInstance(NewRegistryMap(), nil)
+	serverConfig := &ServerConfig{}
+
+	t.Run("defaults to DefaultShutdownTimeout", func(t *testing.T) {
+		require.New(t).Equal(DefaultShutdownTimeout, instance.shutdownTimeout(serverConfig))
+	})
+
+	t.Run("uses the instance option if set", func(t *testing.T) {
+		instance.Config.Options.ShutdownTimeout = 2 * time.Minute
+		require.New(t).Equal(2*time.Minute, instance.shutdownTimeout(serverConfig))
+	})
+
+	t.Run("uses the server option over the instance option", func(t *testing.T) {
+		serverConfig.Options.ShutdownTimeout = time.Second
+		require.New(t).Equal(time.Second, instance.shutdownTimeout(serverConfig))
+	})
+}
+
+func TestInstanceImpl_WaitForServer(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("returns once the named server is serving", func(t *testing.T) {
+		req := require.New(t)
+
+		instance := newTestInstance(t, id, nil)
+		instance.Config.ServerConfigs = []*ServerConfig{newTestServerConfig(id, freeAddress(t))}
+		req.NoError(instance.Run())
+		defer instance.Shutdown()
+
+		req.NoError(instance.WaitForServer("test", 5*time.Second))
+	})
+
+	t.Run("returns an error if the named server fails to bind", func(t *testing.T) {
+		req := require.New(t)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+		defer func() { _ = listener.Close() }()
+
+		instance := newTestInstance(t, id, nil)
+		instance.Config.ServerConfigs = []*ServerConfig{newTestServerConfig(id, listener.Addr().String())}
+		req.Error(instance.Run())
+		defer instance.Shutdown()
+
+		err = instance.WaitForServer("test", 5*time.Second)
+		req.Error(err)
+		req.Contains(err.Error(), "failed to start")
+	})
+
+	t.Run("returns an error for an unknown server after the timeout", func(t *testing.T) {
+		req := require.New(t)
+
+		instance := newTestInstance(t, id, nil)
+		err := instance.WaitForServer("unknown", 50*time.Millisecond)
+		req.Error(err)
+		req.Contains(err.Error(), "timed out")
+	})
+}
+
+func TestInstanceConfig_warnings(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+	instance := newTestInstance(t, id, nil)
+
+	serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+	serverConfig.Options.MinTLSVersion = tls.VersionTLS11
+	instance.Config.ServerConfigs = []*ServerConfig{serverConfig}
+
+	var reported []string
+	instance.Config.Options.OnWarning = func(warning string) {
+		reported = append(reported, warning)
+	}
+
+	req.NoError(instance.Config.Validate(instance.Registry))
+	req.Len(instance.Config.Warnings, 1)
+	req.Contains(instance.Config.Warnings[0], "server [test]")
+	req.Equal(instance.Config.Warnings, reported)
+}
+
+func TestInstanceConfig_bindPointCollisions(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("colliding bind points across servers are an error", func(t *testing.T) {
+		req := require.New(t)
+
+		instance := newTestInstance(t, id, nil)
+
+		first := newTestServerConfig(id, "127.0.0.1:8443")
+		first.Name = "first"
+		second := newTestServerConfig(id, "0.0.0.0:8443")
+		second.Name = "second"
+		second.BindPoints = append(second.BindPoints, &BindPointConfig{InterfaceAddress: "127.0.0.1:8444", Address: "127.0.0.1:8444"})
+		third := newTestServerConfig(id, "127.0.0.1:8444")
+		third.Name = "third"
+
+		instance.Config.ServerConfigs = []*ServerConfig{first, second, third}
+
+		err := instance.Config.Validate(instance.Registry)
+		req.Error(err)
+		req.Contains(err.Error(), "[0.0.0.0:8443] of server [second]")
+		req.Contains(err.Error(), "[127.0.0.1:8444] of server [third]")
+	})
+
+	t.Run("distinct bind points are valid", func(t *testing.T) {
+		instance := newTestInstance(t, id, nil)
+		instance.Config.ServerConfigs = []*ServerConfig{
+			newTestServerConfig(id, "127.0.0.1:8443"),
+			newTestServerConfig(id, "127.0.0.1:8444"),
+		}
+
+		require.New(t).NoError(instance.Config.Validate(instance.Registry))
+	})
+}
+
+// optionValidatingFactory is a mockHandlerFactory that requires the "port" option of each ApiConfig to be an integer
+type optionValidatingFactory struct {
+	mockHandlerFactory
+}
+
+func (f *optionValidatingFactory) ValidateApiConfig(_ *ServerConfig, apiConfig *ApiConfig) error {
+	if _, ok := apiConfig.Options()["port"].(int); !ok {
+		return errors.New("port must be an integer")
+	}
+	return nil
+}
+
+func TestInstanceConfig_validateApiConfigs(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	newInstance := func(t *testing.T, apis ...*ApiConfig) *InstanceImpl {
+		registry := NewRegistryMap()
+		require.New(t).NoError(registry.Add(&optionValidatingFactory{}))
+
+		instance := NewDefaultInstance(registry, id)
+
+		first := newTestServerConfig(id, "127.0.0.1:8443")
+		first.Name = "first"
+		first.APIs = apis
+		second := newTestServerConfig(id, "127.0.0.1:8444")
+		second.Name = "second"
+		second.APIs = apis
+
+		instance.Config.ServerConfigs = []*ServerConfig{first, second}
+
+		return instance
+	}
+
+	t.Run("valid options pass", func(t *testing.T) {
+		instance := newInstance(t, &ApiConfig{binding: "mockHandler", options: map[interface{}]interface{}{"port": 1}})
+		require.New(t).NoError(instance.Config.Validate(instance.Registry))
+	})
+
+	t.Run("invalid options of all servers are reported", func(t *testing.T) {
+		req := require.New(t)
+
+		instance := newInstance(t,
+			&ApiConfig{binding: "mockHandler", options: map[interface{}]interface{}{"port": 1}},
+			&ApiConfig{binding: "mockHandler", options: map[interface{}]interface{}{"port": "one"}},
+		)
+
+		err := instance.Config.Validate(instance.Registry)
+		req.Error(err)
+		req.Contains(err.Error(), "server [first] at web[0] api [1] binding [mockHandler]: port must be an integer")
+		req.Contains(err.Error(), "server [second] at web[1] api [1] binding [mockHandler]: port must be an integer")
+	})
+
+	t.Run("disabled apis are not validated", func(t *testing.T) {
+		instance := newInstance(t,
+			&ApiConfig{binding: "mockHandler", options: map[interface{}]interface{}{"port": 1}},
+			&ApiConfig{binding: "mockHandler", disabled: true},
+		)
+		require.New(t).NoError(instance.Config.Validate(instance.Registry))
+	})
+}
+
+// failingListener is a net.Listener whose Accept always fails
+type failingListener struct {
+	net.Listener
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	return nil, errors.New("accept failed")
+}
+
+func TestInstanceImpl_Build(t *testing.T) {
+	t.Run("handler build errors are returned", func(t *testing.T) {
+		req := require.New(t)
+
+		id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+		registry := NewRegistryMap()
+		req.NoError(registry.Add(&failingHandlerFactory{}))
+
+		healthy := newTestServerConfig(id, freeAddress(t))
+		healthy.Name = "healthy"
+		failing := newTestServerConfig(id, freeAddress(t))
+		failing.Name = "failing"
+		failing.APIs = []*ApiConfig{{binding: "mockHandler", options: map[interface{}]interface{}{"fail": true}}}
+
+		instance := NewDefaultInstance(registry, id)
+		instance.Config.ServerConfigs = []*ServerConfig{healthy, failing}
+
+		err := instance.Build()
+		req.Error(err)
+		req.Contains(err.Error(), "error creating xweb server for failing")
+		req.Contains(err.Error(), "handler failed")
+
+		//the bind points of servers built before the failure are released
+		listener, err := net.Listen("tcp", healthy.BindPoints[0].InterfaceAddress)
+		req.NoError(err)
+		_ = listener.Close()
+	})
+}
+
+func TestInstanceImpl_startRollback(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	healthy := newTestServerConfig(id, freeAddress(t))
+	healthy.Name = "healthy"
+	failing := newTestServerConfig(id, freeAddress(t))
+	failing.Name = "failing"
+
+	instance := newTestInstance(t, id, nil)
+	instance.Config.ServerConfigs = []*ServerConfig{healthy, failing}
+	instance.Config.Options.ListenerMutator = func(listener net.Listener, bindPoint *BindPointConfig) net.Listener {
+		if bindPoint == failing.BindPoints[0] {
+			return &failingListener{Listener: listener}
+		}
+		return listener
+	}
+
+	req.NoError(instance.Run())
+	defer instance.Shutdown()
+
+	select {
+	case err := <-instance.StartErrors():
+		req.Contains(err.Error(), "failing")
+	case <-time.After(5 * time.Second):
+		req.Fail("no start error reported")
+	}
+
+	req.Eventually(instance.isShutdown, 5*time.Second, 10*time.Millisecond)
+	req.Eventually(func() bool {
+		conn, err := net.Dial("tcp", healthy.BindPoints[0].InterfaceAddress)
+		if err == nil {
+			_ = conn.Close()
+		}
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	req.False(instance.IsReady())
+}
+
+func TestInstanceImpl_ValidateOnly(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("valid configs pass without altering the instance", func(t *testing.T) {
+		req := require.New(t)
+
+		instance := newTestInstance(t, id, nil)
+		req.NoError(instance.ValidateOnly(newTestWebConfig(map[string]string{"test": "127.0.0.1:8443"})))
+
+		req.False(instance.Enabled())
+		req.Empty(instance.Config.ServerConfigs)
+		req.Empty(instance.getServers())
+	})
+
+	t.Run("errors are those of LoadConfig", func(t *testing.T) {
+		req := require.New(t)
+
+		config := newTestWebConfig(map[string]string{"test": "127.0.0.1:8443"})
+		config["web"].([]interface{})[0].(map[interface{}]interface{})["apis"] = []interface{}{
+			map[interface{}]interface{}{"binding": "unknown"},
+		}
+
+		err := newTestInstance(t, id, nil).ValidateOnly(config)
+		req.Error(err)
+
+		loadErr := newTestInstance(t, id, nil).LoadConfig(config)
+		req.EqualError(err, loadErr.Error())
+	})
+}
+
+func TestInstanceImpl_lifecycleCallbacks(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	lock := sync.Mutex{}
+	var events []string
+	record := func(event string) {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, event)
+	}
+	recorded := func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), events...)
+	}
+
+	serverConfig := newTestServerConfig(id, freeAddress(t))
+
+	instance := newTestInstance(t, id, nil)
+	instance.Config.ServerConfigs = []*ServerConfig{serverConfig}
+	instance.Config.Options.OnServerStarted = func(started *ServerConfig, addresses []net.Addr) {
+		req.Equal(serverConfig, started)
+		req.Len(addresses, 1)
+		req.Equal(serverConfig.BindPoints[0].InterfaceAddress, addresses[0].String())
+		record("started")
+	}
+	instance.Config.Options.OnServerStopped = func(stopped *ServerConfig) {
+		req.Equal(serverConfig, stopped)
+		record("stopped")
+	}
+	instance.Config.Options.OnShutdownComplete = func() {
+		record("complete")
+	}
+
+	req.NoError(instance.Run())
+	req.Eventually(func() bool { return len(recorded()) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	instance.Shutdown()
+
+	req.Eventually(func() bool { return len(recorded()) == 3 }, 5*time.Second, 10*time.Millisecond)
+	req.Equal([]string{"started", "stopped", "complete"}, recorded())
+}
+
+func TestInstanceImpl_Wait(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("returns nil once all servers have been shut down", func(t *testing.T) {
+		req := require.New(t)
+
+		instance := newTestInstance(t, id, nil)
+		instance.Config.ServerConfigs = []*ServerConfig{newTestServerConfig(id, freeAddress(t))}
+		req.NoError(instance.Run())
+		req.NoError(instance.WaitForServer("test", 5*time.Second))
+
+		waitErr := make(chan error, 1)
+		go func() { waitErr <- instance.Wait() }()
+
+		select {
+		case <-waitErr:
+			req.Fail("Wait returned before Shutdown")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		instance.Shutdown()
+
+		select {
+		case err := <-waitErr:
+			req.NoError(err)
+		case <-time.After(5 * time.Second):
+			req.Fail("Wait did not return after Shutdown")
+		}
+	})
+
+	t.Run("returns the errors of failed servers", func(t *testing.T) {
+		req := require.New(t)
+
+		failing := newTestServerConfig(id, freeAddress(t))
+		failing.Name = "failing"
+
+		instance := newTestInstance(t, id, nil)
+		instance.Config.ServerConfigs = []*ServerConfig{failing}
+		instance.Config.Options.ListenerMutator = func(listener net.Listener, bindPoint *BindPointConfig) net.Listener {
+			return &failingListener{Listener: listener}
+		}
+		req.NoError(instance.Run())
+
+		err := instance.Wait()
+		req.Error(err)
+		req.Contains(err.Error(), "server failing failed")
+	})
+}
+
+func TestInstanceConfig_inheritedOptions(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	newConfigMap := func(options interface{}) map[interface{}]interface{} {
+		configMap := newTestWebConfig(map[string]string{"inherits": "127.0.0.1:443"})
+		configMap[DefaultOptionsSection] = options
+
+		overrides := newTestWebConfig(map[string]string{"overrides": "127.0.0.1:444"})["web"].([]interface{})[0].(map[interface{}]interface{})
+		overrides["options"] = map[interface{}]interface{}{"readTimeout": "7s"}
+		configMap["web"] = append(configMap["web"].([]interface{}), overrides)
+
+		return configMap
+	}
+
+	findServerConfig := func(config *InstanceConfig, name string) *ServerConfig {
+		for _, serverConfig := range config.ServerConfigs {
+			if serverConfig.Name == name {
+				return serverConfig
+			}
+		}
+		return nil
+	}
+
+	t.Run("servers inherit instance options unless they override them", func(t *testing.T) {
+		req := require.New(t)
+
+		instance := newTestInstance(t, id, nil)
+		req.NoError(instance.Config.Parse(newConfigMap(map[interface{}]interface{}{
+			"readTimeout":  "3s",
+			"writeTimeout": "4s",
+		})))
+
+		inherits := findServerConfig(instance.Config, "inherits")
+		req.Equal(3*time.Second, inherits.Options.ReadTimeout)
+		req.Equal(4*time.Second, inherits.Options.WriteTimeout)
+
+		overrides := findServerConfig(instance.Config, "overrides")
+		req.Equal(7*time.Second, overrides.Options.ReadTimeout)
+		req.Equal(4*time.Second, overrides.Options.WriteTimeout)
+
+		defaults := Options{}
+		defaults.Default()
+		req.Equal(defaults.IdleTimeout, inherits.Options.IdleTimeout)
+	})
+
+	t.Run("invalid instance options are an error", func(t *testing.T) {
+		req := require.New(t)
+
+		err := newTestInstance(t, id, nil).Config.Parse(newConfigMap(map[interface{}]interface{}{"readTimeout": 5}))
+		req.Error(err)
+		req.Contains(err.Error(), "error parsing options section [webOptions]: ")
+		req.NotContains(err.Error(), "error parsing options:")
+		req.NotContains(err.Error(), "web configuration")
+
+		err = newTestInstance(t, id, nil).Config.Parse(newConfigMap("fast"))
+		req.EqualError(err, "options section [webOptions] must be a map")
+	})
+
+	t.Run("the options section defaults to webOptions", func(t *testing.T) {
+		req := require.New(t)
+
+		config := &InstanceConfig{Section: "web", DefaultIdentity: id}
+		req.NoError(config.Parse(newConfigMap(map[interface{}]interface{}{"writeTimeout": "4s"})))
+
+		req.Equal(DefaultOptionsSection, config.OptionsSection)
+		req.Equal(4*time.Second, findServerConfig(config, "inherits").Options.WriteTimeout)
+	})
+}
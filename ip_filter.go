@@ -0,0 +1,134 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ipFilter restricts the client addresses that may make requests to a bind point. Denied networks are evaluated
+// before allowed networks. If any allowed networks are listed, clients must be within one of them.
+type ipFilter struct {
+	allowed []*net.IPNet
+	denied  []*net.IPNet
+}
+
+// newIpFilter returns the ipFilter for the AllowedCidrs and DeniedCidrs of a BindPointConfig or nil if neither is set.
+func newIpFilter(bindPoint *BindPointConfig) (*ipFilter, error) {
+	if len(bindPoint.AllowedCidrs) == 0 && len(bindPoint.DeniedCidrs) == 0 {
+		return nil, nil
+	}
+
+	allowed, err := parseCidrs(bindPoint.AllowedCidrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid allowedCidrs: %v", err)
+	}
+
+	denied, err := parseCidrs(bindPoint.DeniedCidrs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid deniedCidrs: %v", err)
+	}
+
+	return &ipFilter{
+		allowed: allowed,
+		denied:  denied,
+	}, nil
+}
+
+// parseCidrs parses CIDRs (e.g. 10.0.0.0/8). Single IP addresses are accepted as networks of a single address.
+func parseCidrs(cidrs []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+
+	for i, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, fmt.Errorf("value [%s] at index [%d] is not a CIDR or IP address", cidr, i)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("value [%s] at index [%d] is not a CIDR or IP address: %v", cidr, i, err)
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// isAllowed returns true if the client with the supplied remote address may make requests. Clients without an IP
+// address (e.g. unix socket peers) are only allowed if no allowed networks are listed.
+func (filter *ipFilter) isAllowed(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil {
+		return len(filter.allowed) == 0
+	}
+
+	for _, network := range filter.denied {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(filter.allowed) == 0 {
+		return true
+	}
+
+	for _, network := range filter.allowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wrap wraps a http.Handler with another http.Handler that responds with http.StatusForbidden (403) to requests from
+// clients that are not allowed. The remote address of requests on PROXY protocol bind points is the client address
+// conveyed by the PROXY protocol header.
+func (filter *ipFilter) wrap(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if !filter.isAllowed(request.RemoteAddr) {
+			http.Error(writer, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return
+		}
+
+		handler.ServeHTTP(writer, request)
+	})
+}
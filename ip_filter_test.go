@@ -0,0 +1,81 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_ipFilter(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+	instance := newTestInstance(t, id, nil)
+
+	bindPoint := &BindPointConfig{}
+	require.New(t).NoError(bindPoint.Parse(map[interface{}]interface{}{
+		"interface":    "127.0.0.1:443",
+		"address":      "127.0.0.1:443",
+		"allowedCidrs": []interface{}{"10.0.0.0/8", "192.168.1.10"},
+		"deniedCidrs":  []interface{}{"10.1.0.0/16"},
+	}))
+
+	serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+	serverConfig.BindPoints = []*BindPointConfig{bindPoint}
+	require.New(t).NoError(serverConfig.Validate(instance.Registry))
+
+	handler := newTestServer(t, instance, serverConfig).httpServers[0].Handler
+
+	serve := func(remoteAddr string) int {
+		request := httptest.NewRequest(http.MethodGet, "/mock-handler", nil)
+		request.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	t.Run("clients within allowed cidrs are served", func(t *testing.T) {
+		req := require.New(t)
+		req.Equal(http.StatusOK, serve("10.2.3.4:5000"))
+		req.Equal(http.StatusOK, serve("192.168.1.10:5000"))
+	})
+
+	t.Run("clients outside allowed cidrs are forbidden", func(t *testing.T) {
+		req := require.New(t)
+		req.Equal(http.StatusForbidden, serve("192.168.1.11:5000"))
+		req.Equal(http.StatusForbidden, serve("[2001:db8::1]:5000"))
+	})
+
+	t.Run("denied cidrs are evaluated before allowed cidrs", func(t *testing.T) {
+		require.New(t).Equal(http.StatusForbidden, serve("10.1.2.3:5000"))
+	})
+
+	t.Run("clients without an ip address are forbidden if allowed cidrs are listed", func(t *testing.T) {
+		require.New(t).Equal(http.StatusForbidden, serve("@"))
+	})
+
+	t.Run("invalid cidrs fail when parsed", func(t *testing.T) {
+		req := require.New(t)
+
+		err := (&BindPointConfig{}).Parse(map[interface{}]interface{}{"deniedCidrs": []interface{}{"10.0.0.0/33"}})
+		req.Error(err)
+		req.Contains(err.Error(), "deniedCidrs")
+
+		req.Error((&BindPointConfig{InterfaceAddress: "127.0.0.1:443", Address: "127.0.0.1:443", AllowedCidrs: []string{"nope"}}).Validate())
+	})
+}
@@ -0,0 +1,86 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// ParseJSON parses a JSON configuration document with the same structure as the configuration map accepted by Parse.
+func (config *InstanceConfig) ParseJSON(data []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	configMap := map[string]interface{}{}
+
+	if err := decoder.Decode(&configMap); err != nil {
+		return fmt.Errorf("error parsing JSON configuration: %v", err)
+	}
+
+	return config.Parse(NormalizeConfigMap(configMap))
+}
+
+// NormalizeConfigMap converts a configuration map with string keys, as produced by encoding/json and other decoders,
+// into the map[interface{}]interface{} form produced by yaml.v2 and expected by all Parse functions. Nested maps and
+// arrays are converted as well. Whole numbers are converted to int, as JSON does not distinguish integers from floating
+// point numbers.
+func NormalizeConfigMap(configMap map[string]interface{}) map[interface{}]interface{} {
+	result := make(map[interface{}]interface{}, len(configMap))
+
+	for key, value := range configMap {
+		result[key] = normalizeConfigValue(value)
+	}
+
+	return result
+}
+
+func normalizeConfigValue(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		return NormalizeConfigMap(typedValue)
+	case map[interface{}]interface{}:
+		result := make(map[interface{}]interface{}, len(typedValue))
+		for key, nestedValue := range typedValue {
+			result[key] = normalizeConfigValue(nestedValue)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(typedValue))
+		for i, nestedValue := range typedValue {
+			result[i] = normalizeConfigValue(nestedValue)
+		}
+		return result
+	case json.Number:
+		if intValue, err := typedValue.Int64(); err == nil {
+			return int(intValue)
+		}
+		if floatValue, err := typedValue.Float64(); err == nil {
+			return floatValue
+		}
+		return typedValue.String()
+	case float64:
+		if typedValue == math.Trunc(typedValue) && math.Abs(typedValue) <= math.MaxInt32 {
+			return int(typedValue)
+		}
+		return typedValue
+	default:
+		return value
+	}
+}
@@ -0,0 +1,55 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestInstanceConfig_ParseJSON(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+	instance := newTestInstance(t, id, nil)
+
+	err := instance.Config.ParseJSON([]byte(`{
+		"web": [{
+			"name": "json",
+			"bindPoints": [{"interface": "127.0.0.1:8443", "address": "127.0.0.1:8443", "maxConnections": 5}],
+			"apis": [{"binding": "mockHandler", "options": {"nested": {"value": 1}}}],
+			"options": {"readTimeout": "5s", "maxHeaderBytes": 2048, "rateLimit": 1.5}
+		}]
+	}`))
+	req.NoError(err)
+	req.NoError(instance.Config.Validate(instance.Registry))
+
+	req.Len(instance.Config.ServerConfigs, 1)
+	serverConfig := instance.Config.ServerConfigs[0]
+
+	req.Equal("json", serverConfig.Name)
+	req.Equal(5, serverConfig.BindPoints[0].MaxConnections)
+	req.Equal(5*time.Second, serverConfig.Options.ReadTimeout)
+	req.Equal(2048, serverConfig.Options.MaxHeaderBytes)
+	req.Equal(1.5, serverConfig.Options.RateLimit)
+	req.Equal(map[interface{}]interface{}{"nested": map[interface{}]interface{}{"value": 1}}, serverConfig.APIs[0].Options())
+
+	t.Run("invalid JSON is an error", func(t *testing.T) {
+		require.New(t).Error(NewDefaultInstance(NewRegistryMap(), id).Config.ParseJSON([]byte(`{"web": [`)))
+	})
+}
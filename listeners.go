@@ -0,0 +1,341 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// PreBoundListeners holds already bound listeners, e.g. inherited via systemd socket activation or from a parent
+// process during a graceful restart, keyed by the InterfaceAddress of the bind points that adopt them. Servers serve an
+// adopted listener instead of binding its bind point, wrapping it with TLS and PROXY protocol handling as configured.
+// Each listener is adopted at most once and is closed with the Server that adopted it, unless Reload hands it over to
+// the Server's replacement. Servers built afterwards for the same bind point bind the address themselves. PreBoundListeners is safe for concurrent use.
+type PreBoundListeners struct {
+	lock      sync.Mutex
+	listeners map[string]net.Listener
+}
+
+// NewPreBoundListeners creates PreBoundListeners from a map of bind point InterfaceAddress's to listeners.
+func NewPreBoundListeners(listeners map[string]net.Listener) *PreBoundListeners {
+	result := &PreBoundListeners{
+		listeners: map[string]net.Listener{},
+	}
+
+	for address, listener := range listeners {
+		result.listeners[strings.TrimSpace(address)] = listener
+	}
+
+	return result
+}
+
+// Take removes and returns the listener for the supplied bind point InterfaceAddress. Nil is returned if there is none
+// or it was already taken.
+func (preBound *PreBoundListeners) Take(address string) net.Listener {
+	preBound.lock.Lock()
+	defer preBound.lock.Unlock()
+
+	address = strings.TrimSpace(address)
+
+	listener := preBound.listeners[address]
+	delete(preBound.listeners, address)
+
+	return listener
+}
+
+// Close closes all listeners that have not been taken, e.g. those whose bind points were removed from the
+// configuration. The first error encountered is returned.
+func (preBound *PreBoundListeners) Close() error {
+	preBound.lock.Lock()
+	defer preBound.lock.Unlock()
+
+	var firstErr error
+
+	for address, listener := range preBound.listeners {
+		if err := listener.Close(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("could not close pre-bound listener for [%s]: %v", address, err)
+		}
+
+		delete(preBound.listeners, address)
+	}
+
+	return firstErr
+}
+
+// takePreBoundListener returns the listener of InstanceOptions.PreBoundListeners for the supplied bind point, nil if
+// there is none. An error is returned if the listener's network does not match the bind point's.
+func (server *Server) takePreBoundListener(bindPoint *BindPointConfig) (net.Listener, error) {
+	if server.instanceOptions.PreBoundListeners == nil {
+		return nil, nil
+	}
+
+	listener := server.instanceOptions.PreBoundListeners.Take(bindPoint.InterfaceAddress)
+
+	if listener == nil {
+		return nil, nil
+	}
+
+	if isUnix := listener.Addr().Network() == "unix"; isUnix != bindPoint.IsUnixSocket() {
+		_ = listener.Close()
+		return nil, fmt.Errorf("pre-bound listener on %s (%s) does not match the network of bind point [%s]", listener.Addr(), listener.Addr().Network(), bindPoint.InterfaceAddress)
+	}
+
+	return listener, nil
+}
+
+// handoffListener accepts connections on a bound listener and hands them to the Server's accepting through its views.
+// It allows Reload to hand the bind points of a replaced Server over to its replacement without closing them: the
+// replacement acquires a view before the replaced Server closes its own, and the bound listener is only closed once
+// all views are closed. Connections are accepted by whichever view accepts first. TLS handshakes use the tls.Config
+// most recently set by setTlsConfig, i.e. that of the replacement once it took the bind point over.
+type handoffListener struct {
+	key      string
+	address  string
+	listener net.Listener
+
+	current   atomic.Pointer[tls.Config]
+	delegate  *tls.Config
+	accepted  chan acceptResult
+	done      chan struct{}
+	acceptErr error
+
+	lock  sync.Mutex
+	views int
+}
+
+// acceptResult is a connection or error returned by the Accept of a bound listener
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// newHandoffListener creates a handoffListener for a bind point that performs TLS handshakes with tlsConfig, if not
+// nil. The listener must be bound with the tls.Config returned by tlsConfig and supplied to serve.
+func newHandoffListener(bindPoint *BindPointConfig, tlsConfig *tls.Config) *handoffListener {
+	handoff := &handoffListener{
+		key:      handoffKey(bindPoint, tlsConfig != nil),
+		address:  bindPoint.InterfaceAddress,
+		accepted: make(chan acceptResult),
+		done:     make(chan struct{}),
+	}
+
+	if tlsConfig != nil {
+		handoff.current.Store(tlsConfig)
+
+		//the shared listener of transporttls dispatches by protocol, also accept clients that do not request one.
+		//tlsConfig is not cloned as the identity updates it during handshakes on other bind points.
+		handoff.delegate = &tls.Config{
+			NextProtos:         append(append([]string{}, tlsConfig.NextProtos...), ""),
+			GetConfigForClient: handoff.getConfigForClient,
+		}
+	}
+
+	return handoff
+}
+
+// handoffKey identifies a bind point by the properties of its bound listener. Bind points with the same key may hand
+// their listener over to each other.
+func handoffKey(bindPoint *BindPointConfig, serveTLS bool) string {
+	return fmt.Sprintf("%s|%s|proxyProtocol=%t|tls=%t", bindPoint.ListenNetwork(), bindPoint.InterfaceAddress, bindPoint.ProxyProtocol, serveTLS)
+}
+
+// tlsConfig returns the tls.Config the listener must be bound with, nil if it serves plain HTTP. It selects the
+// tls.Config set by setTlsConfig for every handshake.
+func (handoff *handoffListener) tlsConfig() *tls.Config {
+	return handoff.delegate
+}
+
+// setTlsConfig sets the tls.Config used for new TLS handshakes.
+func (handoff *handoffListener) setTlsConfig(tlsConfig *tls.Config) {
+	if tlsConfig != nil {
+		handoff.current.Store(tlsConfig)
+	}
+}
+
+// getConfigForClient selects the tls.Config of a handshake from the current tls.Config, see setTlsConfig.
+func (handoff *handoffListener) getConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	config := handoff.current.Load()
+
+	if config.GetConfigForClient != nil {
+		if selected, err := config.GetConfigForClient(hello); err != nil || selected != nil {
+			return selected, err
+		}
+	}
+
+	return config, nil
+}
+
+// serve starts accepting connections on the bound listener.
+func (handoff *handoffListener) serve(listener net.Listener) {
+	handoff.listener = listener
+
+	go func() {
+		defer close(handoff.done)
+
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				//temporary errors are handed to a view, http.Server backs off and accepts again
+				if netErr, ok := err.(net.Error); !ok || !netErr.Temporary() {
+					handoff.acceptErr = err
+					return
+				}
+			}
+
+			handoff.accepted <- acceptResult{conn: conn, err: err}
+		}
+	}()
+}
+
+// view returns a new view that accepts connections on the bound listener.
+func (handoff *handoffListener) view() net.Listener {
+	handoff.lock.Lock()
+	defer handoff.lock.Unlock()
+
+	handoff.views++
+
+	return &handoffView{handoff: handoff, closed: make(chan struct{})}
+}
+
+// release closes the bound listener once the last view is closed. A connection that was accepted but not taken by
+// a view is closed.
+func (handoff *handoffListener) release() {
+	handoff.lock.Lock()
+	handoff.views--
+	last := handoff.views == 0
+	handoff.lock.Unlock()
+
+	if !last {
+		return
+	}
+
+	_ = handoff.listener.Close()
+
+	for {
+		select {
+		case result := <-handoff.accepted:
+			if result.conn != nil {
+				_ = result.conn.Close()
+			}
+		case <-handoff.done:
+			return
+		}
+	}
+}
+
+// handoffView is a net.Listener that accepts the connections of a handoffListener. Closing it stops it from accepting
+// and releases its handoffListener.
+type handoffView struct {
+	handoff   *handoffListener
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (view *handoffView) Accept() (net.Conn, error) {
+	select {
+	case <-view.closed:
+		return nil, net.ErrClosed
+	default:
+	}
+
+	select {
+	case result := <-view.handoff.accepted:
+		return result.conn, result.err
+	case <-view.closed:
+		return nil, net.ErrClosed
+	case <-view.handoff.done:
+		return nil, view.handoff.acceptErr
+	}
+}
+
+func (view *handoffView) Close() error {
+	view.closeOnce.Do(func() {
+		close(view.closed)
+		view.handoff.release()
+	})
+
+	return nil
+}
+
+func (view *handoffView) Addr() net.Addr {
+	return view.handoff.listener.Addr()
+}
+
+// handoffListeners holds the handoffListeners of replaced Server's, keyed by handoffKey, for their replacements to
+// take over. It is safe for concurrent use.
+type handoffListeners struct {
+	lock      sync.Mutex
+	listeners map[string]*handoffListener
+}
+
+// newHandoffListeners collects the handoffListeners of the supplied Server's.
+func newHandoffListeners(servers []*Server) *handoffListeners {
+	result := &handoffListeners{listeners: map[string]*handoffListener{}}
+
+	for _, server := range servers {
+		server.listenLock.Lock()
+		for _, handoff := range server.handoffs {
+			result.listeners[handoff.key] = handoff
+		}
+		server.listenLock.Unlock()
+	}
+
+	return result
+}
+
+// take removes and returns the handoffListener for key, nil if there is none.
+func (handoffs *handoffListeners) take(key string) *handoffListener {
+	if handoffs == nil {
+		return nil
+	}
+
+	handoffs.lock.Lock()
+	defer handoffs.lock.Unlock()
+
+	handoff := handoffs.listeners[key]
+	delete(handoffs.listeners, key)
+
+	return handoff
+}
+
+// canTakeOver returns false if a bind point of the supplied Server's is bound to the address of a handoffListener it
+// cannot take over, e.g. as TLS was enabled or disabled for it. The address must then be released by shutting down the
+// replaced Server before the bind point is bound.
+func (handoffs *handoffListeners) canTakeOver(servers []*Server) bool {
+	handoffs.lock.Lock()
+	defer handoffs.lock.Unlock()
+
+	for _, server := range servers {
+		for _, httpServer := range server.httpServers {
+			key := handoffKey(httpServer.BindPointConfig, httpServer.TLSConfig != nil)
+
+			for _, handoff := range handoffs.listeners {
+				if handoff.address == httpServer.BindPointConfig.InterfaceAddress && handoff.key != key {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
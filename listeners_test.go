@@ -0,0 +1,169 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestPreBoundListeners(t *testing.T) {
+	t.Run("listeners are taken once", func(t *testing.T) {
+		req := require.New(t)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+		defer func() { _ = listener.Close() }()
+
+		preBound := NewPreBoundListeners(map[string]net.Listener{" 0.0.0.0:443 ": listener})
+
+		req.Nil(preBound.Take("0.0.0.0:8443"))
+		req.Same(listener, preBound.Take("0.0.0.0:443"))
+		req.Nil(preBound.Take("0.0.0.0:443"))
+	})
+
+	t.Run("close closes listeners that were not taken", func(t *testing.T) {
+		req := require.New(t)
+
+		taken, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+		defer func() { _ = taken.Close() }()
+
+		remaining, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+
+		preBound := NewPreBoundListeners(map[string]net.Listener{"a:1": taken, "b:1": remaining})
+		req.NotNil(preBound.Take("a:1"))
+		req.NoError(preBound.Close())
+
+		_, err = remaining.Accept()
+		req.ErrorIs(err, net.ErrClosed)
+		req.Nil(preBound.Take("b:1"))
+	})
+}
+
+func TestServer_preBoundListeners(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("servers serve pre-bound listeners instead of binding", func(t *testing.T) {
+		req := require.New(t)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+
+		//the configured address is never bound, the pre-bound listener is served instead
+		interfaceAddress := freeAddress(t)
+
+		instance := newTestInstance(t, id, nil)
+		instance.Config.Options.PreBoundListeners = NewPreBoundListeners(map[string]net.Listener{interfaceAddress: listener})
+
+		server := newTestServer(t, instance, newTestServerConfig(id, interfaceAddress))
+		req.NoError(server.Listen())
+		go func() {
+			_ = server.Start()
+		}()
+		defer server.Shutdown(context.Background())
+
+		req.Equal([]net.Addr{listener.Addr()}, server.BoundAddresses())
+
+		resp, err := newTestClient().Get("https://" + listener.Addr().String() + "/mock-handler")
+		req.NoError(err)
+		_ = resp.Body.Close()
+		req.Equal(http.StatusOK, resp.StatusCode)
+		req.NotNil(resp.TLS)
+
+		_, err = tls.Dial("tcp", interfaceAddress, &tls.Config{InsecureSkipVerify: true})
+		req.Error(err)
+	})
+
+	t.Run("listeners that do not match the bind point network are rejected", func(t *testing.T) {
+		req := require.New(t)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+
+		interfaceAddress := UnixSocketPrefix + t.TempDir() + "/xweb.sock"
+
+		instance := newTestInstance(t, id, nil)
+		instance.Config.Options.PreBoundListeners = NewPreBoundListeners(map[string]net.Listener{interfaceAddress: listener})
+
+		server := newTestServer(t, instance, newTestServerConfig(id, interfaceAddress))
+
+		err = server.Listen()
+		req.Error(err)
+		req.Contains(err.Error(), "does not match the network of bind point")
+
+		_, err = listener.Accept()
+		req.ErrorIs(err, net.ErrClosed)
+	})
+}
+
+func TestHandoffListener(t *testing.T) {
+	t.Run("the bound listener is closed with the last view", func(t *testing.T) {
+		req := require.New(t)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+
+		handoff := newHandoffListener(&BindPointConfig{InterfaceAddress: listener.Addr().String()}, nil)
+		handoff.serve(listener)
+
+		previous := handoff.view()
+		current := handoff.view()
+
+		req.NoError(previous.Close())
+		_, err = previous.Accept()
+		req.ErrorIs(err, net.ErrClosed)
+
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+
+		accepted, err := current.Accept()
+		req.NoError(err)
+		_ = accepted.Close()
+
+		req.NoError(current.Close())
+
+		_, err = net.Dial("tcp", listener.Addr().String())
+		req.Error(err)
+	})
+
+	t.Run("handshakes use the current tls.Config", func(t *testing.T) {
+		req := require.New(t)
+
+		previous := &tls.Config{NextProtos: []string{"previous"}}
+		current := &tls.Config{NextProtos: []string{"current"}}
+
+		handoff := newHandoffListener(&BindPointConfig{InterfaceAddress: "127.0.0.1:0"}, previous)
+		req.Equal([]string{"previous", ""}, handoff.tlsConfig().NextProtos)
+
+		selected, err := handoff.tlsConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+		req.NoError(err)
+		req.Same(previous, selected)
+
+		handoff.setTlsConfig(current)
+
+		selected, err = handoff.tlsConfig().GetConfigForClient(&tls.ClientHelloInfo{})
+		req.NoError(err)
+		req.Same(current, selected)
+	})
+}
@@ -0,0 +1,196 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type accessLogContextKey struct{}
+
+const (
+	// AccessLogFieldBinding is the AccessLogEntry field that holds the binding of the ApiHandler that served the
+	// request.
+	AccessLogFieldBinding = "binding"
+)
+
+// AccessLogEntry describes a single request served by a handler wrapped with NewAccessLogHandler. Handlers downstream
+// of the access log handler may add fields to the entry for the current request via AccessLogEntryFromContext.
+type AccessLogEntry struct {
+	Method     string
+	Path       string
+	RemoteAddr string
+	Status     int
+	Bytes      int64
+	Duration   time.Duration
+
+	lock   sync.Mutex
+	fields map[string]interface{}
+}
+
+// SetField adds or replaces a field of the entry.
+func (entry *AccessLogEntry) SetField(key string, value interface{}) {
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	if entry.fields == nil {
+		entry.fields = map[string]interface{}{}
+	}
+
+	entry.fields[key] = value
+}
+
+// Fields returns a copy of the fields added to the entry via SetField.
+func (entry *AccessLogEntry) Fields() map[string]interface{} {
+	entry.lock.Lock()
+	defer entry.lock.Unlock()
+
+	fields := make(map[string]interface{}, len(entry.fields))
+	for key, value := range entry.fields {
+		fields[key] = value
+	}
+
+	return fields
+}
+
+// AccessLogEntryFromContext returns the AccessLogEntry of the request the context belongs to, or nil if the request
+// is not being access logged.
+func AccessLogEntryFromContext(ctx context.Context) *AccessLogEntry {
+	if entry, ok := ctx.Value(accessLogContextKey{}).(*AccessLogEntry); ok {
+		return entry
+	}
+
+	return nil
+}
+
+// AccessLogSink receives an AccessLogEntry after each request completes.
+type AccessLogSink func(entry *AccessLogEntry)
+
+// AccessLogOptions alters the behavior of the handler returned by NewAccessLogHandler.
+type AccessLogOptions struct {
+	// Sink, if set, receives every AccessLogEntry instead of the default logger.
+	Sink AccessLogSink
+}
+
+// NewAccessLogHandler wraps next with a handler that emits one AccessLogEntry per request after next returns. The
+// status and byte count are those written by next. If options or options.Sink is nil, entries are logged at info
+// level with structured fields.
+func NewAccessLogHandler(next http.Handler, options *AccessLogOptions) http.Handler {
+	sink := LogAccessLogEntry
+
+	if options != nil && options.Sink != nil {
+		sink = options.Sink
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		entry := &AccessLogEntry{
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			RemoteAddr: r.RemoteAddr,
+		}
+
+		writer := &accessLogResponseWriter{ResponseWriter: w}
+
+		defer func() {
+			entry.Status = writer.status
+			if entry.Status == 0 {
+				entry.Status = http.StatusOK
+			}
+			entry.Bytes = writer.bytes
+			entry.Duration = time.Since(start)
+
+			if requestId := RequestIdFromContext(r.Context()); requestId != "" {
+				entry.SetField(AccessLogFieldRequestId, requestId)
+			}
+
+			sink(entry)
+		}()
+
+		next.ServeHTTP(writer, r.WithContext(context.WithValue(r.Context(), accessLogContextKey{}, entry)))
+	})
+}
+
+// LogAccessLogEntry is the default AccessLogSink, it logs the entry at info level with structured fields.
+func LogAccessLogEntry(entry *AccessLogEntry) {
+	fields := logrus.Fields{
+		"method":     entry.Method,
+		"path":       entry.Path,
+		"remoteAddr": entry.RemoteAddr,
+		"status":     entry.Status,
+		"bytes":      entry.Bytes,
+		"duration":   entry.Duration,
+	}
+
+	for key, value := range entry.Fields() {
+		fields[key] = value
+	}
+
+	pfxlog.Logger().WithFields(fields).Info("access")
+}
+
+// accessLogResponseWriter records the status and number of body bytes written to a http.ResponseWriter.
+type accessLogResponseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *accessLogResponseWriter) WriteHeader(status int) {
+	// informational (1xx) responses are followed by the final status
+	if w.status == 0 && status >= http.StatusOK {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessLogResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessLogResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *accessLogResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+
+	return nil, nil, errors.New("underlying http.ResponseWriter does not support hijacking")
+}
+
+// Unwrap returns the underlying http.ResponseWriter for use by http.ResponseController.
+func (w *accessLogResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
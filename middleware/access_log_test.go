@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_NewAccessLogHandler(t *testing.T) {
+	serve := func(handler http.HandlerFunc) *AccessLogEntry {
+		var logged *AccessLogEntry
+		accessLogHandler := NewAccessLogHandler(handler, &AccessLogOptions{
+			Sink: func(entry *AccessLogEntry) {
+				logged = entry
+			},
+		})
+
+		request := httptest.NewRequest(http.MethodPost, "/some/path", nil)
+		request.RemoteAddr = "192.0.2.1:1234"
+		accessLogHandler.ServeHTTP(httptest.NewRecorder(), request)
+
+		return logged
+	}
+
+	t.Run("request and response details are recorded", func(t *testing.T) {
+		req := require.New(t)
+
+		entry := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte("hello"))
+			_, _ = w.Write([]byte(" world"))
+		})
+
+		req.NotNil(entry)
+		req.Equal(http.MethodPost, entry.Method)
+		req.Equal("/some/path", entry.Path)
+		req.Equal("192.0.2.1:1234", entry.RemoteAddr)
+		req.Equal(http.StatusCreated, entry.Status)
+		req.Equal(int64(11), entry.Bytes)
+		req.Greater(entry.Duration.Nanoseconds(), int64(0))
+	})
+
+	t.Run("the status defaults to 200 if not written", func(t *testing.T) {
+		entry := serve(func(w http.ResponseWriter, r *http.Request) {})
+		require.New(t).Equal(http.StatusOK, entry.Status)
+	})
+
+	t.Run("informational statuses are not recorded as the final status", func(t *testing.T) {
+		entry := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusEarlyHints)
+			w.WriteHeader(http.StatusAccepted)
+		})
+		require.New(t).Equal(http.StatusAccepted, entry.Status)
+	})
+
+	t.Run("downstream handlers may add fields", func(t *testing.T) {
+		entry := serve(func(w http.ResponseWriter, r *http.Request) {
+			AccessLogEntryFromContext(r.Context()).SetField(AccessLogFieldBinding, "test-binding")
+		})
+		require.New(t).Equal(map[string]interface{}{AccessLogFieldBinding: "test-binding"}, entry.Fields())
+	})
+
+	t.Run("an entry is emitted if the handler panics", func(t *testing.T) {
+		req := require.New(t)
+
+		var logged *AccessLogEntry
+		handler := NewAccessLogHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("failure")
+		}), &AccessLogOptions{
+			Sink: func(entry *AccessLogEntry) {
+				logged = entry
+			},
+		})
+
+		req.Panics(func() {
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		})
+		req.NotNil(logged)
+	})
+}
@@ -17,13 +17,17 @@
 package middleware
 
 import (
+	"bufio"
 	"bytes"
 	"compress/flate"
 	"compress/gzip"
+	"errors"
 	"fmt"
 	"github.com/andybalholm/brotli"
 	"io"
 	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
@@ -36,6 +40,12 @@ const (
 	HttpHeaderContentLength   = "Content-Length"
 	HttpHeaderAcceptEncoding  = "Accept-Encoding"
 	HttpHeaderContentEncoding = "Content-Encoding"
+	HttpHeaderContentType     = "Content-Type"
+	HttpHeaderConnection      = "Connection"
+	HttpHeaderUpgrade         = "Upgrade"
+	HttpHeaderAccept          = "Accept"
+
+	ContentTypeEventStream = "text/event-stream"
 
 	HttpEncodingGzip     = HttpEncoding("gzip")
 	HttpEncodingBr       = HttpEncoding("br")
@@ -43,10 +53,12 @@ const (
 	HttpEncodingIdentity = HttpEncoding("identity")
 )
 
-var supportedEncodings = map[HttpEncoding]struct{}{
-	HttpEncodingGzip:    {},
-	HttpEncodingBr:      {},
-	HttpEncodingDeflate: {},
+// supportedEncodings maps each supported encoding to its preference when clients accept several with the same quality,
+// higher is preferred
+var supportedEncodings = map[HttpEncoding]int{
+	HttpEncodingBr:      3,
+	HttpEncodingGzip:    2,
+	HttpEncodingDeflate: 1,
 }
 
 var gzPool = sync.Pool{
@@ -65,11 +77,97 @@ var brPool = sync.Pool{
 
 var deflatePool = sync.Pool{
 	New: func() interface{} {
-		w, _ := flate.NewWriter(ioutil.Discard, 4)
+		w, _ := flate.NewWriter(ioutil.Discard, defaultDeflateLevel)
 		return w
 	},
 }
 
+const (
+	defaultDeflateLevel = 4
+
+	// MinCompressionLevel and MaxCompressionLevel bound CompressionOptions.Level, zero selects the default level of
+	// each encoding
+	MinCompressionLevel = 1
+	MaxCompressionLevel = 9
+)
+
+// encoderPools holds a pool of encoders for each supported encoding
+type encoderPools struct {
+	gzip    *sync.Pool
+	br      *sync.Pool
+	deflate *sync.Pool
+}
+
+var defaultEncoderPools = &encoderPools{
+	gzip:    &gzPool,
+	br:      &brPool,
+	deflate: &deflatePool,
+}
+
+// newEncoderPools returns pools of encoders that compress at the supplied level. Levels outside of
+// MinCompressionLevel and MaxCompressionLevel use the shared default pools.
+func newEncoderPools(level int) *encoderPools {
+	if level < MinCompressionLevel || level > MaxCompressionLevel {
+		return defaultEncoderPools
+	}
+
+	return &encoderPools{
+		gzip: &sync.Pool{
+			New: func() interface{} {
+				w, _ := gzip.NewWriterLevel(ioutil.Discard, level)
+				return w
+			},
+		},
+		br: &sync.Pool{
+			New: func() interface{} {
+				return brotli.NewWriterLevel(ioutil.Discard, level)
+			},
+		},
+		deflate: &sync.Pool{
+			New: func() interface{} {
+				w, _ := flate.NewWriter(ioutil.Discard, level)
+				return w
+			},
+		},
+	}
+}
+
+// CompressionError is reported when a compressed response could not be produced or written to the underlying
+// http.ResponseWriter (e.g. the client connection broke).
+type CompressionError struct {
+	Encoding HttpEncoding
+	Method   string
+	Path     string
+	Err      error
+}
+
+func (e *CompressionError) Error() string {
+	return fmt.Sprintf("error writing %s compressed response for %s %s: %v", e.Encoding, e.Method, e.Path, e.Err)
+}
+
+func (e *CompressionError) Unwrap() error {
+	return e.Err
+}
+
+// CompressionOptions alter the behavior of the compression handler.
+type CompressionOptions struct {
+	// OnError, if set, is invoked when a compressed response could not be produced or written. The response is
+	// aborted without panicking. If not set, the error is ignored.
+	OnError func(request *http.Request, err *CompressionError)
+
+	// Level is the compression level used by all encodings, from MinCompressionLevel (fastest) to
+	// MaxCompressionLevel (smallest). Zero uses the default level of each encoding.
+	Level int
+
+	// MinSize is the smallest response body, in bytes, that is compressed. Smaller responses are sent unencoded.
+	MinSize int
+
+	// ContentTypes, if not empty, limits compression to responses whose media type is listed. Entries ending in
+	// "/*" match all subtypes (e.g. "text/*"). Responses without a Content-Type header are matched on their sniffed
+	// content type.
+	ContentTypes []string
+}
+
 // NewCompressionHandler will return a http.Handler that should be at the top of a response pipeline (i.e. before any
 // other http.handlers that write). The returned handler will handle accept-encoding http header interpretation and
 // provide a wrapped writer to all downstream http.handlers that will result in all written content to be compressed if
@@ -80,18 +178,36 @@ var deflatePool = sync.Pool{
 // content response body (including writing more data) after the handler exits may cause issues for the receiving
 // client.
 func NewCompressionHandler(next http.Handler) http.Handler {
+	return NewCompressionHandlerWithOptions(next, nil)
+}
+
+// NewCompressionHandlerWithOptions behaves as NewCompressionHandler with the supplied CompressionOptions. A nil
+// options value uses the defaults.
+func NewCompressionHandlerWithOptions(next http.Handler, options *CompressionOptions) http.Handler {
+	if options == nil {
+		options = &CompressionOptions{}
+	}
+
+	pools := newEncoderPools(options.Level)
+
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		//upgraded connections (e.g. WebSockets) and event streams are hijacked or streamed, never buffered
+		if isUpgradeRequest(r) || isEventStream(r.Header.Get(HttpHeaderAccept)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		acceptEncodingHeader := getSupportedAcceptEncoding(r)
 
 		switch acceptEncodingHeader {
 		case HttpEncodingGzip:
-			handleGZip(w, r, next)
+			handleEncoding(w, r, next, HttpEncodingGzip, pools.gzip, options)
 			return
 		case HttpEncodingBr:
-			handleBr(w, r, next)
+			handleEncoding(w, r, next, HttpEncodingBr, pools.br, options)
 			return
 		case HttpEncodingDeflate:
-			handleDeflate(w, r, next)
+			handleEncoding(w, r, next, HttpEncodingDeflate, pools.deflate, options)
 			return
 		}
 
@@ -99,8 +215,31 @@ func NewCompressionHandler(next http.Handler) http.Handler {
 	})
 }
 
-// getSupportedAcceptEncoding returns the highest priority supported encoding supplied by the client.
-// HttpEncodingIdentity (no encoding) is returned if no accept header is supplied, invalid headers are supplied, or
+// isUpgradeRequest returns true if the request asks to upgrade the connection to another protocol (e.g. WebSockets).
+func isUpgradeRequest(r *http.Request) bool {
+	if r.Header.Get(HttpHeaderUpgrade) == "" {
+		return false
+	}
+
+	for _, value := range r.Header.Values(HttpHeaderConnection) {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isEventStream returns true if the supplied Content-Type or Accept header value is for Server-Sent Events.
+func isEventStream(headerValue string) bool {
+	return strings.HasPrefix(strings.ToLower(strings.TrimSpace(headerValue)), ContentTypeEventStream)
+}
+
+// getSupportedAcceptEncoding returns the highest priority supported encoding supplied by the client. Encodings
+// accepted with the same quality are chosen in the order br, gzip, then deflate regardless of the order in which they
+// are listed. HttpEncodingIdentity (no encoding) is returned if no accept header is supplied, invalid headers are supplied, or
 // no supported encodings are supplied.
 func getSupportedAcceptEncoding(r *http.Request) HttpEncoding {
 	rawHeaders := r.Header.Values(HttpHeaderAcceptEncoding)
@@ -118,7 +257,7 @@ func getSupportedAcceptEncoding(r *http.Request) HttpEncoding {
 			encoding := HttpEncoding(strings.TrimSpace(rawWeightedSplits[0]))
 			qFactor := float32(1) //if not specified, 1 is default
 
-			_, isSupported := supportedEncodings[encoding]
+			preference, isSupported := supportedEncodings[encoding]
 
 			if isSupported {
 				// if 2+, we have a qFactor
@@ -139,7 +278,11 @@ func getSupportedAcceptEncoding(r *http.Request) HttpEncoding {
 				}
 
 				//qFactors are 0.0-1.0 values only
-				if qFactor >= 0 && qFactor <= 1 && qFactor > highestQFactor {
+				if qFactor < 0 || qFactor > 1 {
+					continue
+				}
+
+				if qFactor > highestQFactor || (qFactor == highestQFactor && preference > supportedEncodings[highestSupported]) {
 					highestSupported = encoding
 					highestQFactor = qFactor
 				}
@@ -151,25 +294,84 @@ func getSupportedAcceptEncoding(r *http.Request) HttpEncoding {
 
 // wrappedResponseWriter satisfies http.ResponseWriter and allows the compression handler to redirect
 // Write() calls to compression encoder instead of the actual http.ResponseWriter.
+//
+// Responses that switch protocols (101), are Server-Sent Events (text/event-stream), or hijack the connection bypass
+// the encoder and are written to the actual http.ResponseWriter unmodified. The decision is made when the status is
+// written, the body is first written, or the connection is hijacked, whichever happens first.
 type wrappedResponseWriter struct {
 	status int
 	io.Writer
 	http.ResponseWriter
+
+	decided bool
+	bypass  bool
+}
+
+// decide determines whether the response bypasses the encoder, once.
+func (w *wrappedResponseWriter) decide(status int) {
+	if w.decided {
+		return
+	}
+
+	w.decided = true
+	w.bypass = status == http.StatusSwitchingProtocols || isEventStream(w.Header().Get(HttpHeaderContentType))
 }
 
 // WriteHeader delays writing the status header till after compression is complete. This is done
 // so that the content length header can be properly set. Prematurely calling WriteHeader()
-// will cause all subsequent header changes to not be applied.
+// will cause all subsequent header changes to not be applied. Informational (1xx) statuses other than 101 are written
+// immediately as they are followed by the final status.
 func (w *wrappedResponseWriter) WriteHeader(status int) {
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
+	w.decide(status)
+
+	if w.bypass {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+
 	w.status = status
 }
 
 // Write proxies the normal Write() to instead run through the compression encoder. Actual writing
 // to the http.ResponseWriter is handled via a defer'ed function call.
 func (w *wrappedResponseWriter) Write(b []byte) (int, error) {
+	w.decide(http.StatusOK)
+
+	if w.bypass {
+		return w.ResponseWriter.Write(b)
+	}
+
 	return w.Writer.Write(b)
 }
 
+// Flush flushes bypassed responses. Compressed responses are buffered until the handler completes.
+func (w *wrappedResponseWriter) Flush() {
+	if !w.bypass {
+		return
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack bypasses the encoder and hijacks the underlying connection.
+func (w *wrappedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.decided = true
+	w.bypass = true
+
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return hijacker.Hijack()
+	}
+
+	return nil, nil, errors.New("underlying http.ResponseWriter does not support hijacking")
+}
+
 // CloseHeaderSection is used by the encoder specific function handler to apply the
 // requested HTTP status and close the header section. This is called during the encoders
 // defer'ed section to occur after all content is written. Emulates
@@ -182,75 +384,136 @@ func (w *wrappedResponseWriter) CloseHeaderSection() {
 	w.ResponseWriter.WriteHeader(w.status)
 }
 
-// handleGZip pulls a gzip encoder from the pool encoders and sets it as the writer
-// for the response. The next http.Handler is then invoked and when finished
-// a deferred function will then pull the compressed contents out of the encoder
-// and set the appropriate http headers.
-func handleGZip(w http.ResponseWriter, r *http.Request, next http.Handler) {
-	gz := gzPool.Get().(*gzip.Writer)
-	defer gzPool.Put(gz)
-
-	var b bytes.Buffer
-	gz.Reset(&b)
+// encoder is the common interface of the pooled gzip, deflate, and brotli writers
+type encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
 
-	wrappedWriter := &wrappedResponseWriter{ResponseWriter: w, Writer: gz}
+// handleEncoding buffers the response of the next http.Handler and, if it should be compressed according to the
+// CompressionOptions, compresses it with an encoder pulled from the supplied pool. The appropriate http headers are
+// then set and the response is written.
+//
+// If next panics, nothing is written so that a partial response is not sent and the panic continues. If the
+// compressed response cannot be produced or written, the error is reported to CompressionOptions.OnError instead of
+// panicking.
+func handleEncoding(w http.ResponseWriter, r *http.Request, next http.Handler, encoding HttpEncoding, pool *sync.Pool, options *CompressionOptions) {
+	var raw bytes.Buffer
 
-	defer func() {
-		_ = gz.Close()
-		length := len(b.Bytes())
-		w.Header().Set(HttpHeaderContentEncoding, string(HttpEncodingGzip))
-		w.Header().Set(HttpHeaderContentLength, fmt.Sprint(length))
-		wrappedWriter.CloseHeaderSection()
-		_, _ = w.Write(b.Bytes())
-	}()
+	wrappedWriter := &wrappedResponseWriter{ResponseWriter: w, Writer: &raw}
 
 	next.ServeHTTP(wrappedWriter, r)
-}
 
-// handleDeflate pulls a deflate encoder from the pool encoders and sets it as the writer
-// for the response. The next http.Handler is then invoked and when finished
-// a deferred function will then pull the compressed contents out of the encoder
-// and set the appropriate http headers.
-func handleDeflate(w http.ResponseWriter, r *http.Request, next http.Handler) {
-	deflate := deflatePool.Get().(*flate.Writer)
-	defer deflatePool.Put(deflate)
+	if wrappedWriter.bypass {
+		return
+	}
+
+	if !options.shouldCompress(w.Header(), raw.Bytes()) {
+		if err := writeEncoded(w, wrappedWriter, HttpEncodingIdentity, raw.Bytes()); err != nil {
+			options.reportError(r, HttpEncodingIdentity, err)
+		}
+		return
+	}
+
+	enc := pool.Get().(encoder)
+	defer pool.Put(enc)
 
 	var b bytes.Buffer
-	deflate.Reset(&b)
+	enc.Reset(&b)
 
-	wrappedWriter := &wrappedResponseWriter{ResponseWriter: w, Writer: deflate}
+	if _, err := enc.Write(raw.Bytes()); err != nil {
+		options.reportError(r, encoding, fmt.Errorf("could not encode: %w", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	defer func() {
-		_ = deflate.Close()
-		w.Header().Set(HttpHeaderContentEncoding, string(HttpEncodingDeflate))
-		w.Header().Set(HttpHeaderContentLength, fmt.Sprint(len(b.Bytes())))
-		wrappedWriter.CloseHeaderSection()
-		_, _ = w.Write(b.Bytes())
-	}()
+	if err := enc.Close(); err != nil {
+		options.reportError(r, encoding, fmt.Errorf("could not finish encoding: %w", err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
 
-	next.ServeHTTP(wrappedWriter, r)
+	if err := writeEncoded(w, wrappedWriter, encoding, b.Bytes()); err != nil {
+		options.reportError(r, encoding, err)
+	}
 }
 
-// handleBr pulls a brotli encoder from the pool encoders and sets it as the writer
-// for the response. The next http.Handler is then invoked and when finished
-// a deferred function will then pull the compressed contents out of the encoder
-// and set the appropriate http headers.
-func handleBr(w http.ResponseWriter, r *http.Request, next http.Handler) {
-	w.Header().Set(HttpHeaderContentEncoding, string(HttpEncodingBr))
+// shouldCompress returns true if a response with the supplied headers and body meets the minimum size and content
+// type requirements of the options. Responses without a Content-Type header are given their sniffed content type as
+// it can no longer be sniffed once encoded.
+func (options *CompressionOptions) shouldCompress(header http.Header, body []byte) bool {
+	if len(body) == 0 || len(body) < options.MinSize {
+		return false
+	}
 
-	br := brPool.Get().(*brotli.Writer)
-	defer brPool.Put(br)
+	contentType := header.Get(HttpHeaderContentType)
 
-	var b bytes.Buffer
-	br.Reset(&b)
+	if contentType == "" {
+		contentType = http.DetectContentType(body)
+		header.Set(HttpHeaderContentType, contentType)
+	}
+
+	if len(options.ContentTypes) == 0 {
+		return true
+	}
+
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, allowed := range options.ContentTypes {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
 
-	wrappedWriter := &wrappedResponseWriter{ResponseWriter: w, Writer: br}
+		if strings.HasSuffix(allowed, "/*") {
+			if strings.HasPrefix(mediaType, strings.TrimSuffix(allowed, "*")) {
+				return true
+			}
+		} else if mediaType == allowed {
+			return true
+		}
+	}
+
+	return false
+}
 
+// writeEncoded writes the encoded body and its headers to the underlying http.ResponseWriter. Errors and panics
+// raised by the underlying http.ResponseWriter are returned as errors.
+func writeEncoded(w http.ResponseWriter, wrappedWriter *wrappedResponseWriter, encoding HttpEncoding, body []byte) (err error) {
 	defer func() {
-		_ = br.Close()
-		w.Header().Set(HttpHeaderContentLength, fmt.Sprint(len(b.Bytes())))
-		_, _ = w.Write(b.Bytes())
+		if panicVal := recover(); panicVal != nil {
+			err = fmt.Errorf("panic writing response: %v", panicVal)
+		}
 	}()
 
-	next.ServeHTTP(wrappedWriter, r)
+	if encoding != HttpEncodingIdentity {
+		w.Header().Set(HttpHeaderContentEncoding, string(encoding))
+	}
+	w.Header().Set(HttpHeaderContentLength, fmt.Sprint(len(body)))
+	wrappedWriter.CloseHeaderSection()
+
+	n, err := w.Write(body)
+
+	if err != nil {
+		return err
+	}
+
+	if n != len(body) {
+		return io.ErrShortWrite
+	}
+
+	return nil
+}
+
+func (options *CompressionOptions) reportError(r *http.Request, encoding HttpEncoding, err error) {
+	if options.OnError == nil {
+		return
+	}
+
+	options.OnError(r, &CompressionError{
+		Encoding: encoding,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Err:      err,
+	})
 }
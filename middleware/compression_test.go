@@ -1,8 +1,17 @@
 package middleware
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/brotli"
 	"github.com/stretchr/testify/require"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"testing"
 )
 
@@ -357,3 +366,322 @@ func Test_getSupportedAcceptEncoding(t *testing.T) {
 		req.Equal(HttpEncodingDeflate, encoding)
 	})
 }
+
+// failingResponseWriter is a http.ResponseWriter whose Write fails with err or panics if panicVal is set
+type failingResponseWriter struct {
+	header   http.Header
+	status   int
+	err      error
+	panicVal interface{}
+}
+
+func (f *failingResponseWriter) Header() http.Header {
+	if f.header == nil {
+		f.header = http.Header{}
+	}
+	return f.header
+}
+
+func (f *failingResponseWriter) Write(_ []byte) (int, error) {
+	if f.panicVal != nil {
+		panic(f.panicVal)
+	}
+	return 0, f.err
+}
+
+func (f *failingResponseWriter) WriteHeader(status int) {
+	f.status = status
+}
+
+func newGzipRequest() *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/test", nil)
+	r.Header.Set(HttpHeaderAcceptEncoding, string(HttpEncodingGzip))
+	return r
+}
+
+var helloHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write([]byte("hello"))
+})
+
+func Test_NewCompressionHandlerWithOptions(t *testing.T) {
+	t.Run("compresses the response and preserves the status", func(t *testing.T) {
+		req := require.New(t)
+		recorder := httptest.NewRecorder()
+
+		NewCompressionHandler(helloHandler).ServeHTTP(recorder, newGzipRequest())
+
+		req.Equal(http.StatusCreated, recorder.Code)
+		req.Equal(string(HttpEncodingGzip), recorder.Header().Get(HttpHeaderContentEncoding))
+
+		reader, err := gzip.NewReader(recorder.Body)
+		req.NoError(err)
+		body, err := io.ReadAll(reader)
+		req.NoError(err)
+		req.Equal("hello", string(body))
+	})
+
+	t.Run("a failing underlying writer is reported without panicking", func(t *testing.T) {
+		req := require.New(t)
+		writeErr := errors.New("broken pipe")
+
+		var reported *CompressionError
+		handler := NewCompressionHandlerWithOptions(helloHandler, &CompressionOptions{
+			OnError: func(request *http.Request, err *CompressionError) {
+				reported = err
+			},
+		})
+
+		req.NotPanics(func() {
+			handler.ServeHTTP(&failingResponseWriter{err: writeErr}, newGzipRequest())
+		})
+
+		req.NotNil(reported)
+		req.ErrorIs(reported, writeErr)
+		req.Equal(HttpEncodingGzip, reported.Encoding)
+		req.Equal("/test", reported.Path)
+	})
+
+	t.Run("a panicking underlying writer is reported without panicking", func(t *testing.T) {
+		req := require.New(t)
+
+		var reported *CompressionError
+		handler := NewCompressionHandlerWithOptions(helloHandler, &CompressionOptions{
+			OnError: func(request *http.Request, err *CompressionError) {
+				reported = err
+			},
+		})
+
+		req.NotPanics(func() {
+			handler.ServeHTTP(&failingResponseWriter{panicVal: "connection reset"}, newGzipRequest())
+		})
+
+		req.NotNil(reported)
+		req.Contains(reported.Error(), "connection reset")
+	})
+
+	t.Run("a panicking handler does not write a partial response", func(t *testing.T) {
+		req := require.New(t)
+		recorder := httptest.NewRecorder()
+
+		handler := NewCompressionHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte("partial"))
+			panic("handler failure")
+		}))
+
+		req.Panics(func() {
+			handler.ServeHTTP(recorder, newGzipRequest())
+		})
+
+		req.False(recorder.Flushed)
+		req.Empty(recorder.Body.Bytes())
+		req.Empty(recorder.Header().Get(HttpHeaderContentEncoding))
+	})
+}
+
+func Test_NewCompressionHandler_bypass(t *testing.T) {
+	t.Run("upgrade requests are hijacked unmodified", func(t *testing.T) {
+		req := require.New(t)
+
+		server := httptest.NewServer(NewCompressionHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer func() { _ = conn.Close() }()
+
+			_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n\r\nhello")
+			_ = buf.Flush()
+		})))
+		defer server.Close()
+
+		conn, err := net.Dial("tcp", server.Listener.Addr().String())
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+
+		_, err = conn.Write([]byte("GET /ws HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\nAccept-Encoding: gzip\r\n\r\n"))
+		req.NoError(err)
+
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		req.NoError(err)
+		req.Equal(http.StatusSwitchingProtocols, resp.StatusCode)
+		req.Empty(resp.Header.Get(HttpHeaderContentEncoding))
+
+		body := make([]byte, 5)
+		_, err = io.ReadFull(reader, body)
+		req.NoError(err)
+		req.Equal("hello", string(body))
+	})
+
+	t.Run("switching protocols responses pass through", func(t *testing.T) {
+		req := require.New(t)
+		recorder := httptest.NewRecorder()
+
+		NewCompressionHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusSwitchingProtocols)
+		})).ServeHTTP(recorder, newGzipRequest())
+
+		req.Equal(http.StatusSwitchingProtocols, recorder.Code)
+		req.Empty(recorder.Header().Get(HttpHeaderContentEncoding))
+	})
+
+	t.Run("event streams pass through unmodified and flush", func(t *testing.T) {
+		req := require.New(t)
+		recorder := httptest.NewRecorder()
+
+		NewCompressionHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(HttpHeaderContentType, ContentTypeEventStream)
+			_, _ = w.Write([]byte("data: 1\n\n"))
+			w.(http.Flusher).Flush()
+		})).ServeHTTP(recorder, newGzipRequest())
+
+		req.Equal(http.StatusOK, recorder.Code)
+		req.Empty(recorder.Header().Get(HttpHeaderContentEncoding))
+		req.True(recorder.Flushed)
+		req.Equal("data: 1\n\n", recorder.Body.String())
+	})
+
+	t.Run("requests accepting event streams are not compressed", func(t *testing.T) {
+		req := require.New(t)
+		recorder := httptest.NewRecorder()
+
+		request := newGzipRequest()
+		request.Header.Set(HttpHeaderAccept, ContentTypeEventStream)
+
+		NewCompressionHandler(helloHandler).ServeHTTP(recorder, request)
+
+		req.Empty(recorder.Header().Get(HttpHeaderContentEncoding))
+		req.Equal("hello", recorder.Body.String())
+	})
+}
+
+func Test_NewCompressionHandlerWithOptions_config(t *testing.T) {
+	jsonHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(HttpHeaderContentType, "application/json; charset=utf-8")
+		_, _ = w.Write([]byte(`{"hello":"world"}`))
+	})
+
+	t.Run("responses smaller than the minimum size are not compressed", func(t *testing.T) {
+		req := require.New(t)
+		recorder := httptest.NewRecorder()
+
+		NewCompressionHandlerWithOptions(helloHandler, &CompressionOptions{MinSize: 6}).ServeHTTP(recorder, newGzipRequest())
+
+		req.Equal(http.StatusCreated, recorder.Code)
+		req.Empty(recorder.Header().Get(HttpHeaderContentEncoding))
+		req.Equal("5", recorder.Header().Get(HttpHeaderContentLength))
+		req.Equal("hello", recorder.Body.String())
+	})
+
+	t.Run("responses at the minimum size are compressed", func(t *testing.T) {
+		req := require.New(t)
+		recorder := httptest.NewRecorder()
+
+		NewCompressionHandlerWithOptions(helloHandler, &CompressionOptions{MinSize: 5}).ServeHTTP(recorder, newGzipRequest())
+
+		req.Equal(string(HttpEncodingGzip), recorder.Header().Get(HttpHeaderContentEncoding))
+	})
+
+	t.Run("responses with a listed content type are compressed", func(t *testing.T) {
+		req := require.New(t)
+		recorder := httptest.NewRecorder()
+
+		options := &CompressionOptions{ContentTypes: []string{"text/html", "application/json"}}
+		NewCompressionHandlerWithOptions(jsonHandler, options).ServeHTTP(recorder, newGzipRequest())
+
+		req.Equal(string(HttpEncodingGzip), recorder.Header().Get(HttpHeaderContentEncoding))
+	})
+
+	t.Run("responses with an unlisted content type are not compressed", func(t *testing.T) {
+		req := require.New(t)
+		recorder := httptest.NewRecorder()
+
+		options := &CompressionOptions{ContentTypes: []string{"text/*"}}
+		NewCompressionHandlerWithOptions(jsonHandler, options).ServeHTTP(recorder, newGzipRequest())
+
+		req.Empty(recorder.Header().Get(HttpHeaderContentEncoding))
+		req.Equal(`{"hello":"world"}`, recorder.Body.String())
+	})
+
+	t.Run("wildcard content types match sniffed content types", func(t *testing.T) {
+		req := require.New(t)
+		recorder := httptest.NewRecorder()
+
+		options := &CompressionOptions{ContentTypes: []string{"text/*"}}
+		NewCompressionHandlerWithOptions(helloHandler, options).ServeHTTP(recorder, newGzipRequest())
+
+		req.Equal(string(HttpEncodingGzip), recorder.Header().Get(HttpHeaderContentEncoding))
+		req.Equal("text/plain; charset=utf-8", recorder.Header().Get(HttpHeaderContentType))
+	})
+
+	t.Run("compression levels are applied to each encoding", func(t *testing.T) {
+		body := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog "), 100)
+		bodyHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write(body)
+		})
+
+		for _, encoding := range []HttpEncoding{HttpEncodingGzip, HttpEncodingBr, HttpEncodingDeflate} {
+			req := require.New(t)
+
+			sizeAt := func(level int) int {
+				recorder := httptest.NewRecorder()
+				request := httptest.NewRequest(http.MethodGet, "/test", nil)
+				request.Header.Set(HttpHeaderAcceptEncoding, string(encoding))
+
+				NewCompressionHandlerWithOptions(bodyHandler, &CompressionOptions{Level: level}).ServeHTTP(recorder, request)
+
+				req.Equal(string(encoding), recorder.Header().Get(HttpHeaderContentEncoding))
+				return recorder.Body.Len()
+			}
+
+			req.Less(sizeAt(MaxCompressionLevel), len(body), string(encoding))
+			req.LessOrEqual(sizeAt(MaxCompressionLevel), sizeAt(MinCompressionLevel), string(encoding))
+		}
+	})
+}
+
+func Test_getSupportedAcceptEncoding_preference(t *testing.T) {
+	cases := []struct {
+		acceptEncoding string
+		expected       HttpEncoding
+	}{
+		{"br, gzip", HttpEncodingBr},
+		{"gzip, br", HttpEncodingBr},
+		{"deflate, gzip", HttpEncodingGzip},
+		{"deflate, gzip, br", HttpEncodingBr},
+		{"br;q=0.5, gzip", HttpEncodingGzip},
+		{"gzip;q=0.8, br;q=0.9", HttpEncodingBr},
+		{"br;q=0.8, gzip;q=0.8, deflate;q=0.9", HttpEncodingDeflate},
+		{"br;q=2, gzip", HttpEncodingGzip},
+		{"compress, identity", HttpEncodingIdentity},
+	}
+
+	for _, c := range cases {
+		t.Run(c.acceptEncoding, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/test", nil)
+			r.Header.Set(HttpHeaderAcceptEncoding, c.acceptEncoding)
+
+			require.New(t).Equal(c.expected, getSupportedAcceptEncoding(r))
+		})
+	}
+}
+
+func Test_NewCompressionHandler_brotli(t *testing.T) {
+	req := require.New(t)
+	recorder := httptest.NewRecorder()
+
+	request := httptest.NewRequest(http.MethodGet, "/test", nil)
+	request.Header.Set(HttpHeaderAcceptEncoding, "gzip, br")
+
+	NewCompressionHandler(helloHandler).ServeHTTP(recorder, request)
+
+	req.Equal(http.StatusCreated, recorder.Code)
+	req.Equal(string(HttpEncodingBr), recorder.Header().Get(HttpHeaderContentEncoding))
+	req.Equal(fmt.Sprint(recorder.Body.Len()), recorder.Header().Get(HttpHeaderContentLength))
+
+	body, err := io.ReadAll(brotli.NewReader(recorder.Body))
+	req.NoError(err)
+	req.Equal("hello", string(body))
+}
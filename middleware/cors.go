@@ -0,0 +1,188 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HttpHeaderOrigin                        = "Origin"
+	HttpHeaderVary                          = "Vary"
+	HttpHeaderAccessControlRequestMethod    = "Access-Control-Request-Method"
+	HttpHeaderAccessControlRequestHeaders   = "Access-Control-Request-Headers"
+	HttpHeaderAccessControlAllowOrigin      = "Access-Control-Allow-Origin"
+	HttpHeaderAccessControlAllowMethods     = "Access-Control-Allow-Methods"
+	HttpHeaderAccessControlAllowHeaders     = "Access-Control-Allow-Headers"
+	HttpHeaderAccessControlAllowCredentials = "Access-Control-Allow-Credentials"
+	HttpHeaderAccessControlMaxAge           = "Access-Control-Max-Age"
+
+	// CorsWildcard allows any origin when used as an allowed origin and any request header when used as an allowed
+	// header.
+	CorsWildcard = "*"
+)
+
+// DefaultCorsMethods are the methods allowed by preflight responses if CorsOptions.AllowedMethods is empty.
+var DefaultCorsMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+// CorsOptions alters the behavior of the handler returned by NewCorsHandler.
+type CorsOptions struct {
+	// AllowedOrigins are the origins allowed to make cross-origin requests. An origin may be CorsWildcard to allow
+	// all origins or contain a single "*" to match any value in its place (e.g. "https://*.example.com").
+	AllowedOrigins []string
+
+	// AllowedMethods are the methods allowed by preflight responses, DefaultCorsMethods if empty.
+	AllowedMethods []string
+
+	// AllowedHeaders are the request headers allowed by preflight responses. If it contains CorsWildcard, the headers
+	// requested by the client are allowed.
+	AllowedHeaders []string
+
+	// AllowCredentials allows requests with credentials. The request's origin is echoed back instead of
+	// CorsWildcard as required by browsers for credentialed requests.
+	AllowCredentials bool
+
+	// MaxAge is how long preflight responses may be cached by clients, not sent if zero.
+	MaxAge time.Duration
+}
+
+// NewCorsHandler wraps next with a handler that adds CORS headers to responses of requests from allowed origins and
+// answers CORS preflight requests without calling next. Preflight requests from origins that are not allowed, or for
+// methods that are not allowed, are answered without CORS headers, which browsers treat as a denial. Requests without
+// an Origin header are passed to next unaltered.
+func NewCorsHandler(next http.Handler, options *CorsOptions) http.Handler {
+	methods := options.AllowedMethods
+	if len(methods) == 0 {
+		methods = DefaultCorsMethods
+	}
+
+	allowedMethods := strings.Join(methods, ", ")
+	allowedHeaders := strings.Join(options.AllowedHeaders, ", ")
+	allowAnyHeader := containsString(options.AllowedHeaders, CorsWildcard)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get(HttpHeaderOrigin)
+		preflight := r.Method == http.MethodOptions && r.Header.Get(HttpHeaderAccessControlRequestMethod) != ""
+
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add(HttpHeaderVary, HttpHeaderOrigin)
+
+		allowed, wildcard := options.isAllowedOrigin(origin)
+
+		if preflight {
+			w.Header().Add(HttpHeaderVary, HttpHeaderAccessControlRequestMethod)
+			w.Header().Add(HttpHeaderVary, HttpHeaderAccessControlRequestHeaders)
+
+			if allowed && containsMethod(methods, r.Header.Get(HttpHeaderAccessControlRequestMethod)) {
+				options.setAllowOrigin(w, origin, wildcard)
+				w.Header().Set(HttpHeaderAccessControlAllowMethods, allowedMethods)
+
+				if allowAnyHeader {
+					if requestedHeaders := r.Header.Get(HttpHeaderAccessControlRequestHeaders); requestedHeaders != "" {
+						w.Header().Set(HttpHeaderAccessControlAllowHeaders, requestedHeaders)
+					}
+				} else if allowedHeaders != "" {
+					w.Header().Set(HttpHeaderAccessControlAllowHeaders, allowedHeaders)
+				}
+
+				if options.MaxAge > 0 {
+					w.Header().Set(HttpHeaderAccessControlMaxAge, strconv.Itoa(int(options.MaxAge.Seconds())))
+				}
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if allowed {
+			options.setAllowOrigin(w, origin, wildcard)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isAllowedOrigin returns whether the origin is allowed and whether it was allowed by CorsWildcard.
+func (options *CorsOptions) isAllowedOrigin(origin string) (bool, bool) {
+	allowed := false
+
+	for _, allowedOrigin := range options.AllowedOrigins {
+		if allowedOrigin == CorsWildcard {
+			return true, true
+		}
+
+		if matchOrigin(allowedOrigin, origin) {
+			allowed = true
+		}
+	}
+
+	return allowed, false
+}
+
+func (options *CorsOptions) setAllowOrigin(w http.ResponseWriter, origin string, wildcard bool) {
+	if wildcard && !options.AllowCredentials {
+		w.Header().Set(HttpHeaderAccessControlAllowOrigin, CorsWildcard)
+	} else {
+		w.Header().Set(HttpHeaderAccessControlAllowOrigin, origin)
+	}
+
+	if options.AllowCredentials {
+		w.Header().Set(HttpHeaderAccessControlAllowCredentials, "true")
+	}
+}
+
+// matchOrigin matches an origin against an allowed origin that is either exact or contains a single "*" that matches
+// any value in its place. Origins are matched case-insensitively.
+func matchOrigin(allowedOrigin, origin string) bool {
+	allowedOrigin = strings.ToLower(allowedOrigin)
+	origin = strings.ToLower(origin)
+
+	prefix, suffix, hasWildcard := strings.Cut(allowedOrigin, CorsWildcard)
+
+	if !hasWildcard {
+		return allowedOrigin == origin
+	}
+
+	return len(origin) >= len(prefix)+len(suffix) && strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix)
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, allowedMethod := range methods {
+		if strings.EqualFold(allowedMethod, method) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, cur := range values {
+		if cur == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_NewCorsHandler(t *testing.T) {
+	serve := func(options *CorsOptions, request *http.Request) (*httptest.ResponseRecorder, bool) {
+		called := false
+		handler := NewCorsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			called = true
+		}), options)
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		return recorder, called
+	}
+
+	newRequest := func(method, origin string) *http.Request {
+		request := httptest.NewRequest(method, "/", nil)
+		if origin != "" {
+			request.Header.Set(HttpHeaderOrigin, origin)
+		}
+		return request
+	}
+
+	newPreflight := func(origin, method string) *http.Request {
+		request := newRequest(http.MethodOptions, origin)
+		request.Header.Set(HttpHeaderAccessControlRequestMethod, method)
+		request.Header.Set(HttpHeaderAccessControlRequestHeaders, "X-Custom")
+		return request
+	}
+
+	t.Run("requests without an origin are passed through", func(t *testing.T) {
+		req := require.New(t)
+		recorder, called := serve(&CorsOptions{AllowedOrigins: []string{CorsWildcard}}, newRequest(http.MethodGet, ""))
+		req.True(called)
+		req.Empty(recorder.Header().Get(HttpHeaderAccessControlAllowOrigin))
+	})
+
+	t.Run("exact origins are allowed", func(t *testing.T) {
+		req := require.New(t)
+		recorder, called := serve(&CorsOptions{AllowedOrigins: []string{"https://app.example.com"}}, newRequest(http.MethodGet, "https://app.example.com"))
+		req.True(called)
+		req.Equal("https://app.example.com", recorder.Header().Get(HttpHeaderAccessControlAllowOrigin))
+	})
+
+	t.Run("other origins are not allowed", func(t *testing.T) {
+		req := require.New(t)
+		recorder, called := serve(&CorsOptions{AllowedOrigins: []string{"https://app.example.com"}}, newRequest(http.MethodGet, "https://evil.example.com"))
+		req.True(called)
+		req.Empty(recorder.Header().Get(HttpHeaderAccessControlAllowOrigin))
+	})
+
+	t.Run("wildcard origins are allowed with a wildcard", func(t *testing.T) {
+		req := require.New(t)
+		recorder, _ := serve(&CorsOptions{AllowedOrigins: []string{CorsWildcard}}, newRequest(http.MethodGet, "https://app.example.com"))
+		req.Equal(CorsWildcard, recorder.Header().Get(HttpHeaderAccessControlAllowOrigin))
+	})
+
+	t.Run("subdomain wildcard origins match only the pattern", func(t *testing.T) {
+		req := require.New(t)
+		options := &CorsOptions{AllowedOrigins: []string{"https://*.example.com"}}
+
+		recorder, _ := serve(options, newRequest(http.MethodGet, "https://app.example.com"))
+		req.Equal("https://app.example.com", recorder.Header().Get(HttpHeaderAccessControlAllowOrigin))
+
+		recorder, _ = serve(options, newRequest(http.MethodGet, "https://example.org"))
+		req.Empty(recorder.Header().Get(HttpHeaderAccessControlAllowOrigin))
+	})
+
+	t.Run("credentials echo the origin", func(t *testing.T) {
+		req := require.New(t)
+		recorder, _ := serve(&CorsOptions{AllowedOrigins: []string{CorsWildcard}, AllowCredentials: true}, newRequest(http.MethodGet, "https://app.example.com"))
+		req.Equal("https://app.example.com", recorder.Header().Get(HttpHeaderAccessControlAllowOrigin))
+		req.Equal("true", recorder.Header().Get(HttpHeaderAccessControlAllowCredentials))
+	})
+
+	t.Run("preflight requests are answered without calling the next handler", func(t *testing.T) {
+		req := require.New(t)
+		options := &CorsOptions{
+			AllowedOrigins: []string{"https://app.example.com"},
+			AllowedMethods: []string{http.MethodGet, http.MethodPut},
+			AllowedHeaders: []string{"X-Custom", "Authorization"},
+			MaxAge:         10 * time.Minute,
+		}
+
+		recorder, called := serve(options, newPreflight("https://app.example.com", http.MethodPut))
+		req.False(called)
+		req.Equal(http.StatusNoContent, recorder.Code)
+		req.Equal("https://app.example.com", recorder.Header().Get(HttpHeaderAccessControlAllowOrigin))
+		req.Equal("GET, PUT", recorder.Header().Get(HttpHeaderAccessControlAllowMethods))
+		req.Equal("X-Custom, Authorization", recorder.Header().Get(HttpHeaderAccessControlAllowHeaders))
+		req.Equal("600", recorder.Header().Get(HttpHeaderAccessControlMaxAge))
+	})
+
+	t.Run("preflight requests for methods that are not allowed are denied", func(t *testing.T) {
+		req := require.New(t)
+		recorder, called := serve(&CorsOptions{AllowedOrigins: []string{CorsWildcard}}, newPreflight("https://app.example.com", "PROPFIND"))
+		req.False(called)
+		req.Equal(http.StatusNoContent, recorder.Code)
+		req.Empty(recorder.Header().Get(HttpHeaderAccessControlAllowOrigin))
+	})
+
+	t.Run("wildcard headers echo the requested headers", func(t *testing.T) {
+		req := require.New(t)
+		recorder, _ := serve(&CorsOptions{AllowedOrigins: []string{CorsWildcard}, AllowedHeaders: []string{CorsWildcard}}, newPreflight("https://app.example.com", http.MethodGet))
+		req.Equal("X-Custom", recorder.Header().Get(HttpHeaderAccessControlAllowHeaders))
+	})
+}
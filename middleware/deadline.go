@@ -0,0 +1,67 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RequestTimeoutFunc returns the time a request may take, used as a deadline on its context. A value of zero or less
+// leaves the request without a deadline.
+type RequestTimeoutFunc func(r *http.Request) time.Duration
+
+// NewDeadlineHandler wraps next with a handler that applies the timeout returned by timeout as a deadline to the
+// context of each request. Handlers and the calls they make with the request's context (e.g. database queries, RPCs)
+// are then canceled once the response could no longer be written instead of doing work whose result would be
+// dropped.
+func NewDeadlineHandler(next http.Handler, timeout RequestTimeoutFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestTimeout := timeout(r); requestTimeout > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), requestTimeout)
+			defer cancel()
+
+			r = r.WithContext(ctx)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// IsStreamingRequest returns true for requests that are expected to be long-lived, i.e. protocol upgrades such as
+// websockets and server-sent event streams.
+func IsStreamingRequest(r *http.Request) bool {
+	if r.Header.Get("Upgrade") != "" {
+		for _, value := range r.Header.Values("Connection") {
+			for _, token := range strings.Split(value, ",") {
+				if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+					return true
+				}
+			}
+		}
+	}
+
+	for _, value := range r.Header.Values("Accept") {
+		if strings.Contains(strings.ToLower(value), "text/event-stream") {
+			return true
+		}
+	}
+
+	return false
+}
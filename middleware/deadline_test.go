@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_NewDeadlineHandler(t *testing.T) {
+	serve := func(timeout time.Duration) (time.Time, bool) {
+		var deadline time.Time
+		var ok bool
+
+		handler := NewDeadlineHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			deadline, ok = r.Context().Deadline()
+		}), func(*http.Request) time.Duration {
+			return timeout
+		})
+
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+		return deadline, ok
+	}
+
+	t.Run("a positive timeout sets a deadline", func(t *testing.T) {
+		req := require.New(t)
+		deadline, ok := serve(time.Minute)
+		req.True(ok)
+		req.WithinDuration(time.Now().Add(time.Minute), deadline, 5*time.Second)
+	})
+
+	t.Run("a zero timeout does not set a deadline", func(t *testing.T) {
+		_, ok := serve(0)
+		require.New(t).False(ok)
+	})
+}
+
+func Test_IsStreamingRequest(t *testing.T) {
+	newRequest := func(headers map[string]string) *http.Request {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		return request
+	}
+
+	t.Run("websocket upgrades are streaming", func(t *testing.T) {
+		require.New(t).True(IsStreamingRequest(newRequest(map[string]string{"Connection": "keep-alive, Upgrade", "Upgrade": "websocket"})))
+	})
+
+	t.Run("event streams are streaming", func(t *testing.T) {
+		require.New(t).True(IsStreamingRequest(newRequest(map[string]string{"Accept": "text/event-stream"})))
+	})
+
+	t.Run("other requests are not streaming", func(t *testing.T) {
+		require.New(t).False(IsStreamingRequest(newRequest(map[string]string{"Accept": "application/json"})))
+	})
+}
@@ -0,0 +1,174 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package middleware
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	HttpHeaderRetryAfter = "Retry-After"
+
+	rateLimitSweepInterval = time.Minute
+)
+
+// RateLimitKeyFunc returns the key that requests are rate limited by. Requests with the same key share a token bucket.
+type RateLimitKeyFunc func(r *http.Request) string
+
+// RateLimitKeyClientIP is a RateLimitKeyFunc that limits requests by the client's IP address.
+func RateLimitKeyClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// RateLimitKeyClientCertSubject is a RateLimitKeyFunc that limits requests by the subject of the client's verified
+// certificate or, if the client's certificate was not verified, by the client's IP address. Unverified certificates
+// are not used as clients could otherwise choose a new subject for every request.
+func RateLimitKeyClientCertSubject(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		return "subject:" + r.TLS.VerifiedChains[0][0].Subject.String()
+	}
+
+	return "ip:" + RateLimitKeyClientIP(r)
+}
+
+// RateLimitOptions alters the behavior of the handler returned by NewRateLimitHandler.
+type RateLimitOptions struct {
+	// Rate is the number of requests per second allowed for each key on average.
+	Rate float64
+
+	// Burst is the number of requests allowed for each key in a burst. If less than one, one is used.
+	Burst int
+
+	// Key returns the key requests are limited by, RateLimitKeyClientCertSubject if nil.
+	Key RateLimitKeyFunc
+
+	// Skip, if set, exempts requests for which it returns true from rate limiting.
+	Skip func(r *http.Request) bool
+}
+
+// NewRateLimitHandler wraps next with a handler that limits the rate of requests per key using token buckets.
+// Requests over the limit are answered with http.StatusTooManyRequests (429) and a Retry-After header without calling
+// next.
+func NewRateLimitHandler(next http.Handler, options *RateLimitOptions) http.Handler {
+	limiter := newRateLimiter(options)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if options.Skip != nil && options.Skip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if allowed, retryAfter := limiter.allow(limiter.key(r), time.Now()); !allowed {
+			w.Header().Set(HttpHeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	key   RateLimitKeyFunc
+
+	lock      sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(options *RateLimitOptions) *rateLimiter {
+	limiter := &rateLimiter{
+		rate:    options.Rate,
+		burst:   float64(options.Burst),
+		key:     options.Key,
+		buckets: map[string]*tokenBucket{},
+	}
+
+	if limiter.burst < 1 {
+		limiter.burst = 1
+	}
+
+	if limiter.key == nil {
+		limiter.key = RateLimitKeyClientCertSubject
+	}
+
+	return limiter
+}
+
+// allow takes a token from the bucket for key if one is available. If not, the time until one is available is
+// returned.
+func (limiter *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	limiter.sweep(now)
+
+	bucket, ok := limiter.buckets[key]
+
+	if !ok {
+		bucket = &tokenBucket{tokens: limiter.burst, last: now}
+		limiter.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(limiter.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limiter.rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	if limiter.rate <= 0 {
+		return false, rateLimitSweepInterval
+	}
+
+	return false, time.Duration((1 - bucket.tokens) / limiter.rate * float64(time.Second))
+}
+
+// sweep removes buckets that have refilled completely, as they are equivalent to new buckets. Must be called with
+// the lock held.
+func (limiter *rateLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < rateLimitSweepInterval {
+		return
+	}
+
+	limiter.lastSweep = now
+
+	for key, bucket := range limiter.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*limiter.rate >= limiter.burst {
+			delete(limiter.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func Test_NewRateLimitHandler(t *testing.T) {
+	newRequest := func(remoteAddr, path string) *http.Request {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.RemoteAddr = remoteAddr
+		return request
+	}
+
+	serve := func(handler http.Handler, request *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	t.Run("requests over the burst are rejected with retry after", func(t *testing.T) {
+		req := require.New(t)
+		handler := NewRateLimitHandler(ok, &RateLimitOptions{Rate: 0.5, Burst: 2})
+
+		req.Equal(http.StatusOK, serve(handler, newRequest("192.0.2.1:1000", "/")).Code)
+		req.Equal(http.StatusOK, serve(handler, newRequest("192.0.2.1:1001", "/")).Code)
+
+		recorder := serve(handler, newRequest("192.0.2.1:1002", "/"))
+		req.Equal(http.StatusTooManyRequests, recorder.Code)
+		req.Equal("2", recorder.Header().Get(HttpHeaderRetryAfter))
+	})
+
+	t.Run("clients are limited separately", func(t *testing.T) {
+		req := require.New(t)
+		handler := NewRateLimitHandler(ok, &RateLimitOptions{Rate: 1, Burst: 1})
+
+		req.Equal(http.StatusOK, serve(handler, newRequest("192.0.2.1:1000", "/")).Code)
+		req.Equal(http.StatusTooManyRequests, serve(handler, newRequest("192.0.2.1:1000", "/")).Code)
+		req.Equal(http.StatusOK, serve(handler, newRequest("192.0.2.2:1000", "/")).Code)
+	})
+
+	t.Run("skipped requests are not limited", func(t *testing.T) {
+		req := require.New(t)
+		handler := NewRateLimitHandler(ok, &RateLimitOptions{Rate: 1, Burst: 1, Skip: func(r *http.Request) bool {
+			return r.URL.Path == "/healthz"
+		}})
+
+		req.Equal(http.StatusOK, serve(handler, newRequest("192.0.2.1:1000", "/")).Code)
+		req.Equal(http.StatusOK, serve(handler, newRequest("192.0.2.1:1000", "/healthz")).Code)
+		req.Equal(http.StatusTooManyRequests, serve(handler, newRequest("192.0.2.1:1000", "/")).Code)
+	})
+}
+
+func Test_rateLimiter_allow(t *testing.T) {
+	req := require.New(t)
+	limiter := newRateLimiter(&RateLimitOptions{Rate: 2, Burst: 1})
+	now := time.Now()
+
+	allowed, _ := limiter.allow("key", now)
+	req.True(allowed)
+
+	allowed, retryAfter := limiter.allow("key", now)
+	req.False(allowed)
+	req.Equal(500*time.Millisecond, retryAfter)
+
+	allowed, _ = limiter.allow("key", now.Add(500*time.Millisecond))
+	req.True(allowed)
+
+	limiter.allow("other", now)
+	limiter.sweep(now.Add(time.Hour))
+	req.Empty(limiter.buckets)
+}
+
+func Test_RateLimitKeyClientCertSubject(t *testing.T) {
+	req := require.New(t)
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.RemoteAddr = "192.0.2.1:1000"
+	req.Equal("ip:192.0.2.1", RateLimitKeyClientCertSubject(request))
+
+	unverified := &x509.Certificate{Subject: pkix.Name{CommonName: "unverified"}}
+	request.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{unverified},
+	}
+	req.Equal("ip:192.0.2.1", RateLimitKeyClientCertSubject(request))
+
+	verified := &x509.Certificate{Subject: pkix.Name{CommonName: "client"}}
+	request.TLS = &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{unverified},
+		VerifiedChains:   [][]*x509.Certificate{{verified}},
+	}
+	req.Equal("subject:CN=client", RateLimitKeyClientCertSubject(request))
+}
@@ -0,0 +1,118 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+)
+
+type requestIdContextKey struct{}
+
+const (
+	// RequestIdHeader is the default header request ids are read from and echoed in
+	RequestIdHeader = "X-Request-ID"
+
+	// MaxRequestIdLength is the maximum length of incoming request ids, longer ids are replaced
+	MaxRequestIdLength = 128
+
+	// AccessLogFieldRequestId is the AccessLogEntry field that holds the request id of the request, if any.
+	AccessLogFieldRequestId = "requestId"
+)
+
+// RequestIdOptions alters the behavior of the handler returned by NewRequestIdHandler.
+type RequestIdOptions struct {
+	// Header is the header request ids are read from and echoed in. If empty, RequestIdHeader is used.
+	Header string
+
+	// Generate, if set, returns the ids of requests without a valid request id. If nil, NewRequestId is used.
+	Generate func() string
+}
+
+// NewRequestIdHandler wraps next with a handler that tags each request with a request id, so that log entries may be
+// correlated across services. The id is read from the request id header or, if it is absent or not a valid id,
+// generated. It is stored on the request context, see RequestIdFromContext, set on the request header for handlers
+// that proxy requests, and echoed in the response header.
+func NewRequestIdHandler(next http.Handler, options *RequestIdOptions) http.Handler {
+	header := RequestIdHeader
+	generate := NewRequestId
+
+	if options != nil {
+		if options.Header != "" {
+			header = options.Header
+		}
+
+		if options.Generate != nil {
+			generate = options.Generate
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get(header)
+
+		if !isValidRequestId(requestId) {
+			requestId = generate()
+			r.Header.Set(header, requestId)
+		}
+
+		w.Header().Set(header, requestId)
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIdContextKey{}, requestId)))
+	})
+}
+
+// RequestIdFromContext returns the request id of the request the context belongs to, or an empty string if the
+// request was not handled by a handler returned by NewRequestIdHandler.
+func RequestIdFromContext(ctx context.Context) string {
+	if requestId, ok := ctx.Value(requestIdContextKey{}).(string); ok {
+		return requestId
+	}
+
+	return ""
+}
+
+// NewRequestId returns a random (version 4) UUID.
+func NewRequestId() string {
+	var uuid [16]byte
+
+	if _, err := rand.Read(uuid[:]); err != nil {
+		panic(fmt.Errorf("could not read random bytes for request id: %w", err))
+	}
+
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
+
+// isValidRequestId returns true if requestId is not empty, at most MaxRequestIdLength long, and consists of printable
+// ASCII characters only, so that ids supplied by clients cannot be used to inject content into logs or headers.
+func isValidRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > MaxRequestIdLength {
+		return false
+	}
+
+	for i := 0; i < len(requestId); i++ {
+		if requestId[i] < 0x21 || requestId[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
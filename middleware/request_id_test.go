@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func Test_NewRequestIdHandler(t *testing.T) {
+	serve := func(options *RequestIdOptions, header, requestId string) (string, string, string) {
+		var fromContext, fromRequest string
+
+		handler := NewRequestIdHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fromContext = RequestIdFromContext(r.Context())
+			fromRequest = r.Header.Get(header)
+		}), options)
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		if requestId != "" {
+			request.Header.Set(header, requestId)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		return fromContext, fromRequest, recorder.Header().Get(header)
+	}
+
+	t.Run("incoming request ids are propagated", func(t *testing.T) {
+		req := require.New(t)
+
+		fromContext, fromRequest, fromResponse := serve(nil, RequestIdHeader, "abc-123")
+		req.Equal("abc-123", fromContext)
+		req.Equal("abc-123", fromRequest)
+		req.Equal("abc-123", fromResponse)
+	})
+
+	t.Run("missing request ids are generated", func(t *testing.T) {
+		req := require.New(t)
+
+		fromContext, fromRequest, fromResponse := serve(nil, RequestIdHeader, "")
+		req.Regexp(regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), fromContext)
+		req.Equal(fromContext, fromRequest)
+		req.Equal(fromContext, fromResponse)
+	})
+
+	t.Run("invalid request ids are replaced", func(t *testing.T) {
+		for _, requestId := range []string{"with space", "\x01", strings.Repeat("a", MaxRequestIdLength+1)} {
+			fromContext, _, _ := serve(&RequestIdOptions{Generate: func() string { return "generated" }}, RequestIdHeader, requestId)
+			require.New(t).Equal("generated", fromContext)
+		}
+	})
+
+	t.Run("the header is configurable", func(t *testing.T) {
+		req := require.New(t)
+
+		fromContext, _, fromResponse := serve(&RequestIdOptions{Header: "X-Correlation-ID"}, "X-Correlation-ID", "abc-123")
+		req.Equal("abc-123", fromContext)
+		req.Equal("abc-123", fromResponse)
+	})
+
+	t.Run("request ids are access logged", func(t *testing.T) {
+		req := require.New(t)
+
+		var entry *AccessLogEntry
+		handler := NewRequestIdHandler(NewAccessLogHandler(http.NotFoundHandler(), &AccessLogOptions{
+			Sink: func(e *AccessLogEntry) { entry = e },
+		}), nil)
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set(RequestIdHeader, "abc-123")
+		handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		req.NotNil(entry)
+		req.Equal("abc-123", entry.Fields()[AccessLogFieldRequestId])
+	})
+}
+
+func Test_NewRequestId(t *testing.T) {
+	req := require.New(t)
+
+	first, second := NewRequestId(), NewRequestId()
+	req.Len(first, 36)
+	req.NotEqual(first, second)
+}
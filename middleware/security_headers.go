@@ -0,0 +1,113 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	HttpHeaderStrictTransportSecurity = "Strict-Transport-Security"
+	HttpHeaderContentTypeOptions      = "X-Content-Type-Options"
+	HttpHeaderFrameOptions            = "X-Frame-Options"
+	HttpHeaderContentSecurityPolicy   = "Content-Security-Policy"
+	HttpHeaderReferrerPolicy          = "Referrer-Policy"
+
+	FrameOptionsDeny       = "DENY"
+	FrameOptionsSameOrigin = "SAMEORIGIN"
+)
+
+// SecurityHeadersOptions alters the behavior of the handler returned by NewSecurityHeadersHandler. Headers with zero
+// values are not set.
+type SecurityHeadersOptions struct {
+	// HstsMaxAge is the max-age of the Strict-Transport-Security header, which is only sent on responses to requests
+	// received over TLS.
+	HstsMaxAge time.Duration
+
+	// HstsIncludeSubDomains adds the includeSubDomains directive to the Strict-Transport-Security header
+	HstsIncludeSubDomains bool
+
+	// HstsPreload adds the preload directive to the Strict-Transport-Security header
+	HstsPreload bool
+
+	// ContentTypeNosniff sets "X-Content-Type-Options: nosniff"
+	ContentTypeNosniff bool
+
+	// FrameOptions is the X-Frame-Options value, FrameOptionsDeny or FrameOptionsSameOrigin
+	FrameOptions string
+
+	// ContentSecurityPolicy is the Content-Security-Policy value
+	ContentSecurityPolicy string
+
+	// ReferrerPolicy is the Referrer-Policy value
+	ReferrerPolicy string
+}
+
+// StrictTransportSecurity returns the Strict-Transport-Security header value of the options, or an empty string if
+// HstsMaxAge is not positive.
+func (options *SecurityHeadersOptions) StrictTransportSecurity() string {
+	if options.HstsMaxAge <= 0 {
+		return ""
+	}
+
+	value := "max-age=" + strconv.FormatInt(int64(options.HstsMaxAge/time.Second), 10)
+
+	if options.HstsIncludeSubDomains {
+		value += "; includeSubDomains"
+	}
+
+	if options.HstsPreload {
+		value += "; preload"
+	}
+
+	return value
+}
+
+// NewSecurityHeadersHandler wraps next with a handler that sets security related headers on all responses before
+// calling next, which may override or remove them for individual responses.
+func NewSecurityHeadersHandler(next http.Handler, options *SecurityHeadersOptions) http.Handler {
+	hsts := options.StrictTransportSecurity()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+
+		//browsers ignore HSTS received over plain HTTP, and it must not be sent per RFC 6797
+		if hsts != "" && r.TLS != nil {
+			header.Set(HttpHeaderStrictTransportSecurity, hsts)
+		}
+
+		if options.ContentTypeNosniff {
+			header.Set(HttpHeaderContentTypeOptions, "nosniff")
+		}
+
+		if options.FrameOptions != "" {
+			header.Set(HttpHeaderFrameOptions, options.FrameOptions)
+		}
+
+		if options.ContentSecurityPolicy != "" {
+			header.Set(HttpHeaderContentSecurityPolicy, options.ContentSecurityPolicy)
+		}
+
+		if options.ReferrerPolicy != "" {
+			header.Set(HttpHeaderReferrerPolicy, options.ReferrerPolicy)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
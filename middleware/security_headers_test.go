@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNewSecurityHeadersHandler(t *testing.T) {
+	options := &SecurityHeadersOptions{
+		HstsMaxAge:            time.Hour,
+		HstsIncludeSubDomains: true,
+		HstsPreload:           true,
+		ContentTypeNosniff:    true,
+		FrameOptions:          FrameOptionsSameOrigin,
+		ContentSecurityPolicy: "default-src 'self'",
+		ReferrerPolicy:        "no-referrer",
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("all headers are set over TLS", func(t *testing.T) {
+		req := require.New(t)
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.TLS = &tls.ConnectionState{}
+		recorder := httptest.NewRecorder()
+
+		NewSecurityHeadersHandler(ok, options).ServeHTTP(recorder, request)
+
+		req.Equal("max-age=3600; includeSubDomains; preload", recorder.Header().Get(HttpHeaderStrictTransportSecurity))
+		req.Equal("nosniff", recorder.Header().Get(HttpHeaderContentTypeOptions))
+		req.Equal(FrameOptionsSameOrigin, recorder.Header().Get(HttpHeaderFrameOptions))
+		req.Equal("default-src 'self'", recorder.Header().Get(HttpHeaderContentSecurityPolicy))
+		req.Equal("no-referrer", recorder.Header().Get(HttpHeaderReferrerPolicy))
+	})
+
+	t.Run("hsts is not set without TLS", func(t *testing.T) {
+		req := require.New(t)
+
+		recorder := httptest.NewRecorder()
+		NewSecurityHeadersHandler(ok, options).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		req.Empty(recorder.Header().Values(HttpHeaderStrictTransportSecurity))
+		req.Equal("nosniff", recorder.Header().Get(HttpHeaderContentTypeOptions))
+	})
+
+	t.Run("zero values are not set", func(t *testing.T) {
+		req := require.New(t)
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.TLS = &tls.ConnectionState{}
+		recorder := httptest.NewRecorder()
+
+		NewSecurityHeadersHandler(ok, &SecurityHeadersOptions{}).ServeHTTP(recorder, request)
+
+		for _, header := range []string{HttpHeaderStrictTransportSecurity, HttpHeaderContentTypeOptions, HttpHeaderFrameOptions, HttpHeaderContentSecurityPolicy, HttpHeaderReferrerPolicy} {
+			req.Empty(recorder.Header().Values(header), header)
+		}
+	})
+
+	t.Run("handlers can override headers", func(t *testing.T) {
+		req := require.New(t)
+
+		override := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(HttpHeaderFrameOptions, FrameOptionsDeny)
+			w.Header().Del(HttpHeaderContentSecurityPolicy)
+		})
+
+		recorder := httptest.NewRecorder()
+		NewSecurityHeadersHandler(override, options).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		req.Equal(FrameOptionsDeny, recorder.Header().Get(HttpHeaderFrameOptions))
+		req.Empty(recorder.Header().Values(HttpHeaderContentSecurityPolicy))
+	})
+}
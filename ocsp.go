@@ -0,0 +1,225 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/identity"
+	"golang.org/x/crypto/ocsp"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	ocspRequestTimeout   = time.Second * 10
+	ocspRetryInterval    = time.Minute * 5
+	ocspDefaultInterval  = time.Hour
+	ocspMinimumInterval  = time.Minute
+	ocspMaxResponseBytes = 1024 * 1024
+)
+
+// ocspStapler fetches, caches, and refreshes OCSP responses for the server certificates of a set of
+// identity.Identity's. Cached responses are stapled to certificates returned from the tls.Config.GetCertificate
+// functions wrapped by staple. Certificates without a cached response are served without a staple.
+type ocspStapler struct {
+	name       string
+	identities []identity.Identity
+	client     *http.Client
+
+	lock    sync.RWMutex
+	staples map[[sha256.Size]byte][]byte
+
+	startOnce   sync.Once
+	stopOnce    sync.Once
+	closeNotify chan struct{}
+}
+
+func newOcspStapler(name string, identities []identity.Identity) *ocspStapler {
+	return &ocspStapler{
+		name:        name,
+		identities:  identities,
+		client:      &http.Client{Timeout: ocspRequestTimeout},
+		staples:     map[[sha256.Size]byte][]byte{},
+		closeNotify: make(chan struct{}),
+	}
+}
+
+// Start begins fetching and refreshing OCSP responses on a background goroutine. Subsequent calls have no effect.
+func (stapler *ocspStapler) Start() {
+	stapler.startOnce.Do(func() {
+		go stapler.run()
+	})
+}
+
+// Stop ends the background refresh goroutine. Subsequent calls have no effect.
+func (stapler *ocspStapler) Stop() {
+	stapler.stopOnce.Do(func() {
+		close(stapler.closeNotify)
+	})
+}
+
+func (stapler *ocspStapler) run() {
+	for {
+		interval := stapler.refresh()
+
+		select {
+		case <-time.After(interval):
+		case <-stapler.closeNotify:
+			return
+		}
+	}
+}
+
+// refresh fetches OCSP responses for all current server certificates and returns the interval to wait before the
+// next refresh. Certificates that are no longer in use are dropped from the cache.
+func (stapler *ocspStapler) refresh() time.Duration {
+	log := pfxlog.Logger().WithField("server", stapler.name)
+
+	next := ocspDefaultInterval
+	staples := map[[sha256.Size]byte][]byte{}
+
+	for _, id := range stapler.identities {
+		for _, cert := range id.ServerCert() {
+			if len(cert.Certificate) == 0 {
+				continue
+			}
+
+			key := sha256.Sum256(cert.Certificate[0])
+
+			raw, refreshIn, err := stapler.fetch(cert)
+
+			if err != nil {
+				log.Warnf("could not obtain OCSP response, serving without a staple: %v", err)
+				refreshIn = ocspRetryInterval
+
+				//keep the previous response until it is replaced
+				stapler.lock.RLock()
+				if previous, ok := stapler.staples[key]; ok {
+					staples[key] = previous
+				}
+				stapler.lock.RUnlock()
+			} else {
+				staples[key] = raw
+			}
+
+			if refreshIn < next {
+				next = refreshIn
+			}
+		}
+	}
+
+	stapler.lock.Lock()
+	stapler.staples = staples
+	stapler.lock.Unlock()
+
+	if next < ocspMinimumInterval {
+		next = ocspMinimumInterval
+	}
+
+	return next
+}
+
+// fetch requests an OCSP response for the leaf of the supplied certificate from the first OCSP server it lists. The
+// raw response and the duration after which it should be refreshed are returned.
+func (stapler *ocspStapler) fetch(cert *tls.Certificate) ([]byte, time.Duration, error) {
+	if len(cert.Certificate) < 2 {
+		return nil, 0, errors.New("certificate chain does not include an issuer")
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not parse leaf certificate: %v", err)
+	}
+
+	if len(leaf.OCSPServer) == 0 {
+		return nil, 0, fmt.Errorf("certificate [%s] does not specify an OCSP server", leaf.Subject.CommonName)
+	}
+
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not parse issuer certificate: %v", err)
+	}
+
+	request, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not create OCSP request: %v", err)
+	}
+
+	httpResponse, err := stapler.client.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not contact OCSP server [%s]: %v", leaf.OCSPServer[0], err)
+	}
+	defer func() { _ = httpResponse.Body.Close() }()
+
+	if httpResponse.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("OCSP server [%s] returned status %d", leaf.OCSPServer[0], httpResponse.StatusCode)
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(httpResponse.Body, ocspMaxResponseBytes))
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not read OCSP response: %v", err)
+	}
+
+	response, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not parse OCSP response: %v", err)
+	}
+
+	if response.Status != ocsp.Good {
+		return nil, 0, fmt.Errorf("OCSP status for certificate [%s] is not good: %d", leaf.Subject.CommonName, response.Status)
+	}
+
+	if response.NextUpdate.IsZero() {
+		return raw, ocspDefaultInterval, nil
+	}
+
+	//refresh halfway through the validity window
+	return raw, time.Until(response.ThisUpdate.Add(response.NextUpdate.Sub(response.ThisUpdate) / 2)), nil
+}
+
+// staple wraps a tls.Config.GetCertificate function to attach any cached OCSP response to the returned certificate.
+func (stapler *ocspStapler) staple(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := getCertificate(hello)
+
+		if err != nil || cert == nil || len(cert.Certificate) == 0 {
+			return cert, err
+		}
+
+		stapler.lock.RLock()
+		raw, ok := stapler.staples[sha256.Sum256(cert.Certificate[0])]
+		stapler.lock.RUnlock()
+
+		if !ok {
+			return cert, nil
+		}
+
+		//copy, certificates are shared with the identity and other connections
+		stapledCert := *cert
+		stapledCert.OCSPStaple = raw
+
+		return &stapledCert, nil
+	}
+}
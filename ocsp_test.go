@@ -0,0 +1,166 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/openziti/identity"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newOcspTestIdentity creates a CA, an OCSP responder signed by that CA, and an identity with a leaf certificate
+// issued by the CA that points to the responder. If fail is true the responder returns errors.
+func newOcspTestIdentity(t *testing.T, fail bool) identity.Identity {
+	req := require.New(t)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDer, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	req.NoError(err)
+
+	ca, err := x509.ParseCertificate(caDer)
+	req.NoError(err)
+
+	responder := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if fail {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		body, _ := io.ReadAll(request.Body)
+		ocspRequest, err := ocsp.ParseRequest(body)
+		if err != nil {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		response, err := ocsp.CreateResponse(ca, ca, ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: ocspRequest.SerialNumber,
+			ThisUpdate:   time.Now().Add(-time.Minute),
+			NextUpdate:   time.Now().Add(2 * time.Hour),
+		}, caKey)
+		if err != nil {
+			writer.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		_, _ = writer.Write(response)
+	}))
+	t.Cleanup(responder.Close)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "leaf.example.com"},
+		DNSNames:     []string{"leaf.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:   []string{responder.URL},
+	}
+
+	leafDer, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, &leafKey.PublicKey, caKey)
+	req.NoError(err)
+
+	leafKeyDer, err := x509.MarshalECPrivateKey(leafKey)
+	req.NoError(err)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	chain := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDer}), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer})...)
+	req.NoError(os.WriteFile(certFile, chain, 0600))
+	req.NoError(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: leafKeyDer}), 0600))
+
+	id, err := identity.LoadIdentity(identity.Config{
+		Cert:       certFile,
+		Key:        keyFile,
+		ServerCert: certFile,
+	})
+	req.NoError(err)
+
+	return id
+}
+
+func Test_ocspStapler(t *testing.T) {
+	t.Run("a good response is stapled", func(t *testing.T) {
+		req := require.New(t)
+
+		id := newOcspTestIdentity(t, false)
+		stapler := newOcspStapler("test", []identity.Identity{id})
+
+		interval := stapler.refresh()
+		req.Greater(interval, 50*time.Minute)
+		req.LessOrEqual(interval, time.Hour)
+
+		cert, err := stapler.staple(id.ServerTLSConfig().GetCertificate)(&tls.ClientHelloInfo{})
+		req.NoError(err)
+		req.NotEmpty(cert.OCSPStaple)
+
+		response, err := ocsp.ParseResponse(cert.OCSPStaple, nil)
+		req.NoError(err)
+		req.Equal(ocsp.Good, response.Status)
+
+		//the identity's certificate must not be altered
+		req.Empty(id.ServerCert()[0].OCSPStaple)
+	})
+
+	t.Run("a failed fetch serves without a staple and retries", func(t *testing.T) {
+		req := require.New(t)
+
+		id := newOcspTestIdentity(t, true)
+		stapler := newOcspStapler("test", []identity.Identity{id})
+
+		interval := stapler.refresh()
+		req.Equal(ocspRetryInterval, interval)
+
+		cert, err := stapler.staple(id.ServerTLSConfig().GetCertificate)(&tls.ClientHelloInfo{})
+		req.NoError(err)
+		req.Empty(cert.OCSPStaple)
+	})
+}
@@ -0,0 +1,65 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// panicRecoveryResponseWriter tracks whether a response has been started on a http.ResponseWriter so that a response
+// is only written after a panic if the handler had not begun one.
+type panicRecoveryResponseWriter struct {
+	http.ResponseWriter
+	started bool
+}
+
+func (w *panicRecoveryResponseWriter) WriteHeader(status int) {
+	// informational (1xx) responses are followed by the final status
+	if status >= http.StatusOK {
+		w.started = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *panicRecoveryResponseWriter) Write(b []byte) (int, error) {
+	w.started = true
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *panicRecoveryResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		w.started = true
+		flusher.Flush()
+	}
+}
+
+func (w *panicRecoveryResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hijacker, ok := w.ResponseWriter.(http.Hijacker); ok {
+		w.started = true
+		return hijacker.Hijack()
+	}
+
+	return nil, nil, errors.New("underlying http.ResponseWriter does not support hijacking")
+}
+
+// Unwrap returns the underlying http.ResponseWriter for use by http.ResponseController.
+func (w *panicRecoveryResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,104 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_wrapPanicRecovery(t *testing.T) {
+	server := &Server{}
+
+	serve := func(handlerFunc http.HandlerFunc) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		server.wrapPanicRecovery(handlerFunc).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		return recorder
+	}
+
+	t.Run("a panic before the response is started results in a 500", func(t *testing.T) {
+		req := require.New(t)
+
+		recorder := serve(func(http.ResponseWriter, *http.Request) {
+			panic("boom")
+		})
+
+		req.Equal(http.StatusInternalServerError, recorder.Code)
+		req.Contains(recorder.Body.String(), http.StatusText(http.StatusInternalServerError))
+		req.NotContains(recorder.Body.String(), "boom")
+	})
+
+	t.Run("a panic after the response is started does not change it", func(t *testing.T) {
+		req := require.New(t)
+
+		recorder := serve(func(writer http.ResponseWriter, _ *http.Request) {
+			writer.WriteHeader(http.StatusAccepted)
+			_, _ = writer.Write([]byte("partial"))
+			panic("boom")
+		})
+
+		req.Equal(http.StatusAccepted, recorder.Code)
+		req.Equal("partial", recorder.Body.String())
+	})
+
+	t.Run("OnHandlerPanic replaces the default response", func(t *testing.T) {
+		req := require.New(t)
+
+		var recovered interface{}
+		server.OnHandlerPanic = func(writer http.ResponseWriter, _ *http.Request, panicVal interface{}) {
+			recovered = panicVal
+			writer.WriteHeader(http.StatusTeapot)
+		}
+		defer func() { server.OnHandlerPanic = nil }()
+
+		recorder := serve(func(http.ResponseWriter, *http.Request) {
+			panic("boom")
+		})
+
+		req.Equal(http.StatusTeapot, recorder.Code)
+		req.Equal("boom", recovered)
+	})
+
+	t.Run("OnHandlerPanicWithStack receives the stack and takes precedence", func(t *testing.T) {
+		req := require.New(t)
+
+		var recovered interface{}
+		var recoveredStack string
+		server.OnHandlerPanic = func(http.ResponseWriter, *http.Request, interface{}) {
+			req.Fail("OnHandlerPanic should not be called")
+		}
+		server.OnHandlerPanicWithStack = func(writer http.ResponseWriter, _ *http.Request, panicVal interface{}, stack string) {
+			recovered = panicVal
+			recoveredStack = stack
+			writer.WriteHeader(http.StatusTeapot)
+		}
+		defer func() {
+			server.OnHandlerPanic = nil
+			server.OnHandlerPanicWithStack = nil
+		}()
+
+		recorder := serve(func(http.ResponseWriter, *http.Request) {
+			panic("boom")
+		})
+
+		req.Equal(http.StatusTeapot, recorder.Code)
+		req.Equal("boom", recovered)
+		req.Contains(recoveredStack, "TestServer_wrapPanicRecovery")
+	})
+}
@@ -0,0 +1,360 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/michaelquigley/pfxlog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ProxyBinding is the binding of the ProxyHandlerFactory
+	ProxyBinding = "proxy"
+
+	// DefaultProxyDialTimeout is the time allowed to connect to an upstream if the dialTimeout option is not set
+	DefaultProxyDialTimeout = 10 * time.Second
+)
+
+// ProxyOptions are the options of a ProxyHandler, parsed from the options of its ApiConfig.
+type ProxyOptions struct {
+	// RootPath is the path prefix of the requests that are proxied, "/" if not set
+	RootPath string
+
+	// Upstreams are the http or https base URLs requests are proxied to. Requests are distributed across multiple
+	// upstreams round-robin. The path of a request is appended to the path of the upstream URL.
+	Upstreams []*url.URL
+
+	// StripRootPath, if true, removes the RootPath from the path of requests before they are proxied
+	StripRootPath bool
+
+	// PreserveHost, if true, sends the Host header of the client to upstreams instead of the host of the upstream URL
+	PreserveHost bool
+
+	// SetHeaders are set on requests before they are proxied, replacing any values sent by the client
+	SetHeaders map[string]string
+
+	// RemoveHeaders are removed from requests before they are proxied
+	RemoveHeaders []string
+
+	// DialTimeout is the time allowed to connect to an upstream, DefaultProxyDialTimeout if not set
+	DialTimeout time.Duration
+
+	// ResponseHeaderTimeout, if set, is the time allowed for an upstream to send its response headers
+	ResponseHeaderTimeout time.Duration
+}
+
+// Parse the options map of a ProxyHandler's ApiConfig.
+func (options *ProxyOptions) Parse(config map[interface{}]interface{}) error {
+	if interfaceVal, ok := config["rootPath"]; ok {
+		if rootPath, ok := interfaceVal.(string); ok {
+			options.RootPath = rootPath
+		} else {
+			return errors.New("could not use value for rootPath, not a string")
+		}
+	}
+
+	upstreams, err := parseStringList(config, "upstreams")
+	if err != nil {
+		return err
+	}
+
+	for i, upstream := range upstreams {
+		upstreamUrl, err := url.Parse(upstream)
+		if err != nil {
+			return fmt.Errorf("could not parse upstream [%s] at index [%d]: %v", upstream, i, err)
+		}
+		options.Upstreams = append(options.Upstreams, upstreamUrl)
+	}
+
+	for _, option := range []struct {
+		key    string
+		target *bool
+	}{
+		{"stripRootPath", &options.StripRootPath},
+		{"preserveHost", &options.PreserveHost},
+	} {
+		if interfaceVal, ok := config[option.key]; ok {
+			if value, ok := interfaceVal.(bool); ok {
+				*option.target = value
+			} else {
+				return fmt.Errorf("could not use value for %s, not a boolean", option.key)
+			}
+		}
+	}
+
+	if interfaceVal, ok := config["setHeaders"]; ok {
+		headers, ok := interfaceVal.(map[interface{}]interface{})
+		if !ok {
+			return errors.New("could not use value for setHeaders, not a map")
+		}
+
+		options.SetHeaders = map[string]string{}
+
+		for nameVal, valueVal := range headers {
+			name, ok := nameVal.(string)
+			if !ok {
+				return fmt.Errorf("could not use header name [%v] of setHeaders, not a string", nameVal)
+			}
+
+			value, ok := valueVal.(string)
+			if !ok {
+				return fmt.Errorf("could not use value for setHeaders header [%s], not a string", name)
+			}
+
+			options.SetHeaders[name] = value
+		}
+	}
+
+	if options.RemoveHeaders, err = parseStringList(config, "removeHeaders"); err != nil {
+		return err
+	}
+
+	for _, option := range []struct {
+		key    string
+		target *time.Duration
+	}{
+		{"dialTimeout", &options.DialTimeout},
+		{"responseHeaderTimeout", &options.ResponseHeaderTimeout},
+	} {
+		if interfaceVal, ok := config[option.key]; ok {
+			if durationStr, ok := interfaceVal.(string); ok {
+				if duration, err := time.ParseDuration(durationStr); err == nil {
+					*option.target = duration
+				} else {
+					return fmt.Errorf("could not parse %s %s as a duration (e.g. 1m): %v", option.key, durationStr, err)
+				}
+			} else {
+				return fmt.Errorf("could not use value for %s, not a string", option.key)
+			}
+		}
+	}
+
+	if options.RootPath == "" {
+		options.RootPath = "/"
+	}
+
+	if options.DialTimeout == 0 {
+		options.DialTimeout = DefaultProxyDialTimeout
+	}
+
+	return nil
+}
+
+// Validate this configuration object.
+func (options *ProxyOptions) Validate() error {
+	if !strings.HasPrefix(options.RootPath, "/") {
+		return fmt.Errorf("invalid rootPath [%s], must start with /", options.RootPath)
+	}
+
+	if len(options.Upstreams) == 0 {
+		return errors.New("upstreams must specify at least one upstream")
+	}
+
+	for i, upstream := range options.Upstreams {
+		if upstream.Scheme != "http" && upstream.Scheme != "https" {
+			return fmt.Errorf("invalid upstream [%s] at index [%d], scheme must be http or https", upstream, i)
+		}
+
+		if upstream.Host == "" {
+			return fmt.Errorf("invalid upstream [%s] at index [%d], host must be specified", upstream, i)
+		}
+	}
+
+	if options.DialTimeout < 0 {
+		return fmt.Errorf("value [%s] for dialTimeout too low, must not be negative", options.DialTimeout)
+	}
+
+	if options.ResponseHeaderTimeout < 0 {
+		return fmt.Errorf("value [%s] for responseHeaderTimeout too low, must not be negative", options.ResponseHeaderTimeout)
+	}
+
+	return nil
+}
+
+// ProxyHandlerFactory is an ApiHandlerFactory for the ProxyBinding that creates ProxyHandler's, e.g. to front
+// internal services under path prefixes. It is not registered by default, add it to the Registry of an Instance to
+// enable the binding.
+type ProxyHandlerFactory struct{}
+
+var _ ApiConfigValidatingFactory = &ProxyHandlerFactory{}
+
+// NewProxyHandlerFactory creates a new ProxyHandlerFactory
+func NewProxyHandlerFactory() *ProxyHandlerFactory {
+	return &ProxyHandlerFactory{}
+}
+
+// Binding returns ProxyBinding
+func (factory *ProxyHandlerFactory) Binding() string {
+	return ProxyBinding
+}
+
+// New creates a ProxyHandler from the options of an ApiConfig
+func (factory *ProxyHandlerFactory) New(serverConfig *ServerConfig, options map[interface{}]interface{}) (ApiHandler, error) {
+	proxyOptions, err := factory.parseOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	handler := &ProxyHandler{
+		options:      options,
+		proxyOptions: proxyOptions,
+	}
+
+	serverName := ""
+	if serverConfig != nil {
+		serverName = serverConfig.Name
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: proxyOptions.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.ResponseHeaderTimeout = proxyOptions.ResponseHeaderTimeout
+
+	handler.proxy = &httputil.ReverseProxy{
+		Director:  handler.direct,
+		Transport: transport,
+		ErrorHandler: func(writer http.ResponseWriter, request *http.Request, err error) {
+			pfxlog.Logger().WithField("server", serverName).Warnf("could not proxy request for [%s] to [%s]: %v", request.URL.Path, request.URL.Host, err)
+			writer.WriteHeader(http.StatusBadGateway)
+		},
+	}
+
+	return handler, nil
+}
+
+// Validate has no factory level configuration to check
+func (factory *ProxyHandlerFactory) Validate(*InstanceConfig) error {
+	return nil
+}
+
+// ValidateApiConfig validates the options of an ApiConfig
+func (factory *ProxyHandlerFactory) ValidateApiConfig(_ *ServerConfig, apiConfig *ApiConfig) error {
+	_, err := factory.parseOptions(apiConfig.Options())
+	return err
+}
+
+func (factory *ProxyHandlerFactory) parseOptions(options map[interface{}]interface{}) (*ProxyOptions, error) {
+	proxyOptions := &ProxyOptions{}
+
+	if err := proxyOptions.Parse(options); err != nil {
+		return nil, err
+	}
+
+	if err := proxyOptions.Validate(); err != nil {
+		return nil, err
+	}
+
+	return proxyOptions, nil
+}
+
+// ProxyHandler is an ApiHandler that proxies requests below its RootPath to its upstreams via httputil.ReverseProxy.
+// The client address is appended to the X-Forwarded-For header and the original host and scheme are sent as the
+// X-Forwarded-Host and X-Forwarded-Proto headers. Upgrade requests (e.g. WebSockets) are passed through, which
+// requires the handlerTimeout of the API to be disabled as timed out handlers cannot hijack their connections.
+type ProxyHandler struct {
+	options      map[interface{}]interface{}
+	proxyOptions *ProxyOptions
+	proxy        *httputil.ReverseProxy
+	next         atomic.Uint64
+}
+
+// Binding returns ProxyBinding
+func (handler *ProxyHandler) Binding() string {
+	return ProxyBinding
+}
+
+// Options returns the options of the ApiConfig the ProxyHandler was created for
+func (handler *ProxyHandler) Options() map[interface{}]interface{} {
+	return handler.options
+}
+
+// RootPath returns the rootPath option, "/" if not set
+func (handler *ProxyHandler) RootPath() string {
+	return handler.proxyOptions.RootPath
+}
+
+// IsHandler returns true for requests of the RootPath and any path below it
+func (handler *ProxyHandler) IsHandler(request *http.Request) bool {
+	return matchesPathSegments(request.URL.Path, handler.proxyOptions.RootPath)
+}
+
+func (handler *ProxyHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	handler.proxy.ServeHTTP(writer, request)
+}
+
+// direct rewrites an outgoing request to target the next upstream.
+func (handler *ProxyHandler) direct(request *http.Request) {
+	options := handler.proxyOptions
+	upstream := options.Upstreams[(handler.next.Add(1)-1)%uint64(len(options.Upstreams))]
+
+	requestPath, requestRawPath := request.URL.Path, request.URL.RawPath
+
+	if options.StripRootPath {
+		rootPath := strings.TrimSuffix(options.RootPath, "/")
+		requestPath = "/" + strings.TrimPrefix(strings.TrimPrefix(requestPath, rootPath), "/")
+
+		if requestRawPath != "" {
+			requestRawPath = "/" + strings.TrimPrefix(strings.TrimPrefix(requestRawPath, rootPath), "/")
+		}
+	}
+
+	request.URL.Scheme = upstream.Scheme
+	request.URL.Host = upstream.Host
+	request.URL.Path = strings.TrimSuffix(upstream.Path, "/") + requestPath
+
+	if requestRawPath != "" {
+		request.URL.RawPath = strings.TrimSuffix(upstream.EscapedPath(), "/") + requestRawPath
+	}
+
+	if upstream.RawQuery == "" || request.URL.RawQuery == "" {
+		request.URL.RawQuery = upstream.RawQuery + request.URL.RawQuery
+	} else {
+		request.URL.RawQuery = upstream.RawQuery + "&" + request.URL.RawQuery
+	}
+
+	request.Header.Set("X-Forwarded-Host", request.Host)
+
+	if request.TLS != nil {
+		request.Header.Set("X-Forwarded-Proto", "https")
+	} else {
+		request.Header.Set("X-Forwarded-Proto", "http")
+	}
+
+	if !options.PreserveHost {
+		request.Host = ""
+	}
+
+	for _, name := range options.RemoveHeaders {
+		request.Header.Del(name)
+	}
+
+	for name, value := range options.SetHeaders {
+		request.Header.Set(name, value)
+	}
+
+	//prevent the default User-Agent of the http.Client from being sent if the client did not send one
+	if _, ok := request.Header["User-Agent"]; !ok {
+		request.Header.Set("User-Agent", "")
+	}
+}
@@ -0,0 +1,266 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// proxyProtocolHeaderTimeout limits the time allowed to receive a PROXY protocol header
+	proxyProtocolHeaderTimeout = 10 * time.Second
+
+	// proxyProtocolV1MaxLength is the maximum length of a v1 header, including the trailing CRLF
+	proxyProtocolV1MaxLength = 107
+
+	proxyProtocolV2HeaderLength = 16
+	proxyProtocolV2CmdLocal     = 0x0
+	proxyProtocolV2CmdProxy     = 0x1
+	proxyProtocolV2FamilyInet   = 0x1
+	proxyProtocolV2FamilyInet6  = 0x2
+)
+
+var (
+	proxyProtocolV1Prefix    = []byte("PROXY ")
+	proxyProtocolV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+)
+
+// proxyProtocolListener is a net.Listener that accepts connections that start with a PROXY protocol v1 (text) or v2
+// (binary) header, as sent by L4 load balancers to convey the address of the original client. The header is read
+// from each connection on its first use, rather than in Accept, so that slow clients do not delay other connections.
+// The remote address of each connection is the source address in its header. Connections without a valid header are
+// closed.
+type proxyProtocolListener struct {
+	net.Listener
+}
+
+func newProxyProtocolListener(listener net.Listener) net.Listener {
+	return &proxyProtocolListener{Listener: listener}
+}
+
+func (listener *proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := listener.Listener.Accept()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyProtocolConn{
+		Conn:   conn,
+		reader: bufio.NewReaderSize(conn, proxyProtocolV1MaxLength),
+	}, nil
+}
+
+// proxyProtocolConn is a net.Conn that reads a PROXY protocol header before any other data.
+type proxyProtocolConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	headerOnce sync.Once
+	headerErr  error
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+// readHeader reads the PROXY protocol header once. The connection is closed if the header is not valid.
+func (conn *proxyProtocolConn) readHeader() error {
+	conn.headerOnce.Do(func() {
+		_ = conn.Conn.SetReadDeadline(time.Now().Add(proxyProtocolHeaderTimeout))
+
+		conn.remoteAddr, conn.localAddr, conn.headerErr = readProxyProtocolHeader(conn.reader)
+
+		_ = conn.Conn.SetReadDeadline(time.Time{})
+
+		if conn.headerErr != nil {
+			conn.headerErr = fmt.Errorf("invalid PROXY protocol header from %s: %w", conn.Conn.RemoteAddr(), conn.headerErr)
+			_ = conn.Conn.Close()
+		}
+	})
+
+	return conn.headerErr
+}
+
+func (conn *proxyProtocolConn) Read(b []byte) (int, error) {
+	if err := conn.readHeader(); err != nil {
+		return 0, err
+	}
+
+	return conn.reader.Read(b)
+}
+
+// RemoteAddr returns the source address of the PROXY protocol header or the address of the peer if the header does
+// not convey one (e.g. health checks of the load balancer itself).
+func (conn *proxyProtocolConn) RemoteAddr() net.Addr {
+	if err := conn.readHeader(); err == nil && conn.remoteAddr != nil {
+		return conn.remoteAddr
+	}
+
+	return conn.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address of the PROXY protocol header or the local address of the connection if
+// the header does not convey one.
+func (conn *proxyProtocolConn) LocalAddr() net.Addr {
+	if err := conn.readHeader(); err == nil && conn.localAddr != nil {
+		return conn.localAddr
+	}
+
+	return conn.Conn.LocalAddr()
+}
+
+// readProxyProtocolHeader reads a v1 or v2 PROXY protocol header and returns the source and destination addresses it
+// conveys. Both are nil for headers that do not convey addresses (v1 UNKNOWN, v2 LOCAL or unsupported families).
+func readProxyProtocolHeader(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	if prefix, err := reader.Peek(len(proxyProtocolV1Prefix)); err == nil && bytes.Equal(prefix, proxyProtocolV1Prefix) {
+		return readProxyProtocolV1Header(reader)
+	}
+
+	if signature, err := reader.Peek(len(proxyProtocolV2Signature)); err == nil && bytes.Equal(signature, proxyProtocolV2Signature) {
+		return readProxyProtocolV2Header(reader)
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	return nil, nil, errors.New("connection does not start with a PROXY protocol header")
+}
+
+// readProxyProtocolV1Header reads a text header in the form "PROXY TCP4 <src ip> <dst ip> <src port> <dst port>\r\n"
+// or "PROXY UNKNOWN ...\r\n".
+func readProxyProtocolV1Header(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+
+	for len(line) < proxyProtocolV1MaxLength {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		line = append(line, b)
+
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			return parseProxyProtocolV1Header(strings.TrimSuffix(string(line), "\r\n"))
+		}
+	}
+
+	return nil, nil, fmt.Errorf("v1 header exceeds %d bytes", proxyProtocolV1MaxLength)
+}
+
+func parseProxyProtocolV1Header(line string) (net.Addr, net.Addr, error) {
+	fields := strings.Split(line, " ")
+
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+
+	if len(fields) != 6 {
+		return nil, nil, fmt.Errorf("v1 header [%s] does not have 6 fields", line)
+	}
+
+	if fields[1] != "TCP4" && fields[1] != "TCP6" {
+		return nil, nil, fmt.Errorf("v1 header [%s] has unsupported protocol [%s]", line, fields[1])
+	}
+
+	source, err := parseProxyProtocolV1Address(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, fmt.Errorf("v1 header [%s] has invalid source: %v", line, err)
+	}
+
+	destination, err := parseProxyProtocolV1Address(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, fmt.Errorf("v1 header [%s] has invalid destination: %v", line, err)
+	}
+
+	return source, destination, nil
+}
+
+func parseProxyProtocolV1Address(ipStr, portStr string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid ip [%s]", ipStr)
+	}
+
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port [%s]", portStr)
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// readProxyProtocolV2Header reads a binary header: the v2 signature, a version and command byte, an address family
+// and protocol byte, the length of the address block, and the address block. TLVs in the address block are skipped.
+func readProxyProtocolV2Header(reader *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, proxyProtocolV2HeaderLength)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, nil, err
+	}
+
+	if version := header[12] >> 4; version != 2 {
+		return nil, nil, fmt.Errorf("v2 header has unsupported version [%d]", version)
+	}
+
+	addresses := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, addresses); err != nil {
+		return nil, nil, err
+	}
+
+	switch command := header[12] & 0x0F; command {
+	case proxyProtocolV2CmdLocal:
+		return nil, nil, nil
+	case proxyProtocolV2CmdProxy:
+	default:
+		return nil, nil, fmt.Errorf("v2 header has unsupported command [%d]", command)
+	}
+
+	ipLength := 0
+
+	switch family := header[13] >> 4; family {
+	case proxyProtocolV2FamilyInet:
+		ipLength = net.IPv4len
+	case proxyProtocolV2FamilyInet6:
+		ipLength = net.IPv6len
+	default:
+		//unix sockets and unspecified families do not convey usable addresses
+		return nil, nil, nil
+	}
+
+	if len(addresses) < 2*ipLength+4 {
+		return nil, nil, fmt.Errorf("v2 header address block of %d bytes is too short", len(addresses))
+	}
+
+	source := &net.TCPAddr{
+		IP:   net.IP(addresses[:ipLength]),
+		Port: int(binary.BigEndian.Uint16(addresses[2*ipLength:])),
+	}
+
+	destination := &net.TCPAddr{
+		IP:   net.IP(addresses[ipLength : 2*ipLength]),
+		Port: int(binary.BigEndian.Uint16(addresses[2*ipLength+2:])),
+	}
+
+	return source, destination, nil
+}
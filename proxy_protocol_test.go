@@ -0,0 +1,197 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// proxyProtocolV2Header builds a v2 PROXY header for TCP over IPv4 or IPv6
+func proxyProtocolV2Header(command byte, source, destination *net.TCPAddr) []byte {
+	family, sourceIp, destinationIp := byte(proxyProtocolV2FamilyInet), source.IP.To4(), destination.IP.To4()
+	if sourceIp == nil {
+		family, sourceIp, destinationIp = proxyProtocolV2FamilyInet6, source.IP.To16(), destination.IP.To16()
+	}
+
+	addresses := append(append([]byte{}, sourceIp...), destinationIp...)
+	addresses = binary.BigEndian.AppendUint16(addresses, uint16(source.Port))
+	addresses = binary.BigEndian.AppendUint16(addresses, uint16(destination.Port))
+
+	header := append([]byte{}, proxyProtocolV2Signature...)
+	header = append(header, 0x20|command, family<<4|0x1)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addresses)))
+
+	return append(header, addresses...)
+}
+
+// acceptWithHeader sends header followed by "payload" to a proxyProtocolListener and returns the accepted connection
+func acceptWithHeader(t *testing.T, header []byte) net.Conn {
+	req := require.New(t)
+
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	req.NoError(err)
+	listener := newProxyProtocolListener(tcpListener)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	req.NoError(err)
+	t.Cleanup(func() { _ = client.Close() })
+
+	_, err = client.Write(append(header, []byte("payload")...))
+	req.NoError(err)
+
+	conn, err := listener.Accept()
+	req.NoError(err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return conn
+}
+
+func readPayload(t *testing.T, conn net.Conn) string {
+	payload := make([]byte, len("payload"))
+	_, err := io.ReadFull(conn, payload)
+	require.New(t).NoError(err)
+
+	return string(payload)
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	t.Run("v1 headers set the remote address", func(t *testing.T) {
+		req := require.New(t)
+
+		conn := acceptWithHeader(t, []byte("PROXY TCP4 192.0.2.10 198.51.100.1 50000 443\r\n"))
+
+		req.Equal("192.0.2.10:50000", conn.RemoteAddr().String())
+		req.Equal("198.51.100.1:443", conn.LocalAddr().String())
+		req.Equal("payload", readPayload(t, conn))
+	})
+
+	t.Run("v1 headers support IPv6", func(t *testing.T) {
+		conn := acceptWithHeader(t, []byte("PROXY TCP6 2001:db8::10 2001:db8::1 50000 443\r\n"))
+		require.New(t).Equal("[2001:db8::10]:50000", conn.RemoteAddr().String())
+	})
+
+	t.Run("v1 UNKNOWN headers keep the peer address", func(t *testing.T) {
+		req := require.New(t)
+
+		conn := acceptWithHeader(t, []byte("PROXY UNKNOWN\r\n"))
+
+		req.Contains(conn.RemoteAddr().String(), "127.0.0.1:")
+		req.Equal("payload", readPayload(t, conn))
+	})
+
+	t.Run("v2 headers set the remote address", func(t *testing.T) {
+		req := require.New(t)
+
+		conn := acceptWithHeader(t, proxyProtocolV2Header(proxyProtocolV2CmdProxy,
+			&net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 50000},
+			&net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}))
+
+		req.Equal("192.0.2.10:50000", conn.RemoteAddr().String())
+		req.Equal("198.51.100.1:443", conn.LocalAddr().String())
+		req.Equal("payload", readPayload(t, conn))
+	})
+
+	t.Run("v2 headers support IPv6", func(t *testing.T) {
+		conn := acceptWithHeader(t, proxyProtocolV2Header(proxyProtocolV2CmdProxy,
+			&net.TCPAddr{IP: net.ParseIP("2001:db8::10"), Port: 50000},
+			&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}))
+
+		require.New(t).Equal("[2001:db8::10]:50000", conn.RemoteAddr().String())
+	})
+
+	t.Run("v2 LOCAL headers keep the peer address", func(t *testing.T) {
+		req := require.New(t)
+
+		conn := acceptWithHeader(t, proxyProtocolV2Header(proxyProtocolV2CmdLocal,
+			&net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 50000},
+			&net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 443}))
+
+		req.Contains(conn.RemoteAddr().String(), "127.0.0.1:")
+		req.Equal("payload", readPayload(t, conn))
+	})
+
+	t.Run("connections without a header are rejected", func(t *testing.T) {
+		conn := acceptWithHeader(t, []byte("GET / HTTP/1.1\r\n"))
+
+		_, err := conn.Read(make([]byte, 1))
+		require.New(t).Error(err)
+	})
+
+	t.Run("malformed v1 headers are rejected", func(t *testing.T) {
+		conn := acceptWithHeader(t, []byte("PROXY TCP4 not-an-ip 198.51.100.1 50000 443\r\n"))
+
+		_, err := conn.Read(make([]byte, 1))
+		require.New(t).Error(err)
+	})
+}
+
+func TestServer_proxyProtocol(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	handler := &funcHandler{
+		handlerFunc: func(writer http.ResponseWriter, request *http.Request) {
+			_, _ = writer.Write([]byte(request.RemoteAddr))
+		},
+	}
+
+	serverConfig := newTestServerConfig(id, freeAddress(t))
+	serverConfig.BindPoints[0].ProxyProtocol = true
+
+	server := newTestServer(t, newTestInstance(t, id, handler), serverConfig)
+	go func() {
+		_ = server.Start()
+	}()
+	defer server.Shutdown(context.Background())
+
+	var conn net.Conn
+	req.Eventually(func() bool {
+		var err error
+		conn, err = net.Dial("tcp", server.httpServers[0].Addr)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+	defer func() { _ = conn.Close() }()
+
+	//the PROXY header precedes the TLS handshake
+	_, err := conn.Write([]byte("PROXY TCP4 192.0.2.10 198.51.100.1 50000 443\r\n"))
+	req.NoError(err)
+
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}})
+
+	request, err := http.NewRequest(http.MethodGet, "https://"+server.httpServers[0].Addr+"/", nil)
+	req.NoError(err)
+	req.NoError(request.Write(tlsConn))
+
+	resp, err := http.ReadResponse(bufio.NewReader(tlsConn), request)
+	req.NoError(err)
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	req.NoError(err)
+	req.Equal("192.0.2.10:50000", string(body))
+}
@@ -0,0 +1,199 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestProxyHandler(t *testing.T, options map[interface{}]interface{}) ApiHandler {
+	handler, err := NewProxyHandlerFactory().New(nil, options)
+	require.New(t).NoError(err)
+
+	return handler
+}
+
+func TestProxyOptions(t *testing.T) {
+	t.Run("defaults the root path and dial timeout", func(t *testing.T) {
+		req := require.New(t)
+
+		options := &ProxyOptions{}
+		req.NoError(options.Parse(map[interface{}]interface{}{"upstreams": []interface{}{"http://127.0.0.1:8080"}}))
+		req.Equal("/", options.RootPath)
+		req.Equal(DefaultProxyDialTimeout, options.DialTimeout)
+		req.NoError(options.Validate())
+	})
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		upstreams := []interface{}{"http://127.0.0.1:8080"}
+
+		for expected, options := range map[string]map[interface{}]interface{}{
+			"upstreams must specify at least one upstream":  {},
+			"scheme must be http or https":                  {"upstreams": []interface{}{"ftp://127.0.0.1"}},
+			"host must be specified":                        {"upstreams": []interface{}{"http:///path"}},
+			"must start with /":                             {"upstreams": upstreams, "rootPath": "api"},
+			"could not use value for stripRootPath":         {"upstreams": upstreams, "stripRootPath": "true"},
+			"could not use value for setHeaders, not a map": {"upstreams": upstreams, "setHeaders": "a"},
+			"could not parse dialTimeout":                   {"upstreams": upstreams, "dialTimeout": "soon"},
+			"responseHeaderTimeout too low":                 {"upstreams": upstreams, "responseHeaderTimeout": "-1s"},
+		} {
+			_, err := NewProxyHandlerFactory().New(nil, options)
+			require.New(t).ErrorContains(err, expected)
+		}
+	})
+}
+
+func TestProxyHandler(t *testing.T) {
+	newUpstream := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			_, _ = fmt.Fprintf(writer, "%s %s %s?%s %s %s %s", name, request.Host, request.URL.Path, request.URL.RawQuery,
+				request.Header.Get("X-Added"), request.Header.Get("X-Removed"), request.Header.Get("X-Forwarded-Proto"))
+		}))
+	}
+
+	first := newUpstream("first")
+	defer first.Close()
+	second := newUpstream("second")
+	defer second.Close()
+
+	serve := func(handler http.Handler, target string) string {
+		request := httptest.NewRequest(http.MethodGet, target, nil)
+		request.Header.Set("X-Removed", "removed")
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		return recorder.Body.String()
+	}
+
+	t.Run("proxies to upstreams round-robin", func(t *testing.T) {
+		req := require.New(t)
+
+		handler := newTestProxyHandler(t, map[interface{}]interface{}{
+			"rootPath":      "/api",
+			"upstreams":     []interface{}{first.URL + "/base?key=value", second.URL},
+			"setHeaders":    map[interface{}]interface{}{"X-Added": "added"},
+			"removeHeaders": []interface{}{"X-Removed"},
+		})
+
+		req.True(handler.IsHandler(httptest.NewRequest(http.MethodGet, "/api/users", nil)))
+		req.False(handler.IsHandler(httptest.NewRequest(http.MethodGet, "/apis", nil)))
+
+		firstHost := first.Listener.Addr().String()
+		secondHost := second.Listener.Addr().String()
+
+		req.Equal("first "+firstHost+" /base/api/users?key=value&a=b added  http", serve(handler, "/api/users?a=b"))
+		req.Equal("second "+secondHost+" /api/users? added  http", serve(handler, "/api/users"))
+		req.Equal("first "+firstHost+" /base/api?key=value added  http", serve(handler, "/api"))
+	})
+
+	t.Run("strips the root path and preserves the host", func(t *testing.T) {
+		req := require.New(t)
+
+		handler := newTestProxyHandler(t, map[interface{}]interface{}{
+			"rootPath":      "/api/",
+			"upstreams":     []interface{}{first.URL},
+			"stripRootPath": true,
+			"preserveHost":  true,
+		})
+
+		req.Equal("first example.com /users?  removed http", serve(handler, "http://example.com/api/users"))
+		req.Equal("first example.com /?  removed http", serve(handler, "http://example.com/api"))
+	})
+
+	t.Run("responds with bad gateway if the upstream is unreachable", func(t *testing.T) {
+		req := require.New(t)
+
+		unreachable := httptest.NewServer(http.NotFoundHandler())
+		unreachable.Close()
+
+		handler := newTestProxyHandler(t, map[interface{}]interface{}{"upstreams": []interface{}{unreachable.URL}})
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		req.Equal(http.StatusBadGateway, recorder.Code)
+	})
+}
+
+func TestProxyHandlerFactory_upgrade(t *testing.T) {
+	req := require.New(t)
+
+	//echoes lines after switching protocols
+	upstream := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if request.Header.Get("Upgrade") != "echo" {
+			writer.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		conn, buffer, err := writer.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		_, _ = buffer.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = buffer.Flush()
+
+		line, _ := buffer.ReadString('\n')
+		_, _ = buffer.WriteString(line)
+		_ = buffer.Flush()
+	}))
+	defer upstream.Close()
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	instance := newTestInstance(t, id, nil)
+	req.NoError(instance.GetRegistry().Add(NewProxyHandlerFactory()))
+
+	serverConfig := newTestServerConfig(id, freeAddress(t))
+	serverConfig.APIs = []*ApiConfig{
+		{binding: ProxyBinding, options: map[interface{}]interface{}{"rootPath": "/echo", "upstreams": []interface{}{upstream.URL}}},
+		{binding: "mockHandler"},
+	}
+	req.NoError(serverConfig.Validate(instance.GetRegistry()))
+
+	server := newTestServer(t, instance, serverConfig)
+	startTestServer(t, server)
+	defer server.Shutdown(context.Background())
+
+	conn, err := tls.Dial("tcp", server.httpServers[0].Addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}})
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	_, err = io.WriteString(conn, "GET /echo HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+	req.NoError(err)
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	req.NoError(err)
+	req.Equal(http.StatusSwitchingProtocols, resp.StatusCode)
+
+	_, err = io.WriteString(conn, "hello\n")
+	req.NoError(err)
+
+	line, err := reader.ReadString('\n')
+	req.NoError(err)
+	req.Equal("hello\n", line)
+}
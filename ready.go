@@ -0,0 +1,151 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"github.com/michaelquigley/pfxlog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SystemdNotifySocketEnv is the environment variable systemd uses to provide the socket for sd_notify messages
+	SystemdNotifySocketEnv = "NOTIFY_SOCKET"
+
+	readyPollInterval = 50 * time.Millisecond
+)
+
+// startReadyNotification signals readiness to external supervisors, as configured by InstanceOptions.ReadyFile and
+// InstanceOptions.SystemdNotify, once all servers are serving. Nothing is signaled if a server fails to start or the
+// instance is shut down first.
+func (i *InstanceImpl) startReadyNotification() {
+	options := &i.Config.Options
+
+	if options.ReadyFile == "" && !options.SystemdNotify {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(readyPollInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for _, server := range i.getServers() {
+				if err := server.StartError(); err != nil {
+					pfxlog.Logger().Warnf("server %s failed to start, readiness will not be signaled: %v", server.ServerConfig.Name, err)
+					return
+				}
+			}
+
+			if i.IsReady() {
+				i.signalReady()
+				return
+			}
+
+			if i.isShutdown() {
+				return
+			}
+		}
+	}()
+}
+
+func (i *InstanceImpl) signalReady() {
+	i.readyLock.Lock()
+	defer i.readyLock.Unlock()
+
+	if i.shutdown {
+		return
+	}
+
+	options := &i.Config.Options
+
+	if options.ReadyFile != "" {
+		if err := os.WriteFile(options.ReadyFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+			pfxlog.Logger().Errorf("could not write ready file [%s]: %v", options.ReadyFile, err)
+		} else {
+			i.readyFileWritten = true
+		}
+	}
+
+	if options.SystemdNotify {
+		if err := sdNotify("READY=1"); err != nil {
+			pfxlog.Logger().Errorf("could not notify systemd of readiness: %v", err)
+		}
+	}
+}
+
+// stopReadyNotification prevents readiness from being signaled, removes the ready file if it was written, and
+// notifies systemd that the instance is stopping.
+func (i *InstanceImpl) stopReadyNotification() {
+	i.readyLock.Lock()
+	defer i.readyLock.Unlock()
+
+	i.shutdown = true
+
+	options := &i.Config.Options
+
+	if i.readyFileWritten {
+		if err := os.Remove(options.ReadyFile); err != nil && !os.IsNotExist(err) {
+			pfxlog.Logger().Errorf("could not remove ready file [%s]: %v", options.ReadyFile, err)
+		}
+		i.readyFileWritten = false
+	}
+
+	if options.SystemdNotify {
+		if err := sdNotify("STOPPING=1"); err != nil {
+			pfxlog.Logger().Errorf("could not notify systemd of shutdown: %v", err)
+		}
+	}
+}
+
+func (i *InstanceImpl) isShutdown() bool {
+	i.readyLock.Lock()
+	defer i.readyLock.Unlock()
+
+	return i.shutdown
+}
+
+// sdNotify sends a state message (e.g. READY=1) to systemd via the socket named by SystemdNotifySocketEnv. It does
+// nothing if the process is not running under systemd with notification enabled.
+func sdNotify(state string) error {
+	socket := os.Getenv(SystemdNotifySocketEnv)
+
+	if socket == "" {
+		return nil
+	}
+
+	//abstract namespace sockets are denoted with a leading @
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("could not connect to notify socket: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return fmt.Errorf("could not write to notify socket: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInstanceImpl_readyFile(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+	readyFile := filepath.Join(t.TempDir(), "ready")
+
+	instance := newTestInstance(t, id, nil)
+	instance.Config.Options.ReadyFile = readyFile
+	instance.Config.ServerConfigs = []*ServerConfig{newTestServerConfig(id, freeAddress(t))}
+	req.NoError(instance.Run())
+
+	req.Eventually(func() bool {
+		_, err := os.Stat(readyFile)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	instance.Shutdown()
+
+	_, err := os.Stat(readyFile)
+	req.True(os.IsNotExist(err), "expected the ready file to be removed on shutdown")
+}
+
+func Test_sdNotify(t *testing.T) {
+	t.Run("does nothing when not run by systemd", func(t *testing.T) {
+		t.Setenv(SystemdNotifySocketEnv, "")
+		require.New(t).NoError(sdNotify("READY=1"))
+	})
+
+	t.Run("sends the state to the notify socket", func(t *testing.T) {
+		req := require.New(t)
+
+		// unix socket paths are limited in length, avoid long test temp dirs
+		dir, err := os.MkdirTemp("", "xweb")
+		req.NoError(err)
+		defer func() { _ = os.RemoveAll(dir) }()
+
+		socket := filepath.Join(dir, "notify.sock")
+		conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+		req.NoError(err)
+		defer func() { _ = conn.Close() }()
+
+		t.Setenv(SystemdNotifySocketEnv, socket)
+		req.NoError(sdNotify("READY=1"))
+
+		buf := make([]byte, 64)
+		req.NoError(conn.SetReadDeadline(time.Now().Add(5 * time.Second)))
+		n, err := conn.Read(buf)
+		req.NoError(err)
+		req.Equal("READY=1", string(buf[:n]))
+	})
+}
@@ -0,0 +1,50 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// newHttpsRedirectHandler returns a http.Handler that permanently redirects all requests to the same path and query
+// at address with the https scheme. The port is omitted from redirects if it is the default https port.
+func newHttpsRedirectHandler(address string) http.Handler {
+	host := address
+
+	if hostname, port, err := net.SplitHostPort(address); err == nil && port == "443" {
+		host = hostname
+
+		if strings.Contains(hostname, ":") {
+			host = "[" + hostname + "]"
+		}
+	}
+
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		target := url.URL{
+			Scheme:   "https",
+			Host:     host,
+			Path:     request.URL.Path,
+			RawPath:  request.URL.RawPath,
+			RawQuery: request.URL.RawQuery,
+		}
+
+		http.Redirect(writer, request, target.String(), http.StatusMovedPermanently)
+	})
+}
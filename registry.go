@@ -19,16 +19,31 @@ package xweb
 import (
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
 )
 
 // Registry describes a registry of binding to ApiHandlerFactory registrations
 type Registry interface {
 	Add(factory ApiHandlerFactory) error
 	Get(binding string) ApiHandlerFactory
+
+	// Replace adds a factory to the registry, replacing any previous factory with the same binding. Returns true if
+	// a previous factory was replaced.
+	Replace(factory ApiHandlerFactory) bool
+
+	// Remove removes the factory registered for a binding. Returns true if a factory was registered.
+	Remove(binding string) bool
+
+	// List returns all registered factories ordered by binding
+	List() []ApiHandlerFactory
 }
 
-// RegistryMap is a basic Registry implementation backed by a simple mapping of binding (string) to ApiHandlerFactory instances
+// RegistryMap is a basic Registry implementation backed by a simple mapping of binding (string) to ApiHandlerFactory
+// instances. It is safe for concurrent use.
 type RegistryMap struct {
+	lock      sync.RWMutex
 	factories map[string]ApiHandlerFactory
 }
 
@@ -40,8 +55,12 @@ func NewRegistryMap() *RegistryMap {
 }
 
 // Add adds a factory to the registry. Errors if a previous factory with the same binding is registered.
-func (registry RegistryMap) Add(factory ApiHandlerFactory) error {
+func (registry *RegistryMap) Add(factory ApiHandlerFactory) error {
 	logrus.Debugf("adding xweb factory with binding: %v", factory.Binding())
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
 	if _, ok := registry.factories[factory.Binding()]; ok {
 		return fmt.Errorf("binding [%s] already registered", factory.Binding())
 	}
@@ -52,6 +71,67 @@ func (registry RegistryMap) Add(factory ApiHandlerFactory) error {
 }
 
 // Get retrieves a factory based on a binding or nil if no factory for the binding is registered
-func (registry RegistryMap) Get(binding string) ApiHandlerFactory {
+func (registry *RegistryMap) Get(binding string) ApiHandlerFactory {
+	registry.lock.RLock()
+	defer registry.lock.RUnlock()
+
 	return registry.factories[binding]
 }
+
+// Replace adds a factory to the registry, replacing any previous factory with the same binding. Returns true if a
+// previous factory was replaced.
+func (registry *RegistryMap) Replace(factory ApiHandlerFactory) bool {
+	logrus.Debugf("replacing xweb factory with binding: %v", factory.Binding())
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	_, replaced := registry.factories[factory.Binding()]
+
+	registry.factories[factory.Binding()] = factory
+
+	return replaced
+}
+
+// Remove removes the factory registered for a binding. Returns true if a factory was registered.
+func (registry *RegistryMap) Remove(binding string) bool {
+	logrus.Debugf("removing xweb factory with binding: %v", binding)
+
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	_, removed := registry.factories[binding]
+
+	delete(registry.factories, binding)
+
+	return removed
+}
+
+// List returns all registered factories ordered by binding
+func (registry *RegistryMap) List() []ApiHandlerFactory {
+	registry.lock.RLock()
+	factories := make([]ApiHandlerFactory, 0, len(registry.factories))
+
+	for _, factory := range registry.factories {
+		factories = append(factories, factory)
+	}
+	registry.lock.RUnlock()
+
+	sort.Slice(factories, func(i, j int) bool {
+		return factories[i].Binding() < factories[j].Binding()
+	})
+
+	return factories
+}
+
+// registeredBindings returns the bindings of all factories in the registry as a comma separated list for use in
+// error messages
+func registeredBindings(registry Registry) string {
+	var bindings []string
+
+	for _, factory := range registry.List() {
+		bindings = append(bindings, factory.Binding())
+	}
+
+	return strings.Join(bindings, ", ")
+}
@@ -0,0 +1,116 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+)
+
+func TestRegistryMap_List(t *testing.T) {
+	t.Run("an empty registry lists no factories", func(t *testing.T) {
+		require.New(t).Empty(NewRegistryMap().List())
+	})
+
+	t.Run("factories are listed in binding order", func(t *testing.T) {
+		req := require.New(t)
+
+		registry := NewRegistryMap()
+		for _, binding := range []string{"c", "a", "b"} {
+			req.NoError(registry.Add(&bindingHandlerFactory{binding: binding}))
+		}
+
+		var bindings []string
+		for _, factory := range registry.List() {
+			bindings = append(bindings, factory.Binding())
+		}
+
+		req.Equal([]string{"a", "b", "c"}, bindings)
+	})
+
+	t.Run("unknown bindings are reported with the registered bindings", func(t *testing.T) {
+		req := require.New(t)
+
+		registry := NewRegistryMap()
+		for _, binding := range []string{"b", "a"} {
+			req.NoError(registry.Add(&bindingHandlerFactory{binding: binding}))
+		}
+
+		serverConfig := newTestServerConfig(nil, "127.0.0.1:443")
+		serverConfig.APIs = []*ApiConfig{{binding: "z"}}
+
+		req.ErrorContains(serverConfig.Validate(registry), "invalid binding z, registered bindings are [a, b]")
+	})
+}
+
+func TestRegistryMap_Replace(t *testing.T) {
+	req := require.New(t)
+
+	registry := NewRegistryMap()
+	original := &bindingHandlerFactory{binding: "a"}
+	replacement := &bindingHandlerFactory{binding: "a"}
+
+	req.False(registry.Replace(original))
+	req.Same(original, registry.Get("a"))
+
+	req.Error(registry.Add(replacement))
+	req.Same(original, registry.Get("a"))
+
+	req.True(registry.Replace(replacement))
+	req.Same(replacement, registry.Get("a"))
+	req.Len(registry.List(), 1)
+}
+
+func TestRegistryMap_Remove(t *testing.T) {
+	req := require.New(t)
+
+	registry := NewRegistryMap()
+	req.NoError(registry.Add(&bindingHandlerFactory{binding: "a"}))
+
+	req.True(registry.Remove("a"))
+	req.Nil(registry.Get("a"))
+	req.Empty(registry.List())
+
+	req.False(registry.Remove("a"))
+
+	req.NoError(registry.Add(&bindingHandlerFactory{binding: "a"}))
+}
+
+func TestRegistryMap_concurrent(t *testing.T) {
+	req := require.New(t)
+
+	registry := NewRegistryMap()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		binding := fmt.Sprintf("binding-%d", i)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = registry.Add(&bindingHandlerFactory{binding: binding})
+			_ = registry.Get(binding)
+			_ = registry.List()
+			registry.Replace(&bindingHandlerFactory{binding: binding})
+		}()
+	}
+	wg.Wait()
+
+	req.Len(registry.List(), 20)
+}
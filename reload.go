@@ -0,0 +1,399 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"errors"
+	"fmt"
+	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/identity"
+	"reflect"
+	"sync"
+)
+
+// Reload parses and validates a new configuration and applies it to a running instance. Servers are matched to the
+// new configuration by name. Servers whose configuration is unchanged keep serving without interruption, servers
+// that were added or changed are built, bound, and started, and servers that were removed or changed are then
+// gracefully shut down. If the root identity's configuration changes, all servers are restarted.
+//
+// The bind points of a changed server are handed over to the server replacing it: bind points bound with the same
+// network, PROXY protocol and TLS settings keep their listener and are not closed in between. If a bind point cannot be
+// handed over, e.g. as TLS was enabled or disabled for it, the changed servers are shut down before their
+// replacements are bound.
+//
+// If the new configuration is invalid, an error is returned and the running servers are left untouched. If a new
+// server cannot be built or bound, an error is returned and the previous servers keep serving. Servers that were
+// already shut down to release their bind points are restored from their previous configuration. Reload may be called
+// concurrently with Shutdown, it returns an error once Shutdown has been called.
+func (i *InstanceImpl) Reload(cfgmap map[interface{}]interface{}) error {
+	i.reloadLock.Lock()
+	defer i.reloadLock.Unlock()
+
+	if i.isShutdown() {
+		return errors.New("could not reload configuration, instance has been shut down")
+	}
+
+	oldConfig := i.Config
+
+	newConfig, err := i.loadReloadConfig(cfgmap)
+	if err != nil {
+		return fmt.Errorf("could not reload configuration: %w", err)
+	}
+
+	rootChanged := newConfig.DefaultIdentity != oldConfig.DefaultIdentity
+
+	current := i.getServers()
+	kept := map[*Server]bool{}
+
+	var servers []*Server
+	serverConfigs := make([]*ServerConfig, 0, len(newConfig.ServerConfigs))
+
+	for _, serverConfig := range newConfig.ServerConfigs {
+		if existing := findUnchangedServer(current, kept, serverConfig); existing != nil && !rootChanged {
+			kept[existing] = true
+			stopWatchingServerIdentities(serverConfig)
+
+			servers = append(servers, existing)
+			serverConfigs = append(serverConfigs, existing.ServerConfig)
+			continue
+		}
+
+		//placeholder, replaced with the new server once it is built
+		servers = append(servers, nil)
+		serverConfigs = append(serverConfigs, serverConfig)
+	}
+
+	var replaced []*Server
+
+	for _, server := range current {
+		if !kept[server] {
+			replaced = append(replaced, server)
+		}
+	}
+
+	added, err := i.newReloadedServers(servers, serverConfigs)
+	if err != nil {
+		i.abortReload(newConfig, servers, serverConfigs, added, replaced, false)
+		return err
+	}
+
+	handoffs := newHandoffListeners(replaced)
+	replacedStopped := false
+
+	if handoffs.canTakeOver(added) {
+		i.unregisterServers(replaced)
+	} else {
+		//the bind points that cannot be handed over must be released before they are bound again
+		i.shutdownServers(replaced)
+		replacedStopped = true
+		handoffs = nil
+	}
+
+	if err = i.bindReloadedServers(added, handoffs); err != nil {
+		i.abortReload(newConfig, servers, serverConfigs, added, replaced, replacedStopped)
+		return err
+	}
+
+	i.setServers(servers)
+
+	for _, server := range added {
+		s := server //avoid closure scoping issues
+		s.takeOverBindPoints()
+
+		go func() {
+			if err := s.Start(); err != nil {
+				i.reportStartError(s, err)
+			}
+		}()
+	}
+
+	if !replacedStopped {
+		i.shutdownServers(replaced)
+	}
+
+	for _, server := range replaced {
+		stopWatchingServerIdentities(server.ServerConfig)
+	}
+
+	oldConfig.SourceConfig = newConfig.SourceConfig
+	oldConfig.ServerConfigs = serverConfigs
+	oldConfig.Warnings = newConfig.Warnings
+
+	if rootChanged {
+		if oldConfig.defaultIdentityLoaded && oldConfig.DefaultIdentity != nil {
+			oldConfig.DefaultIdentity.StopWatchingFiles()
+		}
+
+		oldConfig.DefaultIdentity = newConfig.DefaultIdentity
+		oldConfig.defaultIdentityConfig = newConfig.defaultIdentityConfig
+		oldConfig.defaultIdentityLoaded = newConfig.defaultIdentityLoaded
+	}
+
+	pfxlog.Logger().Infof("reloaded configuration, unchanged servers: %v, started servers: %v, stopped servers: %v",
+		serverNames(current, func(server *Server) bool { return kept[server] }),
+		serverNames(added, func(*Server) bool { return true }),
+		serverNames(replaced, func(*Server) bool { return true }))
+
+	return nil
+}
+
+// newReloadedServers builds a Server for each ServerConfig whose placeholder in servers is nil and sets it in servers.
+// The new Server's are returned, including those built before an error is returned.
+func (i *InstanceImpl) newReloadedServers(servers []*Server, serverConfigs []*ServerConfig) ([]*Server, error) {
+	var added []*Server
+
+	for idx, serverConfig := range serverConfigs {
+		if servers[idx] != nil {
+			continue
+		}
+
+		server, err := NewServer(i, serverConfig)
+		if err != nil {
+			return added, fmt.Errorf("could not reload server %s: %w", serverConfig.Name, err)
+		}
+
+		servers[idx] = server
+		added = append(added, server)
+	}
+
+	return added, nil
+}
+
+// bindReloadedServers binds the bind points of the supplied Server's, taking over the listeners in handoffs where
+// possible.
+func (i *InstanceImpl) bindReloadedServers(servers []*Server, handoffs *handoffListeners) error {
+	for _, server := range servers {
+		server.takeover = handoffs
+
+		if err := i.bindServer(server); err != nil {
+			return fmt.Errorf("could not reload server %s: %w", server.ServerConfig.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// loadReloadConfig parses and validates a configuration map into a new InstanceConfig that shares the section names
+// and options of the current configuration. The current root identity is reused if it was supplied rather than loaded
+// from configuration, or if its configuration is unchanged.
+func (i *InstanceImpl) loadReloadConfig(cfgmap map[interface{}]interface{}) (*InstanceConfig, error) {
+	oldConfig := i.Config
+
+	newConfig := &InstanceConfig{
+		Section:                oldConfig.Section,
+		DefaultIdentitySection: oldConfig.DefaultIdentitySection,
+		OptionsSection:         oldConfig.OptionsSection,
+		Options:                oldConfig.Options,
+	}
+
+	if !oldConfig.defaultIdentityLoaded {
+		newConfig.DefaultIdentity = oldConfig.DefaultIdentity
+	}
+
+	if err := newConfig.Parse(cfgmap); err != nil {
+		return nil, err
+	}
+
+	if oldConfig.defaultIdentityLoaded && reflect.DeepEqual(newConfig.defaultIdentityConfig, oldConfig.defaultIdentityConfig) {
+		newConfig.DefaultIdentity = oldConfig.DefaultIdentity
+		newConfig.defaultIdentityLoaded = true
+
+		for _, serverConfig := range newConfig.ServerConfigs {
+			serverConfig.DefaultIdentity = newConfig.DefaultIdentity
+		}
+	}
+
+	if err := newConfig.Validate(i.Registry); err != nil {
+		for _, serverConfig := range newConfig.ServerConfigs {
+			stopWatchingServerIdentities(serverConfig)
+		}
+
+		if newConfig.DefaultIdentity != oldConfig.DefaultIdentity && newConfig.DefaultIdentity != nil {
+			newConfig.DefaultIdentity.StopWatchingFiles()
+		}
+
+		return nil, err
+	}
+
+	return newConfig, nil
+}
+
+// shutdownServers gracefully shuts down the supplied servers and waits for them to finish, which releases their bind
+// points.
+func (i *InstanceImpl) shutdownServers(servers []*Server) {
+	waitGroup := sync.WaitGroup{}
+
+	for _, server := range servers {
+		waitGroup.Add(1)
+
+		localServer := server
+		go func() {
+			defer waitGroup.Done()
+			i.shutdownServer(localServer)
+		}()
+	}
+
+	waitGroup.Wait()
+}
+
+// unregisterServers removes the bind points of the supplied servers from InstanceOptions.BindRegistry, if set, so
+// that the servers replacing them may register them.
+func (i *InstanceImpl) unregisterServers(servers []*Server) {
+	if registry := i.Config.Options.BindRegistry; registry != nil {
+		for _, server := range servers {
+			registry.Unregister(server)
+		}
+	}
+}
+
+// abortReload closes the bind points of the servers added by a failed Reload and leaves the instance running the
+// servers they would have replaced. If those were already shut down, they are rebuilt from their ServerConfig's and
+// started. Servers that cannot be restored are dropped.
+func (i *InstanceImpl) abortReload(newConfig *InstanceConfig, servers []*Server, serverConfigs []*ServerConfig, added []*Server, replaced []*Server, replacedStopped bool) {
+	for _, server := range added {
+		server.closeListeners()
+	}
+
+	i.unregisterServers(added)
+
+	isAdded := map[*Server]bool{}
+	for _, server := range added {
+		isAdded[server] = true
+	}
+
+	for idx, serverConfig := range serverConfigs {
+		if servers[idx] == nil || isAdded[servers[idx]] {
+			stopWatchingServerIdentities(serverConfig)
+		}
+	}
+
+	if newConfig.DefaultIdentity != i.Config.DefaultIdentity {
+		newConfig.DefaultIdentity.StopWatchingFiles()
+	}
+
+	if !replacedStopped {
+		for _, server := range replaced {
+			if err := i.registerBindPoints(server); err != nil {
+				pfxlog.Logger().Errorf("could not register bind points of server %s again: %v", server.ServerConfig.Name, err)
+			}
+		}
+
+		return
+	}
+
+	restored := map[*Server]*Server{}
+
+	for _, server := range replaced {
+		restored[server] = nil
+
+		restoredServer, err := NewServer(i, server.ServerConfig)
+		if err == nil {
+			err = i.bindServer(restoredServer)
+		}
+
+		if err != nil {
+			pfxlog.Logger().Errorf("could not restore server %s: %v", server.ServerConfig.Name, err)
+			stopWatchingServerIdentities(server.ServerConfig)
+			continue
+		}
+
+		restored[server] = restoredServer
+
+		go func() {
+			if err := restoredServer.Start(); err != nil {
+				i.reportStartError(restoredServer, err)
+			}
+		}()
+	}
+
+	var currentServers []*Server
+	var currentServerConfigs []*ServerConfig
+
+	for _, server := range i.getServers() {
+		if restoredServer, ok := restored[server]; ok {
+			if restoredServer == nil {
+				continue
+			}
+
+			server = restoredServer
+		}
+
+		currentServers = append(currentServers, server)
+		currentServerConfigs = append(currentServerConfigs, server.ServerConfig)
+	}
+
+	i.setServers(currentServers)
+	i.Config.ServerConfigs = currentServerConfigs
+}
+
+func (i *InstanceImpl) setServers(servers []*Server) {
+	i.serversLock.Lock()
+	defer i.serversLock.Unlock()
+
+	i.servers = servers
+}
+
+// findUnchangedServer returns the first Server not already kept whose ServerConfig has the same name and was parsed
+// from the same configuration, including the options it inherited from the instance, as the supplied ServerConfig.
+func findUnchangedServer(servers []*Server, kept map[*Server]bool, serverConfig *ServerConfig) *Server {
+	for _, server := range servers {
+		if kept[server] || server.ServerConfig.Name != serverConfig.Name {
+			continue
+		}
+
+		if server.ServerConfig.source != nil && reflect.DeepEqual(server.ServerConfig.source, serverConfig.source) &&
+			reflect.DeepEqual(server.ServerConfig.inheritedOptions, serverConfig.inheritedOptions) {
+			return server
+		}
+	}
+
+	return nil
+}
+
+// serverNames returns the names of the servers that satisfy include.
+func serverNames(servers []*Server, include func(*Server) bool) []string {
+	var names []string
+
+	for _, server := range servers {
+		if include(server) {
+			names = append(names, server.ServerConfig.Name)
+		}
+	}
+
+	return names
+}
+
+// stopWatchingServerIdentities stops file watching on the identities loaded for a ServerConfig. The default identity
+// is shared and left untouched.
+func stopWatchingServerIdentities(serverConfig *ServerConfig) {
+	stopped := map[identity.Identity]bool{}
+
+	stop := func(id identity.Identity) {
+		if id == nil || id == serverConfig.DefaultIdentity || stopped[id] {
+			return
+		}
+
+		stopped[id] = true
+		id.StopWatchingFiles()
+	}
+
+	stop(serverConfig.Identity)
+
+	for _, sniIdentity := range serverConfig.Identities {
+		stop(sniIdentity)
+	}
+}
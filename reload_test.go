@@ -0,0 +1,312 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestWebConfig returns a configuration map with a mockHandler server per name, bound to the matching address.
+func newTestWebConfig(serverAddresses map[string]string) map[interface{}]interface{} {
+	var web []interface{}
+
+	for name, address := range serverAddresses {
+		web = append(web, map[interface{}]interface{}{
+			"name":       name,
+			"apis":       []interface{}{map[interface{}]interface{}{"binding": "mockHandler"}},
+			"bindPoints": []interface{}{map[interface{}]interface{}{"interface": address, "address": address}},
+		})
+	}
+
+	return map[interface{}]interface{}{"web": web}
+}
+
+// failingHandlerFactory is a mockHandlerFactory that fails to create handlers for ApiConfigs with the "fail" option
+type failingHandlerFactory struct {
+	mockHandlerFactory
+}
+
+func (f *failingHandlerFactory) New(serverConfig *ServerConfig, options map[interface{}]interface{}) (ApiHandler, error) {
+	if options["fail"] == true {
+		return nil, errors.New("handler failed")
+	}
+	return f.mockHandlerFactory.New(serverConfig, options)
+}
+
+func TestInstanceImpl_Reload(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+	client := newTestClient()
+
+	serving := func(address string) bool {
+		resp, err := client.Get("https://" + address + "/")
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}
+
+	findServer := func(instance *InstanceImpl, name string) *Server {
+		for _, server := range instance.getServers() {
+			if server.ServerConfig.Name == name {
+				return server
+			}
+		}
+		return nil
+	}
+
+	t.Run("unchanged servers keep serving, removed servers stop, and added servers start", func(t *testing.T) {
+		req := require.New(t)
+
+		keptAddress, removedAddress, addedAddress := freeAddress(t), freeAddress(t), freeAddress(t)
+
+		instance := newTestInstance(t, id, nil)
+		req.NoError(instance.LoadConfig(newTestWebConfig(map[string]string{"kept": keptAddress, "removed": removedAddress})))
+		req.NoError(instance.Run())
+		defer instance.Shutdown()
+
+		req.NoError(instance.WaitForServer("kept", 5*time.Second))
+		req.NoError(instance.WaitForServer("removed", 5*time.Second))
+
+		kept := findServer(instance, "kept")
+
+		req.NoError(instance.Reload(newTestWebConfig(map[string]string{"kept": keptAddress, "added": addedAddress})))
+		req.NoError(instance.WaitForServer("added", 5*time.Second))
+
+		req.Same(kept, findServer(instance, "kept"))
+		req.Nil(findServer(instance, "removed"))
+		req.Len(instance.Config.ServerConfigs, 2)
+
+		req.True(serving(keptAddress))
+		req.True(serving(addedAddress))
+		req.False(serving(removedAddress))
+	})
+
+	t.Run("changed servers are restarted", func(t *testing.T) {
+		req := require.New(t)
+
+		oldAddress, newAddress := freeAddress(t), freeAddress(t)
+
+		instance := newTestInstance(t, id, nil)
+		req.NoError(instance.LoadConfig(newTestWebConfig(map[string]string{"changed": oldAddress})))
+		req.NoError(instance.Run())
+		defer instance.Shutdown()
+
+		req.NoError(instance.WaitForServer("changed", 5*time.Second))
+		previous := findServer(instance, "changed")
+
+		req.NoError(instance.Reload(newTestWebConfig(map[string]string{"changed": newAddress})))
+		req.NoError(instance.WaitForServer("changed", 5*time.Second))
+
+		req.NotSame(previous, findServer(instance, "changed"))
+		req.True(serving(newAddress))
+		req.False(serving(oldAddress))
+	})
+
+	t.Run("servers whose inherited options changed are restarted", func(t *testing.T) {
+		req := require.New(t)
+
+		address := freeAddress(t)
+
+		newConfig := func(readTimeout string) map[interface{}]interface{} {
+			config := newTestWebConfig(map[string]string{"inherits": address})
+			config[DefaultOptionsSection] = map[interface{}]interface{}{"readTimeout": readTimeout}
+			return config
+		}
+
+		instance := newTestInstance(t, id, nil)
+		req.NoError(instance.LoadConfig(newConfig("3s")))
+		req.NoError(instance.Run())
+		defer instance.Shutdown()
+
+		req.NoError(instance.WaitForServer("inherits", 5*time.Second))
+		previous := findServer(instance, "inherits")
+
+		req.NoError(instance.Reload(newConfig("3s")))
+		req.Same(previous, findServer(instance, "inherits"))
+
+		req.NoError(instance.Reload(newConfig("4s")))
+		req.NoError(instance.WaitForServer("inherits", 5*time.Second))
+
+		current := findServer(instance, "inherits")
+		req.NotSame(previous, current)
+		req.Equal(4*time.Second, current.ServerConfig.Options.ReadTimeout)
+		req.True(serving(address))
+	})
+
+	t.Run("the bind points of changed servers are handed over", func(t *testing.T) {
+		req := require.New(t)
+
+		address := freeAddress(t)
+
+		newConfig := func(readTimeout string) map[interface{}]interface{} {
+			config := newTestWebConfig(map[string]string{"test": address})
+			config[DefaultOptionsSection] = map[interface{}]interface{}{"readTimeout": readTimeout}
+			return config
+		}
+
+		instance := newTestInstance(t, id, nil)
+		req.NoError(instance.LoadConfig(newConfig("3s")))
+		req.NoError(instance.Run())
+		defer instance.Shutdown()
+
+		req.NoError(instance.WaitForServer("test", 5*time.Second))
+		previous := findServer(instance, "test")
+
+		req.NoError(instance.Reload(newConfig("4s")))
+		req.NoError(instance.WaitForServer("test", 5*time.Second))
+
+		current := findServer(instance, "test")
+		req.NotSame(previous, current)
+		req.Same(previous.handoffs[0], current.handoffs[0])
+		req.True(serving(address))
+	})
+
+	t.Run("a server that cannot be bound leaves the previous servers serving", func(t *testing.T) {
+		req := require.New(t)
+
+		address := freeAddress(t)
+
+		taken, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+		defer func() { _ = taken.Close() }()
+
+		instance := newTestInstance(t, id, nil)
+		req.NoError(instance.LoadConfig(newTestWebConfig(map[string]string{"test": address})))
+		req.NoError(instance.Run())
+		defer instance.Shutdown()
+
+		req.NoError(instance.WaitForServer("test", 5*time.Second))
+		previous := findServer(instance, "test")
+
+		req.Error(instance.Reload(newTestWebConfig(map[string]string{"test": taken.Addr().String()})))
+
+		req.Same(previous, findServer(instance, "test"))
+		req.True(serving(address))
+	})
+
+	t.Run("servers shut down to release their bind points are restored if the reload fails", func(t *testing.T) {
+		req := require.New(t)
+
+		changedAddress, failingAddress := freeAddress(t), freeAddress(t)
+
+		taken, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+		defer func() { _ = taken.Close() }()
+
+		instance := newTestInstance(t, id, nil)
+		req.NoError(instance.LoadConfig(newTestWebConfig(map[string]string{"changed": changedAddress, "failing": failingAddress})))
+		req.NoError(instance.Run())
+		defer instance.Shutdown()
+
+		req.NoError(instance.WaitForServer("changed", 5*time.Second))
+		req.NoError(instance.WaitForServer("failing", 5*time.Second))
+		previous := findServer(instance, "changed")
+
+		//enabling the PROXY protocol prevents handing the bind point over
+		config := newTestWebConfig(map[string]string{"changed": changedAddress, "failing": taken.Addr().String()})
+		for _, server := range config["web"].([]interface{}) {
+			server := server.(map[interface{}]interface{})
+			if server["name"] == "changed" {
+				server["bindPoints"].([]interface{})[0].(map[interface{}]interface{})["proxyProtocol"] = true
+			}
+		}
+
+		req.Error(instance.Reload(config))
+
+		req.NoError(instance.WaitForServer("changed", 5*time.Second))
+		req.NoError(instance.WaitForServer("failing", 5*time.Second))
+
+		restored := findServer(instance, "changed")
+		req.NotSame(previous, restored)
+		req.False(restored.ServerConfig.BindPoints[0].ProxyProtocol)
+		req.True(serving(changedAddress))
+		req.True(serving(failingAddress))
+	})
+
+	t.Run("an invalid configuration leaves the running servers untouched", func(t *testing.T) {
+		req := require.New(t)
+
+		address := freeAddress(t)
+
+		instance := newTestInstance(t, id, nil)
+		req.NoError(instance.LoadConfig(newTestWebConfig(map[string]string{"test": address})))
+		req.NoError(instance.Run())
+		defer instance.Shutdown()
+
+		req.NoError(instance.WaitForServer("test", 5*time.Second))
+		previous := findServer(instance, "test")
+
+		invalid := newTestWebConfig(map[string]string{"test": "not-an-address"})
+		req.Error(instance.Reload(invalid))
+
+		req.Same(previous, findServer(instance, "test"))
+		req.True(serving(address))
+	})
+}
+
+func TestInstanceImpl_Reload_handlerError(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	registry := NewRegistryMap()
+	req.NoError(registry.Add(&failingHandlerFactory{}))
+
+	address := freeAddress(t)
+
+	instance := NewDefaultInstance(registry, id)
+	req.NoError(instance.LoadConfig(newTestWebConfig(map[string]string{"test": address})))
+	req.NoError(instance.Run())
+	defer instance.Shutdown()
+
+	req.NoError(instance.WaitForServer("test", 5*time.Second))
+
+	failing := newTestWebConfig(map[string]string{"test": address})
+	failing["web"].([]interface{})[0].(map[interface{}]interface{})["apis"] = []interface{}{
+		map[interface{}]interface{}{"binding": "mockHandler", "options": map[interface{}]interface{}{"fail": true}},
+	}
+
+	previous := instance.getServers()[0]
+
+	err := instance.Reload(failing)
+	req.Error(err)
+	req.Contains(err.Error(), "error building handler for api binding [mockHandler]: handler failed")
+
+	req.Equal([]*Server{previous}, instance.getServers())
+	req.NoError(instance.WaitForServer("test", 5*time.Second))
+}
+
+func TestInstanceImpl_Reload_afterShutdown(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	instance := newTestInstance(t, id, nil)
+	req.NoError(instance.LoadConfig(newTestWebConfig(map[string]string{"test": freeAddress(t)})))
+	req.NoError(instance.Run())
+	instance.Shutdown()
+
+	err := instance.Reload(newTestWebConfig(map[string]string{"test": freeAddress(t)}))
+	req.Error(err)
+	req.Contains(err.Error(), "shut down")
+}
@@ -0,0 +1,349 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/openziti/xweb/v2/middleware"
+	"sort"
+	"time"
+)
+
+// ConfigSchemaVersion is the JSON Schema dialect of the schema returned by ConfigSchema
+const ConfigSchemaVersion = "https://json-schema.org/draft/2020-12/schema"
+
+// ConfigSchema returns a JSON Schema describing the configuration parsed by InstanceConfig.Parse, using the default
+// identity (DefaultIdentitySection), web (DefaultConfigSection), and inherited options (DefaultOptionsSection, i.e.
+// `webOptions`) section names. It covers the keys parsed by InstanceConfig, ServerConfig, ApiConfig, BindPointConfig,
+// CorsConfig, and Options, including their types, defaults, and whether they are required. The options of individual
+// APIs are defined by their ApiHandlerFactory and are not described. The result may be encoded with encoding/json.
+func ConfigSchema() map[string]interface{} {
+	inheritedOptions := optionsSchema()
+	inheritedOptions["description"] = "Options inherited by all servers, each server's own options override them"
+
+	return map[string]interface{}{
+		"$schema":     ConfigSchemaVersion,
+		"title":       "xweb configuration",
+		"description": "Configuration for xweb servers and the APIs they host",
+		"type":        "object",
+		"properties": map[string]interface{}{
+			DefaultIdentitySection: identitySchema("The default identity of all servers that do not define their own"),
+			DefaultConfigSection: map[string]interface{}{
+				"description": "The servers to run, each with its own bind points, APIs, identity, and options",
+				"type":        "array",
+				"items":       serverConfigSchema(),
+			},
+			DefaultOptionsSection: inheritedOptions,
+		},
+	}
+}
+
+func serverConfigSchema() map[string]interface{} {
+	return objectSchema("A server hosting APIs on one or more bind points", map[string]interface{}{
+		"name": stringSchema("The name of the server, used in logs and errors"),
+		"apis": map[string]interface{}{
+			"description": "The APIs hosted by the server",
+			"type":        "array",
+			"minItems":    1,
+			"items": objectSchema("An API hosted by the server", map[string]interface{}{
+				"binding": stringSchema("The binding of the ApiHandlerFactory that creates the API"),
+				"enabled": boolSchema("Whether the API is served, disabled APIs are validated but not served", true),
+				"priority": map[string]interface{}{
+					"description": "The routing priority of the API, higher priorities are matched first and the lowest is the default",
+					"type":        "integer",
+					"default":     0,
+				},
+				"maxBodyBytes": map[string]interface{}{
+					"description": "The maximum size of request bodies for the API, overrides the server's maxBodyBytes, 0 is unlimited",
+					"type":        "integer",
+					"minimum":     0,
+				},
+				"handlerTimeout": durationSchema("The maximum time to process a request for the API, overrides the server's handlerTimeout, 0s is unlimited", 0),
+				"middleware": map[string]interface{}{
+					"description": "The names of the middleware registered by the application that wrap only this API, outermost first",
+					"type":        "array",
+					"items":       map[string]interface{}{"type": "string", "minLength": 1},
+				},
+				"options": map[string]interface{}{
+					"description": "Options passed to the ApiHandlerFactory, defined by the API",
+					"type":        "object",
+				},
+			}, "binding"),
+		},
+		"defaultApi":        stringSchema("The binding of the API that serves requests no other API matches"),
+		"requireDefaultApi": boolSchema("Fail instead of using the last API as the default if no API is explicitly the default", false),
+		"resolveAddresses":  boolSchema("Verify that the advertised addresses of all bind points resolve via DNS", false),
+		"bindPoints": map[string]interface{}{
+			"description": "The addresses the server listens on",
+			"type":        "array",
+			"minItems":    1,
+			"items":       bindPointConfigSchema(),
+		},
+		"identity": identitySchema("The identity of the server, defaults to the root identity"),
+		"identities": map[string]interface{}{
+			"description":          "Identities presented to clients that request the keyed server name via SNI",
+			"type":                 "object",
+			"additionalProperties": identitySchema("The identity presented for a server name"),
+		},
+		"clientCas":       stringSchema("CAs used to verify client certificates instead of the identity's CA bundle, a file path or pem:<PEM>"),
+		"cors":            corsConfigSchema(),
+		"securityHeaders": securityHeadersConfigSchema(),
+		"options":         optionsSchema(),
+	}, "name", "apis", "bindPoints")
+}
+
+func bindPointConfigSchema() map[string]interface{} {
+	clientAuth := stringSchema("How client certificates are requested and verified")
+	clientAuth["enum"] = clientAuthPolicies()
+	clientAuth["default"] = DefaultClientAuthPolicy
+
+	return objectSchema("An address the server listens on", map[string]interface{}{
+		"name":       stringSchema("Identifies the bind point in logs and to APIs, unique within the server, defaults to the interface"),
+		"interface":  stringSchema("The <interface>:<port> to listen on or unix:<path> for a unix domain socket, port 0 binds a port chosen by the operating system"),
+		"address":    stringSchema("The public <ip/host>:<port> clients use to reach the bind point"),
+		"newAddress": stringSchema("An <ip/host>:<port> sent to clients in the ziti-ctrl-address header to move to"),
+		"newAddresses": map[string]interface{}{
+			"description": "Additional <ip/host>:<port> addresses sent to clients after newAddress, in order of preference",
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "string"},
+		},
+		"allowedCidrs": map[string]interface{}{
+			"description": "The CIDRs or IP addresses of the clients allowed to make requests, all clients if empty",
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "string"},
+		},
+		"deniedCidrs": map[string]interface{}{
+			"description": "The CIDRs or IP addresses of the clients denied from making requests, evaluated before allowedCidrs",
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "string"},
+		},
+		"newAddressPaths": map[string]interface{}{
+			"description": "Path prefixes of the requests the ziti-ctrl-address header is sent on, all requests if empty",
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "string"},
+		},
+		"apis": map[string]interface{}{
+			"description": "The bindings of the APIs served on the bind point, all APIs if empty",
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "string"},
+		},
+		"serveTLS":       boolSchema("Serve TLS, disabling it is intended for unix socket and loopback interfaces", true),
+		"h2c":            boolSchema("Serve HTTP/2 cleartext (h2c) in addition to HTTP/1.1, requires serveTLS to be false", false),
+		"redirectHttps":  boolSchema("Serve plain HTTP that permanently redirects all requests to the advertised address with the https scheme, implies serveTLS false", false),
+		"proxyProtocol":  boolSchema("Require a PROXY protocol v1 or v2 header ahead of each connection", false),
+		"maxConnections": intSchema("The maximum number of concurrently open connections, 0 is unlimited", 0, 0),
+		"clientAuth":     clientAuth,
+		"network": map[string]interface{}{
+			"description": "The network to bind, tcp4 or tcp6 restrict the bind point to IPv4 or IPv6",
+			"type":        "string",
+			"enum":        []string{NetworkTcp, NetworkTcp4, NetworkTcp6},
+			"default":     NetworkTcp,
+		},
+		"clientCas": stringSchema("CAs used to verify client certificates on this bind point, a file path or pem:<PEM>, overrides the server clientCas"),
+	}, "interface", "address")
+}
+
+func corsConfigSchema() map[string]interface{} {
+	return objectSchema("Enables CORS handling for all bind points of the server", map[string]interface{}{
+		"allowedOrigins": map[string]interface{}{
+			"description": "The origins allowed to make cross-origin requests, * allows all",
+			"type":        "array",
+			"minItems":    1,
+			"items":       map[string]interface{}{"type": "string"},
+		},
+		"allowedMethods": map[string]interface{}{
+			"description": "The methods allowed in cross-origin requests",
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "string"},
+		},
+		"allowedHeaders": map[string]interface{}{
+			"description": "The headers allowed in cross-origin requests",
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "string"},
+		},
+		"allowCredentials": boolSchema("Allow credentials in cross-origin requests", false),
+		"maxAge":           durationSchema("The time preflight responses may be cached", 0),
+	}, "allowedOrigins")
+}
+
+func securityHeadersConfigSchema() map[string]interface{} {
+	frameOptions := stringSchema("The X-Frame-Options header, empty to disable")
+	frameOptions["enum"] = []string{middleware.FrameOptionsDeny, middleware.FrameOptionsSameOrigin, ""}
+	frameOptions["default"] = middleware.FrameOptionsDeny
+
+	return objectSchema("Adds security headers to the responses of all bind points of the server", map[string]interface{}{
+		"hsts":                  boolSchema("Send the Strict-Transport-Security header on TLS bind points", true),
+		"hstsMaxAge":            durationSchema("The max-age of the Strict-Transport-Security header", DefaultHstsMaxAge),
+		"hstsIncludeSubDomains": boolSchema("Add includeSubDomains to the Strict-Transport-Security header", false),
+		"hstsPreload":           boolSchema("Add preload to the Strict-Transport-Security header, requires hstsIncludeSubDomains", false),
+		"contentTypeNosniff":    boolSchema("Send X-Content-Type-Options: nosniff", true),
+		"frameOptions":          frameOptions,
+		"contentSecurityPolicy": stringSchema("The Content-Security-Policy header, not sent if unset"),
+		"referrerPolicy":        stringSchema("The Referrer-Policy header, not sent if unset"),
+	})
+}
+
+func optionsSchema() map[string]interface{} {
+	var tlsVersions []string
+	for tlsVersion := range TlsVersionMap {
+		tlsVersions = append(tlsVersions, tlsVersion)
+	}
+	sort.Strings(tlsVersions)
+
+	minTLSVersion := stringSchema("The minimum TLS version accepted")
+	minTLSVersion["enum"] = tlsVersions
+	minTLSVersion["default"] = ReverseTlsVersionMap[MinTLSVersion]
+
+	maxTLSVersion := stringSchema("The maximum TLS version accepted")
+	maxTLSVersion["enum"] = tlsVersions
+	maxTLSVersion["default"] = ReverseTlsVersionMap[MaxTLSVersion]
+
+	var curves []string
+	for curve := range CurveMap {
+		curves = append(curves, curve)
+	}
+	sort.Strings(curves)
+
+	return objectSchema("Options that apply to all bind points of the server", map[string]interface{}{
+		"readTimeout":              durationSchema("The maximum time to read a request", DefaultHttpReadTimeout),
+		"readHeaderTimeout":        durationSchema("The maximum time to read request headers, readTimeout if unset", 0),
+		"writeTimeout":             durationSchema("The maximum time to write a response", DefaultHttpWriteTimeout),
+		"idleTimeout":              durationSchema("The maximum time to wait for the next request on a kept alive connection", DefaultHttpIdleTimeout),
+		"handlerTimeout":           durationSchema("The maximum time handlers may take to process a request before a 503 is returned, unlimited if unset", 0),
+		"shutdownTimeout":          durationSchema("The time allowed to drain requests on shutdown, the instance default if unset", 0),
+		"minTLSVersion":            minTLSVersion,
+		"maxTLSVersion":            maxTLSVersion,
+		"ocspStapling":             boolSchema("Staple OCSP responses to server certificates", false),
+		"clientCrl":                stringSchema("A file path or http(s) URL of a CRL, revoked client certificates are rejected"),
+		"tlsKeyLogFile":            stringSchema("A file TLS session secrets are appended to for debugging, requires the " + TlsKeyLogEnv + " environment variable to be true"),
+		"clientCrlRefreshInterval": durationSchema("The interval at which a CRL obtained from a URL is refreshed", DefaultCrlRefreshInterval),
+		"accessLog":                boolSchema("Log each request", false),
+		"filterHandshakeErrors":    boolSchema("Log benign TLS handshake errors, e.g. from port scanners, at debug level", false),
+		"requestDeadline":          boolSchema("Set a deadline on the context of each request", false),
+		"requestTimeout":           durationSchema("The request deadline, writeTimeout if unset", 0),
+		"requestDeadlineExemptPaths": map[string]interface{}{
+			"description": "Path prefixes of requests exempt from request deadlines",
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "string"},
+		},
+		"rateLimit": map[string]interface{}{
+			"description": "The requests per second allowed per client, 0 disables rate limiting",
+			"type":        "number",
+			"minimum":     0,
+			"default":     0,
+		},
+		"rateLimitBurst":        intSchema("The burst of requests allowed per client above rateLimit", 0, 0),
+		"trackInFlightRequests": boolSchema("Track in-flight requests for shutdown diagnostics", false),
+		"maxHeaderBytes": map[string]interface{}{
+			"description": "The maximum size of request headers, the Go default of 1MB if unset",
+			"type":        "integer",
+			"minimum":     1,
+		},
+		"maxBodyBytes": map[string]interface{}{
+			"description": "The maximum size of request bodies, unlimited if unset",
+			"type":        "integer",
+			"minimum":     1,
+		},
+		"keepAlivesEnabled": boolSchema("Keep connections alive between requests", true),
+		"compression":       boolSchema("Compress responses with the encoding preferred by the client", true),
+		"compressionLevel": map[string]interface{}{
+			"description": "The compression level from 1 (fastest) to 9 (smallest), the default of each encoding if unset",
+			"type":        "integer",
+			"minimum":     middleware.MinCompressionLevel,
+			"maximum":     middleware.MaxCompressionLevel,
+		},
+		"compressionMinSize": intSchema("The minimum response size in bytes to compress", 0, 0),
+		"curvePreferences": map[string]interface{}{
+			"description": "Elliptic curves used for key exchange in order of preference, the Go defaults if unset",
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "string", "enum": curves},
+		},
+		"compressionContentTypes": map[string]interface{}{
+			"description": "Media types of responses to compress (e.g. text/*), all if unset",
+			"type":        "array",
+			"items":       map[string]interface{}{"type": "string"},
+		},
+	})
+}
+
+func identitySchema(description string) map[string]interface{} {
+	return objectSchema(description, map[string]interface{}{
+		"cert":        stringSchema("The client certificate, as a file path or a PEM value"),
+		"key":         stringSchema("The private key of cert, as a file path, PEM value, or engine reference"),
+		"server_cert": stringSchema("The server certificate, as a file path or a PEM value"),
+		"server_key":  stringSchema("The private key of server_cert, cert's key if unset"),
+		"ca":          stringSchema("The trusted CA bundle, as a file path or a PEM value"),
+		"alt_server_certs": map[string]interface{}{
+			"description": "Additional server certificates, selected by SNI",
+			"type":        "array",
+			"items": objectSchema("An additional server certificate", map[string]interface{}{
+				"server_cert": stringSchema("The server certificate, as a file path or a PEM value"),
+				"server_key":  stringSchema("The private key of server_cert"),
+			}, "server_cert", "server_key"),
+		},
+	}, "cert", "key")
+}
+
+func objectSchema(description string, properties map[string]interface{}, required ...string) map[string]interface{} {
+	result := map[string]interface{}{
+		"description": description,
+		"type":        "object",
+		"properties":  properties,
+	}
+
+	if len(required) > 0 {
+		result["required"] = required
+	}
+
+	return result
+}
+
+func stringSchema(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"type":        "string",
+	}
+}
+
+func durationSchema(description string, defaultValue time.Duration) map[string]interface{} {
+	result := map[string]interface{}{
+		"description": description + " as a duration (e.g. 1m, 30s)",
+		"type":        "string",
+	}
+
+	if defaultValue > 0 {
+		result["default"] = defaultValue.String()
+	}
+
+	return result
+}
+
+func boolSchema(description string, defaultValue bool) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"type":        "boolean",
+		"default":     defaultValue,
+	}
+}
+
+func intSchema(description string, minimum, defaultValue int) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"type":        "integer",
+		"minimum":     minimum,
+		"default":     defaultValue,
+	}
+}
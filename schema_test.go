@@ -0,0 +1,77 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestConfigSchema(t *testing.T) {
+	req := require.New(t)
+
+	schema := ConfigSchema()
+
+	_, err := json.Marshal(schema)
+	req.NoError(err)
+
+	serverSchema := schema["properties"].(map[string]interface{})[DefaultConfigSection].(map[string]interface{})["items"].(map[string]interface{})
+	req.Equal([]string{"name", "apis", "bindPoints"}, serverSchema["required"])
+
+	t.Run("all described options are parsed with their described types", func(t *testing.T) {
+		req := require.New(t)
+
+		optionProperties := serverSchema["properties"].(map[string]interface{})["options"].(map[string]interface{})["properties"].(map[string]interface{})
+
+		for key, propertyVal := range optionProperties {
+			property := propertyVal.(map[string]interface{})
+
+			var value interface{}
+			switch {
+			case property["enum"] != nil:
+				value = property["enum"].([]string)[0]
+			case property["type"] == "string":
+				value = "1s"
+			case property["type"] == "boolean":
+				value = true
+			case property["type"] == "integer":
+				value = 1
+			case property["type"] == "number":
+				value = 1.5
+			case property["type"] == "array" && property["items"].(map[string]interface{})["enum"] != nil:
+				value = []interface{}{property["items"].(map[string]interface{})["enum"].([]string)[0]}
+			case property["type"] == "array":
+				value = []interface{}{"/path"}
+			default:
+				req.Failf("unexpected schema type", "option %s has type %v", key, property["type"])
+			}
+
+			options := &Options{}
+			options.Default()
+			req.NoError(options.Parse(map[interface{}]interface{}{key: value}), "option %s", key)
+		}
+	})
+
+	t.Run("inherited options are described by the webOptions section", func(t *testing.T) {
+		req := require.New(t)
+
+		inheritedOptions := schema["properties"].(map[string]interface{})["webOptions"].(map[string]interface{})
+		serverOptions := serverSchema["properties"].(map[string]interface{})["options"].(map[string]interface{})
+		req.Equal(serverOptions["properties"], inheritedOptions["properties"])
+	})
+}
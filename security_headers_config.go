@@ -0,0 +1,151 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"github.com/openziti/xweb/v2/middleware"
+	"github.com/pkg/errors"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHstsMaxAge is the HSTS max-age of SecurityHeadersConfig's that do not configure one
+	DefaultHstsMaxAge = 365 * 24 * time.Hour
+
+	// MinHstsPreloadMaxAge is the minimum HSTS max-age accepted by browser preload lists
+	MinHstsPreloadMaxAge = 365 * 24 * time.Hour
+)
+
+// SecurityHeadersConfig represents the security headers of a ServerConfig. When present, the headers are set on all
+// responses of all bind points. Unless configured otherwise, HSTS (on TLS bind points only),
+// "X-Content-Type-Options: nosniff", and "X-Frame-Options: DENY" are sent. Content-Security-Policy and
+// Referrer-Policy are only sent if configured.
+type SecurityHeadersConfig struct {
+	Hsts                  bool
+	HstsMaxAge            time.Duration
+	HstsIncludeSubDomains bool
+	HstsPreload           bool
+	ContentTypeNosniff    bool
+	FrameOptions          string
+	ContentSecurityPolicy string
+	ReferrerPolicy        string
+}
+
+// Default sets the default values of a SecurityHeadersConfig.
+func (headers *SecurityHeadersConfig) Default() {
+	headers.Hsts = true
+	headers.HstsMaxAge = DefaultHstsMaxAge
+	headers.ContentTypeNosniff = true
+	headers.FrameOptions = middleware.FrameOptionsDeny
+}
+
+// Parse the configuration map for a SecurityHeadersConfig. Values that are not present keep their defaults.
+func (headers *SecurityHeadersConfig) Parse(config map[interface{}]interface{}) error {
+	headers.Default()
+
+	for _, option := range []struct {
+		key    string
+		target *bool
+	}{
+		{"hsts", &headers.Hsts},
+		{"hstsIncludeSubDomains", &headers.HstsIncludeSubDomains},
+		{"hstsPreload", &headers.HstsPreload},
+		{"contentTypeNosniff", &headers.ContentTypeNosniff},
+	} {
+		if interfaceVal, ok := config[option.key]; ok {
+			if value, ok := interfaceVal.(bool); ok {
+				*option.target = value
+			} else {
+				return fmt.Errorf("could not use value for %s, not a boolean", option.key)
+			}
+		}
+	}
+
+	for _, option := range []struct {
+		key    string
+		target *string
+	}{
+		{"frameOptions", &headers.FrameOptions},
+		{"contentSecurityPolicy", &headers.ContentSecurityPolicy},
+		{"referrerPolicy", &headers.ReferrerPolicy},
+	} {
+		if interfaceVal, ok := config[option.key]; ok {
+			if value, ok := interfaceVal.(string); ok {
+				*option.target = value
+			} else {
+				return fmt.Errorf("could not use value for %s, not a string", option.key)
+			}
+		}
+	}
+
+	if interfaceVal, ok := config["hstsMaxAge"]; ok {
+		if maxAgeStr, ok := interfaceVal.(string); ok {
+			if maxAge, err := time.ParseDuration(maxAgeStr); err == nil {
+				headers.HstsMaxAge = maxAge
+			} else {
+				return fmt.Errorf("could not parse hstsMaxAge %s as a duration (e.g. 8760h): %v", maxAgeStr, err)
+			}
+		} else {
+			return errors.New("could not use value for hstsMaxAge, not a string")
+		}
+	}
+
+	return nil
+}
+
+// Validate this configuration object.
+func (headers *SecurityHeadersConfig) Validate() error {
+	if headers.HstsMaxAge < 0 {
+		return fmt.Errorf("value [%s] for hstsMaxAge too low, must not be negative", headers.HstsMaxAge)
+	}
+
+	if headers.Hsts && headers.HstsPreload {
+		if !headers.HstsIncludeSubDomains {
+			return errors.New("hstsPreload requires hstsIncludeSubDomains to be true")
+		}
+
+		if headers.HstsMaxAge < MinHstsPreloadMaxAge {
+			return fmt.Errorf("value [%s] for hstsMaxAge too low, must be at least %s for hstsPreload", headers.HstsMaxAge, MinHstsPreloadMaxAge)
+		}
+	}
+
+	if headers.FrameOptions != "" && !strings.EqualFold(headers.FrameOptions, middleware.FrameOptionsDeny) && !strings.EqualFold(headers.FrameOptions, middleware.FrameOptionsSameOrigin) {
+		return fmt.Errorf("invalid value [%s] for frameOptions, must be %s, %s, or empty to disable", headers.FrameOptions, middleware.FrameOptionsDeny, middleware.FrameOptionsSameOrigin)
+	}
+
+	return nil
+}
+
+// MiddlewareOptions returns the middleware.SecurityHeadersOptions equivalent of this configuration.
+func (headers *SecurityHeadersConfig) MiddlewareOptions() *middleware.SecurityHeadersOptions {
+	options := &middleware.SecurityHeadersOptions{
+		ContentTypeNosniff:    headers.ContentTypeNosniff,
+		FrameOptions:          strings.ToUpper(headers.FrameOptions),
+		ContentSecurityPolicy: headers.ContentSecurityPolicy,
+		ReferrerPolicy:        headers.ReferrerPolicy,
+	}
+
+	if headers.Hsts {
+		options.HstsMaxAge = headers.HstsMaxAge
+		options.HstsIncludeSubDomains = headers.HstsIncludeSubDomains
+		options.HstsPreload = headers.HstsPreload
+	}
+
+	return options
+}
@@ -0,0 +1,133 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/openziti/xweb/v2/middleware"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestSecurityHeadersConfig_Parse(t *testing.T) {
+	t.Run("defaults are applied", func(t *testing.T) {
+		req := require.New(t)
+		headers := &SecurityHeadersConfig{}
+
+		req.NoError(headers.Parse(map[interface{}]interface{}{}))
+		req.NoError(headers.Validate())
+
+		options := headers.MiddlewareOptions()
+		req.Equal("max-age=31536000", options.StrictTransportSecurity())
+		req.True(options.ContentTypeNosniff)
+		req.Equal(middleware.FrameOptionsDeny, options.FrameOptions)
+		req.Empty(options.ContentSecurityPolicy)
+		req.Empty(options.ReferrerPolicy)
+	})
+
+	t.Run("all values are parsed", func(t *testing.T) {
+		req := require.New(t)
+		headers := &SecurityHeadersConfig{}
+
+		req.NoError(headers.Parse(map[interface{}]interface{}{
+			"hstsMaxAge":            "17520h",
+			"hstsIncludeSubDomains": true,
+			"hstsPreload":           true,
+			"contentTypeNosniff":    false,
+			"frameOptions":          "sameorigin",
+			"contentSecurityPolicy": "default-src 'self'",
+			"referrerPolicy":        "no-referrer",
+		}))
+		req.NoError(headers.Validate())
+
+		options := headers.MiddlewareOptions()
+		req.Equal("max-age=63072000; includeSubDomains; preload", options.StrictTransportSecurity())
+		req.False(options.ContentTypeNosniff)
+		req.Equal(middleware.FrameOptionsSameOrigin, options.FrameOptions)
+		req.Equal("default-src 'self'", options.ContentSecurityPolicy)
+		req.Equal("no-referrer", options.ReferrerPolicy)
+	})
+
+	t.Run("individual headers can be disabled", func(t *testing.T) {
+		req := require.New(t)
+		headers := &SecurityHeadersConfig{}
+
+		req.NoError(headers.Parse(map[interface{}]interface{}{
+			"hsts":         false,
+			"frameOptions": "",
+		}))
+		req.NoError(headers.Validate())
+
+		options := headers.MiddlewareOptions()
+		req.Empty(options.StrictTransportSecurity())
+		req.Empty(options.FrameOptions)
+		req.True(options.ContentTypeNosniff)
+	})
+
+	t.Run("invalid types are rejected", func(t *testing.T) {
+		req := require.New(t)
+
+		err := (&SecurityHeadersConfig{}).Parse(map[interface{}]interface{}{"hsts": "yes"})
+		req.EqualError(err, "could not use value for hsts, not a boolean")
+
+		err = (&SecurityHeadersConfig{}).Parse(map[interface{}]interface{}{"frameOptions": 1})
+		req.EqualError(err, "could not use value for frameOptions, not a string")
+
+		err = (&SecurityHeadersConfig{}).Parse(map[interface{}]interface{}{"hstsMaxAge": "forever"})
+		req.Error(err)
+	})
+}
+
+func TestSecurityHeadersConfig_Validate(t *testing.T) {
+	t.Run("negative max age is rejected", func(t *testing.T) {
+		req := require.New(t)
+		headers := &SecurityHeadersConfig{}
+		headers.Default()
+		headers.HstsMaxAge = -time.Second
+
+		req.Error(headers.Validate())
+	})
+
+	t.Run("preload requires includeSubDomains", func(t *testing.T) {
+		req := require.New(t)
+		headers := &SecurityHeadersConfig{}
+		headers.Default()
+		headers.HstsPreload = true
+
+		req.EqualError(headers.Validate(), "hstsPreload requires hstsIncludeSubDomains to be true")
+	})
+
+	t.Run("preload requires a max age of at least a year", func(t *testing.T) {
+		req := require.New(t)
+		headers := &SecurityHeadersConfig{}
+		headers.Default()
+		headers.HstsPreload = true
+		headers.HstsIncludeSubDomains = true
+		headers.HstsMaxAge = time.Hour
+
+		req.Error(headers.Validate())
+	})
+
+	t.Run("unknown frame options are rejected", func(t *testing.T) {
+		req := require.New(t)
+		headers := &SecurityHeadersConfig{}
+		headers.Default()
+		headers.FrameOptions = "ALLOW-FROM https://example.com"
+
+		req.Error(headers.Validate())
+	})
+}
@@ -19,16 +19,25 @@ package xweb
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"github.com/michaelquigley/pfxlog"
 	"github.com/openziti/foundation/v2/debugz"
+	"github.com/openziti/identity"
 	transporttls "github.com/openziti/transport/v2/tls"
-	"github.com/openziti/xweb/v2/middleware"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 type ContextKey string
@@ -41,17 +50,44 @@ type ServerContext struct {
 	BindPoint    *BindPointConfig
 	ServerConfig *ServerConfig
 	Config       *InstanceConfig
+
+	// Handler is the ApiHandler the demux selected for the request, nil until the request is routed. Middleware that
+	// wraps the demux observes it once the wrapped http.Handler returns.
+	Handler ApiHandler
+
+	//true for the copy of a bind point's ServerContext made for a single request, which may be updated in place
+	perRequest bool
+}
+
+// BindPointName returns the name of the bind point that accepted the request, see BindPointConfig.GetName. An empty
+// string is returned if the ServerContext has no BindPoint.
+func (serverContext *ServerContext) BindPointName() string {
+	if serverContext.BindPoint == nil {
+		return ""
+	}
+
+	return serverContext.BindPoint.GetName()
 }
 
 type namedHttpServer struct {
 	*http.Server
+	Name            string //the name of the bind point, see BindPointConfig.GetName
 	ApiBindingList  []string
 	BindPointConfig *BindPointConfig
 	ServerConfig    *ServerConfig
 	InstanceConfig  *InstanceConfig
+
+	listening   atomic.Bool
+	connections *connectionLimiter
+	shutdownCtx context.Context
 }
 
-func (s namedHttpServer) NewBaseContext(_ net.Listener) context.Context {
+// ConnectionCount returns the number of connections accepted on the bind point that are still open.
+func (s *namedHttpServer) ConnectionCount() int64 {
+	return s.connections.count.Load()
+}
+
+func (s *namedHttpServer) NewBaseContext(_ net.Listener) context.Context {
 	serverContext := &ServerContext{
 		BindPoint:    s.BindPointConfig,
 		ServerConfig: s.ServerConfig,
@@ -61,6 +97,10 @@ func (s namedHttpServer) NewBaseContext(_ net.Listener) context.Context {
 	ctx := context.Background()
 	ctx = context.WithValue(ctx, ServerContextKey, serverContext)
 
+	if s.shutdownCtx != nil {
+		ctx = context.WithValue(ctx, ShutdownContextKey, s.shutdownCtx)
+	}
+
 	return ctx
 }
 
@@ -73,7 +113,43 @@ type Server struct {
 	config         interface{}
 	Handle         http.Handler
 	OnHandlerPanic func(writer http.ResponseWriter, request *http.Request, panicVal interface{})
-	ServerConfig   *ServerConfig
+
+	// OnHandlerPanicWithStack, if set, is called instead of OnHandlerPanic with the recovered panic value and the
+	// stack of the panicking goroutine.
+	OnHandlerPanicWithStack func(writer http.ResponseWriter, request *http.Request, panicVal interface{}, stack string)
+
+	ServerConfig *ServerConfig
+	ocspStapler  *ocspStapler
+	crlChecker   *crlChecker
+	tlsKeyLog    io.Closer
+
+	instanceOptions *InstanceOptions
+	shuttingDown    atomic.Bool
+	shutdownCtx     context.Context
+	cancelShutdown  context.CancelFunc
+
+	startErrLock sync.Mutex
+	startErr     error
+
+	listenLock sync.Mutex
+	listeners  []net.Listener
+	handoffs   []*handoffListener
+	takeover   *handoffListeners
+
+	tlsVersionOptions atomic.Pointer[TlsVersionOptions]
+	connDeadlines     *connDeadlines
+	requestDeadline   atomic.Pointer[requestDeadline]
+	handlerTimeouts   atomic.Pointer[handlerTimeouts]
+
+	inFlight *inFlightTracker
+
+	apiMiddleware *apiMiddleware
+
+	healthCheckers []HealthCheckingApiHandler
+
+	lifecycleLock     sync.Mutex
+	lifecycleHandlers []LifecycleApiHandler
+	startedHandlers   []LifecycleApiHandler
 }
 
 // NewServer creates a new Server from a ServerConfig. All necessary http.Handler's will be created from the supplied
@@ -81,62 +157,168 @@ type Server struct {
 func NewServer(instance Instance, serverConfig *ServerConfig) (*Server, error) {
 	logWriter := pfxlog.Logger().Writer()
 
-	tlsConfig := serverConfig.Identity.ServerTLSConfig()
-	tlsConfig.ClientAuth = tls.RequestClientCert
-	tlsConfig.MinVersion = uint16(serverConfig.Options.MinTLSVersion)
-	tlsConfig.MaxVersion = uint16(serverConfig.Options.MaxTLSVersion)
-
 	server := &Server{
-		logWriter:    logWriter,
-		config:       &serverConfig,
-		httpServers:  []*namedHttpServer{},
-		ServerConfig: serverConfig,
+		logWriter:       logWriter,
+		config:          &serverConfig,
+		httpServers:     []*namedHttpServer{},
+		ServerConfig:    serverConfig,
+		instanceOptions: &instance.GetConfig().Options,
+	}
+
+	server.shutdownCtx, server.cancelShutdown = context.WithCancel(context.Background())
+
+	tlsVersionOptions := serverConfig.Options.TlsVersionOptions
+	server.tlsVersionOptions.Store(&tlsVersionOptions)
+	server.connDeadlines = newConnDeadlines(&serverConfig.Options)
+	server.requestDeadline.Store(newRequestDeadline(&serverConfig.Options))
+	server.handlerTimeouts.Store(newHandlerTimeouts(&serverConfig.Options, serverConfig.APIs))
+
+	if serverConfig.Options.TrackInFlightRequests {
+		server.inFlight = &inFlightTracker{}
+	}
+
+	var tlsConfig *tls.Config
+
+	if server.instanceOptions.InsecureNoTLS {
+		if err := enableInsecureNoTls(serverConfig); err != nil {
+			return nil, fmt.Errorf("error creating server: %v", err)
+		}
+	} else {
+		var err error
+		if tlsConfig, err = server.newTlsConfig(); err != nil {
+			return nil, fmt.Errorf("error creating server: %v", err)
+		}
 	}
 
 	server.SetParent(instance)
 
+	apiMiddleware, err := newApiMiddleware(serverConfig, server.instanceOptions.ApiMiddleware)
+	if err != nil {
+		return nil, fmt.Errorf("error creating server: %v", err)
+	}
+	server.apiMiddleware = apiMiddleware
+
 	var handlers []ApiHandler
 	var apiBindingList []string
+	handlerMap := map[string]ApiHandler{}
+	bindingOrder := map[string]int{}
+	disabledBindings := map[string]bool{}
+
+	//handlers are built in priority order, which DemuxFactory's use for matching and default selection
+	for _, api := range serverConfig.prioritizedApis() {
+		if !api.Enabled() {
+			disabledBindings[api.Binding()] = true
+			pfxlog.Logger().Infof("api binding [%s] of server %s is disabled, skipping", api.Binding(), serverConfig.Name)
+			continue
+		}
 
-	for _, api := range serverConfig.APIs {
 		if apiFactory := instance.GetRegistry().Get(api.Binding()); apiFactory != nil {
 			if handler, err := apiFactory.New(serverConfig, api.Options()); err != nil {
-				pfxlog.Logger().Fatalf("encountered error building handler for api binding [%s]: %v", api.Binding(), err)
+				return nil, fmt.Errorf("error creating server: error building handler for api binding [%s]: %v", api.Binding(), err)
 			} else {
 				handlers = append(handlers, handler)
 				apiBindingList = append(apiBindingList, api.binding)
+
+				if lifecycleHandler, ok := handler.(LifecycleApiHandler); ok {
+					server.lifecycleHandlers = append(server.lifecycleHandlers, lifecycleHandler)
+				}
+
+				if _, ok := handlerMap[api.binding]; !ok {
+					handlerMap[api.binding] = handler
+					bindingOrder[api.binding] = len(bindingOrder)
+
+					if healthChecker, ok := handler.(HealthCheckingApiHandler); ok {
+						server.healthCheckers = append(server.healthCheckers, healthChecker)
+					}
+				}
 			}
 		} else {
-			pfxlog.Logger().Fatalf("encountered api binding [%s] which has no associated factory registered", api.Binding())
+			return nil, fmt.Errorf("error creating server: api binding [%s] has no associated factory registered", api.Binding())
 		}
 	}
 
-	demuxHandler, err := instance.GetDemuxFactory().Build(handlers)
+	//BindPointDemuxFactory's build a DemuxHandler per bind point instead of one shared by all bind points
+	_, perBindPoint := instance.GetDemuxFactory().(BindPointDemuxFactory)
 
-	if err != nil {
-		return nil, fmt.Errorf("error creating server: %v", err)
-	}
+	var demuxHandler DemuxHandler
 
-	demuxHandler.SetParent(server)
+	if !perBindPoint {
+		if demuxHandler, err = server.buildDemux(instance, nil, handlers); err != nil {
+			return nil, fmt.Errorf("error creating server: %v", err)
+		}
+	}
 
 	for _, bindPoint := range serverConfig.BindPoints {
+		bindPointHandler, bindPointBindingList := http.Handler(demuxHandler), apiBindingList
+
+		if len(bindPoint.APIs) > 0 {
+			//bindings of disabled apis are dropped, validation ensures at least one remains
+			bindPointBindingList = nil
+			for _, binding := range bindPoint.APIs {
+				if _, ok := handlerMap[binding]; ok || !disabledBindings[binding] {
+					bindPointBindingList = append(bindPointBindingList, binding)
+				}
+			}
+
+			//served in priority order, as are the apis of bind points that do not list them
+			sort.SliceStable(bindPointBindingList, func(i, j int) bool {
+				iOrder, iOk := bindingOrder[bindPointBindingList[i]]
+				jOrder, jOk := bindingOrder[bindPointBindingList[j]]
+				return iOk && (!jOk || iOrder < jOrder)
+			})
+
+			if bindPointHandler, err = server.newDedicatedHandler(instance, bindPoint, bindPointBindingList, handlerMap); err != nil {
+				return nil, fmt.Errorf("error creating server: %v", err)
+			}
+		} else if perBindPoint {
+			if bindPointHandler, err = server.buildDemux(instance, bindPoint, handlers); err != nil {
+				return nil, fmt.Errorf("error creating server: %v", err)
+			}
+		}
+
+		bindPointTlsConfig := tlsConfig
+
+		if bindPoint.DisableTLS {
+			bindPointTlsConfig = nil
+		} else if tlsConfig != nil {
+			if bindPointTlsConfig, err = server.newBindPointTlsConfig(bindPoint, tlsConfig); err != nil {
+				return nil, fmt.Errorf("error creating server: %v", err)
+			}
+		}
+
+		if bindPoint.RedirectHttps {
+			bindPointHandler, bindPointBindingList = newHttpsRedirectHandler(bindPoint.Address), nil
+		}
+
+		handler, err := server.wrapHandler(serverConfig, bindPoint, bindPointHandler)
+		if err != nil {
+			return nil, fmt.Errorf("error creating server: %v", err)
+		}
+
+		if bindPoint.H2c && bindPointTlsConfig == nil {
+			//prior knowledge and upgraded HTTP/2 connections are served by the http2.Server, all others by http.Server
+			handler = h2c.NewHandler(handler, &http2.Server{IdleTimeout: serverConfig.Options.IdleTimeout})
+		}
+
 		namedServer := &namedHttpServer{
-			ApiBindingList:  apiBindingList,
+			Name:            bindPoint.GetName(),
+			ApiBindingList:  bindPointBindingList,
 			ServerConfig:    serverConfig,
 			BindPointConfig: bindPoint,
 			InstanceConfig:  instance.GetConfig(),
+			shutdownCtx:     server.shutdownCtx,
 			Server: &http.Server{
-				Addr:         bindPoint.InterfaceAddress,
-				WriteTimeout: serverConfig.Options.WriteTimeout,
-				ReadTimeout:  serverConfig.Options.ReadTimeout,
-				IdleTimeout:  serverConfig.Options.IdleTimeout,
-				Handler:      server.wrapHandler(serverConfig, bindPoint, demuxHandler),
-				TLSConfig:    tlsConfig,
-				ErrorLog:     log.New(logWriter, "", 0),
+				Addr:           bindPoint.InterfaceAddress,
+				MaxHeaderBytes: serverConfig.Options.MaxHeaderBytes,
+				Handler:        handler,
+				TLSConfig:      bindPointTlsConfig,
+				ErrorLog:       server.newErrorLog(bindPoint),
 			},
 		}
 
 		namedServer.BaseContext = namedServer.NewBaseContext
+		namedServer.connections = newConnectionLimiter(bindPoint.MaxConnections)
+		namedServer.ConnState = newConnStateHook(namedServer.connections, server.connDeadlines, server.instanceOptions.OnConnState, bindPoint)
 
 		server.httpServers = append(server.httpServers, namedServer)
 	}
@@ -144,43 +326,467 @@ func NewServer(instance Instance, serverConfig *ServerConfig) (*Server, error) {
 	return server, nil
 }
 
-func (server *Server) wrapHandler(_ *ServerConfig, point *BindPointConfig, handler http.Handler) http.Handler {
-	//innermost/bottom -> outermost/top
-	handler = server.wrapSetCtrlAddressHeader(point, handler)
-	handler = server.wrapPanicRecovery(handler)
-	handler = middleware.NewCompressionHandler(handler)
-	return handler
+// newErrorLog returns the http.Server ErrorLog of a bind point, from InstanceOptions.ErrorLog if it is set and
+// returns one, otherwise one that writes to the default logger, filtering benign TLS handshake errors if the
+// filterHandshakeErrors option is enabled.
+func (server *Server) newErrorLog(bindPoint *BindPointConfig) *log.Logger {
+	if server.instanceOptions.ErrorLog != nil {
+		if errorLog := server.instanceOptions.ErrorLog(server.ServerConfig, bindPoint); errorLog != nil {
+			return errorLog
+		}
+	}
+
+	if server.ServerConfig.Options.FilterHandshakeErrors {
+		return log.New(newHandshakeErrorFilter(server.ServerConfig.Name, server.logWriter), "", 0)
+	}
+
+	return log.New(server.logWriter, "", 0)
+}
+
+// newConnStateHook returns a http.Server ConnState hook that accounts connections with limiter, applies the timeouts
+// of deadlines and then, if set, notifies onConnState with the supplied BindPointConfig. Replacing the ConnState of the
+// http.Server (e.g. via a mutator) bypasses connection accounting and timeouts, use InstanceOptions.OnConnState
+// instead.
+func newConnStateHook(limiter *connectionLimiter, deadlines *connDeadlines, onConnState func(net.Conn, http.ConnState, *BindPointConfig), bindPoint *BindPointConfig) func(net.Conn, http.ConnState) {
+	return func(conn net.Conn, state http.ConnState) {
+		limiter.onConnState(conn, state)
+		deadlines.onConnState(conn, state)
+
+		if onConnState != nil {
+			onConnState(conn, state, bindPoint)
+		}
+	}
+}
+
+// newDedicatedHandler creates the http.Handler for a bind point that lists the APIs it serves, bindings being the
+// enabled ones. A bind point that serves a single API serves it directly without demultiplexing. A bind point that
+// serves multiple APIs serves them via its own DemuxHandler.
+func (server *Server) newDedicatedHandler(instance Instance, bindPoint *BindPointConfig, bindings []string, handlerMap map[string]ApiHandler) (http.Handler, error) {
+	var handlers []ApiHandler
+
+	for _, binding := range bindings {
+		handler, ok := handlerMap[binding]
+
+		if !ok {
+			return nil, fmt.Errorf("bind point [%s] lists api binding [%s] which is not an api of the server", bindPoint.InterfaceAddress, binding)
+		}
+
+		handlers = append(handlers, handler)
+	}
+
+	if len(handlers) == 1 {
+		handler := handlers[0]
+		return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			serveApiHandler(handler, writer, request)
+		}), nil
+	}
+
+	demuxHandler, err := server.buildDemux(instance, bindPoint, handlers)
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating handler for bind point [%s]: %v", bindPoint.InterfaceAddress, err)
+	}
+
+	return demuxHandler, nil
+}
+
+// buildDemux builds a DemuxHandler for handlers with the Instance's DemuxFactory. If the DemuxFactory is a
+// BindPointDemuxFactory and bindPoint is not nil, the DemuxHandler is built for that bind point.
+func (server *Server) buildDemux(instance Instance, bindPoint *BindPointConfig, handlers []ApiHandler) (DemuxHandler, error) {
+	if err := server.requireDefaultApi(handlers); err != nil {
+		return nil, err
+	}
+
+	factory := instance.GetDemuxFactory()
+
+	var demuxHandler DemuxHandler
+	var err error
+
+	if bindPointFactory, ok := factory.(BindPointDemuxFactory); ok && bindPoint != nil {
+		demuxHandler, err = bindPointFactory.BuildForBindPoint(server.ServerConfig, bindPoint, handlers)
+	} else {
+		demuxHandler, err = factory.Build(handlers)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	demuxHandler.SetParent(server)
+
+	return server.applyDefaultApi(demuxHandler, handlers)
+}
+
+// requireDefaultApi returns an error if ServerConfig.RequireDefaultApi is set and neither ServerConfig.DefaultApi nor
+// an ApiHandler declaring itself the default is among handlers.
+func (server *Server) requireDefaultApi(handlers []ApiHandler) error {
+	if !server.ServerConfig.RequireDefaultApi {
+		return nil
+	}
+
+	for _, handler := range handlers {
+		if server.ServerConfig.DefaultApi != "" && handler.Binding() == server.ServerConfig.DefaultApi {
+			return nil
+		}
+	}
+
+	declared, err := getDeclaredDefault(handlers)
+	if err != nil {
+		return err
+	}
+
+	if declared == nil {
+		var bindings []string
+		for _, handler := range handlers {
+			bindings = append(bindings, handler.Binding())
+		}
+
+		return fmt.Errorf("requireDefaultApi is set but no default api is declared for apis [%s], set defaultApi or declare a default via DefaultApiHandler", strings.Join(bindings, ", "))
+	}
+
+	return nil
+}
+
+// applyDefaultApi wraps demuxHandler to serve unmatched requests with the ApiHandler of ServerConfig.DefaultApi, if set
+// and one of handlers.
+func (server *Server) applyDefaultApi(demuxHandler DemuxHandler, handlers []ApiHandler) (DemuxHandler, error) {
+	if server.ServerConfig.DefaultApi == "" {
+		return demuxHandler, nil
+	}
+
+	for _, handler := range handlers {
+		if handler.Binding() != server.ServerConfig.DefaultApi {
+			continue
+		}
+
+		matchingDemuxHandler, ok := demuxHandler.(MatchingDemuxHandler)
+		if !ok {
+			return nil, fmt.Errorf("defaultApi [%s] is not supported by DemuxHandler %T, it must be a MatchingDemuxHandler", server.ServerConfig.DefaultApi, demuxHandler)
+		}
+
+		return &defaultApiDemuxHandler{MatchingDemuxHandler: matchingDemuxHandler, defaultApi: handler}, nil
+	}
+
+	return demuxHandler, nil
 }
 
-// wrapPanicRecovery wraps a http.Handler with another http.Handler that provides recovery.
+// newTlsConfig creates the tls.Config shared by all bind points of the Server. It presents the ServerConfig's Identity
+// or, if the client requested a matching server name, one of its SNI Identities and applies OCSP stapling and the
+// Server's current TlsVersionOptions.
+func (server *Server) newTlsConfig() (*tls.Config, error) {
+	serverConfig := server.ServerConfig
+
+	tlsConfig := newServerTlsConfig(serverConfig.Identity, &serverConfig.Options)
+
+	var clientCas *x509.CertPool
+	if serverConfig.ClientCas != "" {
+		var err error
+		if clientCas, err = LoadClientCas(serverConfig.ClientCas); err != nil {
+			return nil, fmt.Errorf("could not load clientCas of server [%s]: %v", serverConfig.Name, err)
+		}
+
+		tlsConfig.ClientCAs = clientCas
+	}
+
+	if serverConfig.Options.OcspStapling {
+		identities := []identity.Identity{serverConfig.Identity}
+		for _, sniIdentity := range serverConfig.Identities {
+			identities = append(identities, sniIdentity)
+		}
+
+		server.ocspStapler = newOcspStapler(serverConfig.Name, identities)
+		tlsConfig.GetCertificate = server.ocspStapler.staple(tlsConfig.GetCertificate)
+	}
+
+	if serverConfig.Options.ClientCrl != "" {
+		var crlIssuers []*x509.Certificate
+		var err error
+		if serverConfig.ClientCas != "" {
+			if crlIssuers, err = identity.LoadCert(serverConfig.ClientCas); err != nil {
+				return nil, fmt.Errorf("could not load clientCas of server [%s]: %v", serverConfig.Name, err)
+			}
+		}
+
+		if server.crlChecker, err = newCrlChecker(serverConfig.Name, serverConfig.Options.ClientCrl, serverConfig.Options.ClientCrlRefreshInterval, crlIssuers); err != nil {
+			return nil, fmt.Errorf("could not load clientCrl of server [%s]: %v", serverConfig.Name, err)
+		}
+
+		tlsConfig.VerifyPeerCertificate = server.crlChecker.verifyPeerCertificate
+	}
+
+	tlsKeyLogFile := serverConfig.Options.TlsKeyLogFile
+	if tlsKeyLogFile == "" {
+		tlsKeyLogFile = server.instanceOptions.TlsKeyLogFile
+	}
+
+	if tlsKeyLogFile != "" {
+		tlsKeyLog, err := openTlsKeyLog(serverConfig.Name, tlsKeyLogFile)
+		if err != nil {
+			return nil, err
+		}
+
+		server.tlsKeyLog = tlsKeyLog
+		tlsConfig.KeyLogWriter = tlsKeyLog
+	}
+
+	if len(serverConfig.Identities) > 0 {
+		sniConfigs := map[string]*tls.Config{}
+		for serverName, sniIdentity := range serverConfig.Identities {
+			sniConfig := newServerTlsConfig(sniIdentity, &serverConfig.Options)
+
+			if clientCas != nil {
+				sniConfig.ClientCAs = clientCas
+			}
+
+			if server.ocspStapler != nil {
+				sniConfig.GetCertificate = server.ocspStapler.staple(sniConfig.GetCertificate)
+			}
+
+			if server.crlChecker != nil {
+				sniConfig.VerifyPeerCertificate = server.crlChecker.verifyPeerCertificate
+			}
+
+			sniConfig.KeyLogWriter = tlsConfig.KeyLogWriter
+
+			sniConfigs[serverName] = sniConfig
+		}
+		tlsConfig.GetConfigForClient = newSniGetConfigForClient(tlsConfig.GetConfigForClient, sniConfigs)
+	}
+
+	tlsConfig.GetConfigForClient = server.newTlsVersionGetConfigForClient(tlsConfig, tlsConfig.GetConfigForClient)
+
+	return tlsConfig, nil
+}
+
+// newBindPointTlsConfig returns the tls.Config of a bind point, tlsConfig unless the bind point requires a clone to
+// apply its client authentication policy and client CAs or notify InstanceOptions.OnClientHello.
+func (server *Server) newBindPointTlsConfig(bindPoint *BindPointConfig, tlsConfig *tls.Config) (*tls.Config, error) {
+	clientAuth := bindPoint.ClientAuth()
+	onClientHello := server.instanceOptions.OnClientHello
+
+	var clientCas *x509.CertPool
+	if bindPoint.ClientCas != "" {
+		var err error
+		if clientCas, err = LoadClientCas(bindPoint.ClientCas); err != nil {
+			return nil, fmt.Errorf("could not load clientCas of bind point [%s]: %v", bindPoint.InterfaceAddress, err)
+		}
+	}
+
+	if clientAuth == tlsConfig.ClientAuth && clientCas == nil && onClientHello == nil {
+		return tlsConfig, nil
+	}
+
+	bindPointTlsConfig := tlsConfig.Clone()
+
+	if clientAuth != tlsConfig.ClientAuth || clientCas != nil {
+		bindPointTlsConfig.ClientAuth = clientAuth
+
+		if clientCas != nil {
+			bindPointTlsConfig.ClientCAs = clientCas
+		}
+
+		bindPointTlsConfig.GetConfigForClient = newClientAuthGetConfigForClient(clientAuth, clientCas, bindPointTlsConfig.GetConfigForClient)
+	}
+
+	if onClientHello != nil {
+		bindPointTlsConfig.GetConfigForClient = newClientHelloNotifier(onClientHello, bindPoint, bindPointTlsConfig.GetConfigForClient)
+	}
+
+	return bindPointTlsConfig, nil
+}
+
+// newClientAuthGetConfigForClient returns a function suitable for tls.Config.GetConfigForClient that applies
+// clientAuth and, if not nil, clientCas to the tls.Config selected by next, e.g. the tls.Config of an SNI identity.
+func newClientAuthGetConfigForClient(clientAuth tls.ClientAuthType, clientCas *x509.CertPool, next func(*tls.ClientHelloInfo) (*tls.Config, error)) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if next == nil {
+			return nil, nil
+		}
+
+		config, err := next(hello)
+
+		if err != nil || config == nil {
+			return config, err
+		}
+
+		if config.ClientAuth == clientAuth && (clientCas == nil || config.ClientCAs == clientCas) {
+			return config, nil
+		}
+
+		config = config.Clone()
+		config.ClientAuth = clientAuth
+
+		if clientCas != nil {
+			config.ClientCAs = clientCas
+		}
+
+		return config, nil
+	}
+}
+
+// newServerTlsConfig creates a tls.Config for the supplied identity.Identity with the TLS options from Options applied.
+// It advertises HTTP/2 and HTTP/1.1 via ALPN, so every tls.Config selected during a handshake (i.e. by SNI) offers
+// the same protocols.
+func newServerTlsConfig(id identity.Identity, options *Options) *tls.Config {
+	tlsConfig := id.ServerTLSConfig()
+	tlsConfig.NextProtos = []string{"h2", "http/1.1"}
+	tlsConfig.ClientAuth = tls.RequestClientCert
+	tlsConfig.MinVersion = uint16(options.MinTLSVersion)
+	tlsConfig.MaxVersion = uint16(options.MaxTLSVersion)
+	tlsConfig.CurvePreferences = options.CurvePreferences
+
+	return tlsConfig
+}
+
+// newSniGetConfigForClient returns a function suitable for tls.Config.GetConfigForClient that selects a tls.Config
+// from sniConfigs by the server name (SNI) requested by the client. If the client did not request a server name or
+// no tls.Config is present for it, defaultGetConfigForClient is used if not nil. Returning a nil tls.Config
+// defers to the tls.Config the function was installed on.
+func newSniGetConfigForClient(defaultGetConfigForClient func(*tls.ClientHelloInfo) (*tls.Config, error), sniConfigs map[string]*tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		if sniConfig, ok := sniConfigs[strings.ToLower(hello.ServerName)]; ok {
+			return sniConfig, nil
+		}
+
+		if defaultGetConfigForClient != nil {
+			return defaultGetConfigForClient(hello)
+		}
+
+		return nil, nil
+	}
+}
+
+// newClientHelloNotifier returns a function suitable for tls.Config.GetConfigForClient that invokes onClientHello for
+// the supplied BindPointConfig and then defers to next, if not nil, to select the tls.Config used for the handshake.
+func newClientHelloNotifier(onClientHello func(*tls.ClientHelloInfo, *BindPointConfig), bindPoint *BindPointConfig, next func(*tls.ClientHelloInfo) (*tls.Config, error)) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		onClientHello(hello, bindPoint)
+
+		if next != nil {
+			return next(hello)
+		}
+
+		return nil, nil
+	}
+}
+
+// newTlsVersionGetConfigForClient returns a function suitable for tls.Config.GetConfigForClient that applies the
+// Server's current TlsVersionOptions to the tls.Config selected by next (or base if next is nil or selects none). This
+// allows UpdateOptions to alter TLS versions and curve preferences for new handshakes without rebinding.
+func (server *Server) newTlsVersionGetConfigForClient(base *tls.Config, next func(*tls.ClientHelloInfo) (*tls.Config, error)) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		config := base
+
+		if next != nil {
+			selected, err := next(hello)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if selected != nil {
+				config = selected
+			}
+		}
+
+		options := server.tlsVersionOptions.Load()
+
+		if config.MinVersion == uint16(options.MinTLSVersion) && config.MaxVersion == uint16(options.MaxTLSVersion) &&
+			reflect.DeepEqual(config.CurvePreferences, options.CurvePreferences) {
+			return config, nil
+		}
+
+		config = config.Clone()
+		config.MinVersion = uint16(options.MinTLSVersion)
+		config.MaxVersion = uint16(options.MaxTLSVersion)
+		config.CurvePreferences = options.CurvePreferences
+
+		return config, nil
+	}
+}
+
+// wrapHandler wraps the handler of a bind point with its middleware chain, see InstanceOptions.Middleware
+func (server *Server) wrapHandler(_ *ServerConfig, point *BindPointConfig, handler http.Handler) (http.Handler, error) {
+	chain, err := server.defaultMiddleware(point)
+	if err != nil {
+		return nil, err
+	}
+
+	if server.instanceOptions.Middleware != nil {
+		chain = server.instanceOptions.Middleware(server, point, chain)
+	}
+
+	//outermost first, so wrap from the innermost
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i].Wrap(handler)
+	}
+
+	handler = server.wrapServerContext(handler)
+	handler = server.connDeadlines.wrap(handler)
+	return handler, nil
+}
+
+// wrapServerContext wraps a http.Handler with another http.Handler that gives each request its own copy of the bind
+// point's ServerContext, so that the demux can record the selected ApiHandler for all middleware.
+func (server *Server) wrapServerContext(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if serverContext := ServerContextFromRequestContext(request.Context()); serverContext != nil {
+			requestServerContext := *serverContext
+			requestServerContext.perRequest = true
+			request = request.WithContext(context.WithValue(request.Context(), ServerContextKey, &requestServerContext))
+		}
+
+		handler.ServeHTTP(writer, request)
+	})
+}
+
+// wrapPanicRecovery wraps a http.Handler with another http.Handler that provides recovery. If neither
+// OnHandlerPanicWithStack nor OnHandlerPanic is set, the panic is logged with its stack and, if the handler had not
+// started a response, a 500 Internal Server Error is written.
 func (server *Server) wrapPanicRecovery(handler http.Handler) http.Handler {
 	wrappedHandler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		recoveryWriter := &panicRecoveryResponseWriter{ResponseWriter: writer}
+
 		defer func() {
 			if panicVal := recover(); panicVal != nil {
+				if server.OnHandlerPanicWithStack != nil {
+					server.OnHandlerPanicWithStack(writer, request, panicVal, debugz.GenerateLocalStack())
+					return
+				}
 				if server.OnHandlerPanic != nil {
 					server.OnHandlerPanic(writer, request, panicVal)
 					return
 				}
 				pfxlog.Logger().Errorf("panic caught by server handler: %v\n%v", panicVal, debugz.GenerateLocalStack())
+
+				if !recoveryWriter.started {
+					http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
 			}
 		}()
 
-		handler.ServeHTTP(writer, request)
+		handler.ServeHTTP(recoveryWriter, request)
 	})
 
 	return wrappedHandler
 }
 
-// wrapSetCtrlAddressHeader will check to see if the bindPoint is configured to advertise a "new address". If so
-// the value is added to the ZitiCtrlAddressHeader which will be sent out on every response. Clients can check this
-// header to be notified that the controller is or will be moving from one ip/hostname to another. When the
-// new address value is set, both the old and new addresses should be valid as the clients will begin using the
-// new address on their next connect.
+// wrapSetCtrlAddressHeader will check to see if the bindPoint is configured to advertise "new addresses". If so
+// the values are added to the ZitiCtrlAddressHeader, comma separated in order of preference, which will be sent out on
+// every response or, if the bind point lists NewAddressPaths, on responses to requests for those paths. Clients can
+// check this header to be notified that the controller is or will be moving from one ip/hostname to another. When new
+// address values are set, both the old and new addresses should be valid as the clients will begin using the first
+// reachable new address on their next connect.
 func (server *Server) wrapSetCtrlAddressHeader(point *BindPointConfig, handler http.Handler) http.Handler {
+	var addresses []string
+	for _, newAddress := range point.AllNewAddresses() {
+		addresses = append(addresses, "https://"+newAddress)
+	}
+	headerValue := strings.Join(addresses, ",")
+
 	wrappedHandler := http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
-		if point.NewAddress != "" {
-			address := "https://" + point.NewAddress
-			writer.Header().Set(ZitiCtrlAddressHeader, address)
+		if headerValue != "" && point.SendsNewAddressesFor(request.URL.Path) {
+			writer.Header().Set(ZitiCtrlAddressHeader, headerValue)
 		}
 
 		handler.ServeHTTP(writer, request)
@@ -189,38 +795,505 @@ func (server *Server) wrapSetCtrlAddressHeader(point *BindPointConfig, handler h
 	return wrappedHandler
 }
 
-// Start the server and all underlying http.Server's
-func (server *Server) Start() error {
-	logger := pfxlog.Logger()
+// Listen binds all bind points of the server synchronously. If a bind point cannot be bound, the bind points already
+// bound are closed and an error is returned. Calling Listen on a Server that is already bound has no effect. Listen is
+// called by InstanceImpl.Build so that bind failures (e.g. an address already in use) are reported before any server
+// is started.
+func (server *Server) Listen() (err error) {
+	server.listenLock.Lock()
+	defer server.listenLock.Unlock()
+
+	if server.listeners != nil {
+		return nil
+	}
+
+	defer func() {
+		if err != nil {
+			server.setStartError(err)
+		}
+	}()
+
+	var listeners []net.Listener
+	var handoffs []*handoffListener
 
 	for _, httpServer := range server.httpServers {
-		logger.Infof("starting ApiConfig to listen and serve tls on %s for server %s with APIs: %v", httpServer.Addr, httpServer.ServerConfig.Name, httpServer.ApiBindingList)
+		var l net.Listener
+		handoff, err := server.listen(httpServer)
+		if err == nil {
+			l = newConnectionLimitListener(handoff.view(), httpServer.connections)
+			l, err = server.mutateListener(httpServer, l)
+		}
 
-		cfg := httpServer.TLSConfig
-		// make sure to listen to the expected protocols
-		cfg.NextProtos = append(cfg.NextProtos, "h2", "http/1.1", "")
-		l, err := transporttls.ListenTLS(httpServer.Addr, httpServer.ServerConfig.Name, cfg)
 		if err != nil {
+			for _, listener := range listeners {
+				_ = listener.Close()
+			}
 			return fmt.Errorf("error listening: %s", err)
 		}
-		err = httpServer.Serve(l)
 
-		if !errors.Is(err, http.ErrServerClosed) {
-			return fmt.Errorf("error listening: %s", err)
+		listeners = append(listeners, l)
+		handoffs = append(handoffs, handoff)
+	}
+
+	server.listeners = listeners
+	server.handoffs = handoffs
+
+	return nil
+}
+
+// takeOverBindPoints makes the bind points the Server took over from the Server it replaces, see Reload, perform
+// TLS handshakes with the tls.Config's of the Server.
+func (server *Server) takeOverBindPoints() {
+	server.listenLock.Lock()
+	defer server.listenLock.Unlock()
+
+	for i, handoff := range server.handoffs {
+		handoff.setTlsConfig(server.httpServers[i].TLSConfig)
+	}
+}
+
+// BoundAddresses returns the addresses of the listeners of all bind points, in the order of the ServerConfig's bind
+// points, once they are bound by Listen. Unlike BindPointConfig.InterfaceAddress, they contain the actual port of bind
+// points bound to port 0. Nil is returned if the Server is not bound.
+func (server *Server) BoundAddresses() []net.Addr {
+	server.listenLock.Lock()
+	defer server.listenLock.Unlock()
+
+	var addresses []net.Addr
+
+	for _, listener := range server.listeners {
+		addresses = append(addresses, listener.Addr())
+	}
+
+	return addresses
+}
+
+// HttpServers returns the http.Server of each bind point, in the order of the ServerConfig's bind points. It is
+// intended for integrations that read or attach to them, e.g. ConnContext or RegisterOnShutdown, after the Server is
+// created and before it is started (e.g. between InstanceImpl.Build and InstanceImpl.Start). Mutating them once they
+// are started is not safe. Replacing their BaseContext or ConnState bypasses the ServerContext of requests and the
+// connection accounting and timeouts of the Server, see InstanceOptions.OnConnState. Their timeouts are not set, the
+// Server applies its TimeoutOptions to their connections so that UpdateOptions may change them.
+func (server *Server) HttpServers() []*http.Server {
+	var httpServers []*http.Server
+
+	for _, httpServer := range server.httpServers {
+		httpServers = append(httpServers, httpServer.Server)
+	}
+
+	return httpServers
+}
+
+// Listeners returns the listener of each bind point, in the order of the ServerConfig's bind points, once they are
+// bound by Listen. They are the listeners served by the http.Server's returned by HttpServers, as returned by
+// InstanceOptions.ListenerMutator if set. Nil is returned if the Server is not bound.
+func (server *Server) Listeners() []net.Listener {
+	server.listenLock.Lock()
+	defer server.listenLock.Unlock()
+
+	return append([]net.Listener(nil), server.listeners...)
+}
+
+// mutateListener applies InstanceOptions.ListenerMutator, if set, to the bound listener of a namedHttpServer. The
+// listener is closed if the ListenerMutator does not return a listener.
+func (server *Server) mutateListener(httpServer *namedHttpServer, listener net.Listener) (net.Listener, error) {
+	if server.instanceOptions.ListenerMutator == nil {
+		return listener, nil
+	}
+
+	mutated := server.instanceOptions.ListenerMutator(listener, httpServer.BindPointConfig)
+
+	if mutated == nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("listener mutator returned no listener for bind point [%s]", httpServer.BindPointConfig.InterfaceAddress)
+	}
+
+	return mutated, nil
+}
+
+// closeListeners closes all listeners bound by Listen. Listeners that are being served are closed as well, which
+// stops the http.Server's serving them.
+func (server *Server) closeListeners() {
+	server.listenLock.Lock()
+	defer server.listenLock.Unlock()
+
+	for _, listener := range server.listeners {
+		_ = listener.Close()
+	}
+}
+
+// Start the server and all underlying http.Server's. Start calls Listen if the server's bind points have not been bound
+// yet and returns its error. Otherwise, Start blocks until all http.Server's stop serving or one of them fails.
+func (server *Server) Start() (err error) {
+	if err = server.Listen(); err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			server.setStartError(err)
+		}
+	}()
+
+	if err = server.startHandlers(); err != nil {
+		server.closeListeners()
+		return err
+	}
+
+	if server.ocspStapler != nil {
+		server.ocspStapler.Start()
+	}
+
+	if server.crlChecker != nil {
+		server.crlChecker.Start()
+	}
+
+	server.listenLock.Lock()
+	listeners := server.listeners
+	server.listenLock.Unlock()
+
+	for _, httpServer := range server.httpServers {
+		httpServer.SetKeepAlivesEnabled(server.ServerConfig.Options.KeepAlivesEnabled)
+	}
+
+	serveErrors := make(chan error, len(server.httpServers))
+
+	for i, httpServer := range server.httpServers {
+		localServer := httpServer
+		listener := listeners[i]
+
+		localServer.listening.Store(true)
+
+		go func() {
+			err := localServer.Serve(listener)
+			localServer.listening.Store(false)
+
+			if !errors.Is(err, http.ErrServerClosed) {
+				serveErrors <- fmt.Errorf("error serving on %s: %s", localServer.Addr, err)
+				return
+			}
+
+			serveErrors <- nil
+		}()
+	}
+
+	if onServerStarted := server.instanceOptions.OnServerStarted; onServerStarted != nil {
+		onServerStarted(server.ServerConfig, server.BoundAddresses())
+	}
+
+	for range server.httpServers {
+		if err := <-serveErrors; err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-// Shutdown stops the server and all underlying http.Server's
+// listen returns the handoffListener of the bind point of the supplied namedHttpServer. The listener of the Server it
+// replaces is taken over if it is bound with the same network, PROXY protocol and TLS settings, otherwise the bind
+// point is bound.
+func (server *Server) listen(httpServer *namedHttpServer) (*handoffListener, error) {
+	bindPoint := httpServer.BindPointConfig
+
+	if handoff := server.takeover.take(handoffKey(bindPoint, httpServer.TLSConfig != nil)); handoff != nil {
+		pfxlog.Logger().WithField("bindPoint", httpServer.Name).Infof("taking over listener on %s for bind point %s of server %s with APIs: %v", handoff.listener.Addr(), bindPoint.InterfaceAddress, httpServer.ServerConfig.Name, httpServer.ApiBindingList)
+		return handoff, nil
+	}
+
+	handoff := newHandoffListener(bindPoint, httpServer.TLSConfig)
+
+	listener, err := server.bind(httpServer, handoff.tlsConfig())
+	if err != nil {
+		return nil, err
+	}
+
+	handoff.serve(listener)
+
+	return handoff, nil
+}
+
+// bind binds the bind point of the supplied namedHttpServer, performing TLS handshakes with cfg if not nil. Bind
+// points with a listener in InstanceOptions.PreBoundListeners adopt it instead of binding. TCP bind points are bound
+// via transporttls.ListenTLS.
+// Unix socket bind points are bound via net.Listen and wrapped with TLS. Bind points without a tls.Config (unix socket
+// bind points with TLS disabled, or all bind points when InstanceOptions.InsecureNoTLS is set) are bound via
+// net.Listen and serve plain HTTP. TCP bind points that accept the PROXY protocol are bound via net.Listen, as the
+// PROXY protocol header must be read before the TLS handshake. The socket file of a unix socket bind point is removed
+// when its listener is closed.
+func (server *Server) bind(httpServer *namedHttpServer, cfg *tls.Config) (net.Listener, error) {
+	logger := pfxlog.Logger().WithField("bindPoint", httpServer.Name)
+	bindPoint := httpServer.BindPointConfig
+
+	if listener, err := server.takePreBoundListener(bindPoint); err != nil {
+		return nil, err
+	} else if listener != nil {
+		logger.Infof("adopting pre-bound listener on %s for bind point %s of server %s with APIs: %v", listener.Addr(), bindPoint.InterfaceAddress, httpServer.ServerConfig.Name, httpServer.ApiBindingList)
+		return wrapListener(bindPoint, listener, cfg), nil
+	}
+
+	if !bindPoint.IsUnixSocket() {
+		if bindPoint.RedirectHttps {
+			logger.Infof("starting redirect to https://%s on %s for server %s", bindPoint.Address, httpServer.Addr, httpServer.ServerConfig.Name)
+		} else if cfg == nil {
+			logger.Warnf("starting ApiConfig to listen and serve WITHOUT TLS on %s for server %s with APIs: %v", httpServer.Addr, httpServer.ServerConfig.Name, httpServer.ApiBindingList)
+
+			if !bindPoint.IsLoopback() {
+				logger.Warnf("!!! bind point %s of server %s serves plain HTTP on a non-loopback interface, requests and responses are NOT ENCRYPTED and may be read or altered on the network !!!", httpServer.Addr, httpServer.ServerConfig.Name)
+			}
+		} else if bindPoint.ProxyProtocol {
+			logger.Infof("starting ApiConfig to listen and serve tls with PROXY protocol on %s for server %s with APIs: %v", httpServer.Addr, httpServer.ServerConfig.Name, httpServer.ApiBindingList)
+		} else if bindPoint.ListenNetwork() != NetworkTcp {
+			logger.Infof("starting ApiConfig to listen and serve tls on %s (%s) for server %s with APIs: %v", httpServer.Addr, bindPoint.ListenNetwork(), httpServer.ServerConfig.Name, httpServer.ApiBindingList)
+		} else {
+			logger.Infof("starting ApiConfig to listen and serve tls on %s for server %s with APIs: %v", httpServer.Addr, httpServer.ServerConfig.Name, httpServer.ApiBindingList)
+			return transporttls.ListenTLS(httpServer.Addr, httpServer.ServerConfig.Name, cfg)
+		}
+
+		listener, err := net.Listen(bindPoint.ListenNetwork(), httpServer.Addr)
+		if err != nil {
+			return nil, err
+		}
+
+		return wrapListener(bindPoint, listener, cfg), nil
+	}
+
+	path := bindPoint.UnixSocketPath()
+
+	if err := removeStaleUnixSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg == nil {
+		logger.Infof("starting ApiConfig to listen and serve on unix socket %s for server %s with APIs: %v", path, httpServer.ServerConfig.Name, httpServer.ApiBindingList)
+	} else {
+		logger.Infof("starting ApiConfig to listen and serve tls on unix socket %s for server %s with APIs: %v", path, httpServer.ServerConfig.Name, httpServer.ApiBindingList)
+	}
+
+	return wrapListener(bindPoint, listener, cfg), nil
+}
+
+// wrapListener wraps a listener bound via net.Listen to read PROXY protocol headers, if enabled for the bind point,
+// and to perform TLS handshakes if cfg is not nil.
+func wrapListener(bindPoint *BindPointConfig, listener net.Listener, cfg *tls.Config) net.Listener {
+	if bindPoint.ProxyProtocol {
+		listener = newProxyProtocolListener(listener)
+	}
+
+	if cfg != nil {
+		listener = tls.NewListener(listener, cfg)
+	}
+
+	return listener
+}
+
+// removeStaleUnixSocket removes the socket file at path if it was left behind by a process that is no longer
+// listening on it. An error is returned if the path exists and is not a socket or another process is listening on it.
+func removeStaleUnixSocket(path string) error {
+	info, err := os.Stat(path)
+
+	if os.IsNotExist(err) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("unix socket path [%s] exists and is not a socket", path)
+	}
+
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("unix socket path [%s] is already in use", path)
+	}
+
+	return os.Remove(path)
+}
+
+// StartError returns the error that caused the Server to fail to start or stop serving, nil otherwise.
+func (server *Server) StartError() error {
+	server.startErrLock.Lock()
+	defer server.startErrLock.Unlock()
+
+	return server.startErr
+}
+
+func (server *Server) setStartError(err error) {
+	server.startErrLock.Lock()
+	defer server.startErrLock.Unlock()
+
+	server.startErr = err
+}
+
+// UpdateOptions applies the timeout, TLS version, and request deadline values of options to the running Server
+// without closing its listeners. Read header timeouts apply to connections accepted after the update, read and write
+// timeouts to requests received after the update, and idle timeouts to connections that become idle after the
+// update. TLS versions and curve preferences apply to handshakes performed after the update. Request deadlines apply
+// to requests received after the update. Handler timeouts apply to requests received after the update. The shutdown
+// timeout applies to the next shutdown.
+//
+// All other options (e.g. ocspStapling, clientCrl, tlsKeyLogFile, accessLog, filterHandshakeErrors, rateLimit,
+// maxHeaderBytes, maxBodyBytes, keepAlivesEnabled) are fixed when the Server is created and require the Server to be
+// rebuilt and rebound to change. If any of them differ from the current options, an error is returned and no values
+// are applied.
+func (server *Server) UpdateOptions(options Options) error {
+	if err := options.TimeoutOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid timeout option: %v", err)
+	}
+
+	if err := options.TlsVersionOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid TLS version option: %v", err)
+	}
+
+	if err := options.RequestDeadlineOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid request deadline option: %v", err)
+	}
+
+	current := server.ServerConfig.Options
+
+	if options.OcspOptions != current.OcspOptions {
+		return errors.New("ocspStapling cannot be changed without rebuilding the server")
+	}
+
+	if options.CrlOptions != current.CrlOptions {
+		return errors.New("clientCrl and clientCrlRefreshInterval cannot be changed without rebuilding the server")
+	}
+
+	if options.TlsKeyLogOptions != current.TlsKeyLogOptions {
+		return errors.New("tlsKeyLogFile cannot be changed without rebuilding the server")
+	}
+
+	if options.ErrorLogOptions != current.ErrorLogOptions {
+		return errors.New("filterHandshakeErrors cannot be changed without rebuilding the server")
+	}
+
+	if options.AccessLogOptions != current.AccessLogOptions {
+		return errors.New("accessLog cannot be changed without rebuilding the server")
+	}
+
+	if options.RateLimitOptions != current.RateLimitOptions {
+		return errors.New("rateLimit and rateLimitBurst cannot be changed without rebuilding the server")
+	}
+
+	if options.InFlightOptions != current.InFlightOptions {
+		return errors.New("trackInFlightRequests cannot be changed without rebuilding the server")
+	}
+
+	if options.SizeLimitOptions != current.SizeLimitOptions {
+		return errors.New("maxHeaderBytes and maxBodyBytes cannot be changed without rebuilding the server")
+	}
+
+	if options.ConnectionOptions != current.ConnectionOptions {
+		return errors.New("keepAlivesEnabled cannot be changed without rebuilding the server")
+	}
+
+	if !reflect.DeepEqual(options.CompressionOptions, current.CompressionOptions) {
+		return errors.New("compression options cannot be changed without rebuilding the server")
+	}
+
+	server.connDeadlines.timeouts.Store(newConnTimeouts(&options))
+
+	tlsVersionOptions := options.TlsVersionOptions
+	server.tlsVersionOptions.Store(&tlsVersionOptions)
+
+	server.requestDeadline.Store(newRequestDeadline(&options))
+	server.handlerTimeouts.Store(newHandlerTimeouts(&options, server.ServerConfig.APIs))
+
+	server.ServerConfig.Options.TimeoutOptions = options.TimeoutOptions
+	server.ServerConfig.Options.TlsVersionOptions = options.TlsVersionOptions
+	server.ServerConfig.Options.RequestDeadlineOptions = options.RequestDeadlineOptions
+
+	return nil
+}
+
+// Shutdown stops the server and all underlying http.Server's. In-flight requests are allowed to complete until ctx
+// is done, after which any remaining connections are closed. If the trackInFlightRequests option is enabled, the
+// requests still in flight at that time are logged. The shutdown context of requests (see
+// ShutdownContextFromRequestContext) is canceled first so that long-running handlers may abort early.
 func (server *Server) Shutdown(ctx context.Context) {
+	server.shuttingDown.Store(true)
+	server.cancelShutdown()
+
 	_ = server.logWriter.Close()
 
+	if server.ocspStapler != nil {
+		server.ocspStapler.Stop()
+	}
+
+	if server.crlChecker != nil {
+		server.crlChecker.Stop()
+	}
+
+	var undrained []*namedHttpServer
+
 	for _, httpServer := range server.httpServers {
-		localServer := httpServer
-		func() {
-			_ = localServer.Shutdown(ctx)
-		}()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			pfxlog.Logger().Warnf("server %s on %s did not shutdown gracefully, closing remaining connections: %v", httpServer.ServerConfig.Name, httpServer.Addr, err)
+			undrained = append(undrained, httpServer)
+		}
+	}
+
+	if len(undrained) > 0 {
+		server.logInFlightRequests()
+	}
+
+	for _, httpServer := range undrained {
+		_ = httpServer.Close()
+	}
+
+	//close listeners that were bound but never served
+	server.closeListeners()
+
+	server.stopHandlers(ctx)
+
+	if server.tlsKeyLog != nil {
+		_ = server.tlsKeyLog.Close()
+	}
+}
+
+// startHandlers starts the LifecycleApiHandler's of the Server in the priority order of their ApiConfig's. If one fails, those
+// already started are stopped and the error is returned.
+func (server *Server) startHandlers() error {
+	server.lifecycleLock.Lock()
+	defer server.lifecycleLock.Unlock()
+
+	if server.shuttingDown.Load() {
+		return fmt.Errorf("server %s is shutting down", server.ServerConfig.Name)
+	}
+
+	for _, handler := range server.lifecycleHandlers {
+		if err := handler.Start(); err != nil {
+			server.stopStartedHandlers(context.Background())
+			return fmt.Errorf("error starting api binding [%s] of server %s: %v", handler.Binding(), server.ServerConfig.Name, err)
+		}
+
+		server.startedHandlers = append(server.startedHandlers, handler)
+	}
+
+	return nil
+}
+
+// stopHandlers stops the started LifecycleApiHandler's of the Server
+func (server *Server) stopHandlers(ctx context.Context) {
+	server.lifecycleLock.Lock()
+	defer server.lifecycleLock.Unlock()
+
+	server.stopStartedHandlers(ctx)
+}
+
+// stopStartedHandlers stops started LifecycleApiHandler's in the reverse order they were started. The caller must
+// hold lifecycleLock.
+func (server *Server) stopStartedHandlers(ctx context.Context) {
+	for i := len(server.startedHandlers) - 1; i >= 0; i-- {
+		server.startedHandlers[i].Stop(ctx)
 	}
+
+	server.startedHandlers = nil
 }
@@ -17,12 +17,22 @@
 package xweb
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"github.com/michaelquigley/pfxlog"
+	"github.com/openziti/foundation/v2/errorz"
 	"github.com/openziti/identity"
 	"github.com/pkg/errors"
+	"net"
+	"sort"
+	"strings"
+	"time"
 )
 
+// minRecommendedTimeout is the shortest HTTP timeout that does not produce a configuration warning
+const minRecommendedTimeout = time.Second
+
 // ServerConfig is the configuration that will eventually be used to create a xweb.Server (which in turn houses all
 // the components necessary to run multiple http.Server's).
 type ServerConfig struct {
@@ -34,10 +44,50 @@ type ServerConfig struct {
 
 	DefaultIdentity identity.Identity
 	Identity        identity.Identity
+
+	// Identities are optional identities keyed by server name. They are selected during the TLS handshake by the
+	// server name (SNI) requested by the client. Identity is used if the client's server name does not match.
+	Identities map[string]identity.Identity
+
+	// ClientCas, if set, is the location of the CAs used to verify client certificates instead of the CA bundle of the
+	// identity, either a file path or inline PEM prefixed with "pem:". Bind points may override it.
+	ClientCas string
+
+	// Cors, if set, enables CORS handling for all bind points of the server.
+	Cors *CorsConfig
+
+	// SecurityHeaders, if set, adds security headers (e.g. HSTS) to the responses of all bind points of the server.
+	SecurityHeaders *SecurityHeadersConfig
+
+	// DefaultApi, if set, is the binding of the API that serves requests no other API matches. It overrides the default
+	// selected by the DemuxFactory (see DefaultApiHandler) on all bind points serving the API. The DemuxFactory must
+	// build MatchingDemuxHandler's.
+	DefaultApi string
+
+	// RequireDefaultApi, if true, makes building the Server fail if a demultiplexed bind point has no explicit default
+	// API, i.e. DefaultApi is not served on it and no ApiHandler declares itself the default via DefaultApiHandler,
+	// instead of falling back to the last ApiHandler (see getDefault). It prevents unmatched requests from being
+	// routed to whichever API happens to be last.
+	RequireDefaultApi bool
+
+	// ResolveAddresses, if true, makes Validate verify that the advertised Address and new addresses of all bind
+	// points resolve via DNS. It is disabled by default so that configurations validate without network access.
+	ResolveAddresses bool
+
+	//the configuration map the ServerConfig was parsed from, used to detect changes on reload
+	source map[interface{}]interface{}
+
+	//the instance wide options the server's own options are parsed over, see InstanceConfig.OptionsSection
+	inheritedOptions *Options
+
+	//true if the config is only parsed and validated, identity file watching is skipped
+	validateOnly bool
 }
 
 // Parse parses a configuration map to set all relevant ServerConfig values.
 func (config *ServerConfig) Parse(configMap map[interface{}]interface{}, pathContext string) error {
+	config.source = configMap
+
 	//parse name, required, string
 	if nameInterface, ok := configMap["name"]; ok {
 		if name, ok := nameInterface.(string); ok {
@@ -71,6 +121,33 @@ func (config *ServerConfig) Parse(configMap map[interface{}]interface{}, pathCon
 		return errors.New("apis section is required")
 	}
 
+	//parse default api
+	if defaultApiInterface, ok := configMap["defaultApi"]; ok {
+		if defaultApi, ok := defaultApiInterface.(string); ok {
+			config.DefaultApi = defaultApi
+		} else {
+			return errors.New("defaultApi must be a string if defined")
+		}
+	} //no else, optional
+
+	//parse require default api
+	if requireInterface, ok := configMap["requireDefaultApi"]; ok {
+		if require, ok := requireInterface.(bool); ok {
+			config.RequireDefaultApi = require
+		} else {
+			return errors.New("requireDefaultApi must be a boolean if defined")
+		}
+	} //no else, optional
+
+	//parse resolve addresses
+	if resolveInterface, ok := configMap["resolveAddresses"]; ok {
+		if resolve, ok := resolveInterface.(bool); ok {
+			config.ResolveAddresses = resolve
+		} else {
+			return errors.New("resolveAddresses must be a boolean if defined")
+		}
+	} //no else, optional
+
 	//parse listen address
 	if addressInterface, ok := configMap["bindPoints"]; ok {
 		if addressesArrayInterfaces, ok := addressInterface.([]interface{}); ok {
@@ -102,8 +179,10 @@ func (config *ServerConfig) Parse(configMap map[interface{}]interface{}, pathCon
 					return fmt.Errorf("error loading identity: %v", err)
 				}
 
-				if err := config.DefaultIdentity.WatchFiles(); err != nil {
-					pfxlog.Logger().Warnf("could not enable file watching on bind point identity: %v", err)
+				if !config.validateOnly {
+					if err := config.Identity.WatchFiles(); err != nil {
+						pfxlog.Logger().Warnf("could not enable file watching on server identity: %v", err)
+					}
 				}
 			} else {
 				return fmt.Errorf("error parsing identity section: %v", err)
@@ -115,13 +194,95 @@ func (config *ServerConfig) Parse(configMap map[interface{}]interface{}, pathCon
 
 	} //no else, optional, will defer to router identity
 
+	//parse SNI identities
+	if identitiesInterface, ok := configMap["identities"]; ok {
+		if identitiesMap, ok := identitiesInterface.(map[interface{}]interface{}); ok {
+			config.Identities = map[string]identity.Identity{}
+
+			for serverNameInterface, identityInterface := range identitiesMap {
+				serverName, ok := serverNameInterface.(string)
+				if !ok {
+					return fmt.Errorf("identities section keys must be strings, got [%v]", serverNameInterface)
+				}
+
+				serverName = strings.ToLower(strings.TrimSpace(serverName))
+
+				if serverName == "" {
+					return errors.New("identities section keys must not be empty")
+				}
+
+				identityMap, ok := identityInterface.(map[interface{}]interface{})
+				if !ok {
+					return fmt.Errorf("identities section entry [%s] must be a map", serverName)
+				}
+
+				identityConfig, err := parseIdentityConfig(identityMap, pathContext+".identities."+serverName)
+				if err != nil {
+					return fmt.Errorf("error parsing identities section entry [%s]: %v", serverName, err)
+				}
+
+				sniIdentity, err := identity.LoadIdentity(*identityConfig)
+				if err != nil {
+					return fmt.Errorf("error loading identities section entry [%s]: %v", serverName, err)
+				}
+
+				if !config.validateOnly {
+					if err := sniIdentity.WatchFiles(); err != nil {
+						pfxlog.Logger().Warnf("could not enable file watching on identity for server name [%s]: %v", serverName, err)
+					}
+				}
+
+				config.Identities[serverName] = sniIdentity
+			}
+		} else {
+			return errors.New("identities section must be a map if defined")
+		}
+	} //no else, optional
+
+	//parse client CAs
+	if clientCasInterface, ok := configMap["clientCas"]; ok {
+		if clientCas, ok := clientCasInterface.(string); ok {
+			config.ClientCas = clientCas
+		} else {
+			return errors.New("clientCas must be a string if defined")
+		}
+	} //no else, optional
+
+	//parse cors
+	if corsInterface, ok := configMap["cors"]; ok {
+		if corsMap, ok := corsInterface.(map[interface{}]interface{}); ok {
+			config.Cors = &CorsConfig{}
+			if err := config.Cors.Parse(corsMap); err != nil {
+				return fmt.Errorf("error parsing cors section: %v", err)
+			}
+		} else {
+			return errors.New("cors section must be a map if defined")
+		}
+	} //no else, optional
+
+	//parse security headers
+	if securityHeadersInterface, ok := configMap["securityHeaders"]; ok {
+		if securityHeadersMap, ok := securityHeadersInterface.(map[interface{}]interface{}); ok {
+			config.SecurityHeaders = &SecurityHeadersConfig{}
+			if err := config.SecurityHeaders.Parse(securityHeadersMap); err != nil {
+				return fmt.Errorf("error parsing securityHeaders section: %v", err)
+			}
+		} else {
+			return errors.New("securityHeaders section must be a map if defined")
+		}
+	} //no else, optional
+
 	//parse options
-	config.Options = Options{}
-	config.Options.Default()
+	if config.inheritedOptions != nil {
+		config.Options = *config.inheritedOptions
+	} else {
+		config.Options = Options{}
+		config.Options.Default()
+	}
 
 	if optionsInterface, ok := configMap["options"]; ok {
 		if optionMap, ok := optionsInterface.(map[interface{}]interface{}); ok {
-			if err := config.Options.Parse(optionMap); err != nil {
+			if err := config.Options.parse(optionMap); err != nil {
 				return fmt.Errorf("error parsing options section: %v", err)
 			}
 		} //no else, options are optional
@@ -130,6 +291,31 @@ func (config *ServerConfig) Parse(configMap map[interface{}]interface{}, pathCon
 	return nil
 }
 
+// enabledApiBindings returns the bindings of the enabled APIs of the server, in configuration order.
+func (config *ServerConfig) enabledApiBindings() []string {
+	var bindings []string
+
+	for _, api := range config.APIs {
+		if api.Enabled() {
+			bindings = append(bindings, api.Binding())
+		}
+	}
+
+	return bindings
+}
+
+// prioritizedApis returns the APIs of the server ordered by descending ApiConfig.Priority. APIs with equal priorities
+// keep their configuration order.
+func (config *ServerConfig) prioritizedApis() []*ApiConfig {
+	apis := append([]*ApiConfig(nil), config.APIs...)
+
+	sort.SliceStable(apis, func(i, j int) bool {
+		return apis[i].Priority() > apis[j].Priority()
+	})
+
+	return apis
+}
+
 // Validate all ServerConfig values
 func (config *ServerConfig) Validate(registry Registry) error {
 	if config.Name == "" {
@@ -147,7 +333,7 @@ func (config *ServerConfig) Validate(registry Registry) error {
 
 		//check if binding is valid
 		if binding := registry.Get(api.Binding()); binding == nil {
-			return fmt.Errorf("invalid ApiConfig at index [%d]: invalid binding %s", i, api.Binding())
+			return fmt.Errorf("invalid ApiConfig at index [%d]: invalid binding %s, registered bindings are [%s]", i, api.Binding(), registeredBindings(registry))
 		}
 	}
 
@@ -155,10 +341,48 @@ func (config *ServerConfig) Validate(registry Registry) error {
 		return errors.New("no addresses specified, must specify at lest one")
 	}
 
+	//api bindings of the server, true if at least one ApiConfig with the binding is enabled
+	apiBindings := map[string]bool{}
+	for _, api := range config.APIs {
+		apiBindings[api.Binding()] = apiBindings[api.Binding()] || api.Enabled()
+	}
+
+	if len(config.enabledApiBindings()) == 0 {
+		return errors.New("no enabled APIs, must enable at least one")
+	}
+
+	if config.DefaultApi != "" && !apiBindings[config.DefaultApi] {
+		return fmt.Errorf("defaultApi [%s] is not an enabled api of the server", config.DefaultApi)
+	}
+
+	//index of the bind point that uses a name, names identify bind points in ServerContext's
+	bindPointNames := map[string]int{}
+
 	for i, address := range config.BindPoints {
 		if err := address.Validate(); err != nil {
 			return fmt.Errorf("invalid address at index [%d]: %v", i, err)
 		}
+
+		if other, ok := bindPointNames[address.GetName()]; ok {
+			return fmt.Errorf("invalid address at index [%d]: name [%s] is already used by the address at index [%d]", i, address.GetName(), other)
+		}
+		bindPointNames[address.GetName()] = i
+
+		enabled := 0
+		for _, binding := range address.APIs {
+			bindingEnabled, ok := apiBindings[binding]
+			if !ok {
+				return fmt.Errorf("invalid address at index [%d]: api binding [%s] is not an api of the server", i, binding)
+			}
+
+			if bindingEnabled {
+				enabled++
+			}
+		}
+
+		if len(address.APIs) > 0 && enabled == 0 {
+			return fmt.Errorf("invalid address at index [%d]: all api bindings listed are disabled", i)
+		}
 	}
 
 	if config.Identity == nil {
@@ -169,14 +393,200 @@ func (config *ServerConfig) Validate(registry Registry) error {
 		config.Identity = config.DefaultIdentity
 	}
 
+	if err := config.validateAdvertisedAddresses(); err != nil {
+		return err
+	}
+
+	if config.ClientCas != "" {
+		if _, err := LoadClientCas(config.ClientCas); err != nil {
+			return fmt.Errorf("invalid clientCas: %v", err)
+		}
+	}
+
+	if config.Cors != nil {
+		if err := config.Cors.Validate(); err != nil {
+			return fmt.Errorf("invalid cors section: %v", err)
+		}
+	}
+
+	if config.SecurityHeaders != nil {
+		if err := config.SecurityHeaders.Validate(); err != nil {
+			return fmt.Errorf("invalid securityHeaders section: %v", err)
+		}
+	}
+
 	if err := config.Options.TlsVersionOptions.Validate(); err != nil {
 		return fmt.Errorf("invalid TLS version option: %v", err)
 	}
 
+	if err := config.Options.CrlOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid CRL option: %v", err)
+	}
+
 	if err := config.Options.TimeoutOptions.Validate(); err != nil {
 		return fmt.Errorf("invalid timeout option: %v", err)
 	}
 
+	if err := config.Options.RequestDeadlineOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid request deadline option: %v", err)
+	}
+
+	if err := config.Options.RateLimitOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid rate limit option: %v", err)
+	}
+
+	if err := config.Options.SizeLimitOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid size limit option: %v", err)
+	}
+
+	if err := config.Options.CompressionOptions.Validate(); err != nil {
+		return fmt.Errorf("invalid compression option: %v", err)
+	}
+
 	return nil
 
 }
+
+// Warnings returns non-fatal advisories for configuration values that are valid but suspicious or discouraged. It
+// should be called after Validate.
+func (config *ServerConfig) Warnings() []string {
+	var warnings []string
+
+	if config.Options.MinTLSVersion < tls.VersionTLS12 {
+		warnings = append(warnings, fmt.Sprintf("minTLSVersion [%s] enables deprecated TLS versions older than TLS1.2", ReverseTlsVersionMap[config.Options.MinTLSVersion]))
+	}
+
+	for name, timeout := range map[string]time.Duration{
+		"readTimeout":       config.Options.ReadTimeout,
+		"readHeaderTimeout": config.Options.ReadHeaderTimeout,
+		"writeTimeout":      config.Options.WriteTimeout,
+		"idleTimeout":       config.Options.IdleTimeout,
+	} {
+		if timeout > 0 && timeout < minRecommendedTimeout {
+			warnings = append(warnings, fmt.Sprintf("%s [%s] is very short and may cause requests to fail, values of at least %s are recommended", name, timeout, minRecommendedTimeout))
+		}
+	}
+
+	for i, bindPoint := range config.BindPoints {
+		//redirect bind points serve no content, only redirects to https
+		if bindPoint.DisableTLS && !bindPoint.RedirectHttps {
+			if bindPoint.IsLoopback() {
+				warnings = append(warnings, fmt.Sprintf("bind point at index [%d] serves plain HTTP without TLS", i))
+			} else {
+				warnings = append(warnings, fmt.Sprintf("bind point at index [%d] serves plain HTTP without TLS on non-loopback interface [%s], traffic is unencrypted on the network", i, bindPoint.InterfaceAddress))
+			}
+		}
+	}
+
+	sort.Strings(warnings)
+
+	return warnings
+}
+
+// LoadClientCas loads the CAs used to verify client certificates from clientCas, either a file path or inline PEM
+// prefixed with "pem:", into an x509.CertPool. An error is returned if no certificates are found.
+func LoadClientCas(clientCas string) (*x509.CertPool, error) {
+	certs, err := identity.LoadCert(clientCas)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(certs) == 0 {
+		return nil, errors.New("no certificates found")
+	}
+
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+
+	return pool, nil
+}
+
+// validateAdvertisedAddresses verifies that each SNI identity is valid for its server name and that the identity
+// presented on each TLS bind point is valid for the new addresses advertised to clients, so that clients do not fail
+// once they switch to a new address. An Address that selects an SNI identity must be covered by it as well. If
+// ResolveAddresses is set, the Address and new addresses must resolve. All failures are returned together.
+func (config *ServerConfig) validateAdvertisedAddresses() error {
+	var errs errorz.MultipleErrors
+
+	for serverName, sniIdentity := range config.Identities {
+		if err := validateIdentityFor(sniIdentity, serverName); err != nil {
+			errs = append(errs, fmt.Errorf("invalid identity for server name [%s]: %v", serverName, err))
+		}
+	}
+
+	for i, bindPoint := range config.BindPoints {
+		for _, address := range append([]string{bindPoint.Address}, bindPoint.AllNewAddresses()...) {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				//reported by BindPointConfig.Validate
+				continue
+			}
+
+			//clients already connect to the Address, only addresses they are told to move to and addresses served by
+			//an SNI identity must be covered
+			if !bindPoint.DisableTLS && (address != bindPoint.Address || config.hasSniIdentity(host)) {
+				if err := validateIdentityFor(config.identityFor(host), host); err != nil {
+					errs = append(errs, fmt.Errorf("invalid address at index [%d]: identity is not valid for advertised address [%s]: %v", i, address, err))
+				}
+			}
+
+			if config.ResolveAddresses && net.ParseIP(host) == nil {
+				if _, err := net.LookupHost(host); err != nil {
+					errs = append(errs, fmt.Errorf("invalid address at index [%d]: advertised address [%s] does not resolve: %v", i, address, err))
+				}
+			}
+		}
+	}
+
+	return errs.ToError()
+}
+
+// hasSniIdentity returns true if clients requesting the supplied server name are presented one of the Identities.
+func (config *ServerConfig) hasSniIdentity(serverName string) bool {
+	_, ok := config.Identities[strings.ToLower(serverName)]
+	return ok
+}
+
+// identityFor returns the identity presented to clients requesting the supplied server name, i.e. the SNI identity for
+// the server name if there is one, otherwise Identity. Server names are matched case insensitively, as during the TLS
+// handshake.
+func (config *ServerConfig) identityFor(serverName string) identity.Identity {
+	if sniIdentity, ok := config.Identities[strings.ToLower(serverName)]; ok {
+		return sniIdentity
+	}
+
+	return config.Identity
+}
+
+// validateIdentityFor verifies that at least one of the server certificates of the supplied identity.Identity is
+// valid for the supplied host name or IP.
+func validateIdentityFor(id identity.Identity, host string) error {
+	serverCerts := id.ServerCert()
+
+	if len(serverCerts) == 0 {
+		return errors.New("identity has no server certificates")
+	}
+
+	for _, serverCert := range serverCerts {
+		leaf := serverCert.Leaf
+
+		if leaf == nil {
+			if len(serverCert.Certificate) == 0 {
+				continue
+			}
+
+			var err error
+			if leaf, err = x509.ParseCertificate(serverCert.Certificate[0]); err != nil {
+				continue
+			}
+		}
+
+		if err := leaf.VerifyHostname(host); err == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("no server certificate is valid for [%s]", host)
+}
@@ -0,0 +1,2351 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"github.com/openziti/identity"
+	"github.com/openziti/xweb/v2/middleware"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var _ ApiHandlerFactory = (*mockHandlerFactory)(nil)
+
+// mockHandlerFactory returns handler from New if set, otherwise a new mockHandler
+type mockHandlerFactory struct {
+	handler ApiHandler
+}
+
+func (m *mockHandlerFactory) Binding() string {
+	return "mockHandler"
+}
+
+func (m *mockHandlerFactory) New(_ *ServerConfig, _ map[interface{}]interface{}) (ApiHandler, error) {
+	if m.handler != nil {
+		return m.handler, nil
+	}
+	return &mockHandler{}, nil
+}
+
+// funcHandler is a mockHandler that serves requests with handlerFunc
+type funcHandler struct {
+	mockHandler
+	handlerFunc http.HandlerFunc
+}
+
+func (f *funcHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	f.handlerFunc(writer, request)
+}
+
+func (m *mockHandlerFactory) Validate(_ *InstanceConfig) error {
+	return nil
+}
+
+// writeTestCert generates a self-signed server certificate and key for the given common name and writes them
+// as PEM to certFile and keyFile.
+func writeTestCert(t *testing.T, commonName string, serial int64, certFile, keyFile string) {
+	req := require.New(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	req.NoError(err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              []string{commonName},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	req.NoError(err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	req.NoError(err)
+
+	req.NoError(os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	req.NoError(os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+}
+
+// newTestIdentity creates an identity.Identity backed by a self-signed certificate on disk, returning the identity
+// and the paths of the files used so tests may alter them.
+func newTestIdentity(t *testing.T, commonName string) (identity.Identity, string, string) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+
+	writeTestCert(t, commonName, 1, certFile, keyFile)
+
+	id, err := identity.LoadIdentity(identity.Config{
+		Cert:       certFile,
+		Key:        keyFile,
+		ServerCert: certFile,
+	})
+	require.New(t).NoError(err)
+
+	return id, certFile, keyFile
+}
+
+// newTestServerConfig creates a ServerConfig with a single mockHandler API and a single bind point on
+// interfaceAddress.
+func newTestServerConfig(id identity.Identity, interfaceAddress string) *ServerConfig {
+	serverConfig := &ServerConfig{
+		Name:            "test",
+		APIs:            []*ApiConfig{{binding: "mockHandler"}},
+		BindPoints:      []*BindPointConfig{{InterfaceAddress: interfaceAddress, Address: interfaceAddress}},
+		DefaultIdentity: id,
+		Identity:        id,
+	}
+	serverConfig.Options.Default()
+
+	return serverConfig
+}
+
+// newTestInstance creates an InstanceImpl with a Registry containing a mockHandlerFactory that returns handler if
+// not nil.
+func newTestInstance(t *testing.T, id identity.Identity, handler ApiHandler) *InstanceImpl {
+	registry := NewRegistryMap()
+	require.New(t).NoError(registry.Add(&mockHandlerFactory{handler: handler}))
+
+	return NewDefaultInstance(registry, id)
+}
+
+// freeAddress returns a loopback host:port that was free at the time of the call.
+func freeAddress(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.New(t).NoError(err)
+	defer func() { _ = listener.Close() }()
+
+	return listener.Addr().String()
+}
+
+// startTestServer starts the Server in the background and waits for its first bind point to accept TLS connections.
+func startTestServer(t *testing.T, server *Server) {
+	go func() {
+		_ = server.Start()
+	}()
+
+	//only connect once serving, the shared TLS listener may dispatch connections before ListenTLS has set up the listener
+	httpServer := server.httpServers[0]
+	require.New(t).Eventually(httpServer.listening.Load, 5*time.Second, time.Millisecond)
+
+	address := httpServer.Addr
+	require.New(t).Eventually(func() bool {
+		conn, err := tls.Dial("tcp", address, &tls.Config{InsecureSkipVerify: true})
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// newTestClient returns a http.Client that trusts any server certificate.
+func newTestClient() *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+}
+
+// newTestServer builds a Server for the supplied ServerConfig.
+func newTestServer(t *testing.T, instance Instance, serverConfig *ServerConfig) *Server {
+	server, err := NewServer(instance, serverConfig)
+	require.New(t).NoError(err)
+
+	return server
+}
+
+// handshake performs a TLS handshake against the supplied server tls.Config over an in-memory connection using
+// clientTlsConfig and returns the client's connection state.
+func handshake(serverTlsConfig, clientTlsConfig *tls.Config) (tls.ConnectionState, error) {
+	serverConn, clientConn := net.Pipe()
+	defer func() { _ = serverConn.Close() }()
+	defer func() { _ = clientConn.Close() }()
+
+	go func() {
+		_ = tls.Server(serverConn, serverTlsConfig).Handshake()
+		_ = serverConn.Close()
+	}()
+
+	client := tls.Client(clientConn, clientTlsConfig)
+	err := client.Handshake()
+
+	return client.ConnectionState(), err
+}
+
+// handshakeWith performs a handshake against serverTlsConfig presenting clientCert, reading to observe TLS 1.3
+// rejections that occur after the client completes the handshake
+func handshakeWith(serverTlsConfig *tls.Config, clientCert tls.Certificate) error {
+	serverConn, clientConn := net.Pipe()
+	defer func() { _ = clientConn.Close() }()
+
+	go func() {
+		server := tls.Server(serverConn, serverTlsConfig)
+		if server.Handshake() == nil {
+			_, _ = server.Write([]byte{1})
+		}
+		_ = serverConn.Close()
+	}()
+
+	client := tls.Client(clientConn, &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{clientCert},
+	})
+
+	if err := client.Handshake(); err != nil {
+		return err
+	}
+
+	_, err := client.Read(make([]byte, 1))
+	return err
+}
+
+// handshakeLeaf performs a TLS handshake against the supplied server tls.Config over an in-memory connection and
+// returns the leaf certificate presented by the server.
+func handshakeLeaf(t *testing.T, serverTlsConfig *tls.Config, serverName string) *x509.Certificate {
+	req := require.New(t)
+
+	state, err := handshake(serverTlsConfig, &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         serverName,
+	})
+	req.NoError(err)
+	req.NotEmpty(state.PeerCertificates)
+
+	return state.PeerCertificates[0]
+}
+
+func TestServer_certificateReload(t *testing.T) {
+	req := require.New(t)
+
+	id, certFile, keyFile := newTestIdentity(t, "before.example.com")
+	server := newTestServer(t, newTestInstance(t, id, nil), newTestServerConfig(id, "127.0.0.1:0"))
+	tlsConfig := server.httpServers[0].TLSConfig
+
+	leaf := handshakeLeaf(t, tlsConfig, "")
+	req.Equal("before.example.com", leaf.Subject.CommonName)
+
+	writeTestCert(t, "after.example.com", 2, certFile, keyFile)
+
+	// file watching calls Reload() when the files change, call it directly to avoid timing dependencies
+	req.NoError(id.Reload())
+
+	leaf = handshakeLeaf(t, tlsConfig, "")
+	req.Equal("after.example.com", leaf.Subject.CommonName)
+}
+
+func TestServer_sniIdentities(t *testing.T) {
+	defaultId, _, _ := newTestIdentity(t, "default.example.com")
+	aId, _, _ := newTestIdentity(t, "a.example.com")
+	bId, _, _ := newTestIdentity(t, "b.example.com")
+
+	serverConfig := newTestServerConfig(defaultId, "127.0.0.1:0")
+	serverConfig.Identities = map[string]identity.Identity{
+		"a.example.com": aId,
+		"b.example.com": bId,
+	}
+
+	server := newTestServer(t, newTestInstance(t, defaultId, nil), serverConfig)
+	tlsConfig := server.httpServers[0].TLSConfig
+
+	t.Run("a matching server name selects its identity", func(t *testing.T) {
+		req := require.New(t)
+		req.Equal("a.example.com", handshakeLeaf(t, tlsConfig, "a.example.com").Subject.CommonName)
+		req.Equal("b.example.com", handshakeLeaf(t, tlsConfig, "b.example.com").Subject.CommonName)
+	})
+
+	t.Run("server names are matched case insensitively", func(t *testing.T) {
+		req := require.New(t)
+		req.Equal("b.example.com", handshakeLeaf(t, tlsConfig, "B.Example.COM").Subject.CommonName)
+	})
+
+	t.Run("an unknown server name falls back to the default identity", func(t *testing.T) {
+		req := require.New(t)
+		req.Equal("default.example.com", handshakeLeaf(t, tlsConfig, "c.example.com").Subject.CommonName)
+	})
+
+	t.Run("no server name falls back to the default identity", func(t *testing.T) {
+		req := require.New(t)
+		req.Equal("default.example.com", handshakeLeaf(t, tlsConfig, "").Subject.CommonName)
+	})
+}
+
+// negotiatedProtocol connects to address requesting serverName, offering HTTP/2 and HTTP/1.1 via ALPN, and returns the
+// protocol negotiated by the server.
+func negotiatedProtocol(t *testing.T, address, serverName string) string {
+	req := require.New(t)
+
+	conn, err := tls.Dial("tcp", address, &tls.Config{
+		InsecureSkipVerify: true,
+		ServerName:         serverName,
+		NextProtos:         []string{"h2", "http/1.1"},
+	})
+	req.NoError(err)
+	defer func() { _ = conn.Close() }()
+
+	return conn.ConnectionState().NegotiatedProtocol
+}
+
+func TestServer_sniIdentitiesAlpn(t *testing.T) {
+	req := require.New(t)
+
+	defaultId, _, _ := newTestIdentity(t, "127.0.0.1")
+	sniId, _, _ := newTestIdentity(t, "b.example.com")
+
+	serverConfig := newTestServerConfig(defaultId, freeAddress(t))
+	serverConfig.BindPoints[0].Network = NetworkTcp4
+	serverConfig.Identities = map[string]identity.Identity{"b.example.com": sniId}
+
+	server := newTestServer(t, newTestInstance(t, defaultId, nil), serverConfig)
+	startTestServer(t, server)
+	defer server.Shutdown(context.Background())
+
+	address := server.httpServers[0].Addr
+	req.Equal("h2", negotiatedProtocol(t, address, "a.example.com"))
+	req.Equal("h2", negotiatedProtocol(t, address, "b.example.com"))
+}
+
+func Test_validateIdentityFor(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "a.example.com")
+
+	t.Run("a host in the certificate is valid", func(t *testing.T) {
+		require.New(t).NoError(validateIdentityFor(id, "a.example.com"))
+	})
+
+	t.Run("an IP in the certificate is valid", func(t *testing.T) {
+		require.New(t).NoError(validateIdentityFor(id, "127.0.0.1"))
+	})
+
+	t.Run("a host not in the certificate is invalid", func(t *testing.T) {
+		require.New(t).Error(validateIdentityFor(id, "b.example.com"))
+	})
+}
+
+func TestServer_onClientHello(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "a.example.com")
+	instance := newTestInstance(t, id, nil)
+
+	var helloServerNames []string
+	var helloBindPoints []*BindPointConfig
+
+	instance.Config.Options.OnClientHello = func(hello *tls.ClientHelloInfo, bindPoint *BindPointConfig) {
+		helloServerNames = append(helloServerNames, hello.ServerName)
+		helloBindPoints = append(helloBindPoints, bindPoint)
+	}
+
+	serverConfig := newTestServerConfig(id, "127.0.0.1:0")
+	server := newTestServer(t, instance, serverConfig)
+
+	leaf := handshakeLeaf(t, server.httpServers[0].TLSConfig, "a.example.com")
+
+	req.Equal("a.example.com", leaf.Subject.CommonName)
+	req.Equal([]string{"a.example.com"}, helloServerNames)
+	req.Equal([]*BindPointConfig{serverConfig.BindPoints[0]}, helloBindPoints)
+}
+
+func TestServer_bindPointTlsConfigAlpn(t *testing.T) {
+	defaultId, _, _ := newTestIdentity(t, "127.0.0.1")
+	sniId, _, _ := newTestIdentity(t, "b.example.com")
+
+	assertAlpn := func(t *testing.T, instance *InstanceImpl, serverConfig *ServerConfig) {
+		req := require.New(t)
+
+		serverConfig.BindPoints[0].Network = NetworkTcp4
+		serverConfig.Identities = map[string]identity.Identity{"b.example.com": sniId}
+
+		server := newTestServer(t, instance, serverConfig)
+		startTestServer(t, server)
+		defer server.Shutdown(context.Background())
+
+		address := server.httpServers[0].Addr
+		req.Equal("h2", negotiatedProtocol(t, address, "a.example.com"))
+		req.Equal("h2", negotiatedProtocol(t, address, "b.example.com"))
+	}
+
+	t.Run("is kept when OnClientHello is set", func(t *testing.T) {
+		instance := newTestInstance(t, defaultId, nil)
+		instance.Config.Options.OnClientHello = func(*tls.ClientHelloInfo, *BindPointConfig) {}
+
+		assertAlpn(t, instance, newTestServerConfig(defaultId, freeAddress(t)))
+	})
+
+	t.Run("is kept when the bind point overrides clientAuth", func(t *testing.T) {
+		serverConfig := newTestServerConfig(defaultId, freeAddress(t))
+		serverConfig.BindPoints[0].ClientAuthPolicy = "none"
+
+		assertAlpn(t, newTestInstance(t, defaultId, nil), serverConfig)
+	})
+}
+
+func TestServer_shutdownTimeout(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	requestStarted := make(chan struct{})
+	releaseRequest := make(chan struct{})
+	defer close(releaseRequest)
+
+	handler := &funcHandler{
+		handlerFunc: func(writer http.ResponseWriter, request *http.Request) {
+			close(requestStarted)
+			<-releaseRequest
+		},
+	}
+
+	server := newTestServer(t, newTestInstance(t, id, handler), newTestServerConfig(id, freeAddress(t)))
+	startTestServer(t, server)
+
+	requestErr := make(chan error, 1)
+	go func() {
+		resp, err := newTestClient().Get("https://" + server.httpServers[0].Addr + "/mock-handler")
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+		requestErr <- err
+	}()
+
+	<-requestStarted
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	server.Shutdown(ctx)
+	req.Less(time.Since(start), 5*time.Second)
+
+	select {
+	case err := <-requestErr:
+		req.Error(err, "in-flight request past the shutdown deadline should be terminated")
+	case <-time.After(5 * time.Second):
+		req.Fail("in-flight request was not terminated after the shutdown deadline")
+	}
+}
+
+func TestServer_shutdownContext(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	requestStarted := make(chan struct{})
+
+	handler := &funcHandler{
+		handlerFunc: func(writer http.ResponseWriter, request *http.Request) {
+			shutdownCtx := ShutdownContextFromRequestContext(request.Context())
+			if shutdownCtx == nil {
+				writer.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+
+			close(requestStarted)
+
+			select {
+			case <-shutdownCtx.Done():
+				writer.WriteHeader(http.StatusServiceUnavailable)
+			case <-time.After(5 * time.Second):
+				writer.WriteHeader(http.StatusOK)
+			}
+		},
+	}
+
+	server := newTestServer(t, newTestInstance(t, id, handler), newTestServerConfig(id, freeAddress(t)))
+	startTestServer(t, server)
+
+	statusCodes := make(chan int, 1)
+	go func() {
+		resp, err := newTestClient().Get("https://" + server.httpServers[0].Addr + "/mock-handler")
+		if err != nil {
+			statusCodes <- 0
+			return
+		}
+		_ = resp.Body.Close()
+		statusCodes <- resp.StatusCode
+	}()
+
+	select {
+	case <-requestStarted:
+	case <-time.After(5 * time.Second):
+		req.FailNow("request did not reach the handler")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	server.Shutdown(ctx)
+	req.Less(time.Since(start), 4*time.Second, "the handler should have aborted when the shutdown started")
+
+	//the in-flight request is drained with the response the handler wrote when it aborted
+	req.Equal(http.StatusServiceUnavailable, <-statusCodes)
+}
+
+func TestServer_UpdateOptions(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "a.example.com")
+	server := newTestServer(t, newTestInstance(t, id, nil), newTestServerConfig(id, "127.0.0.1:0"))
+	tlsConfig := server.httpServers[0].TLSConfig
+
+	tls12Client := &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+	}
+
+	t.Run("timeouts and TLS versions are applied without rebuilding", func(t *testing.T) {
+		req := require.New(t)
+
+		_, err := handshake(tlsConfig, tls12Client)
+		req.NoError(err)
+
+		options := server.ServerConfig.Options
+		options.ReadTimeout = time.Minute
+		options.ReadHeaderTimeout = 10 * time.Second
+		options.WriteTimeout = 2 * time.Minute
+		options.IdleTimeout = 3 * time.Minute
+		options.MinTLSVersion = tls.VersionTLS13
+
+		req.NoError(server.UpdateOptions(options))
+
+		req.Equal(&connTimeouts{
+			read:       time.Minute,
+			readHeader: 10 * time.Second,
+			write:      2 * time.Minute,
+			idle:       3 * time.Minute,
+		}, server.connDeadlines.timeouts.Load())
+
+		_, err = handshake(tlsConfig, tls12Client)
+		req.Error(err)
+
+		state, err := handshake(tlsConfig, &tls.Config{InsecureSkipVerify: true})
+		req.NoError(err)
+		req.Equal(uint16(tls.VersionTLS13), state.Version)
+	})
+
+	t.Run("invalid options are rejected", func(t *testing.T) {
+		options := server.ServerConfig.Options
+		options.ReadTimeout = 0
+		require.New(t).Error(server.UpdateOptions(options))
+	})
+
+	t.Run("options that require a rebuild are rejected", func(t *testing.T) {
+		options := server.ServerConfig.Options
+		options.OcspStapling = true
+		require.New(t).Error(server.UpdateOptions(options))
+	})
+}
+
+func TestServer_curvePreferences(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	x25519Client := &tls.Config{
+		InsecureSkipVerify: true,
+		CurvePreferences:   []tls.CurveID{tls.X25519},
+	}
+
+	t.Run("are parsed", func(t *testing.T) {
+		req := require.New(t)
+
+		options := &Options{}
+		options.Default()
+		req.Empty(options.CurvePreferences)
+
+		req.NoError(options.Parse(map[interface{}]interface{}{"curvePreferences": []interface{}{"P-384", "P-256"}}))
+		req.Equal([]tls.CurveID{tls.CurveP384, tls.CurveP256}, options.CurvePreferences)
+
+		err := options.Parse(map[interface{}]interface{}{"curvePreferences": []interface{}{"P-256", "P-999"}})
+		req.ErrorContains(err, "invalid value [P-999]")
+	})
+
+	t.Run("restrict key exchange", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.Options.CurvePreferences = []tls.CurveID{tls.CurveP256, tls.CurveP384}
+
+		server := newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+		tlsConfig := server.httpServers[0].TLSConfig
+		req.Equal([]tls.CurveID{tls.CurveP256, tls.CurveP384}, tlsConfig.CurvePreferences)
+
+		_, err := handshake(tlsConfig, x25519Client)
+		req.Error(err)
+
+		_, err = handshake(tlsConfig, &tls.Config{InsecureSkipVerify: true, CurvePreferences: []tls.CurveID{tls.CurveP384}})
+		req.NoError(err)
+
+		options := server.ServerConfig.Options
+		options.CurvePreferences = nil
+		req.NoError(server.UpdateOptions(options))
+
+		_, err = handshake(tlsConfig, x25519Client)
+		req.NoError(err)
+	})
+}
+func TestServer_Listen(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("binds all bind points before the server is started", func(t *testing.T) {
+		req := require.New(t)
+
+		instance := newTestInstance(t, id, nil)
+		server := newTestServer(t, instance, newTestServerConfig(id, freeAddress(t)))
+		defer server.Shutdown(context.Background())
+
+		req.NoError(server.Listen())
+		req.NoError(server.Listen(), "expected listening twice to have no effect")
+
+		//the address is bound, a second bind fails
+		_, err := net.Listen("tcp", server.httpServers[0].Addr)
+		req.Error(err)
+	})
+
+	t.Run("returns an error and closes bound bind points if any bind point fails to bind", func(t *testing.T) {
+		req := require.New(t)
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		req.NoError(err)
+		defer func() { _ = listener.Close() }()
+
+		freeAddr := freeAddress(t)
+
+		serverConfig := newTestServerConfig(id, freeAddr)
+		serverConfig.BindPoints = append(serverConfig.BindPoints, &BindPointConfig{
+			InterfaceAddress: listener.Addr().String(),
+			Address:          listener.Addr().String(),
+		})
+
+		instance := newTestInstance(t, id, nil)
+		server := newTestServer(t, instance, serverConfig)
+
+		req.Error(server.Listen())
+		req.Error(server.StartError())
+
+		rebound, err := net.Listen("tcp", freeAddr)
+		req.NoError(err, "expected the bound bind point to be closed")
+		_ = rebound.Close()
+	})
+}
+
+func TestServer_unixSocket(t *testing.T) {
+	// unix socket paths are limited in length, avoid long test temp dirs
+	dir, err := os.MkdirTemp("", "xweb")
+	require.New(t).NoError(err)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	id, _, _ := newTestIdentity(t, "localhost")
+
+	newUnixClient := func(path string) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", path)
+				},
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name       string
+		disableTls bool
+		scheme     string
+	}{
+		{name: "with TLS", scheme: "https"},
+		{name: "without TLS", disableTls: true, scheme: "http"},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			req := require.New(t)
+			path := filepath.Join(dir, "admin.sock")
+
+			serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+			serverConfig.BindPoints[0].InterfaceAddress = UnixSocketPrefix + path
+			serverConfig.BindPoints[0].DisableTLS = testCase.disableTls
+			req.NoError(serverConfig.BindPoints[0].Validate())
+
+			server := newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+
+			go func() {
+				_ = server.Start()
+			}()
+
+			client := newUnixClient(path)
+			req.Eventually(func() bool {
+				resp, err := client.Get(testCase.scheme + "://localhost/")
+				if err != nil {
+					return false
+				}
+				_ = resp.Body.Close()
+				return true
+			}, 5*time.Second, 10*time.Millisecond)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.Shutdown(ctx)
+
+			_, err := os.Stat(path)
+			req.True(os.IsNotExist(err), "expected socket file to be removed on shutdown")
+		})
+	}
+
+	t.Run("stale socket files are replaced", func(t *testing.T) {
+		req := require.New(t)
+		path := filepath.Join(dir, "stale.sock")
+
+		listener, err := net.Listen("unix", path)
+		req.NoError(err)
+		listener.(*net.UnixListener).SetUnlinkOnClose(false)
+		req.NoError(listener.Close())
+
+		req.NoError(removeStaleUnixSocket(path))
+
+		_, err = os.Stat(path)
+		req.True(os.IsNotExist(err))
+	})
+
+	t.Run("sockets in use are not replaced", func(t *testing.T) {
+		req := require.New(t)
+		path := filepath.Join(dir, "used.sock")
+
+		listener, err := net.Listen("unix", path)
+		req.NoError(err)
+		defer func() { _ = listener.Close() }()
+
+		req.Error(removeStaleUnixSocket(path))
+	})
+}
+
+func TestBindPointConfig_unixSocket(t *testing.T) {
+	t.Run("unix socket interfaces are parsed and validated", func(t *testing.T) {
+		req := require.New(t)
+		bindPoint := &BindPointConfig{}
+		req.NoError(bindPoint.Parse(map[interface{}]interface{}{
+			"interface": "unix:/var/run/ziti/admin.sock",
+			"address":   "localhost:443",
+			"serveTLS":  false,
+		}))
+		req.NoError(bindPoint.Validate())
+		req.True(bindPoint.IsUnixSocket())
+		req.True(bindPoint.DisableTLS)
+		req.Equal("/var/run/ziti/admin.sock", bindPoint.UnixSocketPath())
+	})
+
+	t.Run("an empty unix socket path is invalid", func(t *testing.T) {
+		bindPoint := &BindPointConfig{InterfaceAddress: "unix:", Address: "localhost:443"}
+		require.New(t).Error(bindPoint.Validate())
+	})
+
+	t.Run("TLS may be disabled for TCP interfaces", func(t *testing.T) {
+		bindPoint := &BindPointConfig{InterfaceAddress: "127.0.0.1:443", Address: "localhost:443", DisableTLS: true}
+		require.New(t).NoError(bindPoint.Validate())
+	})
+}
+
+func TestServer_accessLog(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "localhost")
+	instance := newTestInstance(t, id, nil)
+
+	entries := make(chan *middleware.AccessLogEntry, 1)
+	instance.Config.Options.AccessLogSink = func(entry *middleware.AccessLogEntry) {
+		entries <- entry
+	}
+
+	serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+	serverConfig.Options.AccessLog = true
+	server := newTestServer(t, instance, serverConfig)
+
+	recorder := httptest.NewRecorder()
+	server.httpServers[0].Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/some/path", nil))
+
+	entry := <-entries
+	req.Equal(http.StatusOK, entry.Status)
+	req.Equal("/some/path", entry.Path)
+	req.Equal(int64(len("mockHandler")), entry.Bytes)
+	req.Equal("mockHandler", entry.Fields()[middleware.AccessLogFieldBinding])
+}
+
+func TestServer_requestDeadline(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "localhost")
+
+	serve := func(t *testing.T, options func(*Options), request *http.Request) (time.Time, bool) {
+		var deadline time.Time
+		var ok bool
+
+		handler := &funcHandler{handlerFunc: func(writer http.ResponseWriter, request *http.Request) {
+			deadline, ok = request.Context().Deadline()
+		}}
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		options(&serverConfig.Options)
+		server := newTestServer(t, newTestInstance(t, id, handler), serverConfig)
+
+		server.httpServers[0].Handler.ServeHTTP(httptest.NewRecorder(), request)
+
+		return deadline, ok
+	}
+
+	t.Run("deadlines are disabled by default", func(t *testing.T) {
+		_, ok := serve(t, func(*Options) {}, httptest.NewRequest(http.MethodGet, "/", nil))
+		require.New(t).False(ok)
+	})
+
+	t.Run("the write timeout is used if no request timeout is set", func(t *testing.T) {
+		req := require.New(t)
+		deadline, ok := serve(t, func(options *Options) {
+			options.RequestDeadline = true
+			options.WriteTimeout = time.Minute
+		}, httptest.NewRequest(http.MethodGet, "/", nil))
+		req.True(ok)
+		req.WithinDuration(time.Now().Add(time.Minute), deadline, 5*time.Second)
+	})
+
+	t.Run("the request timeout is used if set", func(t *testing.T) {
+		req := require.New(t)
+		deadline, ok := serve(t, func(options *Options) {
+			options.RequestDeadline = true
+			options.RequestTimeout = time.Hour
+		}, httptest.NewRequest(http.MethodGet, "/", nil))
+		req.True(ok)
+		req.WithinDuration(time.Now().Add(time.Hour), deadline, 5*time.Second)
+	})
+
+	t.Run("exempt paths and streaming requests do not have deadlines", func(t *testing.T) {
+		req := require.New(t)
+		enable := func(options *Options) {
+			options.RequestDeadline = true
+			options.RequestDeadlineExemptPaths = []string{"/events"}
+		}
+
+		_, ok := serve(t, enable, httptest.NewRequest(http.MethodGet, "/events/feed", nil))
+		req.False(ok)
+
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.Header.Set("Accept", "text/event-stream")
+		_, ok = serve(t, enable, request)
+		req.False(ok)
+	})
+}
+
+// bindingHandlerFactory is an ApiHandlerFactory for a configurable binding that creates pathHandler's named after it
+type bindingHandlerFactory struct {
+	binding string
+}
+
+func (f *bindingHandlerFactory) Binding() string {
+	return f.binding
+}
+
+func (f *bindingHandlerFactory) New(_ *ServerConfig, _ map[interface{}]interface{}) (ApiHandler, error) {
+	return &bindingPathHandler{pathHandler: pathHandler{name: f.binding, rootPath: "/" + f.binding}}, nil
+}
+
+func (f *bindingHandlerFactory) Validate(_ *InstanceConfig) error {
+	return nil
+}
+
+// bindingPathHandler is a pathHandler whose binding is its name
+type bindingPathHandler struct {
+	pathHandler
+}
+
+func (h *bindingPathHandler) Binding() string {
+	return h.name
+}
+
+func TestServer_dedicatedBindPoints(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "localhost")
+
+	registry := NewRegistryMap()
+	for _, binding := range []string{"a", "b", "c"} {
+		req.NoError(registry.Add(&bindingHandlerFactory{binding: binding}))
+	}
+
+	instance := NewDefaultInstance(registry, id)
+	instance.DemuxFactory = &PathPrefixDemuxFactory{}
+
+	serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+	serverConfig.APIs = []*ApiConfig{{binding: "a"}, {binding: "b"}, {binding: "c"}}
+	serverConfig.BindPoints = []*BindPointConfig{
+		{InterfaceAddress: "127.0.0.1:443", Address: "127.0.0.1:443"},
+		{InterfaceAddress: "127.0.0.1:444", Address: "127.0.0.1:444", APIs: []string{"a"}},
+		{InterfaceAddress: "127.0.0.1:445", Address: "127.0.0.1:445", APIs: []string{"b", "c"}},
+	}
+	req.NoError(serverConfig.Validate(registry))
+
+	server := newTestServer(t, instance, serverConfig)
+
+	t.Run("bind points without apis serve all apis", func(t *testing.T) {
+		req := require.New(t)
+		req.Equal([]string{"a", "b", "c"}, server.httpServers[0].ApiBindingList)
+		req.Equal("b", serveName(server.httpServers[0].Handler, "/b", "127.0.0.1:1000"))
+	})
+
+	t.Run("a bind point dedicated to one api serves it for all paths", func(t *testing.T) {
+		req := require.New(t)
+		req.Equal([]string{"a"}, server.httpServers[1].ApiBindingList)
+		req.Equal("a", serveName(server.httpServers[1].Handler, "/b", "127.0.0.1:1000"))
+	})
+
+	t.Run("a bind point with multiple apis demultiplexes them", func(t *testing.T) {
+		req := require.New(t)
+		req.Equal([]string{"b", "c"}, server.httpServers[2].ApiBindingList)
+		req.Equal("b", serveName(server.httpServers[2].Handler, "/b", "127.0.0.1:1000"))
+		req.Equal("c", serveName(server.httpServers[2].Handler, "/c", "127.0.0.1:1000"))
+	})
+
+	t.Run("bind points may not list apis the server does not have", func(t *testing.T) {
+		serverConfig.BindPoints[1].APIs = []string{"unknown"}
+		defer func() { serverConfig.BindPoints[1].APIs = []string{"a"} }()
+
+		require.New(t).Error(serverConfig.Validate(registry))
+	})
+}
+
+func TestServer_apiPriority(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "localhost")
+
+	registry := NewRegistryMap()
+	for _, binding := range []string{"api", "apiv2", "other"} {
+		require.New(t).NoError(registry.Add(&bindingHandlerFactory{binding: binding}))
+	}
+
+	//string prefix matching so that "/api" overlaps "/apiv2"
+	instance := NewDefaultInstance(registry, id)
+	instance.DemuxFactory = &PathPrefixDemuxFactory{StringPrefixMatching: true}
+
+	newServer := func(t *testing.T, apis ...*ApiConfig) *Server {
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.APIs = apis
+		serverConfig.BindPoints = append(serverConfig.BindPoints, &BindPointConfig{
+			InterfaceAddress: "127.0.0.1:444",
+			Address:          "127.0.0.1:444",
+			APIs:             []string{"api", "apiv2"},
+		})
+		require.New(t).NoError(serverConfig.Validate(registry))
+
+		return newTestServer(t, instance, serverConfig)
+	}
+
+	t.Run("without priorities routing depends on configuration order", func(t *testing.T) {
+		req := require.New(t)
+
+		server := newServer(t, &ApiConfig{binding: "api"}, &ApiConfig{binding: "apiv2"}, &ApiConfig{binding: "other"})
+		req.Equal("api", serveName(server.httpServers[0].Handler, "/apiv2/x", "127.0.0.1:1000"))
+		req.Equal("other", serveName(server.httpServers[0].Handler, "/unknown", "127.0.0.1:1000"))
+	})
+
+	t.Run("with priorities routing does not depend on configuration order", func(t *testing.T) {
+		orders := [][]*ApiConfig{
+			{{binding: "api", priority: 1}, {binding: "apiv2", priority: 2}, {binding: "other"}},
+			{{binding: "other"}, {binding: "api", priority: 1}, {binding: "apiv2", priority: 2}},
+			{{binding: "apiv2", priority: 2}, {binding: "other"}, {binding: "api", priority: 1}},
+		}
+
+		for _, apis := range orders {
+			req := require.New(t)
+
+			server := newServer(t, apis...)
+			req.Equal([]string{"apiv2", "api", "other"}, server.httpServers[0].ApiBindingList)
+			req.Equal("apiv2", serveName(server.httpServers[0].Handler, "/apiv2/x", "127.0.0.1:1000"))
+			req.Equal("api", serveName(server.httpServers[0].Handler, "/api/x", "127.0.0.1:1000"))
+			req.Equal("other", serveName(server.httpServers[0].Handler, "/unknown", "127.0.0.1:1000"))
+
+			req.Equal([]string{"apiv2", "api"}, server.httpServers[1].ApiBindingList)
+			req.Equal("apiv2", serveName(server.httpServers[1].Handler, "/apiv2/x", "127.0.0.1:1000"))
+			req.Equal("api", serveName(server.httpServers[1].Handler, "/unknown", "127.0.0.1:1000"))
+		}
+	})
+
+	t.Run("priority must be an integer", func(t *testing.T) {
+		req := require.New(t)
+
+		api := &ApiConfig{}
+		req.NoError(api.Parse(map[interface{}]interface{}{"binding": "api", "priority": -1}))
+		req.Equal(-1, api.Priority())
+		req.Error(api.Parse(map[interface{}]interface{}{"binding": "api", "priority": "high"}))
+	})
+}
+
+// defaultPathHandler is a bindingPathHandler that declares itself the default
+type defaultPathHandler struct {
+	bindingPathHandler
+}
+
+func (h *defaultPathHandler) IsDefault() bool {
+	return true
+}
+
+// defaultHandlerFactory is a bindingHandlerFactory that creates defaultPathHandler's
+type defaultHandlerFactory struct {
+	bindingHandlerFactory
+}
+
+func (f *defaultHandlerFactory) New(_ *ServerConfig, _ map[interface{}]interface{}) (ApiHandler, error) {
+	return &defaultPathHandler{bindingPathHandler{pathHandler: pathHandler{name: f.binding, rootPath: "/" + f.binding}}}, nil
+}
+
+func TestServer_defaultApi(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "localhost")
+
+	registry := NewRegistryMap()
+	for _, binding := range []string{"a", "b"} {
+		require.New(t).NoError(registry.Add(&bindingHandlerFactory{binding: binding}))
+	}
+	require.New(t).NoError(registry.Add(&defaultHandlerFactory{bindingHandlerFactory{binding: "c"}}))
+
+	instance := NewDefaultInstance(registry, id)
+	instance.DemuxFactory = &PathPrefixDemuxFactory{}
+
+	newServerConfig := func(defaultApi string, apis ...*ApiConfig) *ServerConfig {
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.APIs = apis
+		serverConfig.DefaultApi = defaultApi
+		serverConfig.BindPoints = append(serverConfig.BindPoints, &BindPointConfig{
+			InterfaceAddress: "127.0.0.1:444",
+			Address:          "127.0.0.1:444",
+			APIs:             []string{"b", "c"},
+		})
+		return serverConfig
+	}
+
+	t.Run("the default api serves unmatched requests regardless of order and IsDefault", func(t *testing.T) {
+		req := require.New(t)
+
+		for _, apis := range [][]*ApiConfig{
+			{{binding: "a"}, {binding: "b"}, {binding: "c"}},
+			{{binding: "c"}, {binding: "b"}, {binding: "a"}},
+		} {
+			serverConfig := newServerConfig("a", apis...)
+			req.NoError(serverConfig.Validate(registry))
+
+			server := newTestServer(t, instance, serverConfig)
+			req.Equal("a", serveName(server.httpServers[0].Handler, "/unknown", "127.0.0.1:1000"))
+			req.Equal("b", serveName(server.httpServers[0].Handler, "/b", "127.0.0.1:1000"))
+
+			//bind points that do not serve the default api keep the default of the DemuxFactory
+			req.Equal("c", serveName(server.httpServers[1].Handler, "/unknown", "127.0.0.1:1000"))
+		}
+	})
+
+	t.Run("defaultApi is parsed", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := &ServerConfig{}
+		req.NoError(serverConfig.Parse(map[interface{}]interface{}{
+			"name":       "test",
+			"defaultApi": "a",
+			"apis":       []interface{}{map[interface{}]interface{}{"binding": "a"}},
+			"bindPoints": []interface{}{map[interface{}]interface{}{"interface": "127.0.0.1:443", "address": "127.0.0.1:443"}},
+		}, ""))
+		req.Equal("a", serverConfig.DefaultApi)
+	})
+
+	t.Run("defaultApi must be an enabled api of the server", func(t *testing.T) {
+		req := require.New(t)
+
+		req.Error(newServerConfig("d", &ApiConfig{binding: "a"}, &ApiConfig{binding: "b"}, &ApiConfig{binding: "c"}).Validate(registry))
+		req.Error(newServerConfig("a", &ApiConfig{binding: "a", disabled: true}, &ApiConfig{binding: "b"}, &ApiConfig{binding: "c"}).Validate(registry))
+	})
+
+	t.Run("requireDefaultApi rejects falling back to the last api", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.APIs = []*ApiConfig{{binding: "a"}, {binding: "b"}}
+		serverConfig.RequireDefaultApi = true
+		req.NoError(serverConfig.Validate(registry))
+
+		_, err := NewServer(instance, serverConfig)
+		req.Error(err)
+		req.Contains(err.Error(), "requireDefaultApi is set but no default api is declared for apis [a, b]")
+
+		serverConfig.RequireDefaultApi = false
+		server := newTestServer(t, instance, serverConfig)
+		req.Equal("b", serveName(server.httpServers[0].Handler, "/unknown", "127.0.0.1:1000"))
+	})
+
+	t.Run("requireDefaultApi accepts explicit defaults", func(t *testing.T) {
+		req := require.New(t)
+
+		//the second bind point serves c, which declares itself the default, the first serves the defaultApi
+		serverConfig := newServerConfig("a", &ApiConfig{binding: "a"}, &ApiConfig{binding: "b"}, &ApiConfig{binding: "c"})
+		serverConfig.RequireDefaultApi = true
+		req.NoError(serverConfig.Validate(registry))
+
+		server := newTestServer(t, instance, serverConfig)
+		req.Equal("a", serveName(server.httpServers[0].Handler, "/unknown", "127.0.0.1:1000"))
+		req.Equal("c", serveName(server.httpServers[1].Handler, "/unknown", "127.0.0.1:1000"))
+
+		serverConfig.BindPoints[1].APIs = []string{"a", "b"}
+		_, err := NewServer(instance, serverConfig)
+		req.NoError(err)
+	})
+
+	t.Run("requireDefaultApi is parsed", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := &ServerConfig{}
+		req.NoError(serverConfig.Parse(map[interface{}]interface{}{
+			"name":              "test",
+			"requireDefaultApi": true,
+			"apis":              []interface{}{map[interface{}]interface{}{"binding": "a"}},
+			"bindPoints":        []interface{}{map[interface{}]interface{}{"interface": "127.0.0.1:443", "address": "127.0.0.1:443"}},
+		}, ""))
+		req.True(serverConfig.RequireDefaultApi)
+
+		req.Error((&ServerConfig{}).Parse(map[interface{}]interface{}{"name": "test", "requireDefaultApi": "yes"}, ""))
+	})
+}
+
+func TestServer_serverContextHandler(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	var served *ServerContext
+	handler := &funcHandler{handlerFunc: func(writer http.ResponseWriter, request *http.Request) {
+		served = ServerContextFromRequestContext(request.Context())
+	}}
+
+	server := newTestServer(t, newTestInstance(t, id, handler), newTestServerConfig(id, "127.0.0.1:443"))
+	httpServer := server.httpServers[0]
+
+	baseContext := httpServer.NewBaseContext(nil)
+	request := httptest.NewRequest(http.MethodGet, "/mock-handler", nil).WithContext(baseContext)
+	httpServer.Handler.ServeHTTP(httptest.NewRecorder(), request)
+
+	req.NotNil(served)
+	req.Equal(ApiHandler(handler), served.Handler)
+	req.Same(httpServer.BindPointConfig, served.BindPoint)
+	req.Same(server.ServerConfig, served.ServerConfig)
+
+	//the bind point's ServerContext is shared by all requests and is not modified
+	req.Nil(ServerContextFromRequestContext(baseContext).Handler)
+}
+
+func Test_serveApiHandler_serverContext(t *testing.T) {
+	req := require.New(t)
+
+	serverContext := &ServerContext{}
+	handler := &pathHandler{name: "a"}
+
+	var served *ServerContext
+	observer := &funcHandler{handlerFunc: func(writer http.ResponseWriter, request *http.Request) {
+		served = ServerContextFromRequestContext(request.Context())
+	}}
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request = request.WithContext(context.WithValue(request.Context(), ServerContextKey, serverContext))
+
+	serveApiHandler(&routingHandler{ApiHandler: handler, next: observer}, httptest.NewRecorder(), request)
+
+	req.NotNil(served)
+	req.IsType(&routingHandler{}, served.Handler)
+	req.NotSame(serverContext, served)
+	req.Nil(serverContext.Handler)
+}
+
+// routingHandler is an ApiHandler that serves requests with next
+type routingHandler struct {
+	ApiHandler
+	next http.Handler
+}
+
+func (h *routingHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	h.next.ServeHTTP(writer, request)
+}
+
+func TestServer_disabledApis(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "localhost")
+
+	registry := NewRegistryMap()
+	for _, binding := range []string{"a", "b"} {
+		req.NoError(registry.Add(&bindingHandlerFactory{binding: binding}))
+	}
+
+	instance := NewDefaultInstance(registry, id)
+	instance.DemuxFactory = &PathPrefixDemuxFactory{}
+
+	disabled := &ApiConfig{}
+	req.NoError(disabled.Parse(map[interface{}]interface{}{"binding": "b", "enabled": false}))
+	req.False(disabled.Enabled())
+
+	serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+	serverConfig.APIs = []*ApiConfig{{binding: "a"}, disabled}
+	serverConfig.BindPoints = []*BindPointConfig{
+		{InterfaceAddress: "127.0.0.1:443", Address: "127.0.0.1:443"},
+		{InterfaceAddress: "127.0.0.1:444", Address: "127.0.0.1:444", APIs: []string{"a", "b"}},
+	}
+	req.NoError(serverConfig.Validate(registry))
+
+	server := newTestServer(t, instance, serverConfig)
+
+	t.Run("disabled apis are not served", func(t *testing.T) {
+		req := require.New(t)
+		req.Equal([]string{"a"}, server.httpServers[0].ApiBindingList)
+		req.Equal([]string{"a"}, server.httpServers[1].ApiBindingList)
+		req.NotEqual("b", serveName(server.httpServers[0].Handler, "/b", "127.0.0.1:1000"))
+		req.Equal("a", serveName(server.httpServers[1].Handler, "/b", "127.0.0.1:1000"))
+	})
+
+	t.Run("enabled must be a boolean", func(t *testing.T) {
+		api := &ApiConfig{}
+		require.New(t).Error(api.Parse(map[interface{}]interface{}{"binding": "a", "enabled": "no"}))
+	})
+
+	t.Run("disabled apis must have a valid binding", func(t *testing.T) {
+		serverConfig.APIs[1] = &ApiConfig{binding: "unknown", disabled: true}
+		defer func() { serverConfig.APIs[1] = disabled }()
+
+		require.New(t).Error(serverConfig.Validate(registry))
+	})
+
+	t.Run("bind points may not list only disabled apis", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig.BindPoints[1].APIs = []string{"b"}
+		defer func() { serverConfig.BindPoints[1].APIs = []string{"a", "b"} }()
+
+		err := serverConfig.Validate(registry)
+		req.Error(err)
+		req.Contains(err.Error(), "disabled")
+	})
+
+	t.Run("at least one api must be enabled", func(t *testing.T) {
+		serverConfig.APIs[0].disabled = true
+		defer func() { serverConfig.APIs[0].disabled = false }()
+
+		require.New(t).Error(serverConfig.Validate(registry))
+	})
+}
+
+func TestServerConfig_advertisedAddresses(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+	instance := newTestInstance(t, id, nil)
+
+	t.Run("new addresses must be covered by the identity", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.BindPoints[0].NewAddress = "ctrl.example.com:443"
+		serverConfig.BindPoints = append(serverConfig.BindPoints, &BindPointConfig{
+			InterfaceAddress: "127.0.0.1:444",
+			Address:          "127.0.0.1:444",
+			NewAddresses:     []string{"127.0.0.1:8444", "other.example.com:444"},
+		})
+
+		err := serverConfig.Validate(instance.Registry)
+		req.Error(err)
+		req.Contains(err.Error(), "invalid address at index [0]: identity is not valid for advertised address [ctrl.example.com:443]")
+		req.Contains(err.Error(), "invalid address at index [1]: identity is not valid for advertised address [other.example.com:444]")
+		req.NotContains(err.Error(), "127.0.0.1:8444")
+	})
+
+	t.Run("new addresses may be covered by an sni identity", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.BindPoints[0].NewAddress = "ctrl.example.com:443"
+		sniId, _, _ := newTestIdentity(t, "ctrl.example.com")
+		serverConfig.Identities = map[string]identity.Identity{"ctrl.example.com": sniId}
+
+		req.NoError(serverConfig.Validate(instance.Registry))
+
+		//identities keys are lowercased when parsed, hosts are matched case insensitively
+		serverConfig.BindPoints[0].NewAddress = "Ctrl.Example.COM:443"
+		req.NoError(serverConfig.Validate(instance.Registry))
+	})
+
+	t.Run("addresses served by an sni identity must be covered by it", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.BindPoints[0].Address = "ctrl.example.com:443"
+		sniId, _, _ := newTestIdentity(t, "other.example.com")
+		serverConfig.Identities = map[string]identity.Identity{"ctrl.example.com": sniId}
+
+		err := serverConfig.Validate(instance.Registry)
+		req.Error(err)
+		req.Contains(err.Error(), "invalid identity for server name [ctrl.example.com]")
+		req.Contains(err.Error(), "invalid address at index [0]: identity is not valid for advertised address [ctrl.example.com:443]")
+
+		sniId, _, _ = newTestIdentity(t, "ctrl.example.com")
+		serverConfig.Identities = map[string]identity.Identity{"ctrl.example.com": sniId}
+		req.NoError(serverConfig.Validate(instance.Registry))
+	})
+
+	t.Run("bind points without tls are not checked", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.BindPoints[0].DisableTLS = true
+		serverConfig.BindPoints[0].NewAddress = "ctrl.example.com:443"
+
+		req.NoError(serverConfig.Validate(instance.Registry))
+	})
+
+	t.Run("addresses must resolve if enabled", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.BindPoints[0].Address = "xweb.invalid:443"
+		serverConfig.ResolveAddresses = true
+
+		err := serverConfig.Validate(instance.Registry)
+		req.Error(err)
+		req.Contains(err.Error(), "advertised address [xweb.invalid:443] does not resolve")
+
+		serverConfig.ResolveAddresses = false
+		req.NoError(serverConfig.Validate(instance.Registry))
+	})
+}
+
+func TestServer_ctrlAddressHeader(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+	instance := newTestInstance(t, id, nil)
+
+	header := func(bindPoint *BindPointConfig, path string) string {
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.BindPoints = []*BindPointConfig{bindPoint}
+
+		//the certificates presented must be valid for the new addresses
+		serverConfig.Identities = map[string]identity.Identity{}
+		for _, address := range bindPoint.AllNewAddresses() {
+			host, _, err := net.SplitHostPort(address)
+			require.New(t).NoError(err)
+			serverConfig.Identities[host], _, _ = newTestIdentity(t, host)
+		}
+
+		require.New(t).NoError(serverConfig.Validate(instance.Registry))
+
+		recorder := httptest.NewRecorder()
+		newTestServer(t, instance, serverConfig).httpServers[0].Handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+		return recorder.Header().Get(ZitiCtrlAddressHeader)
+	}
+
+	t.Run("no header is sent without new addresses", func(t *testing.T) {
+		require.New(t).Empty(header(&BindPointConfig{InterfaceAddress: "127.0.0.1:443", Address: "127.0.0.1:443"}, "/"))
+	})
+
+	t.Run("a single new address is sent as is", func(t *testing.T) {
+		bindPoint := &BindPointConfig{}
+		require.New(t).NoError(bindPoint.Parse(map[interface{}]interface{}{
+			"interface":  "127.0.0.1:443",
+			"address":    "127.0.0.1:443",
+			"newAddress": "ctrl.example.com:443",
+		}))
+
+		require.New(t).Equal("https://ctrl.example.com:443", header(bindPoint, "/"))
+	})
+
+	t.Run("multiple new addresses are sent in order", func(t *testing.T) {
+		bindPoint := &BindPointConfig{}
+		require.New(t).NoError(bindPoint.Parse(map[interface{}]interface{}{
+			"interface":    "127.0.0.1:443",
+			"address":      "127.0.0.1:443",
+			"newAddress":   "first.example.com:443",
+			"newAddresses": []interface{}{"second.example.com:443", "first.example.com:443"},
+		}))
+
+		require.New(t).Equal("https://first.example.com:443,https://second.example.com:443", header(bindPoint, "/"))
+	})
+
+	t.Run("new addresses may be limited to paths", func(t *testing.T) {
+		req := require.New(t)
+
+		bindPoint := &BindPointConfig{}
+		req.NoError(bindPoint.Parse(map[interface{}]interface{}{
+			"interface":       "127.0.0.1:443",
+			"address":         "127.0.0.1:443",
+			"newAddress":      "ctrl.example.com:443",
+			"newAddressPaths": []interface{}{"/version", "/authenticate"},
+		}))
+
+		req.Empty(header(bindPoint, "/"))
+		req.Equal("https://ctrl.example.com:443", header(bindPoint, "/version"))
+		req.Equal("https://ctrl.example.com:443", header(bindPoint, "/authenticate?method=cert"))
+	})
+
+	t.Run("new address paths must be absolute", func(t *testing.T) {
+		bindPoint := &BindPointConfig{InterfaceAddress: "127.0.0.1:443", Address: "127.0.0.1:443", NewAddressPaths: []string{"version"}}
+		require.New(t).Error(bindPoint.Validate())
+	})
+
+	t.Run("invalid new addresses are an error", func(t *testing.T) {
+		bindPoint := &BindPointConfig{InterfaceAddress: "127.0.0.1:443", Address: "127.0.0.1:443", NewAddresses: []string{"no-port"}}
+		require.New(t).Error(bindPoint.Validate())
+	})
+}
+
+func TestServerConfig_Warnings(t *testing.T) {
+	t.Run("default options have no warnings", func(t *testing.T) {
+		require.New(t).Empty(newTestServerConfig(nil, "127.0.0.1:443").Warnings())
+	})
+
+	t.Run("suspicious options are warned about", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(nil, "127.0.0.1:443")
+		serverConfig.Options.MinTLSVersion = tls.VersionTLS10
+		serverConfig.Options.WriteTimeout = time.Millisecond
+
+		warnings := serverConfig.Warnings()
+		req.Len(warnings, 2)
+		req.Contains(warnings[0], "TLS1.0")
+		req.Contains(warnings[1], "writeTimeout")
+	})
+
+	t.Run("plain HTTP is warned about more loudly on non-loopback interfaces", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(nil, "127.0.0.1:443")
+		serverConfig.BindPoints = []*BindPointConfig{
+			{InterfaceAddress: "127.0.0.1:80", Address: "localhost:80", DisableTLS: true},
+			{InterfaceAddress: "0.0.0.0:81", Address: "localhost:81", DisableTLS: true},
+		}
+
+		warnings := serverConfig.Warnings()
+		req.Len(warnings, 2)
+		req.NotContains(warnings[0], "non-loopback")
+		req.Contains(warnings[1], "non-loopback interface [0.0.0.0:81]")
+	})
+}
+
+func TestBindPointConfig_IsLoopback(t *testing.T) {
+	req := require.New(t)
+
+	for address, loopback := range map[string]bool{
+		"127.0.0.1:80":     true,
+		"[::1]:80":         true,
+		"localhost:80":     true,
+		"unix:/tmp/a.sock": true,
+		"0.0.0.0:80":       false,
+		"[::]:80":          false,
+		"10.0.0.1:80":      false,
+		"example.com:80":   false,
+	} {
+		req.Equal(loopback, (&BindPointConfig{InterfaceAddress: address}).IsLoopback(), address)
+	}
+}
+
+func TestBindPointConfig_name(t *testing.T) {
+	t.Run("is parsed", func(t *testing.T) {
+		req := require.New(t)
+
+		bindPoint := &BindPointConfig{}
+		req.NoError(bindPoint.Parse(map[interface{}]interface{}{
+			"name":      " public ",
+			"interface": "127.0.0.1:443",
+			"address":   "127.0.0.1:443",
+		}))
+		req.Equal("public", bindPoint.Name)
+
+		req.Error((&BindPointConfig{}).Parse(map[interface{}]interface{}{"name": 1}))
+	})
+
+	t.Run("defaults to the interface address", func(t *testing.T) {
+		req := require.New(t)
+
+		bindPoint := &BindPointConfig{}
+		req.NoError(bindPoint.Parse(map[interface{}]interface{}{
+			"interface": "127.0.0.1:0",
+			"address":   "127.0.0.1:443",
+		}))
+		req.Equal("127.0.0.1:0", bindPoint.Name)
+	})
+
+	t.Run("is the name of the bind point's http server", func(t *testing.T) {
+		req := require.New(t)
+		id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.BindPoints[0].Name = "public"
+		serverConfig.BindPoints = append(serverConfig.BindPoints, &BindPointConfig{InterfaceAddress: "127.0.0.1:444", Address: "127.0.0.1:444"})
+
+		server := newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+		req.Equal("public", server.httpServers[0].Name)
+		req.Equal("127.0.0.1:444", server.httpServers[1].Name)
+	})
+}
+
+func TestServer_tcpWithoutTls(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	serverConfig := newTestServerConfig(id, freeAddress(t))
+	serverConfig.BindPoints[0].DisableTLS = true
+	req.NoError(serverConfig.BindPoints[0].Validate())
+
+	server := newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+	req.Nil(server.httpServers[0].TLSConfig)
+
+	go func() {
+		_ = server.Start()
+	}()
+	defer server.Shutdown(context.Background())
+
+	req.Eventually(func() bool {
+		resp, err := http.Get("http://" + server.httpServers[0].Addr + "/mock-handler")
+		if err != nil {
+			return false
+		}
+		_ = resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestServer_clientAuth(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("defaults to requesting a client certificate", func(t *testing.T) {
+		server := newTestServer(t, newTestInstance(t, id, nil), newTestServerConfig(id, "127.0.0.1:443"))
+		require.New(t).Equal(tls.RequestClientCert, server.httpServers[0].TLSConfig.ClientAuth)
+	})
+
+	t.Run("is parsed and validated", func(t *testing.T) {
+		req := require.New(t)
+
+		bindPoint := &BindPointConfig{}
+		req.NoError(bindPoint.Parse(map[interface{}]interface{}{
+			"interface":  "127.0.0.1:443",
+			"address":    "127.0.0.1:443",
+			"clientAuth": "require",
+		}))
+		req.NoError(bindPoint.Validate())
+		req.Equal(tls.RequireAnyClientCert, bindPoint.ClientAuth())
+
+		bindPoint.ClientAuthPolicy = "sometimes"
+		req.ErrorContains(bindPoint.Validate(), "invalid value [sometimes] for clientAuth")
+
+		bindPoint.ClientAuthPolicy = "none"
+		bindPoint.DisableTLS = true
+		req.ErrorContains(bindPoint.Validate(), "clientAuth may not be set when serveTLS is false")
+
+		req.Error((&BindPointConfig{}).Parse(map[interface{}]interface{}{"clientAuth": 1}))
+	})
+
+	t.Run("is applied per bind point", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.BindPoints = append(serverConfig.BindPoints, &BindPointConfig{
+			InterfaceAddress: "127.0.0.1:444",
+			Address:          "127.0.0.1:444",
+			ClientAuthPolicy: "require",
+		})
+
+		server := newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+		req.Len(server.httpServers, 2)
+
+		defaultTlsConfig := server.httpServers[0].TLSConfig
+		requireTlsConfig := server.httpServers[1].TLSConfig
+
+		req.Equal(tls.RequestClientCert, defaultTlsConfig.ClientAuth)
+		req.Equal(tls.RequireAnyClientCert, requireTlsConfig.ClientAuth)
+
+		clientTlsConfig := &tls.Config{InsecureSkipVerify: true}
+
+		_, err := handshake(defaultTlsConfig, clientTlsConfig)
+		req.NoError(err)
+
+		// TLS 1.3 clients complete the handshake before the server rejects the missing certificate, read to observe it
+		serverConn, clientConn := net.Pipe()
+		defer func() { _ = clientConn.Close() }()
+
+		go func() {
+			_ = tls.Server(serverConn, requireTlsConfig).Handshake()
+			_ = serverConn.Close()
+		}()
+
+		client := tls.Client(clientConn, clientTlsConfig)
+		err = client.Handshake()
+		if err == nil {
+			_, err = client.Read(make([]byte, 1))
+		}
+		req.Error(err)
+	})
+}
+
+func TestServer_clientCas(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+	clientId, clientCertFile, _ := newTestIdentity(t, "client")
+	otherClientId, _, _ := newTestIdentity(t, "other")
+
+	clientCertPem, err := os.ReadFile(clientCertFile)
+	require.New(t).NoError(err)
+
+	t.Run("are validated", func(t *testing.T) {
+		req := require.New(t)
+
+		req.NoError((&BindPointConfig{InterfaceAddress: "127.0.0.1:443", Address: "127.0.0.1:443", ClientCas: clientCertFile}).Validate())
+		req.NoError((&BindPointConfig{InterfaceAddress: "127.0.0.1:443", Address: "127.0.0.1:443", ClientCas: "pem:" + string(clientCertPem)}).Validate())
+
+		err := (&BindPointConfig{InterfaceAddress: "127.0.0.1:443", Address: "127.0.0.1:443", ClientCas: filepath.Join(t.TempDir(), "missing.pem")}).Validate()
+		req.ErrorContains(err, "invalid clientCas")
+
+		err = (&BindPointConfig{InterfaceAddress: "127.0.0.1:443", Address: "127.0.0.1:443", ClientCas: "pem:not a certificate"}).Validate()
+		req.ErrorContains(err, "invalid clientCas")
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.ClientCas = "pem:not a certificate"
+		req.ErrorContains(serverConfig.Validate(newTestInstance(t, id, nil).GetRegistry()), "invalid clientCas")
+	})
+
+	t.Run("are applied per server", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.ClientCas = clientCertFile
+		serverConfig.BindPoints[0].ClientAuthPolicy = "requireAndVerify"
+
+		server := newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+		tlsConfig := server.httpServers[0].TLSConfig
+
+		req.NoError(handshakeWith(tlsConfig, *clientId.Cert()))
+		req.Error(handshakeWith(tlsConfig, *otherClientId.Cert()))
+	})
+
+	t.Run("are applied per bind point", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.BindPoints[0].ClientAuthPolicy = "requireAndVerify"
+		serverConfig.BindPoints[0].ClientCas = "pem:" + string(clientCertPem)
+
+		server := newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+		tlsConfig := server.httpServers[0].TLSConfig
+
+		req.NoError(handshakeWith(tlsConfig, *clientId.Cert()))
+		req.Error(handshakeWith(tlsConfig, *otherClientId.Cert()))
+	})
+}
+
+func TestBindPointConfig_network(t *testing.T) {
+	t.Run("is parsed and validated", func(t *testing.T) {
+		req := require.New(t)
+
+		bindPoint := &BindPointConfig{}
+		req.NoError(bindPoint.Parse(map[interface{}]interface{}{
+			"interface": "[::]:8443",
+			"address":   "127.0.0.1:8443",
+			"network":   "tcp6",
+		}))
+		req.NoError(bindPoint.Validate())
+		req.Equal(NetworkTcp6, bindPoint.ListenNetwork())
+
+		for _, invalid := range []struct {
+			network          string
+			interfaceAddress string
+			err              string
+		}{
+			{"udp", "127.0.0.1:8443", "invalid value [udp] for network"},
+			{NetworkTcp4, "[::1]:8443", "IPv6 interface address"},
+			{NetworkTcp6, "127.0.0.1:8443", "IPv4 interface address"},
+			{NetworkTcp4, "unix:/tmp/a.sock", "unix socket"},
+		} {
+			bindPoint := &BindPointConfig{InterfaceAddress: invalid.interfaceAddress, Address: "127.0.0.1:8443", Network: invalid.network}
+			req.ErrorContains(bindPoint.Validate(), invalid.err, invalid.network)
+		}
+
+		req.Equal(NetworkTcp, (&BindPointConfig{InterfaceAddress: "0.0.0.0:8443"}).ListenNetwork())
+		req.Equal("unix", (&BindPointConfig{InterfaceAddress: "unix:/tmp/a.sock"}).ListenNetwork())
+	})
+
+	t.Run("is used to listen", func(t *testing.T) {
+		req := require.New(t)
+
+		id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+		serverConfig := newTestServerConfig(id, freeAddress(t))
+		serverConfig.BindPoints[0].Network = NetworkTcp4
+
+		server := newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+		startTestServer(t, server)
+		defer server.Shutdown(context.Background())
+
+		resp, err := newTestClient().Get("https://" + server.httpServers[0].Addr + "/mock-handler")
+		req.NoError(err)
+		_ = resp.Body.Close()
+		req.Equal(http.StatusOK, resp.StatusCode)
+	})
+}
+
+func TestServer_h2c(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("requires TLS to be disabled", func(t *testing.T) {
+		req := require.New(t)
+
+		bindPoint := &BindPointConfig{}
+		req.NoError(bindPoint.Parse(map[interface{}]interface{}{
+			"interface": "127.0.0.1:8080",
+			"address":   "127.0.0.1:8080",
+			"h2c":       true,
+		}))
+		req.ErrorContains(bindPoint.Validate(), "h2c may only be set when serveTLS is false")
+
+		bindPoint.DisableTLS = true
+		req.NoError(bindPoint.Validate())
+	})
+
+	t.Run("serves prior knowledge HTTP/2 clients", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, freeAddress(t))
+		serverConfig.BindPoints[0].DisableTLS = true
+		serverConfig.BindPoints[0].H2c = true
+
+		server := newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+		go func() {
+			_ = server.Start()
+		}()
+		defer server.Shutdown(context.Background())
+
+		client := &http.Client{
+			Transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, network, addr)
+				},
+			},
+		}
+
+		url := "http://" + server.httpServers[0].Addr + "/mock-handler"
+
+		var resp *http.Response
+		req.Eventually(func() bool {
+			var err error
+			resp, err = client.Get(url)
+			return err == nil
+		}, 5*time.Second, 10*time.Millisecond)
+		_ = resp.Body.Close()
+
+		req.Equal(http.StatusOK, resp.StatusCode)
+		req.Equal(2, resp.ProtoMajor)
+
+		//HTTP/1.1 clients are still served
+		resp, err := http.Get(url)
+		req.NoError(err)
+		_ = resp.Body.Close()
+		req.Equal(1, resp.ProtoMajor)
+	})
+}
+
+func TestServer_redirectHttps(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("implies plain HTTP", func(t *testing.T) {
+		req := require.New(t)
+
+		bindPoint := &BindPointConfig{}
+		req.NoError(bindPoint.Parse(map[interface{}]interface{}{
+			"interface":     "127.0.0.1:8080",
+			"address":       "127.0.0.1:8443",
+			"redirectHttps": true,
+		}))
+		req.True(bindPoint.DisableTLS)
+		req.NoError(bindPoint.Validate())
+
+		err := (&BindPointConfig{}).Parse(map[interface{}]interface{}{
+			"interface":     "127.0.0.1:8080",
+			"address":       "127.0.0.1:8443",
+			"redirectHttps": true,
+			"serveTLS":      true,
+		})
+		req.ErrorContains(err, "redirectHttps may not be set when serveTLS is true")
+
+		bindPoint.APIs = []string{"test"}
+		req.ErrorContains(bindPoint.Validate(), "apis may not be set when redirectHttps is true")
+	})
+
+	t.Run("omits the default https port", func(t *testing.T) {
+		req := require.New(t)
+
+		for address, expected := range map[string]string{
+			"example.com:443":  "https://example.com/a%2Fb/c?d=e",
+			"example.com:8443": "https://example.com:8443/a%2Fb/c?d=e",
+			"[::1]:443":        "https://[::1]/a%2Fb/c?d=e",
+		} {
+			recorder := httptest.NewRecorder()
+			newHttpsRedirectHandler(address).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "http://other/a%2Fb/c?d=e", nil))
+
+			req.Equal(http.StatusMovedPermanently, recorder.Code)
+			req.Equal(expected, recorder.Header().Get("Location"))
+		}
+	})
+
+	t.Run("redirects to the advertised address alongside TLS bind points", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, freeAddress(t))
+		redirectAddress := freeAddress(t)
+		serverConfig.BindPoints = append(serverConfig.BindPoints, &BindPointConfig{
+			InterfaceAddress: redirectAddress,
+			Address:          serverConfig.BindPoints[0].Address,
+			DisableTLS:       true,
+			RedirectHttps:    true,
+		})
+		instance := newTestInstance(t, id, nil)
+		req.NoError(serverConfig.Validate(instance.GetRegistry()))
+
+		server := newTestServer(t, instance, serverConfig)
+		startTestServer(t, server)
+		defer server.Shutdown(context.Background())
+
+		client := newTestClient()
+		client.CheckRedirect = func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}
+
+		resp, err := client.Get("http://" + redirectAddress + "/mock-handler?a=b")
+		req.NoError(err)
+		_ = resp.Body.Close()
+
+		req.Equal(http.StatusMovedPermanently, resp.StatusCode)
+		req.Equal("https://"+serverConfig.BindPoints[0].Address+"/mock-handler?a=b", resp.Header.Get("Location"))
+
+		//the redirect is followed to the TLS bind point
+		client.CheckRedirect = nil
+		resp, err = client.Get("http://" + redirectAddress + "/mock-handler")
+		req.NoError(err)
+		_ = resp.Body.Close()
+		req.Equal(http.StatusOK, resp.StatusCode)
+	})
+}
+
+func TestServer_errorLog(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("defaults to the default logger", func(t *testing.T) {
+		server := newTestServer(t, newTestInstance(t, id, nil), newTestServerConfig(id, "127.0.0.1:443"))
+		require.New(t).NotNil(server.httpServers[0].ErrorLog)
+	})
+
+	t.Run("is supplied per bind point", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, freeAddress(t))
+		serverConfig.BindPoints[0].Network = NetworkTcp4
+
+		var lock sync.Mutex
+		buffer := &bytes.Buffer{}
+		errorLog := log.New(writerFunc(func(p []byte) (int, error) {
+			lock.Lock()
+			defer lock.Unlock()
+			return buffer.Write(p)
+		}), "", 0)
+
+		instance := newTestInstance(t, id, nil)
+		instance.Config.Options.ErrorLog = func(config *ServerConfig, bindPoint *BindPointConfig) *log.Logger {
+			req.Same(serverConfig, config)
+			req.Same(serverConfig.BindPoints[0], bindPoint)
+			return errorLog
+		}
+
+		server := newTestServer(t, instance, serverConfig)
+		req.Same(errorLog, server.httpServers[0].ErrorLog)
+
+		startTestServer(t, server)
+		defer server.Shutdown(context.Background())
+
+		conn, err := net.Dial("tcp", server.httpServers[0].Addr)
+		req.NoError(err)
+		_, _ = conn.Write([]byte("not a TLS client hello\r\n\r\n"))
+		_ = conn.Close()
+
+		req.Eventually(func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+			return strings.Contains(buffer.String(), "TLS handshake error")
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+}
+
+// writerFunc is an io.Writer implemented by a function
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestServer_maxHeaderBytes(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("defaults to the Go default", func(t *testing.T) {
+		instance := newTestInstance(t, id, nil)
+		server := newTestServer(t, instance, newTestServerConfig(id, "127.0.0.1:443"))
+
+		require.New(t).Equal(0, server.httpServers[0].MaxHeaderBytes)
+	})
+
+	t.Run("is applied to each bind point", func(t *testing.T) {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		req.NoError(serverConfig.Options.Parse(map[interface{}]interface{}{"maxHeaderBytes": 4 << 20}))
+
+		instance := newTestInstance(t, id, nil)
+		server := newTestServer(t, instance, serverConfig)
+
+		req.Equal(4<<20, server.httpServers[0].MaxHeaderBytes)
+	})
+
+	t.Run("must be positive", func(t *testing.T) {
+		options := &Options{}
+		require.New(t).Error(options.Parse(map[interface{}]interface{}{"maxHeaderBytes": 0}))
+	})
+}
+
+func TestServer_keepAlivesEnabled(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	get := func(t *testing.T, keepAlivesEnabled bool) *http.Response {
+		serverConfig := newTestServerConfig(id, freeAddress(t))
+		serverConfig.Options.KeepAlivesEnabled = keepAlivesEnabled
+
+		server := newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+		startTestServer(t, server)
+		t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+		resp, err := newTestClient().Get("https://" + server.httpServers[0].Addr + "/mock-handler")
+		require.New(t).NoError(err)
+		_ = resp.Body.Close()
+
+		return resp
+	}
+
+	t.Run("connections are kept alive by default", func(t *testing.T) {
+		require.New(t).False(get(t, true).Close)
+	})
+
+	t.Run("connections are closed after each request when disabled", func(t *testing.T) {
+		//Close reports the response's "Connection: close" header
+		require.New(t).True(get(t, false).Close)
+	})
+}
+
+func TestServer_compression(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	handler := &funcHandler{handlerFunc: func(writer http.ResponseWriter, _ *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		_, _ = writer.Write([]byte(`{"hello":"world"}`))
+	}}
+
+	get := func(t *testing.T, options map[interface{}]interface{}) *http.Response {
+		req := require.New(t)
+
+		serverConfig := newTestServerConfig(id, freeAddress(t))
+		req.NoError(serverConfig.Options.Parse(options))
+		req.NoError(serverConfig.Options.CompressionOptions.Validate())
+
+		server := newTestServer(t, newTestInstance(t, id, handler), serverConfig)
+		startTestServer(t, server)
+		t.Cleanup(func() { server.Shutdown(context.Background()) })
+
+		request, err := http.NewRequest(http.MethodGet, "https://"+server.httpServers[0].Addr+"/mock-handler", nil)
+		req.NoError(err)
+		request.Header.Set("Accept-Encoding", "gzip")
+
+		resp, err := newTestClient().Do(request)
+		req.NoError(err)
+		_ = resp.Body.Close()
+
+		return resp
+	}
+
+	t.Run("responses are compressed by default", func(t *testing.T) {
+		require.New(t).Equal("gzip", get(t, map[interface{}]interface{}{}).Header.Get("Content-Encoding"))
+	})
+
+	t.Run("responses are not compressed when disabled", func(t *testing.T) {
+		require.New(t).Empty(get(t, map[interface{}]interface{}{"compression": false}).Header.Get("Content-Encoding"))
+	})
+
+	t.Run("responses smaller than compressionMinSize are not compressed", func(t *testing.T) {
+		require.New(t).Empty(get(t, map[interface{}]interface{}{"compressionMinSize": 1024}).Header.Get("Content-Encoding"))
+	})
+
+	t.Run("responses are limited to compressionContentTypes", func(t *testing.T) {
+		req := require.New(t)
+
+		resp := get(t, map[interface{}]interface{}{"compressionContentTypes": []interface{}{"text/*"}})
+		req.Empty(resp.Header.Get("Content-Encoding"))
+
+		resp = get(t, map[interface{}]interface{}{"compressionContentTypes": []interface{}{"application/json"}, "compressionLevel": 9})
+		req.Equal("gzip", resp.Header.Get("Content-Encoding"))
+	})
+
+	t.Run("invalid options are rejected", func(t *testing.T) {
+		req := require.New(t)
+
+		for _, config := range []map[interface{}]interface{}{
+			{"compressionLevel": 10},
+			{"compressionMinSize": -1},
+			{"compressionContentTypes": []interface{}{" "}},
+		} {
+			options := &Options{}
+			options.Default()
+			req.NoError(options.Parse(config))
+			req.Error(options.CompressionOptions.Validate(), "%v", config)
+		}
+
+		options := &Options{}
+		options.Default()
+		req.Error(options.Parse(map[interface{}]interface{}{"compression": "yes"}))
+	})
+
+	t.Run("compression options cannot be updated", func(t *testing.T) {
+		req := require.New(t)
+
+		server := newTestServer(t, newTestInstance(t, id, handler), newTestServerConfig(id, freeAddress(t)))
+
+		options := server.ServerConfig.Options
+		options.CompressionContentTypes = []string{"text/*"}
+		req.Error(server.UpdateOptions(options))
+	})
+}
+
+// lifecycleEvents records the Start and Stop calls of lifecycleHandler's
+type lifecycleEvents struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (e *lifecycleEvents) add(event string) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.events = append(e.events, event)
+}
+
+func (e *lifecycleEvents) get() []string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]string(nil), e.events...)
+}
+
+// lifecycleHandler is a mockHandler that records Start and Stop calls
+type lifecycleHandler struct {
+	mockHandler
+	startErr error
+	events   *lifecycleEvents
+	name     string
+}
+
+func (h *lifecycleHandler) Start() error {
+	h.events.add("start " + h.name)
+	return h.startErr
+}
+
+func (h *lifecycleHandler) Stop(_ context.Context) {
+	h.events.add("stop " + h.name)
+}
+
+func TestServer_lifecycleHandlers(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	newServer := func(t *testing.T, handlers ...*lifecycleHandler) *Server {
+		registry := NewRegistryMap()
+		require.New(t).NoError(registry.Add(&sequenceHandlerFactory{handlers: handlers}))
+
+		serverConfig := newTestServerConfig(id, freeAddress(t))
+		serverConfig.APIs = nil
+		for range handlers {
+			serverConfig.APIs = append(serverConfig.APIs, &ApiConfig{binding: "mockHandler"})
+		}
+
+		return newTestServer(t, NewDefaultInstance(registry, id), serverConfig)
+	}
+
+	t.Run("handlers are started with the server and stopped in reverse order on shutdown", func(t *testing.T) {
+		req := require.New(t)
+
+		events := &lifecycleEvents{}
+		server := newServer(t, &lifecycleHandler{events: events, name: "a"}, &lifecycleHandler{events: events, name: "b"})
+
+		startTestServer(t, server)
+
+		req.Eventually(func() bool {
+			return len(events.get()) == 2
+		}, 5*time.Second, 10*time.Millisecond)
+		req.Equal([]string{"start a", "start b"}, events.get())
+
+		server.Shutdown(context.Background())
+
+		req.Equal([]string{"start a", "start b", "stop b", "stop a"}, events.get())
+	})
+
+	t.Run("a failing handler aborts start and stops started handlers", func(t *testing.T) {
+		req := require.New(t)
+
+		events := &lifecycleEvents{}
+		server := newServer(t, &lifecycleHandler{events: events, name: "a"}, &lifecycleHandler{events: events, name: "b", startErr: errors.New("boom")})
+
+		req.ErrorContains(server.Start(), "boom")
+		req.Equal([]string{"start a", "start b", "stop a"}, events.get())
+
+		server.Shutdown(context.Background())
+		req.Equal([]string{"start a", "start b", "stop a"}, events.get())
+	})
+}
+
+// sequenceHandlerFactory returns its handlers in order from successive calls to New
+type sequenceHandlerFactory struct {
+	mockHandlerFactory
+	handlers []*lifecycleHandler
+	next     int
+}
+
+func (f *sequenceHandlerFactory) New(_ *ServerConfig, _ map[interface{}]interface{}) (ApiHandler, error) {
+	handler := f.handlers[f.next]
+	f.next++
+	return handler, nil
+}
+
+// countingListener is a net.Listener that counts accepted connections
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func TestServer_listenerMutator(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("bind point listeners are replaced by the mutated listener", func(t *testing.T) {
+		req := require.New(t)
+
+		//the mutator runs on the goroutine starting the server, hand the bind point over to observe its writes
+		mutatedBindPoints := make(chan *BindPointConfig, 1)
+		counting := &countingListener{}
+
+		instance := newTestInstance(t, id, nil)
+		instance.Config.Options.ListenerMutator = func(listener net.Listener, bindPoint *BindPointConfig) net.Listener {
+			counting.Listener = listener
+			mutatedBindPoints <- bindPoint
+			return counting
+		}
+
+		server := newTestServer(t, instance, newTestServerConfig(id, freeAddress(t)))
+		startTestServer(t, server)
+		defer server.Shutdown(context.Background())
+
+		req.Same(server.httpServers[0].BindPointConfig, <-mutatedBindPoints)
+		req.Eventually(func() bool {
+			return counting.accepted.Load() > 0
+		}, 5*time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("a mutator that returns no listener fails the bind", func(t *testing.T) {
+		instance := newTestInstance(t, id, nil)
+		instance.Config.Options.ListenerMutator = func(net.Listener, *BindPointConfig) net.Listener {
+			return nil
+		}
+
+		server := newTestServer(t, instance, newTestServerConfig(id, freeAddress(t)))
+		require.New(t).Error(server.Listen())
+	})
+}
+
+func TestServer_maxConnections(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	serverConfig := newTestServerConfig(id, freeAddress(t))
+	serverConfig.BindPoints[0].MaxConnections = 1
+
+	server := newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+	startTestServer(t, server)
+	defer server.Shutdown(context.Background())
+
+	httpServer := server.httpServers[0]
+	url := "https://" + httpServer.Addr + "/mock-handler"
+
+	//startTestServer's probe connection is released once the server notices it is closed
+	req.Eventually(func() bool {
+		return httpServer.ConnectionCount() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	first := newTestClient()
+	resp, err := first.Get(url)
+	req.NoError(err)
+	_ = resp.Body.Close()
+
+	//the first client keeps its connection alive and open
+	req.Equal(int64(1), httpServer.ConnectionCount())
+
+	second := newTestClient()
+	second.Timeout = 200 * time.Millisecond
+	_, err = second.Get(url)
+	req.Error(err, "expected the second connection to wait for the first to close")
+
+	first.CloseIdleConnections()
+
+	second.Timeout = 5 * time.Second
+	resp, err = second.Get(url)
+	req.NoError(err)
+	_ = resp.Body.Close()
+}
+
+func TestServer_onConnState(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	serverConfig := newTestServerConfig(id, freeAddress(t))
+	serverConfig.BindPoints[0].MaxConnections = 1
+
+	var lock sync.Mutex
+	var states []http.ConnState
+	var bindPoints []*BindPointConfig
+
+	instance := newTestInstance(t, id, nil)
+	instance.Config.Options.OnConnState = func(conn net.Conn, state http.ConnState, bindPoint *BindPointConfig) {
+		lock.Lock()
+		defer lock.Unlock()
+		states = append(states, state)
+		bindPoints = append(bindPoints, bindPoint)
+	}
+
+	server := newTestServer(t, instance, serverConfig)
+	startTestServer(t, server)
+	defer server.Shutdown(context.Background())
+
+	httpServer := server.httpServers[0]
+	url := "https://" + httpServer.Addr + "/mock-handler"
+
+	//connections are still accounted for the maxConnections option
+	for i := 0; i < 2; i++ {
+		client := newTestClient()
+		resp, err := client.Get(url)
+		req.NoError(err)
+		_ = resp.Body.Close()
+		client.CloseIdleConnections()
+
+		req.Eventually(func() bool {
+			return httpServer.ConnectionCount() == 0
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	req.Contains(states, http.StateNew)
+	req.Contains(states, http.StateActive)
+	req.Contains(states, http.StateIdle)
+	req.Contains(states, http.StateClosed)
+
+	for _, bindPoint := range bindPoints {
+		req.Same(serverConfig.BindPoints[0], bindPoint)
+	}
+}
+
+func TestServer_BoundAddresses(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	serverConfig := newTestServerConfig(id, "127.0.0.1:0")
+	serverConfig.BindPoints[0].Address = "127.0.0.1:443"
+	req.NoError(serverConfig.BindPoints[0].Validate(), "expected port 0 to be a valid interface port")
+
+	server := newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+	defer server.Shutdown(context.Background())
+
+	req.Nil(server.BoundAddresses())
+	req.NoError(server.Listen())
+
+	addresses := server.BoundAddresses()
+	req.Len(addresses, 1)
+
+	tcpAddr, ok := addresses[0].(*net.TCPAddr)
+	req.True(ok)
+	req.NotZero(tcpAddr.Port)
+
+	go func() {
+		_ = server.Start()
+	}()
+
+	resp, err := newTestClient().Get("https://" + addresses[0].String() + "/mock-handler")
+	req.NoError(err)
+	_ = resp.Body.Close()
+	req.Equal(http.StatusOK, resp.StatusCode)
+
+	t.Run("http servers and listeners are exposed in bind point order", func(t *testing.T) {
+		req := require.New(t)
+
+		httpServers := server.HttpServers()
+		req.Len(httpServers, 1)
+		req.Same(server.httpServers[0].Server, httpServers[0])
+
+		listeners := server.Listeners()
+		req.Len(listeners, 1)
+		req.Equal(addresses[0], listeners[0].Addr())
+
+		unbound := newTestServer(t, newTestInstance(t, id, nil), newTestServerConfig(id, "127.0.0.1:0"))
+		req.Nil(unbound.Listeners())
+	})
+
+	t.Run("advertised addresses may not use port 0", func(t *testing.T) {
+		bindPoint := &BindPointConfig{InterfaceAddress: "127.0.0.1:0", Address: "127.0.0.1:0"}
+		require.New(t).Error(bindPoint.Validate())
+	})
+}
@@ -0,0 +1,79 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/michaelquigley/pfxlog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Reloader is implemented by Instance's that can apply a new configuration while running, see InstanceImpl.Reload.
+type Reloader interface {
+	Reload(cfgmap map[interface{}]interface{}) error
+}
+
+// ConfigLoader loads a fresh configuration map, e.g. by re-reading the configuration file the process started with.
+type ConfigLoader func() (map[interface{}]interface{}, error)
+
+// WatchSignals reloads the configuration of a Reloader each time the process receives SIGHUP. The configuration is
+// obtained from loader and passed to Reloader.Reload, failures are logged and leave the running configuration in
+// place. Signals are watched until the returned function is called, which may be called more than once.
+func WatchSignals(reloader Reloader, loader ConfigLoader) func() {
+	signals := make(chan os.Signal, 1)
+	closeNotify := make(chan struct{})
+	stopOnce := sync.Once{}
+
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(signals)
+
+		for {
+			select {
+			case <-signals:
+				reloadFromLoader(reloader, loader)
+			case <-closeNotify:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		stopOnce.Do(func() {
+			close(closeNotify)
+		})
+	}
+}
+
+func reloadFromLoader(reloader Reloader, loader ConfigLoader) {
+	log := pfxlog.Logger()
+
+	log.Info("SIGHUP received, reloading configuration")
+
+	cfgmap, err := loader()
+	if err != nil {
+		log.Errorf("could not load configuration for reload: %v", err)
+		return
+	}
+
+	if err = reloader.Reload(cfgmap); err != nil {
+		log.Errorf("could not reload configuration: %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+//go:build !windows
+
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// reloaderFunc adapts a function to the Reloader interface
+type reloaderFunc func(cfgmap map[interface{}]interface{}) error
+
+func (f reloaderFunc) Reload(cfgmap map[interface{}]interface{}) error {
+	return f(cfgmap)
+}
+
+func TestWatchSignals(t *testing.T) {
+	req := require.New(t)
+
+	cfgmap := map[interface{}]interface{}{"web": []interface{}{}}
+	reloads := atomic.Int32{}
+
+	stop := WatchSignals(reloaderFunc(func(reloaded map[interface{}]interface{}) error {
+		req.Equal(cfgmap, reloaded)
+		reloads.Add(1)
+		return nil
+	}), func() (map[interface{}]interface{}, error) {
+		return cfgmap, nil
+	})
+
+	req.NoError(syscall.Kill(syscall.Getpid(), syscall.SIGHUP))
+	req.Eventually(func() bool { return reloads.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	stop()
+	stop()
+}
@@ -0,0 +1,318 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	// StaticBinding is the binding of the StaticFileHandlerFactory
+	StaticBinding = "static"
+
+	// DefaultStaticIndex is the index file served for directories by StaticFileHandler's that do not configure one
+	DefaultStaticIndex = "index.html"
+)
+
+// StaticFileOptions are the options of a StaticFileHandler, parsed from the options of its ApiConfig.
+type StaticFileOptions struct {
+	// RootPath is the path prefix the files are served under, "/" if not set (e.g. "/ui" serves index.html for "/ui/")
+	RootPath string
+
+	// Directory is the directory files are served from. Exactly one of Directory and FileSystem must be set.
+	Directory string
+
+	// FileSystem is the name of a fs.FS (e.g. an embed.FS) supplied to NewStaticFileHandlerFactory that files are
+	// served from. Exactly one of Directory and FileSystem must be set.
+	FileSystem string
+
+	// Index is the file served for requests of directories, DefaultStaticIndex if not set
+	Index string
+
+	// SpaFallback, if true, serves the Index of the root directory instead of a 404 for files that do not exist, so
+	// that single page applications may route paths client side
+	SpaFallback bool
+
+	// CacheControl, if set, is the Cache-Control header value of all responses
+	CacheControl string
+}
+
+// Parse the options map of a StaticFileHandler's ApiConfig.
+func (options *StaticFileOptions) Parse(config map[interface{}]interface{}) error {
+	for _, option := range []struct {
+		key    string
+		target *string
+	}{
+		{"rootPath", &options.RootPath},
+		{"directory", &options.Directory},
+		{"fileSystem", &options.FileSystem},
+		{"index", &options.Index},
+		{"cacheControl", &options.CacheControl},
+	} {
+		if interfaceVal, ok := config[option.key]; ok {
+			if value, ok := interfaceVal.(string); ok {
+				*option.target = value
+			} else {
+				return fmt.Errorf("could not use value for %s, not a string", option.key)
+			}
+		}
+	}
+
+	if interfaceVal, ok := config["spaFallback"]; ok {
+		if spaFallback, ok := interfaceVal.(bool); ok {
+			options.SpaFallback = spaFallback
+		} else {
+			return errors.New("could not use value for spaFallback, not a boolean")
+		}
+	}
+
+	if options.RootPath == "" {
+		options.RootPath = "/"
+	}
+
+	if options.Index == "" {
+		options.Index = DefaultStaticIndex
+	}
+
+	return nil
+}
+
+// Validate this configuration object.
+func (options *StaticFileOptions) Validate() error {
+	if !strings.HasPrefix(options.RootPath, "/") {
+		return fmt.Errorf("invalid rootPath [%s], must start with /", options.RootPath)
+	}
+
+	if (options.Directory == "") == (options.FileSystem == "") {
+		return errors.New("exactly one of directory and fileSystem must be specified")
+	}
+
+	if options.Directory != "" {
+		if info, err := os.Stat(options.Directory); err != nil {
+			return fmt.Errorf("invalid directory [%s]: %v", options.Directory, err)
+		} else if !info.IsDir() {
+			return fmt.Errorf("invalid directory [%s]: not a directory", options.Directory)
+		}
+	}
+
+	if strings.Contains(options.Index, "/") || !fs.ValidPath(options.Index) {
+		return fmt.Errorf("invalid index [%s], must be a file name", options.Index)
+	}
+
+	return nil
+}
+
+// StaticFileHandlerFactory is an ApiHandlerFactory for the StaticBinding that creates StaticFileHandler's, e.g. to
+// host web UIs. It is not registered by default, add it to the Registry of an Instance to enable the binding.
+type StaticFileHandlerFactory struct {
+	fileSystems map[string]fs.FS
+}
+
+var _ ApiConfigValidatingFactory = &StaticFileHandlerFactory{}
+
+// NewStaticFileHandlerFactory creates a new StaticFileHandlerFactory. The supplied file systems (e.g. embed.FS's) may
+// be served by name via the fileSystem option. It may be nil if files are only served from directories.
+func NewStaticFileHandlerFactory(fileSystems map[string]fs.FS) *StaticFileHandlerFactory {
+	factory := &StaticFileHandlerFactory{
+		fileSystems: map[string]fs.FS{},
+	}
+
+	for name, fileSystem := range fileSystems {
+		factory.fileSystems[name] = fileSystem
+	}
+
+	return factory
+}
+
+// Binding returns StaticBinding
+func (factory *StaticFileHandlerFactory) Binding() string {
+	return StaticBinding
+}
+
+// New creates a StaticFileHandler from the options of an ApiConfig
+func (factory *StaticFileHandlerFactory) New(_ *ServerConfig, options map[interface{}]interface{}) (ApiHandler, error) {
+	staticOptions, err := factory.parseOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
+	fileSystem := factory.fileSystems[staticOptions.FileSystem]
+
+	if staticOptions.Directory != "" {
+		fileSystem = os.DirFS(staticOptions.Directory)
+	}
+
+	return &StaticFileHandler{
+		options:       options,
+		staticOptions: staticOptions,
+		fileSystem:    fileSystem,
+	}, nil
+}
+
+// Validate has no factory level configuration to check
+func (factory *StaticFileHandlerFactory) Validate(*InstanceConfig) error {
+	return nil
+}
+
+// ValidateApiConfig validates the options of an ApiConfig
+func (factory *StaticFileHandlerFactory) ValidateApiConfig(_ *ServerConfig, apiConfig *ApiConfig) error {
+	_, err := factory.parseOptions(apiConfig.Options())
+	return err
+}
+
+func (factory *StaticFileHandlerFactory) parseOptions(options map[interface{}]interface{}) (*StaticFileOptions, error) {
+	staticOptions := &StaticFileOptions{}
+
+	if err := staticOptions.Parse(options); err != nil {
+		return nil, err
+	}
+
+	if err := staticOptions.Validate(); err != nil {
+		return nil, err
+	}
+
+	if staticOptions.FileSystem != "" {
+		if _, ok := factory.fileSystems[staticOptions.FileSystem]; !ok {
+			return nil, fmt.Errorf("invalid fileSystem [%s], no file system with that name was supplied", staticOptions.FileSystem)
+		}
+	}
+
+	return staticOptions, nil
+}
+
+// StaticFileHandler is an ApiHandler that serves the files of a directory or fs.FS below its RootPath. Only GET and
+// HEAD requests are allowed. Conditional and range requests are supported via http.ServeContent.
+type StaticFileHandler struct {
+	options       map[interface{}]interface{}
+	staticOptions *StaticFileOptions
+	fileSystem    fs.FS
+}
+
+// Binding returns StaticBinding
+func (handler *StaticFileHandler) Binding() string {
+	return StaticBinding
+}
+
+// Options returns the options of the ApiConfig the StaticFileHandler was created for
+func (handler *StaticFileHandler) Options() map[interface{}]interface{} {
+	return handler.options
+}
+
+// RootPath returns the rootPath option, "/" if not set
+func (handler *StaticFileHandler) RootPath() string {
+	return handler.staticOptions.RootPath
+}
+
+// IsHandler returns true for requests of the RootPath and any path below it
+func (handler *StaticFileHandler) IsHandler(request *http.Request) bool {
+	return matchesPathSegments(request.URL.Path, handler.staticOptions.RootPath)
+}
+
+func (handler *StaticFileHandler) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet && request.Method != http.MethodHead {
+		writer.Header().Set("Allow", "GET, HEAD")
+		http.Error(writer, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(request.URL.Path, strings.TrimSuffix(handler.staticOptions.RootPath, "/"))
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+
+	file, info, err := handler.open(name)
+
+	if errors.Is(err, fs.ErrNotExist) && handler.staticOptions.SpaFallback {
+		file, info, err = handler.open("")
+	}
+
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			http.NotFound(writer, request)
+		} else {
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	defer func() { _ = file.Close() }()
+
+	content, err := readSeeker(file)
+	if err != nil {
+		http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if handler.staticOptions.CacheControl != "" {
+		writer.Header().Set("Cache-Control", handler.staticOptions.CacheControl)
+	}
+
+	http.ServeContent(writer, request, info.Name(), info.ModTime(), content)
+}
+
+// open returns the named file and its fs.FileInfo, or the Index file if name is a directory. The root directory is
+// named "".
+func (handler *StaticFileHandler) open(name string) (fs.File, fs.FileInfo, error) {
+	if name == "" {
+		name = "."
+	}
+
+	info, err := fs.Stat(handler.fileSystem, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if info.IsDir() {
+		name = path.Join(name, handler.staticOptions.Index)
+
+		if info, err = fs.Stat(handler.fileSystem, name); err != nil {
+			return nil, nil, err
+		}
+
+		if info.IsDir() {
+			return nil, nil, fs.ErrNotExist
+		}
+	}
+
+	file, err := handler.fileSystem.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return file, info, nil
+}
+
+// readSeeker returns file as an io.ReadSeeker for http.ServeContent. Files of os.DirFS and embed.FS are seekable,
+// others are read into memory.
+func readSeeker(file fs.File) (io.ReadSeeker, error) {
+	if content, ok := file.(io.ReadSeeker); ok {
+		return content, nil
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(data), nil
+}
@@ -0,0 +1,195 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+func newTestStaticHandler(t *testing.T, factory *StaticFileHandlerFactory, options map[interface{}]interface{}) ApiHandler {
+	handler, err := factory.New(nil, options)
+	require.New(t).NoError(err)
+
+	return handler
+}
+
+func serveStatic(handler http.Handler, method, target string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(method, target, nil))
+
+	return recorder
+}
+
+func TestStaticFileOptions(t *testing.T) {
+	t.Run("defaults the root path and index", func(t *testing.T) {
+		req := require.New(t)
+
+		options := &StaticFileOptions{}
+		req.NoError(options.Parse(map[interface{}]interface{}{"directory": t.TempDir()}))
+		req.Equal("/", options.RootPath)
+		req.Equal(DefaultStaticIndex, options.Index)
+		req.NoError(options.Validate())
+	})
+
+	t.Run("rejects invalid options", func(t *testing.T) {
+		factory := NewStaticFileHandlerFactory(map[string]fs.FS{"ui": fstest.MapFS{}})
+		dir := t.TempDir()
+
+		file := filepath.Join(dir, "file")
+		require.New(t).NoError(os.WriteFile(file, nil, 0600))
+
+		for expected, options := range map[string]map[interface{}]interface{}{
+			"could not use value for index, not a string":        {"directory": dir, "index": 1},
+			"could not use value for spaFallback, not a boolean": {"directory": dir, "spaFallback": "true"},
+			"exactly one of directory and fileSystem":            {},
+			"must start with /":                                  {"directory": dir, "rootPath": "ui"},
+			"not a directory":                                    {"directory": file},
+			"no file system with that name":                      {"fileSystem": "other"},
+			"must be a file name":                                {"fileSystem": "ui", "index": "a/index.html"},
+		} {
+			_, err := factory.New(nil, options)
+			require.New(t).ErrorContains(err, expected)
+		}
+	})
+}
+
+func TestStaticFileHandler(t *testing.T) {
+	fileSystem := fstest.MapFS{
+		"index.html":          {Data: []byte("root index")},
+		"app.js":              {Data: []byte("app")},
+		"docs/index.html":     {Data: []byte("docs index")},
+		"docs/start.htm":      {Data: []byte("docs start")},
+		"empty/placeholder":   {Data: []byte("placeholder")},
+		"nested/deep/file.js": {Data: []byte("deep")},
+	}
+	factory := NewStaticFileHandlerFactory(map[string]fs.FS{"ui": fileSystem})
+
+	t.Run("serves files and indexes below the root path", func(t *testing.T) {
+		req := require.New(t)
+
+		handler := newTestStaticHandler(t, factory, map[interface{}]interface{}{
+			"fileSystem":   "ui",
+			"rootPath":     "/ui",
+			"cacheControl": "max-age=60",
+		})
+
+		req.True(handler.IsHandler(httptest.NewRequest(http.MethodGet, "/ui", nil)))
+		req.True(handler.IsHandler(httptest.NewRequest(http.MethodGet, "/ui/app.js", nil)))
+		req.False(handler.IsHandler(httptest.NewRequest(http.MethodGet, "/uix", nil)))
+
+		for target, expected := range map[string]string{
+			"/ui":                     "root index",
+			"/ui/":                    "root index",
+			"/ui/app.js":              "app",
+			"/ui/docs/":               "docs index",
+			"/ui/nested/deep/file.js": "deep",
+		} {
+			recorder := serveStatic(handler, http.MethodGet, target)
+			req.Equal(http.StatusOK, recorder.Code, target)
+			req.Equal(expected, recorder.Body.String(), target)
+			req.Equal("max-age=60", recorder.Header().Get("Cache-Control"))
+		}
+
+		req.Equal(http.StatusNotFound, serveStatic(handler, http.MethodGet, "/ui/missing.js").Code)
+		req.Equal(http.StatusNotFound, serveStatic(handler, http.MethodGet, "/ui/empty/").Code)
+
+		//paths are confined to the file system
+		req.Equal("app", serveStatic(handler, http.MethodGet, "/ui/../../app.js").Body.String())
+
+		recorder := serveStatic(handler, http.MethodPost, "/ui/app.js")
+		req.Equal(http.StatusMethodNotAllowed, recorder.Code)
+		req.Equal("GET, HEAD", recorder.Header().Get("Allow"))
+	})
+
+	t.Run("serves the configured index", func(t *testing.T) {
+		req := require.New(t)
+
+		handler := newTestStaticHandler(t, factory, map[interface{}]interface{}{"fileSystem": "ui", "index": "start.htm"})
+
+		recorder := serveStatic(handler, http.MethodGet, "/docs/")
+		req.Equal(http.StatusOK, recorder.Code)
+		req.Equal("docs start", recorder.Body.String())
+	})
+
+	t.Run("falls back to the root index for single page applications", func(t *testing.T) {
+		req := require.New(t)
+
+		handler := newTestStaticHandler(t, factory, map[interface{}]interface{}{"fileSystem": "ui", "spaFallback": true})
+
+		recorder := serveStatic(handler, http.MethodGet, "/some/client/route")
+		req.Equal(http.StatusOK, recorder.Code)
+		req.Equal("root index", recorder.Body.String())
+		req.Equal("text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	})
+
+	t.Run("serves directories", func(t *testing.T) {
+		req := require.New(t)
+
+		dir := t.TempDir()
+		req.NoError(os.WriteFile(filepath.Join(dir, "index.html"), []byte("directory index"), 0600))
+
+		handler := newTestStaticHandler(t, factory, map[interface{}]interface{}{"directory": dir})
+
+		recorder := serveStatic(handler, http.MethodGet, "/")
+		req.Equal(http.StatusOK, recorder.Code)
+		req.Equal("directory index", recorder.Body.String())
+	})
+}
+
+func TestStaticFileHandlerFactory_demux(t *testing.T) {
+	req := require.New(t)
+
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	instance := newTestInstance(t, id, nil)
+	req.NoError(instance.GetRegistry().Add(NewStaticFileHandlerFactory(map[string]fs.FS{
+		"ui": fstest.MapFS{"index.html": {Data: []byte("ui")}},
+	})))
+
+	serverConfig := newTestServerConfig(id, freeAddress(t))
+	serverConfig.APIs = []*ApiConfig{
+		{binding: StaticBinding, options: map[interface{}]interface{}{"fileSystem": "ui", "rootPath": "/ui"}},
+		{binding: "mockHandler"},
+	}
+	req.NoError(serverConfig.Validate(instance.GetRegistry()))
+
+	server := newTestServer(t, instance, serverConfig)
+	startTestServer(t, server)
+	defer server.Shutdown(context.Background())
+
+	for target, expected := range map[string]string{
+		"/ui/":          "ui",
+		"/mock-handler": "mockHandler",
+	} {
+		resp, err := newTestClient().Get("https://" + server.httpServers[0].Addr + target)
+		req.NoError(err)
+
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		req.NoError(err)
+		req.Equal(expected, string(body))
+	}
+}
@@ -0,0 +1,43 @@
+/*
+	Copyright NetFoundry Inc.
+
+	Licensed under the Apache License, Version 2.0 (the "License");
+	you may not use this file except in compliance with the License.
+	You may obtain a copy of the License at
+
+	https://www.apache.org/licenses/LICENSE-2.0
+
+	Unless required by applicable law or agreed to in writing, software
+	distributed under the License is distributed on an "AS IS" BASIS,
+	WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+	See the License for the specific language governing permissions and
+	limitations under the License.
+*/
+
+package xweb
+
+import (
+	"fmt"
+	"github.com/michaelquigley/pfxlog"
+	"os"
+)
+
+// TlsKeyLogEnv is the environment variable that must be set to "true" for the tlsKeyLogFile option and
+// InstanceOptions.TlsKeyLogFile to be honored.
+const TlsKeyLogEnv = "XWEB_ALLOW_TLS_KEY_LOG"
+
+// openTlsKeyLog opens the TLS key log file at path for appending if TlsKeyLogEnv allows it.
+func openTlsKeyLog(serverName string, path string) (*os.File, error) {
+	if os.Getenv(TlsKeyLogEnv) != "true" {
+		return nil, fmt.Errorf("server %s requested a TLS key log file, which requires the %s environment variable to be set to true", serverName, TlsKeyLogEnv)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open TLS key log file for server %s: %v", serverName, err)
+	}
+
+	pfxlog.Logger().Warnf("!!! INSECURE: server %s is writing TLS session secrets to %s, traffic captured from it can be decrypted, this must never be used in production !!!", serverName, path)
+
+	return file, nil
+}
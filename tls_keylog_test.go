@@ -0,0 +1,79 @@
+/*
+Copyright NetFoundry Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package xweb
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestServer_tlsKeyLogFile(t *testing.T) {
+	id, _, _ := newTestIdentity(t, "127.0.0.1")
+
+	t.Run("requires the environment variable", func(t *testing.T) {
+		req := require.New(t)
+
+		t.Setenv(TlsKeyLogEnv, "")
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.Options.TlsKeyLogFile = filepath.Join(t.TempDir(), "keys.log")
+
+		_, err := NewServer(newTestInstance(t, id, nil), serverConfig)
+		req.ErrorContains(err, TlsKeyLogEnv)
+		req.NoFileExists(serverConfig.Options.TlsKeyLogFile)
+	})
+
+	t.Run("writes session secrets", func(t *testing.T) {
+		req := require.New(t)
+
+		t.Setenv(TlsKeyLogEnv, "true")
+
+		serverConfig := newTestServerConfig(id, "127.0.0.1:443")
+		serverConfig.Options.TlsKeyLogFile = filepath.Join(t.TempDir(), "keys.log")
+
+		server := newTestServer(t, newTestInstance(t, id, nil), serverConfig)
+		defer server.Shutdown(context.Background())
+
+		_, err := handshake(server.httpServers[0].TLSConfig, &tls.Config{InsecureSkipVerify: true})
+		req.NoError(err)
+
+		keys, err := os.ReadFile(serverConfig.Options.TlsKeyLogFile)
+		req.NoError(err)
+		req.Contains(string(keys), "CLIENT_HANDSHAKE_TRAFFIC_SECRET")
+	})
+
+	t.Run("defaults to the instance option", func(t *testing.T) {
+		req := require.New(t)
+
+		t.Setenv(TlsKeyLogEnv, "true")
+
+		keyLogFile := filepath.Join(t.TempDir(), "keys.log")
+
+		instance := newTestInstance(t, id, nil)
+		instance.GetConfig().Options.TlsKeyLogFile = keyLogFile
+
+		server := newTestServer(t, instance, newTestServerConfig(id, "127.0.0.1:443"))
+		defer server.Shutdown(context.Background())
+
+		req.NotNil(server.httpServers[0].TLSConfig.KeyLogWriter)
+		req.FileExists(keyLogFile)
+	})
+}